@@ -0,0 +1,91 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MessageTypeAck 服务器确认消息已保存
+const MessageTypeAck = "ack"
+
+// maxOutboxSize 待确认队列的最大长度，超出时丢弃最早的消息
+const maxOutboxSize = 1000
+
+// Ack 服务器对一条消息的确认
+type Ack struct {
+	ClientMsgID string `json:"client_msg_id"`
+	MessageID   uint   `json:"message_id"`
+	SessionID   uint   `json:"session_id"`
+	Seq         uint   `json:"seq"`
+	Duplicate   bool   `json:"duplicate,omitempty"` // 服务器已处理过该消息，本次重发被忽略
+}
+
+// Pending 返回尚未收到确认的消息数
+func (ws *WSClient) Pending() int {
+	ws.outboxMu.Lock()
+	defer ws.outboxMu.Unlock()
+	return len(ws.outbox)
+}
+
+// addToOutbox 将消息放入待确认队列
+func (ws *WSClient) addToOutbox(clientMsgID string, data []byte) {
+	ws.outboxMu.Lock()
+	defer ws.outboxMu.Unlock()
+	if len(ws.outbox) >= maxOutboxSize {
+		log.Printf("outbox full, dropping unacknowledged message %s", ws.outbox[0].clientMsgID)
+		ws.outbox = ws.outbox[1:]
+	}
+	ws.outbox = append(ws.outbox, outboxEntry{clientMsgID: clientMsgID, data: data})
+}
+
+// handleAck 收到确认后将消息移出待确认队列，返回消息是否为 ack
+func (ws *WSClient) handleAck(frame *frameEnvelope) bool {
+	if frame.Type != MessageTypeAck {
+		return false
+	}
+	var ack Ack
+	if err := json.Unmarshal(frame.Content, &ack); err != nil {
+		return false
+	}
+
+	ws.removeFromOutbox(ack.ClientMsgID)
+	if ws.config.Debug {
+		log.Printf("[DEBUG] Message %s acknowledged (id: %d, seq: %d, duplicate: %v)",
+			ack.ClientMsgID, ack.MessageID, ack.Seq, ack.Duplicate)
+	}
+	return true
+}
+
+// removeFromOutbox 将消息移出待确认队列
+func (ws *WSClient) removeFromOutbox(clientMsgID string) {
+	ws.outboxMu.Lock()
+	defer ws.outboxMu.Unlock()
+	for i, entry := range ws.outbox {
+		if entry.clientMsgID == clientMsgID {
+			ws.outbox = append(ws.outbox[:i], ws.outbox[i+1:]...)
+			return
+		}
+	}
+}
+
+// retransmit 在新连接上按发送顺序重发未确认的消息，服务器会忽略已处理过的消息
+func (ws *WSClient) retransmit(conn *websocket.Conn) error {
+	ws.outboxMu.Lock()
+	pending := make([]outboxEntry, len(ws.outbox))
+	copy(pending, ws.outbox)
+	ws.outboxMu.Unlock()
+
+	for _, entry := range pending {
+		conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+		if err := conn.WriteMessage(websocket.TextMessage, entry.data); err != nil {
+			return err
+		}
+	}
+	if len(pending) > 0 {
+		log.Printf("websocket retransmitted %d unacknowledged message(s)", len(pending))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 附件消息类型
+const (
+	MessageTypeImage = "image"
+	MessageTypeFile  = "file"
+)
+
+// Attachment 上传后的附件信息
+type Attachment struct {
+	ID          uint   `json:"ID"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// IsImage 是否为图片
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// AttachmentMessage 图片或文件消息，发送时只需填写 AttachmentID 和 Caption
+type AttachmentMessage struct {
+	AttachmentID uint   `json:"attachment_id"`
+	Caption      string `json:"caption,omitempty"`
+	FileName     string `json:"file_name,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+// UploadAttachment 上传本地文件，返回附件信息
+func (c *Client) UploadAttachment(path string) (*Attachment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file failed: %w", err)
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return nil, fmt.Errorf("create form file failed: %w", err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, fmt.Errorf("read file failed: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("close multipart writer failed: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/attachments", c.config.BaseURL)
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("User-Agent", c.config.UserAgent)
+	if c.config.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.AuthToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response body failed: %w", err)
+	}
+	if c.config.Debug {
+		fmt.Printf("[DEBUG] Request: POST %s (%s)\n", url, path)
+		fmt.Printf("[DEBUG] Response Status: %d\n", resp.StatusCode)
+		fmt.Printf("[DEBUG] Response Body: %s\n", string(respBody))
+	}
+
+	var attachment Attachment
+	if err := decodeResponse(resp.StatusCode, respBody, &attachment); err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// SendAttachment 发送已上传的附件，图片以 image 消息发送，其他文件以 file 消息发送
+func (ws *WSClient) SendAttachment(attachment *Attachment, caption string) error {
+	msgType := MessageTypeFile
+	if attachment.IsImage() {
+		msgType = MessageTypeImage
+	}
+	return ws.Send(msgType, AttachmentMessage{AttachmentID: attachment.ID, Caption: caption})
+}
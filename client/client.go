@@ -16,6 +16,9 @@ type Config struct {
 	AuthToken string        // JWT认证token
 	UserAgent string        // User-Agent
 	Debug     bool          // 是否开启调试模式
+
+	// ReconnectAttempts WebSocket 断线后的最大重连次数，0 使用默认值，负数表示不重连
+	ReconnectAttempts int
 }
 
 // Client 聊天机器人客户端
@@ -95,17 +98,22 @@ func (c *Client) do(method, path string, body interface{}, result interface{}) e
 		fmt.Printf("[DEBUG] Response Body: %s\n", string(respBody))
 	}
 
+	return decodeResponse(resp.StatusCode, respBody, result)
+}
+
+// decodeResponse 检查响应状态码并解析响应结果
+func decodeResponse(statusCode int, respBody []byte, result interface{}) error {
 	// 检查响应状态码
-	if resp.StatusCode >= 400 {
+	if statusCode >= 400 {
 		var errResp ErrorResponse
 		if err := json.Unmarshal(respBody, &errResp); err != nil {
-			return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
+			return fmt.Errorf("HTTP %d: %s", statusCode, string(respBody))
 		}
 		return &errResp
 	}
 
-	// 解析响应结果
-	if result != nil {
+	// 解析响应结果，202 等状态可能没有响应体
+	if result != nil && len(respBody) > 0 {
 		if err := json.Unmarshal(respBody, result); err != nil {
 			return fmt.Errorf("unmarshal response body failed: %w", err)
 		}
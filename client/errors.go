@@ -0,0 +1,83 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MessageTypeError 服务器处理消息失败，内容为 ServerError
+const MessageTypeError = "error"
+
+// 服务器错误码
+const (
+	ErrorCodeInvalidMessage       = "invalid_message"
+	ErrorCodeUnsupportedType      = "unsupported_type"
+	ErrorCodeSessionNotFound      = "session_not_found"
+	ErrorCodeSessionNotActive     = "session_not_active"
+	ErrorCodeSessionNotWaiting    = "session_not_waiting"
+	ErrorCodeSessionNotAssigned   = "session_not_assigned"
+	ErrorCodeAttachmentNotFound   = "attachment_not_found"
+	ErrorCodeAttachmentForbidden  = "attachment_forbidden"
+	ErrorCodeAttachmentNotAllowed = "attachment_not_allowed"
+	ErrorCodeAttachmentSent       = "attachment_sent"
+	ErrorCodeMessageBlocked       = "message_blocked"
+	ErrorCodeServerRestarting     = "server_restarting"
+	ErrorCodeServerBusy           = "server_busy"
+	ErrorCodeTimeout              = "timeout"
+	ErrorCodeInternal             = "internal_error"
+)
+
+// ServerError 服务器通过 error 帧返回的错误，ClientMsgID 为出错消息的 client_msg_id
+type ServerError struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	Detail      string `json:"detail,omitempty"`
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+}
+
+func (e *ServerError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%s (%s): %s", e.Message, e.Code, e.Detail)
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// Temporary 服务端错误和重启中、繁忙时未处理的消息可以稍后重试，其他错误重发同样的消息仍会失败
+func (e *ServerError) Temporary() bool {
+	switch e.Code {
+	case ErrorCodeInternal, ErrorCodeServerRestarting, ErrorCodeServerBusy:
+		return true
+	}
+	return false
+}
+
+// ParseServerError 从服务器推送的消息中解析错误，消息不是 error 帧时返回 false
+func ParseServerError(message []byte) (*ServerError, bool) {
+	var frame frameEnvelope
+	if err := json.Unmarshal(message, &frame); err != nil || frame.Type != MessageTypeError {
+		return nil, false
+	}
+	return decodeServerError(frame.Content), true
+}
+
+// decodeServerError 解析 error 帧的内容，内容无法解析时仍返回一个错误
+func decodeServerError(content json.RawMessage) *ServerError {
+	var serverErr ServerError
+	if err := json.Unmarshal(content, &serverErr); err != nil || serverErr.Code == "" {
+		return &ServerError{Code: ErrorCodeInternal, Message: "malformed error frame"}
+	}
+	return &serverErr
+}
+
+// handleError 出错的消息不会被确认：不可重试的错误将消息移出待确认队列，
+// 服务端错误保留在队列中，重连后重发。error 帧仍交给调用方处理
+func (ws *WSClient) handleError(frame *frameEnvelope) {
+	if frame.Type != MessageTypeError {
+		return
+	}
+	serverErr := decodeServerError(frame.Content)
+	if serverErr.ClientMsgID == "" || serverErr.Temporary() {
+		return
+	}
+	ws.removeFromOutbox(serverErr.ClientMsgID)
+}
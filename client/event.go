@@ -0,0 +1,52 @@
+package client
+
+// 输入状态和已读回执消息类型，这些事件不保存为消息，也不需要确认
+const (
+	MessageTypeTypingStart = "typing_start" // 对方开始输入，内容为 TypingEvent
+	MessageTypeTypingStop  = "typing_stop"  // 对方停止输入，内容为 TypingEvent
+	MessageTypeRead        = "read"
+	MessageTypeReadReceipt = "read_receipt" // 已读位置更新，内容为 ReadReceipt
+)
+
+// TypingEvent 输入状态，Sender 为 bot 或 agent
+type TypingEvent struct {
+	SessionID uint   `json:"session_id"`
+	Sender    string `json:"sender,omitempty"`
+}
+
+// ReadRequest 已读请求
+type ReadRequest struct {
+	SessionID uint `json:"session_id"`
+	Seq       uint `json:"seq"`
+}
+
+// ReadReceipt 已读回执，Reader 为 customer 或 agent
+type ReadReceipt struct {
+	SessionID uint   `json:"session_id"`
+	Reader    string `json:"reader"`
+	UserID    uint   `json:"user_id"`
+	Seq       uint   `json:"seq"`
+}
+
+// isEventType 判断是否为不需要确认的事件
+func isEventType(msgType string) bool {
+	switch msgType {
+	case MessageTypeTypingStart, MessageTypeTypingStop, MessageTypeRead:
+		return true
+	}
+	return false
+}
+
+// SendTyping 通知接待的客服开始或停止输入，sessionID 为 0 时使用当前会话
+func (ws *WSClient) SendTyping(sessionID uint, typing bool) error {
+	msgType := MessageTypeTypingStop
+	if typing {
+		msgType = MessageTypeTypingStart
+	}
+	return ws.Send(msgType, TypingEvent{SessionID: sessionID})
+}
+
+// MarkRead 标记会话中序号不大于 seq 的消息为已读，服务器以 read_receipt 帧确认
+func (ws *WSClient) MarkRead(sessionID uint, seq uint) error {
+	return ws.Send(MessageTypeRead, ReadRequest{SessionID: sessionID, Seq: seq})
+}
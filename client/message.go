@@ -54,8 +54,9 @@ type MessageQueryParams struct {
 
 // MessageQueryResult 消息查询结果
 type MessageQueryResult struct {
-	Total    int64     `json:"total"`
-	Messages []Message `json:"messages"`
+	Total       int64     `json:"total"`
+	UnreadCount int64     `json:"unread_count"` // 已读位置之后的机器人和客服消息数
+	Messages    []Message `json:"messages"`
 }
 
 // GetMessageHistory 获取消息历史
@@ -101,8 +102,26 @@ type TextMessage struct {
 	Text string `json:"text"`
 }
 
-// SendMessage 发送消息
-func (c *Client) SendMessage(msgType string, content interface{}) error {
+// MessageTypeEcho 客户在其他设备上发送的消息，内容为 EchoMessage
+const MessageTypeEcho = "echo"
+
+// EchoMessage 在其他设备上发送的原始消息
+type EchoMessage struct {
+	Type    string          `json:"type"`
+	Content json.RawMessage `json:"content"`
+}
+
+// MessageResponse 服务器返回的消息
+type MessageResponse struct {
+	Type      string          `json:"type"`
+	Content   json.RawMessage `json:"content"`
+	Timestamp time.Time       `json:"timestamp"`
+	Extra     json.RawMessage `json:"extra,omitempty"`
+}
+
+// SendMessage 通过HTTP发送消息并返回机器人的回复，回复同时会推送到已打开的WebSocket连接。
+// 会话由人工客服接待时消息直接转发给客服，返回 nil
+func (c *Client) SendMessage(msgType string, content interface{}) (*MessageResponse, error) {
 	msg := struct {
 		Type    string      `json:"type"`
 		Content interface{} `json:"content"`
@@ -111,10 +130,17 @@ func (c *Client) SendMessage(msgType string, content interface{}) error {
 		Content: content,
 	}
 
-	return c.do("POST", "/api/message/send", msg, nil)
+	var resp MessageResponse
+	if err := c.do(http.MethodPost, "/api/message/send", msg, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Type == "" {
+		return nil, nil
+	}
+	return &resp, nil
 }
 
-// SendText 发送文本消息
-func (c *Client) SendText(text string) error {
+// SendText 通过HTTP发送文本消息
+func (c *Client) SendText(text string) (*MessageResponse, error) {
 	return c.SendMessage("text", TextMessage{Text: text})
 }
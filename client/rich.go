@@ -0,0 +1,195 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 消息类型
+const (
+	MessageTypeText           = "text"
+	MessageTypeQuickReplies   = "quick_replies"
+	MessageTypeCard           = "card"
+	MessageTypeCarousel       = "carousel"
+	MessageTypeButtonPostback = "button_postback"
+	MessageTypePostback       = "postback"
+)
+
+// Button 按钮或快捷回复，Payload 非空时点击后回传 postback，URL 非空时打开链接
+type Button struct {
+	Title   string `json:"title"`
+	Payload string `json:"payload,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// QuickRepliesMessage 文本加快捷回复
+type QuickRepliesMessage struct {
+	Text    string   `json:"text"`
+	Replies []Button `json:"replies"`
+}
+
+// CardMessage 卡片消息
+type CardMessage struct {
+	Title    string   `json:"title"`
+	Subtitle string   `json:"subtitle,omitempty"`
+	ImageURL string   `json:"image_url,omitempty"`
+	Buttons  []Button `json:"buttons,omitempty"`
+}
+
+// CarouselMessage 轮播消息
+type CarouselMessage struct {
+	Cards []CardMessage `json:"cards"`
+}
+
+// ButtonPostbackMessage 文本加按钮
+type ButtonPostbackMessage struct {
+	Text    string   `json:"text"`
+	Buttons []Button `json:"buttons"`
+}
+
+// PostbackMessage 回传事件
+type PostbackMessage struct {
+	Payload string `json:"payload"`
+	Title   string `json:"title,omitempty"`
+}
+
+// Choice 渲染后的编号选项，编号从1开始
+type Choice struct {
+	Number int
+	Button
+}
+
+// RenderedMessage 渲染为文本和编号选项的消息
+type RenderedMessage struct {
+	Text    string
+	Choices []Choice
+
+	inline bool // 选项已经写在卡片文本中
+}
+
+// RenderMessage 将服务器消息渲染为文本和编号选项，轮播中各卡片的按钮连续编号
+func RenderMessage(msgType string, content json.RawMessage) (*RenderedMessage, error) {
+	rendered := &RenderedMessage{}
+	switch msgType {
+	case MessageTypeText:
+		var msg TextMessage
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshal text message failed: %w", err)
+		}
+		rendered.Text = msg.Text
+	case MessageTypeQuickReplies:
+		var msg QuickRepliesMessage
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshal quick replies failed: %w", err)
+		}
+		rendered.Text = msg.Text
+		rendered.addChoices(msg.Replies)
+	case MessageTypeButtonPostback:
+		var msg ButtonPostbackMessage
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshal button message failed: %w", err)
+		}
+		rendered.Text = msg.Text
+		rendered.addChoices(msg.Buttons)
+	case MessageTypeCard:
+		var msg CardMessage
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshal card failed: %w", err)
+		}
+		rendered.addCard(msg)
+	case MessageTypeCarousel:
+		var msg CarouselMessage
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshal carousel failed: %w", err)
+		}
+		for _, card := range msg.Cards {
+			rendered.addCard(card)
+		}
+	case MessageTypeImage, MessageTypeFile:
+		var msg AttachmentMessage
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshal attachment failed: %w", err)
+		}
+		rendered.Text = fmt.Sprintf("[%s: %s] %s", msgType, msg.FileName, msg.URL)
+		if msg.Caption != "" {
+			rendered.Text += "\n" + msg.Caption
+		}
+	case MessageTypePostback:
+		var msg PostbackMessage
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshal postback failed: %w", err)
+		}
+		rendered.Text = msg.Title
+		if rendered.Text == "" {
+			rendered.Text = msg.Payload
+		}
+	default:
+		return nil, fmt.Errorf("unsupported message type: %s", msgType)
+	}
+	return rendered, nil
+}
+
+// addChoices 追加编号选项
+func (m *RenderedMessage) addChoices(buttons []Button) {
+	for _, button := range buttons {
+		m.Choices = append(m.Choices, Choice{Number: len(m.Choices) + 1, Button: button})
+	}
+}
+
+// addCard 追加一张卡片，卡片之间空一行
+func (m *RenderedMessage) addCard(card CardMessage) {
+	var b strings.Builder
+	if m.Text != "" {
+		b.WriteString(m.Text)
+		b.WriteString("\n\n")
+	}
+	fmt.Fprintf(&b, "[%s]", card.Title)
+	if card.Subtitle != "" {
+		b.WriteString("\n" + card.Subtitle)
+	}
+	if card.ImageURL != "" {
+		b.WriteString("\n(image: " + card.ImageURL + ")")
+	}
+	for _, button := range card.Buttons {
+		m.Choices = append(m.Choices, Choice{Number: len(m.Choices) + 1, Button: button})
+		fmt.Fprintf(&b, "\n  %d. %s", len(m.Choices), choiceLabel(button))
+	}
+	m.Text = b.String()
+	m.inline = true
+}
+
+// String 返回文本和编号选项，卡片的按钮已包含在文本中
+func (m *RenderedMessage) String() string {
+	if len(m.Choices) == 0 || m.inline {
+		return m.Text
+	}
+
+	var b strings.Builder
+	b.WriteString(m.Text)
+	for _, choice := range m.Choices {
+		fmt.Fprintf(&b, "\n  %d. %s", choice.Number, choiceLabel(choice.Button))
+	}
+	return b.String()
+}
+
+// Choose 根据编号返回选项
+func (m *RenderedMessage) Choose(number int) (Choice, bool) {
+	if number < 1 || number > len(m.Choices) {
+		return Choice{}, false
+	}
+	return m.Choices[number-1], true
+}
+
+// choiceLabel 选项的显示文本，链接按钮附带URL
+func choiceLabel(button Button) string {
+	if button.URL != "" {
+		return fmt.Sprintf("%s (%s)", button.Title, button.URL)
+	}
+	return button.Title
+}
+
+// SendPostback 回传按钮或快捷回复的 payload
+func (ws *WSClient) SendPostback(payload, title string) error {
+	return ws.Send(MessageTypePostback, PostbackMessage{Payload: payload, Title: title})
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 流式回复帧类型
+const (
+	MessageTypeStreamStart = "stream_start"
+	MessageTypeStreamDelta = "stream_delta"
+	MessageTypeStreamEnd   = "stream_end"
+)
+
+// StreamChunk 流式回复帧内容
+type StreamChunk struct {
+	ReplyID string `json:"reply_id"`
+	Delta   string `json:"delta,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+// StreamEvent 拼装器处理一帧后的结果
+type StreamEvent struct {
+	Type    string // 帧类型
+	ReplyID string // 回复ID
+	Delta   string // 本帧的增量文本
+	Text    string // 截至本帧拼接出的文本，stream_end 时为完整回复
+	Done    bool   // 回复是否结束
+	Started bool   // stream_end 之前是否收到过该回复的其他帧，同一客户的其他设备只会收到 stream_end
+}
+
+// StreamAssembler 将 stream_start/stream_delta/stream_end 帧重新拼装为完整回复
+type StreamAssembler struct {
+	mu      sync.Mutex
+	replies map[string]*strings.Builder
+}
+
+// NewStreamAssembler 创建流式回复拼装器
+func NewStreamAssembler() *StreamAssembler {
+	return &StreamAssembler{
+		replies: make(map[string]*strings.Builder),
+	}
+}
+
+// Feed 处理一条服务器消息，如果不是流式帧则返回 ok=false
+func (a *StreamAssembler) Feed(message []byte) (event StreamEvent, ok bool, err error) {
+	var msg struct {
+		Type    string          `json:"type"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return StreamEvent{}, false, fmt.Errorf("unmarshal message failed: %w", err)
+	}
+
+	switch msg.Type {
+	case MessageTypeStreamStart, MessageTypeStreamDelta, MessageTypeStreamEnd:
+	default:
+		return StreamEvent{}, false, nil
+	}
+
+	var chunk StreamChunk
+	if err := json.Unmarshal(msg.Content, &chunk); err != nil {
+		return StreamEvent{}, true, fmt.Errorf("unmarshal stream chunk failed: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	builder, exists := a.replies[chunk.ReplyID]
+	if !exists {
+		builder = &strings.Builder{}
+		a.replies[chunk.ReplyID] = builder
+	}
+
+	event = StreamEvent{Type: msg.Type, ReplyID: chunk.ReplyID}
+	switch msg.Type {
+	case MessageTypeStreamDelta:
+		builder.WriteString(chunk.Delta)
+		event.Delta = chunk.Delta
+		event.Text = builder.String()
+	case MessageTypeStreamEnd:
+		// 以服务器给出的完整文本为准，丢帧时也能拿到正确结果
+		event.Text = chunk.Text
+		if event.Text == "" {
+			event.Text = builder.String()
+		}
+		event.Done = true
+		event.Started = exists
+		delete(a.replies, chunk.ReplyID)
+	}
+
+	return event, true, nil
+}
+
+// SendTextStream 发送文本消息，并要求服务器以流式片段返回回复
+func (ws *WSClient) SendTextStream(text string) error {
+	return ws.sendRequest(messageRequest{
+		Type:    "text",
+		Content: TextMessage{Text: text},
+		Stream:  true,
+	})
+}
+
+// ReceiveReply 接收一条完整回复：流式回复会在拼装完成后返回，每个片段通过 onDelta 回调；
+// 文本和富消息渲染为文本后返回；服务器返回 error 帧时返回 *ServerError。期间收到的其他类型消息会被丢弃
+func (ws *WSClient) ReceiveReply(ctx context.Context, onDelta func(delta string)) (string, error) {
+	assembler := NewStreamAssembler()
+	for {
+		message, err := ws.Receive(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		event, ok, err := assembler.Feed(message)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			if event.Delta != "" && onDelta != nil {
+				onDelta(event.Delta)
+			}
+			if event.Done {
+				return event.Text, nil
+			}
+			continue
+		}
+
+		var msg struct {
+			Type    string          `json:"type"`
+			Content json.RawMessage `json:"content"`
+		}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return "", fmt.Errorf("unmarshal message failed: %w", err)
+		}
+		if msg.Type == MessageTypeError {
+			return "", decodeServerError(msg.Content)
+		}
+		if rendered, err := RenderMessage(msg.Type, msg.Content); err == nil {
+			return rendered.String(), nil
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// 增量同步消息类型
+const (
+	MessageTypeSync         = "sync"
+	MessageTypeSyncMessage  = "sync_message"  // 一条缺失的消息，内容为 Message
+	MessageTypeSyncComplete = "sync_complete" // 同步结束，内容为 SyncComplete
+)
+
+// SessionCursor 某个会话中已收到的最后一条消息序号
+type SessionCursor struct {
+	SessionID uint `json:"session_id"`
+	LastSeq   uint `json:"last_seq"`
+}
+
+// SyncRequest 同步请求
+type SyncRequest struct {
+	Sessions []SessionCursor `json:"sessions"`
+}
+
+// SyncComplete 同步结束标记，HasMore 为 true 时需要用新的序号再次同步
+type SyncComplete struct {
+	Sessions []SessionCursor `json:"sessions"`
+	HasMore  bool            `json:"has_more"`
+}
+
+// SyncResult REST 同步结果
+type SyncResult struct {
+	Messages []Message    `json:"messages"`
+	Complete SyncComplete `json:"complete"`
+}
+
+// Sync 通过HTTP获取各会话中序号大于 LastSeq 的消息
+func (c *Client) Sync(req SyncRequest) (*SyncResult, error) {
+	var result SyncResult
+	if err := c.do(http.MethodPost, "/api/message/sync", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// frameEnvelope 服务器推送帧的公共部分
+type frameEnvelope struct {
+	Type    string          `json:"type"`
+	Content json.RawMessage `json:"content"`
+	Extra   json.RawMessage `json:"extra,omitempty"`
+}
+
+// seqExtra 已保存消息在 extra 中附带的序号信息
+type seqExtra struct {
+	SessionID uint `json:"session_id"`
+	Seq       uint `json:"seq"`
+}
+
+// Sync 请求服务器补发各会话中缺失的消息。消息以 sync_message 帧推送，最后是 sync_complete 帧。
+// 重连后客户端会自动同步，一般无需手动调用
+func (ws *WSClient) Sync() error {
+	return ws.sendRequest(messageRequest{
+		Type:    MessageTypeSync,
+		Content: ws.syncRequest(),
+	})
+}
+
+// LastSeq 返回会话中已收到的最后一条消息序号
+func (ws *WSClient) LastSeq(sessionID uint) uint {
+	ws.seqMu.Lock()
+	defer ws.seqMu.Unlock()
+	return ws.lastSeq[sessionID]
+}
+
+// syncRequest 以已收到的最后序号构造同步请求
+func (ws *WSClient) syncRequest() SyncRequest {
+	ws.seqMu.Lock()
+	defer ws.seqMu.Unlock()
+	req := SyncRequest{Sessions: make([]SessionCursor, 0, len(ws.lastSeq))}
+	for sessionID, seq := range ws.lastSeq {
+		req.Sessions = append(req.Sessions, SessionCursor{SessionID: sessionID, LastSeq: seq})
+	}
+	return req
+}
+
+// trackSeq 记录帧中携带的消息序号。sync_complete 且 has_more 时返回 true，调用方应继续同步
+func (ws *WSClient) trackSeq(frame *frameEnvelope) (syncMore bool) {
+	switch frame.Type {
+	case MessageTypeSyncMessage:
+		var msg seqExtra
+		if err := json.Unmarshal(frame.Content, &msg); err == nil {
+			ws.advanceSeq(msg.SessionID, msg.Seq)
+		}
+	case MessageTypeSyncComplete:
+		var complete SyncComplete
+		if err := json.Unmarshal(frame.Content, &complete); err == nil {
+			for _, cursor := range complete.Sessions {
+				ws.advanceSeq(cursor.SessionID, cursor.LastSeq)
+			}
+			return complete.HasMore
+		}
+	default:
+		if len(frame.Extra) == 0 {
+			return false
+		}
+		var extra seqExtra
+		if err := json.Unmarshal(frame.Extra, &extra); err == nil {
+			ws.advanceSeq(extra.SessionID, extra.Seq)
+		}
+	}
+	return false
+}
+
+// advanceSeq 序号只增不减
+func (ws *WSClient) advanceSeq(sessionID uint, seq uint) {
+	if sessionID == 0 || seq == 0 {
+		return
+	}
+	ws.seqMu.Lock()
+	defer ws.seqMu.Unlock()
+	if seq > ws.lastSeq[sessionID] {
+		ws.lastSeq[sessionID] = seq
+	}
+}
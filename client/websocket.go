@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -21,8 +22,17 @@ type HeartbeatConfig struct {
 	RTTThreshold    time.Duration
 }
 
-// WSClient WebSocket客户端
+// 断线重连参数
+const (
+	defaultReconnectAttempts = 10
+	minReconnectDelay        = time.Second
+	maxReconnectDelay        = 30 * time.Second
+)
+
+// WSClient WebSocket客户端，连接异常断开后自动重连、恢复会话并同步缺失的消息
 type WSClient struct {
+	client         *Client
+	connMu         sync.Mutex
 	conn           *websocket.Conn
 	send           chan []byte
 	receive        chan []byte
@@ -36,13 +46,85 @@ type WSClient struct {
 		mutex           sync.Mutex
 	}
 	heartbeatConfig HeartbeatConfig
+
+	sessionMu sync.RWMutex
+	sessionID uint // 服务器推送的当前会话ID，重连时用于恢复会话
+
+	seqMu   sync.Mutex
+	lastSeq map[uint]uint // 会话ID -> 已收到的最后一条消息序号
+
+	outboxMu sync.Mutex
+	outbox   []outboxEntry // 已发送但尚未收到 ack 的消息，按发送顺序排列，重连后重发
+
+	restartMu    sync.Mutex
+	restartDelay time.Duration // 服务器重启前建议的重连等待时间，下次重连时使用
+	restarting   bool
+}
+
+// outboxEntry 等待确认的消息
+type outboxEntry struct {
+	clientMsgID string
+	data        []byte
+}
+
+// MessageTypeServerRestarting 服务器即将重启，随后断开连接，客户端按提示的时间重连
+const MessageTypeServerRestarting = "server_restarting"
+
+// ServerRestarting server_restarting 帧的内容
+type ServerRestarting struct {
+	ReconnectAfterMs int64 `json:"reconnect_after_ms"`
+}
+
+// sessionInfo 服务器在连接建立后推送的会话信息
+type sessionInfo struct {
+	SessionID uint `json:"session_id"`
+	Resumed   bool `json:"resumed"`
 }
 
 // MessageHandler 消息处理函数类型
 type MessageHandler func(message []byte)
 
-// ConnectWebSocket 连接WebSocket服务器
+// ConnectWebSocket 连接WebSocket服务器，开始新的会话
 func (c *Client) ConnectWebSocket() (*WSClient, error) {
+	return c.ResumeWebSocket(0)
+}
+
+// ResumeWebSocket 连接WebSocket服务器并尝试恢复 sessionID 对应的会话，
+// 会话已结束或超过宽限期时服务器会开始新的会话。sessionID 为 0 时直接开始新的会话
+func (c *Client) ResumeWebSocket(sessionID uint) (*WSClient, error) {
+	conn, err := c.dialWebSocket(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &WSClient{
+		client:  c,
+		conn:    conn,
+		send:    make(chan []byte, 256),
+		receive: make(chan []byte, 256),
+		done:    make(chan struct{}),
+		config:  c.config,
+		heartbeatConfig: HeartbeatConfig{
+			MinInterval:     15 * time.Second,
+			MaxInterval:     60 * time.Second,
+			InitialInterval: 30 * time.Second,
+			AdjustFactor:    1.5,
+			RTTThreshold:    time.Second,
+		},
+		lastSeq: make(map[uint]uint),
+	}
+
+	// 设置初始心跳间隔
+	ws.heartbeatStats.currentInterval = ws.heartbeatConfig.InitialInterval
+
+	// 启动读写goroutine
+	go ws.run(conn)
+
+	return ws, nil
+}
+
+// dialWebSocket 建立WebSocket连接
+func (c *Client) dialWebSocket(sessionID uint) (*websocket.Conn, error) {
 	// 解析WebSocket URL
 	u, err := url.Parse(c.config.BaseURL)
 	if err != nil {
@@ -57,57 +139,132 @@ func (c *Client) ConnectWebSocket() (*WSClient, error) {
 		u.Scheme = "wss"
 	}
 	u.Path = "/ws"
+	if sessionID > 0 {
+		u.RawQuery = url.Values{"session_id": {fmt.Sprintf("%d", sessionID)}}.Encode()
+	}
 
 	// 添加认证token
-	if c.config.AuthToken != "" {
-		header := make(map[string][]string)
-		header["Authorization"] = []string{"Bearer " + c.config.AuthToken}
-		dialer := websocket.Dialer{
-			HandshakeTimeout: c.config.Timeout,
-		}
+	if c.config.AuthToken == "" {
+		return nil, fmt.Errorf("authentication token required")
+	}
+	header := make(map[string][]string)
+	header["Authorization"] = []string{"Bearer " + c.config.AuthToken}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: c.config.Timeout,
+	}
 
-		// 建立连接
-		conn, _, err := dialer.Dial(u.String(), header)
-		if err != nil {
-			return nil, fmt.Errorf("dial websocket failed: %w", err)
+	// 建立连接
+	conn, _, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		return nil, fmt.Errorf("dial websocket failed: %w", err)
+	}
+	return conn, nil
+}
+
+// run 运行读写循环，连接异常断开后重连，直到关闭或重连失败
+func (ws *WSClient) run(conn *websocket.Conn) {
+	for {
+		connDone := make(chan struct{})
+		go ws.writePump(conn, connDone)
+		err := ws.readPump(conn)
+		close(connDone)
+		conn.Close()
+
+		select {
+		case <-ws.done:
+			return
+		default:
 		}
 
-		ws := &WSClient{
-			conn:    conn,
-			send:    make(chan []byte, 256),
-			receive: make(chan []byte, 256),
-			done:    make(chan struct{}),
-			config:  c.config,
-			heartbeatConfig: HeartbeatConfig{
-				MinInterval:     15 * time.Second,
-				MaxInterval:     60 * time.Second,
-				InitialInterval: 30 * time.Second,
-				AdjustFactor:    1.5,
-				RTTThreshold:    time.Second,
-			},
+		// 设备数超过上限被服务器断开时不重连，否则会挤掉其他设备
+		if websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			log.Printf("websocket closed by server: %v", err)
+			ws.close(false)
+			return
 		}
 
-		// 设置初始心跳间隔
-		ws.heartbeatStats.currentInterval = ws.heartbeatConfig.InitialInterval
+		conn = ws.reconnect()
+		if conn == nil {
+			ws.close(false)
+			return
+		}
+	}
+}
 
-		// 启动读写goroutine
-		go ws.readPump()
-		go ws.writePump()
+// reconnect 按指数退避重连并恢复会话，连接建立后先发送同步请求补齐断线期间的消息。
+// 重连失败或客户端已关闭时返回 nil
+func (ws *WSClient) reconnect() *websocket.Conn {
+	attempts := ws.config.ReconnectAttempts
+	if attempts == 0 {
+		attempts = defaultReconnectAttempts
+	}
 
-		return ws, nil
+	// 服务器重启导致的断开按服务器建议的时间重连，各客户端错开重连时间
+	delay := minReconnectDelay
+	ws.restartMu.Lock()
+	if ws.restarting {
+		delay = ws.restartDelay
+		ws.restarting = false
 	}
+	ws.restartMu.Unlock()
 
-	return nil, fmt.Errorf("authentication token required")
+	for attempt := 1; attempt <= attempts; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-ws.done:
+			return nil
+		}
+		if delay *= 2; delay < minReconnectDelay {
+			delay = minReconnectDelay
+		} else if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+
+		conn, err := ws.client.dialWebSocket(ws.SessionID())
+		if err != nil {
+			log.Printf("websocket reconnect attempt %d/%d failed: %v", attempt, attempts, err)
+			continue
+		}
+
+		// 写协程尚未启动，可以直接写入连接，保证同步请求和未确认的消息先于排队中的消息发送
+		if err := ws.writeSync(conn); err != nil {
+			log.Printf("websocket sync after reconnect failed: %v", err)
+			conn.Close()
+			continue
+		}
+		if err := ws.retransmit(conn); err != nil {
+			log.Printf("websocket retransmit after reconnect failed: %v", err)
+			conn.Close()
+			continue
+		}
+
+		ws.connMu.Lock()
+		ws.conn = conn
+		ws.connMu.Unlock()
+		log.Printf("websocket reconnected after %d attempt(s)", attempt)
+		return conn
+	}
+	return nil
 }
 
-// readPump 从WebSocket连接读取消息
-func (ws *WSClient) readPump() {
-	defer func() {
-		ws.Close()
-	}()
+// writeSync 在连接上发送同步请求，尚未收到任何消息时无需同步
+func (ws *WSClient) writeSync(conn *websocket.Conn) error {
+	req := ws.syncRequest()
+	if len(req.Sessions) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(messageRequest{Type: MessageTypeSync, Content: req})
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
 
+// readPump 从WebSocket连接读取消息，返回连接断开的原因
+func (ws *WSClient) readPump(conn *websocket.Conn) error {
 	// 设置pong处理器来计算RTT和调整心跳间隔
-	ws.conn.SetPongHandler(func(string) error {
+	conn.SetPongHandler(func(string) error {
 		ws.heartbeatStats.mutex.Lock()
 		defer ws.heartbeatStats.mutex.Unlock()
 
@@ -119,7 +276,7 @@ func (ws *WSClient) readPump() {
 		ws.adjustHeartbeatInterval(rtt)
 
 		// 重置读取超时（当前心跳间隔的1.5倍）
-		ws.conn.SetReadDeadline(time.Now().Add(time.Duration(float64(ws.heartbeatStats.currentInterval) * 1.5)))
+		conn.SetReadDeadline(time.Now().Add(time.Duration(float64(ws.heartbeatStats.currentInterval) * 1.5)))
 
 		if ws.config.Debug {
 			log.Printf("[DEBUG] Received pong, RTT: %v, new interval: %v", rtt, ws.heartbeatStats.currentInterval)
@@ -129,41 +286,55 @@ func (ws *WSClient) readPump() {
 	})
 
 	// 初始读取超时
-	ws.conn.SetReadDeadline(time.Now().Add(time.Second * 45))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 45))
 
 	for {
-		_, message, err := ws.conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("websocket read error: %v", err)
 			}
-			return
+			return err
 		}
 
 		// 重置读取超时
-		ws.conn.SetReadDeadline(time.Now().Add(time.Duration(float64(ws.heartbeatStats.currentInterval) * 1.5)))
+		conn.SetReadDeadline(time.Now().Add(time.Duration(float64(ws.heartbeatStats.currentInterval) * 1.5)))
+
+		var frame frameEnvelope
+		if err := json.Unmarshal(message, &frame); err == nil {
+			// 会话信息和消息确认由客户端自己处理，不交给调用方
+			if ws.handleSessionFrame(&frame) || ws.handleAck(&frame) {
+				continue
+			}
+			ws.handleError(&frame)
+			ws.handleRestart(&frame)
+			// 一次同步的消息数有上限，未同步完时继续同步
+			if ws.trackSeq(&frame) {
+				ws.Sync()
+			}
+		}
 
 		select {
 		case ws.receive <- message:
 		case <-ws.done:
-			return
+			return nil
 		}
 	}
 }
 
-// writePump 向WebSocket连接写入消息
-func (ws *WSClient) writePump() {
+// writePump 向WebSocket连接写入消息，写入失败时关闭连接使读协程退出
+func (ws *WSClient) writePump(conn *websocket.Conn, connDone <-chan struct{}) {
 	ticker := time.NewTicker(ws.heartbeatStats.currentInterval)
 	defer func() {
 		ticker.Stop()
-		ws.Close()
+		conn.Close()
 	}()
 
 	for {
 		select {
 		case message := <-ws.send:
-			ws.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
-			if err := ws.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				log.Printf("websocket write error: %v", err)
 				return
 			}
@@ -173,8 +344,8 @@ func (ws *WSClient) writePump() {
 			ws.heartbeatStats.lastPingTime = time.Now()
 			ws.heartbeatStats.mutex.Unlock()
 
-			ws.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
-			if err := ws.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				log.Printf("websocket ping error: %v", err)
 				return
 			}
@@ -182,6 +353,8 @@ func (ws *WSClient) writePump() {
 			if ws.config.Debug {
 				log.Printf("[DEBUG] Sent heartbeat ping with interval: %v", ws.heartbeatStats.currentInterval)
 			}
+		case <-connDone:
+			return
 		case <-ws.done:
 			return
 		}
@@ -207,20 +380,34 @@ func (ws *WSClient) adjustHeartbeatInterval(rtt time.Duration) {
 	}
 }
 
+// messageRequest 发送给服务器的消息格式
+type messageRequest struct {
+	Type        string      `json:"type"`
+	Content     interface{} `json:"content"`
+	Stream      bool        `json:"stream,omitempty"`
+	ClientMsgID string      `json:"client_msg_id,omitempty"` // 重发时保持不变，服务器据此去重
+}
+
 // Send 发送消息
 func (ws *WSClient) Send(msgType string, content interface{}) error {
-	msg := struct {
-		Type    string      `json:"type"`
-		Content interface{} `json:"content"`
-	}{
+	return ws.sendRequest(messageRequest{
 		Type:    msgType,
 		Content: content,
-	}
+	})
+}
 
+// sendRequest 序列化并发送消息。聊天消息附带 client_msg_id 并放入待确认队列，收到 ack 前重连会重发
+func (ws *WSClient) sendRequest(msg messageRequest) error {
+	if msg.Type != MessageTypeSync && !isEventType(msg.Type) && msg.ClientMsgID == "" {
+		msg.ClientMsgID = uuid.New().String()
+	}
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("marshal message failed: %w", err)
 	}
+	if msg.ClientMsgID != "" {
+		ws.addToOutbox(msg.ClientMsgID, data)
+	}
 
 	select {
 	case ws.send <- data:
@@ -259,10 +446,65 @@ func (ws *WSClient) Listen(handler MessageHandler) {
 	}
 }
 
-// Close 关闭连接
+// handleSessionFrame 记录服务器推送的会话ID，返回消息是否为会话信息
+func (ws *WSClient) handleSessionFrame(frame *frameEnvelope) bool {
+	if frame.Type != "session" {
+		return false
+	}
+	var session sessionInfo
+	if err := json.Unmarshal(frame.Content, &session); err != nil {
+		return false
+	}
+
+	ws.sessionMu.Lock()
+	ws.sessionID = session.SessionID
+	ws.sessionMu.Unlock()
+	if ws.config.Debug {
+		log.Printf("[DEBUG] Session %d (resumed: %v)", session.SessionID, session.Resumed)
+	}
+	return true
+}
+
+// handleRestart 记录服务器建议的重连等待时间，server_restarting 帧仍交给调用方处理
+func (ws *WSClient) handleRestart(frame *frameEnvelope) {
+	if frame.Type != MessageTypeServerRestarting {
+		return
+	}
+	var restart ServerRestarting
+	if err := json.Unmarshal(frame.Content, &restart); err != nil {
+		return
+	}
+
+	ws.restartMu.Lock()
+	ws.restartDelay = time.Duration(restart.ReconnectAfterMs) * time.Millisecond
+	ws.restarting = true
+	ws.restartMu.Unlock()
+	log.Printf("server is restarting, reconnecting in %v", ws.restartDelay)
+}
+
+// SessionID 返回当前会话ID，断线后传给 ResumeWebSocket 可恢复会话
+func (ws *WSClient) SessionID() uint {
+	ws.sessionMu.RLock()
+	defer ws.sessionMu.RUnlock()
+	return ws.sessionID
+}
+
+// Close 关闭连接且不再重连。发送正常关闭帧，服务器据此结束会话；未发送关闭帧的断开会保留会话以便恢复
 func (ws *WSClient) Close() {
+	ws.close(true)
+}
+
+// close 关闭连接，连接异常时不发送关闭帧
+func (ws *WSClient) close(graceful bool) {
 	ws.closeOnce.Do(func() {
 		close(ws.done)
+		ws.connMu.Lock()
+		defer ws.connMu.Unlock()
+		if graceful {
+			ws.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(time.Second))
+		}
 		ws.conn.Close()
 	})
 }
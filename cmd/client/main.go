@@ -8,7 +8,9 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/JennerWork/chatbot/client"
@@ -59,9 +61,9 @@ func main() {
 	if err != nil {
 		log.Printf("Failed to get message history: %v", err)
 	} else {
-		fmt.Println("\nRecent messages:")
+		fmt.Printf("\nRecent messages (%d unread):\n", result.UnreadCount)
 		for _, msg := range result.Messages {
-			fmt.Printf("[%s] %s: %s\n", msg.CreatedAt.String(), msg.Sender, msg.Content)
+			fmt.Printf("[%s] %s: %s\n", msg.CreatedAt.String(), msg.Sender, historyText(msg))
 		}
 		fmt.Println()
 	}
@@ -78,27 +80,99 @@ func main() {
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	// 启动消息接收goroutine
+	assembler := client.NewStreamAssembler()
+	var lastMessage atomic.Value
 	go func() {
 		ws.Listen(func(message []byte) {
+			// 流式回复逐段打印
+			if event, ok, err := assembler.Feed(message); ok {
+				if err != nil {
+					log.Printf("Failed to parse stream chunk: %v", err)
+					return
+				}
+				switch event.Type {
+				case client.MessageTypeStreamStart:
+					fmt.Print("\rReceived: ")
+				case client.MessageTypeStreamDelta:
+					fmt.Print(event.Delta)
+				case client.MessageTypeStreamEnd:
+					if !event.Started {
+						fmt.Printf("\rReceived: %s", event.Text)
+					}
+					fmt.Print("\n> ")
+				}
+				return
+			}
+
 			var msg struct {
 				Type    string          `json:"type"`
 				Content json.RawMessage `json:"content"`
+				Extra   json.RawMessage `json:"extra"`
 			}
 			if err := json.Unmarshal(message, &msg); err != nil {
 				log.Printf("Failed to parse message: %v", err)
 				return
 			}
 
+			// 重连后补发的消息按历史记录显示
 			switch msg.Type {
-			case "text":
-				var textMsg client.TextMessage
-				if err := json.Unmarshal(msg.Content, &textMsg); err != nil {
-					log.Printf("Failed to parse text message: %v", err)
+			case client.MessageTypeSyncMessage:
+				var synced client.Message
+				if err := json.Unmarshal(msg.Content, &synced); err != nil {
+					log.Printf("Failed to parse synced message: %v", err)
 					return
 				}
-				fmt.Printf("\rReceived: %s\n> ", textMsg.Text)
-			default:
+				fmt.Printf("\rSynced [%s]: %s\n> ", synced.Sender, historyText(synced))
+				markRead(ws, synced.SessionID, synced.Seq)
+				return
+			case client.MessageTypeSyncComplete, client.MessageTypeTypingStop, client.MessageTypeReadReceipt:
+				return
+			case client.MessageTypeServerRestarting:
+				fmt.Print("\rServer is restarting, reconnecting shortly...\n> ")
+				return
+			case client.MessageTypeError:
+				if serverErr, ok := client.ParseServerError(message); ok {
+					fmt.Printf("\rError: %s\n> ", serverErr.Message)
+				}
+				return
+			case client.MessageTypeTypingStart:
+				// 机器人回复很快，只提示客服正在输入
+				var typing client.TypingEvent
+				if err := json.Unmarshal(msg.Content, &typing); err == nil && typing.Sender == "agent" {
+					fmt.Print("\rAgent is typing...\n> ")
+				}
+				return
+			case client.MessageTypeEcho:
+				// 在其他设备上发送的消息
+				var echo client.EchoMessage
+				if err := json.Unmarshal(msg.Content, &echo); err != nil {
+					log.Printf("Failed to parse echo message: %v", err)
+					return
+				}
+				text := string(echo.Content)
+				if rendered, err := client.RenderMessage(echo.Type, echo.Content); err == nil {
+					text = rendered.String()
+				}
+				fmt.Printf("\rYou (other device): %s\n> ", text)
+				return
+			}
+
+			rendered, err := client.RenderMessage(msg.Type, msg.Content)
+			if err != nil {
 				fmt.Printf("\rReceived unknown message type: %s\n> ", msg.Type)
+				return
+			}
+			// 记住最近一条消息的选项，输入编号即可选择
+			lastMessage.Store(rendered)
+			fmt.Printf("\rReceived: %s\n> ", rendered.String())
+
+			// 已保存的消息在 extra 中附带序号，显示后标记为已读
+			var saved struct {
+				SessionID uint `json:"session_id"`
+				Seq       uint `json:"seq"`
+			}
+			if len(msg.Extra) > 0 && json.Unmarshal(msg.Extra, &saved) == nil {
+				markRead(ws, saved.SessionID, saved.Seq)
 			}
 		})
 	}()
@@ -106,7 +180,7 @@ func main() {
 	// 命令行交互
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Connected to chat server. Type your message and press Enter to send.")
-	fmt.Println("Type '/quit' to exit, '/history' to view message history.")
+	fmt.Println("Type '/quit' to exit, '/history' to view message history, '/upload <path> [caption]' to send a file.")
 	fmt.Print("> ")
 
 	for {
@@ -142,12 +216,28 @@ func main() {
 				} else {
 					fmt.Println("\nRecent messages:")
 					for _, msg := range result.Messages {
-						fmt.Printf("[%s] %s: %s\n", msg.CreatedAt.String(), msg.Sender, msg.Content)
+						fmt.Printf("[%s] %s: %s\n", msg.CreatedAt.String(), msg.Sender, historyText(msg))
 					}
 				}
 			default:
-				// 发送消息
-				if err := ws.SendText(input); err != nil {
+				// 上传并发送附件：/upload <path> [caption]
+				if strings.HasPrefix(input, "/upload ") {
+					sendAttachment(c, ws, strings.TrimSpace(strings.TrimPrefix(input, "/upload ")))
+					break
+				}
+
+				// 输入编号时选择最近一条消息的选项
+				if choice, ok := chooseOption(&lastMessage, input); ok {
+					if choice.URL != "" {
+						fmt.Printf("Open in your browser: %s\n", choice.URL)
+					} else if err := ws.SendPostback(choice.Payload, choice.Title); err != nil {
+						log.Printf("Failed to send postback: %v", err)
+					}
+					break
+				}
+
+				// 发送消息，回复以流式片段返回
+				if err := ws.SendTextStream(input); err != nil {
 					log.Printf("Failed to send message: %v", err)
 				}
 			}
@@ -155,3 +245,53 @@ func main() {
 		}
 	}
 }
+
+// markRead 更新会话的已读位置
+func markRead(ws *client.WSClient, sessionID uint, seq uint) {
+	if sessionID == 0 || seq == 0 {
+		return
+	}
+	if err := ws.MarkRead(sessionID, seq); err != nil {
+		log.Printf("Failed to mark messages as read: %v", err)
+	}
+}
+
+// sendAttachment 上传文件并以图片或文件消息发送
+func sendAttachment(c *client.Client, ws *client.WSClient, args string) {
+	path, caption, _ := strings.Cut(args, " ")
+	if path == "" {
+		fmt.Println("Usage: /upload <path> [caption]")
+		return
+	}
+
+	attachment, err := c.UploadAttachment(path)
+	if err != nil {
+		log.Printf("Failed to upload attachment: %v", err)
+		return
+	}
+	if err := ws.SendAttachment(attachment, strings.TrimSpace(caption)); err != nil {
+		log.Printf("Failed to send attachment: %v", err)
+	}
+}
+
+// chooseOption 将输入的编号解析为最近一条消息中的选项
+func chooseOption(lastMessage *atomic.Value, input string) (client.Choice, bool) {
+	number, err := strconv.Atoi(input)
+	if err != nil {
+		return client.Choice{}, false
+	}
+	rendered, ok := lastMessage.Load().(*client.RenderedMessage)
+	if !ok {
+		return client.Choice{}, false
+	}
+	return rendered.Choose(number)
+}
+
+// historyText 将历史消息渲染为文本
+func historyText(msg client.Message) string {
+	rendered, err := client.RenderMessage(msg.Type, json.RawMessage(msg.Content))
+	if err != nil {
+		return msg.Content
+	}
+	return rendered.String()
+}
@@ -0,0 +1,59 @@
+// seqrepair 检查消息序号的连续性，并可将不连续的会话重新编号
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/dao"
+	"github.com/JennerWork/chatbot/pkg/db"
+)
+
+func main() {
+	configPath := flag.String("config", "../../config.yaml", "配置文件路径")
+	sessionID := flag.Uint("session", 0, "只检查该会话，0 表示所有会话")
+	limit := flag.Int("limit", 1000, "最多报告的不连续位置数")
+	repair := flag.Bool("repair", false, "将不连续的会话重新编号")
+	flag.Parse()
+
+	if err := config.LoadConfig(*configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := db.Init(&config.GlobalConfig.Database); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	messageDAO := dao.NewMessageDAO(db.GetDB())
+
+	gaps, err := messageDAO.FindSeqGaps(uint(*sessionID), *limit)
+	if err != nil {
+		log.Fatalf("Failed to find sequence gaps: %v", err)
+	}
+	if len(gaps) == 0 {
+		log.Printf("No sequence gaps found")
+		return
+	}
+
+	sessions := make(map[uint]bool)
+	for _, gap := range gaps {
+		if gap.After == gap.Next {
+			log.Printf("Session %d: duplicate seq %d", gap.SessionID, gap.Next)
+		} else {
+			log.Printf("Session %d: gap %d -> %d", gap.SessionID, gap.After, gap.Next)
+		}
+		sessions[gap.SessionID] = true
+	}
+	log.Printf("%d gaps in %d sessions", len(gaps), len(sessions))
+
+	if !*repair {
+		log.Printf("Run with -repair to renumber these sessions")
+		return
+	}
+	for id := range sessions {
+		renumbered, err := messageDAO.RepairSessionSeq(id)
+		if err != nil {
+			log.Fatalf("Failed to repair session %d: %v", id, err)
+		}
+		log.Printf("Session %d repaired (%d messages renumbered)", id, renumbered)
+	}
+}
@@ -9,11 +9,17 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/JennerWork/chatbot/internal/broker"
 	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/flow"
 	"github.com/JennerWork/chatbot/internal/handler"
+	"github.com/JennerWork/chatbot/internal/intent"
+	"github.com/JennerWork/chatbot/internal/moderation"
 	"github.com/JennerWork/chatbot/internal/server"
 	"github.com/JennerWork/chatbot/internal/service"
+	"github.com/JennerWork/chatbot/internal/storage"
 	"github.com/JennerWork/chatbot/pkg/db"
+	"github.com/google/uuid"
 )
 
 // Run 启动应用程序
@@ -40,38 +46,128 @@ func Run(configPath string) error {
 	// 获取数据库连接
 	dbConn := db.GetDB()
 
-	// 创建消息服务
-	log.Printf("Initializing message service...")
-	msgService := service.NewMessageService(dbConn)
-	log.Printf("Message service initialized")
+	// 启动消息序号检查
+	seqMonitor := service.NewSeqMonitor(dbConn, config.GlobalConfig.Sequence)
+	seqMonitor.Start()
+	defer seqMonitor.Stop()
+
+	// 定期删除超出去重窗口的客户端消息ID
+	clientMessagePurger := service.NewClientMessagePurger(dbConn, config.GlobalConfig.Message.DedupeWindow)
+	clientMessagePurger.Start()
+	defer clientMessagePurger.Stop()
+
+	// 创建回复引擎
+	log.Printf("Initializing reply engine (%s)...", config.GlobalConfig.Reply.Engine)
+	replyEngine, err := service.NewReplyEngine(config.GlobalConfig.Reply)
+	if err != nil {
+		return fmt.Errorf("failed to initialize reply engine: %v", err)
+	}
+	log.Printf("Reply engine initialized")
+
+	// 加载意图定义
+	intents, err := loadIntents(config.GlobalConfig.Intent)
+	if err != nil {
+		return fmt.Errorf("failed to load intents: %v", err)
+	}
+	defer intents.Close()
+
+	// 加载知识库索引
+	log.Printf("Building knowledge base index...")
+	knowledgeService := service.NewKnowledgeService(dbConn, config.GlobalConfig.Knowledge)
+	if err := knowledgeService.Reindex(); err != nil {
+		return fmt.Errorf("failed to build knowledge base index: %v", err)
+	}
+
+	// 加载多轮对话流程
+	flows, err := loadFlows(config.GlobalConfig.Flow)
+	if err != nil {
+		return fmt.Errorf("failed to load flows: %v", err)
+	}
+
+	// 创建跨节点投递和在线登记
+	cluster, err := newCluster(config.GlobalConfig.Cluster)
+	if err != nil {
+		return fmt.Errorf("failed to initialize cluster: %v", err)
+	}
+	defer cluster.Broker.Close()
+	defer cluster.Presence.Close()
 
-	// 创建连接管理器
+	// 创建连接管理器，人工客服转接通过它推送消息
 	log.Printf("Creating connection manager...")
-	cm := server.NewConnectionManager(dbConn)
+	cm := server.NewConnectionManager(dbConn, config.GlobalConfig.WebSocket, cluster)
+	defer cm.Close()
 	log.Printf("Connection manager created")
 
+	// 创建个人信息保护服务，开启保险库时校验密钥
+	privacyService, err := service.NewPrivacyService(dbConn, config.GlobalConfig.Privacy)
+	if err != nil {
+		return fmt.Errorf("failed to initialize privacy service: %v", err)
+	}
+
+	// 创建人工客服排队队列，并恢复重启前的排队状态
+	routingQueue := server.NewRoutingQueue(dbConn, cm, config.GlobalConfig.Routing)
+	handoffService := service.NewHandoffService(dbConn, cm, routingQueue, privacyService)
+	if err := routingQueue.Start(handoffService); err != nil {
+		return fmt.Errorf("failed to start routing queue: %v", err)
+	}
+
+	// 创建附件服务
+	attachmentDir := config.ResolvePath(config.GlobalConfig.Attachment.Dir)
+	if attachmentDir == "" {
+		attachmentDir = config.ResolvePath("data/attachments")
+	}
+	blobStore, err := storage.NewFSBlobStore(attachmentDir)
+	if err != nil {
+		return fmt.Errorf("failed to initialize attachment storage: %v", err)
+	}
+	attachmentService := service.NewAttachmentService(dbConn, blobStore, config.GlobalConfig.Attachment)
+	log.Printf("Attachments are stored in %s", attachmentDir)
+
+	// 创建内容审核服务，未开启审核时仍提供审核记录的复核
+	moderator, err := moderation.NewLocalModerator(config.GlobalConfig.Moderation)
+	if err != nil {
+		return fmt.Errorf("failed to initialize moderation: %v", err)
+	}
+	moderationService := service.NewModerationService(dbConn, moderator, privacyService, config.GlobalConfig.Moderation)
+
+	// 创建消息服务
+	log.Printf("Initializing message service...")
+	chatService, err := service.NewChatService(dbConn, service.ChatOptions{
+		ReplyEngine:   replyEngine,
+		Intents:       intents,
+		Knowledge:     knowledgeService,
+		Flows:         flows,
+		Handoff:       handoffService,
+		HandoffConfig: config.GlobalConfig.Handoff,
+		Privacy:       privacyService,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize chat service: %v", err)
+	}
+	msgService := service.NewMessageService(dbConn, chatService, handoffService, attachmentService, moderationService, privacyService, config.GlobalConfig.Message)
+	log.Printf("Message service initialized")
+
 	// 创建消息处理器
 	log.Printf("Setting up WebSocket handlers...")
-	handlers := handler.NewWebSocketHandler(msgService)
+	eventService := service.NewEventService(dbConn, cm)
+	handlers := handler.NewWebSocketHandler(msgService, service.NewSyncService(dbConn), eventService)
+	// 聊天消息拦截器，按注册顺序执行：审计最先执行以记录被拒绝的消息
+	if config.GlobalConfig.Message.Audit {
+		handlers.Use(handler.NewAuditInterceptor())
+	}
+	handlers.Use(
+		handler.NewSizeLimitInterceptor(config.GlobalConfig.Message.MaxSize),
+		handler.NewProfanityInterceptor(config.GlobalConfig.Message.ProfanityWords),
+	)
+	agentHandlers := handler.NewAgentHandler(handoffService, eventService)
 	log.Printf("WebSocket handlers initialized")
 
 	// 创建HTTP服务器
 	log.Printf("Creating HTTP server...")
 	srv := server.NewServer()
-	srv.SetupRoutes(dbConn, handlers, cm)
+	srv.SetupRoutes(dbConn, handlers, agentHandlers, cm, knowledgeService, attachmentService, moderationService, privacyService)
 	log.Printf("HTTP server created and routes configured")
 
-	// 启动定期清理不活跃连接的goroutine
-	log.Printf("Starting inactive connection cleanup routine...")
-	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-			log.Printf("Running cleanup of inactive connections...")
-			cm.CleanInactiveConnections(30 * time.Minute)
-		}
-	}()
-
 	// 启动HTTP服务器
 	log.Printf("Starting HTTP server on port %d...", config.GlobalConfig.App.Port)
 	go func() {
@@ -92,6 +188,10 @@ func Run(configPath string) error {
 
 	log.Println("Received shutdown signal, initiating graceful shutdown...")
 
+	// 先排空WebSocket连接：http.Server.Shutdown 不会处理已升级的连接
+	log.Println("Draining WebSocket connections...")
+	cm.Drain(context.Background())
+
 	// 设置关闭超时时间
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -105,3 +205,78 @@ func Run(configPath string) error {
 	log.Println("Server shutdown completed successfully")
 	return nil
 }
+
+// newCluster 按配置创建跨节点投递方式和在线登记
+func newCluster(cfg config.ClusterConfig) (server.Cluster, error) {
+	nodeID := cfg.NodeID
+	if nodeID == "" {
+		nodeID = uuid.New().String()
+	}
+
+	switch cfg.Broker {
+	case "", "memory":
+		log.Printf("Running as a single node %s", nodeID)
+		return server.Cluster{
+			NodeID:   nodeID,
+			Broker:   broker.NewMemoryBroker(),
+			Presence: broker.NewMemoryPresence(),
+		}, nil
+	case "postgres":
+		channel := cfg.Channel
+		if channel == "" {
+			channel = "chatbot_delivery"
+		}
+		b, err := broker.NewPostgresBroker(db.GetDB(), config.GlobalConfig.Database.DSN(), channel)
+		if err != nil {
+			return server.Cluster{}, err
+		}
+		log.Printf("Node %s listening for deliveries on channel %s", nodeID, channel)
+		return server.Cluster{
+			NodeID:   nodeID,
+			Broker:   b,
+			Presence: broker.NewPostgresPresence(db.GetDB(), nodeID, cfg.PresenceTTL),
+		}, nil
+	default:
+		return server.Cluster{}, fmt.Errorf("unknown cluster broker: %s", cfg.Broker)
+	}
+}
+
+// loadIntents 加载意图定义，按配置开启热加载
+func loadIntents(cfg config.IntentConfig) (*intent.Engine, error) {
+	if cfg.File == "" {
+		log.Printf("No intent file configured, using built-in intents")
+		return intent.NewEngine(intent.DefaultDefinition())
+	}
+
+	path := config.ResolvePath(cfg.File)
+	log.Printf("Loading intents from %s...", path)
+	engine, err := intent.NewEngineFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Watch {
+		if err := engine.Watch(); err != nil {
+			return nil, err
+		}
+		log.Printf("Watching %s for intent changes", path)
+	}
+	return engine, nil
+}
+
+// loadFlows 加载内置流程和配置目录中的流程定义
+func loadFlows(cfg config.FlowConfig) (*flow.Engine, error) {
+	engine, err := flow.NewEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Dir != "" {
+		dir := config.ResolvePath(cfg.Dir)
+		log.Printf("Loading flows from %s...", dir)
+		if err := engine.LoadDir(dir); err != nil {
+			return nil, err
+		}
+	}
+	return engine, nil
+}
@@ -0,0 +1,35 @@
+package broker
+
+import "encoding/json"
+
+// Target 投递目标类型
+type Target string
+
+const (
+	TargetCustomer Target = "customer" // 客户的所有连接，ID 为客户ID
+	TargetSession  Target = "session"  // 关联某个会话的客户连接，ID 为会话ID
+	TargetAgent    Target = "agent"    // 客服的连接，ID 为客服ID
+	TargetAgents   Target = "agents"   // 所有在线客服，ID 为 0
+)
+
+// Delivery 一次跨节点投递
+type Delivery struct {
+	Target Target          `json:"target"`
+	ID     uint            `json:"id"`
+	Frame  json.RawMessage `json:"frame"`            // 推送给连接的帧
+	Origin string          `json:"origin"`           // 发布投递的节点ID
+	Except string          `json:"except,omitempty"` // 不投递的连接ID，如回显时发送消息的连接
+}
+
+// Handler 处理收到的投递
+type Handler func(d Delivery)
+
+// Broker 在集群节点之间分发投递。每个节点的连接管理器订阅投递，并推送给本节点上的匹配连接
+type Broker interface {
+	// Publish 发布投递，所有节点（包括发布者自己）的订阅者都会收到
+	Publish(d Delivery) error
+	// Subscribe 注册投递处理函数
+	Subscribe(h Handler)
+	// Close 停止接收投递并释放资源
+	Close() error
+}
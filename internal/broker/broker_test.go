@@ -0,0 +1,191 @@
+package broker
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDSNEnv 测试使用的 PostgreSQL 连接串，未设置时跳过需要数据库的测试
+const testDSNEnv = "CHATBOT_TEST_DSN"
+
+func TestNotificationRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		delivery Delivery
+		wantJSON string
+	}{
+		{"customer", Delivery{Target: TargetCustomer, ID: 1, Frame: json.RawMessage(`{"type":"text"}`), Origin: "node-a"},
+			`{"target":"customer","id":1,"frame":{"type":"text"},"origin":"node-a"}`},
+		{"except the sending connection", Delivery{Target: TargetSession, ID: 7, Frame: json.RawMessage(`{}`), Origin: "node-a", Except: "conn-1"},
+			`{"target":"session","id":7,"frame":{},"origin":"node-a","except":"conn-1"}`},
+		{"all agents", Delivery{Target: TargetAgents, Frame: json.RawMessage(`[1,2]`), Origin: "node-b"},
+			`{"target":"agents","id":0,"frame":[1,2],"origin":"node-b"}`},
+	}
+	b := &PostgresBroker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := json.Marshal(notification{Delivery: tt.delivery})
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			// 载荷未写入 broker_payloads 时不带 ref
+			if string(payload) != tt.wantJSON {
+				t.Errorf("payload = %s, want %s", payload, tt.wantJSON)
+			}
+			got, err := b.decode(string(payload))
+			if err != nil {
+				t.Fatalf("decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.delivery) {
+				t.Errorf("decode() = %+v, want %+v", got, tt.delivery)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	b := &PostgresBroker{}
+	for _, payload := range []string{"", "not json", `{"id":"1"}`} {
+		if _, err := b.decode(payload); err == nil {
+			t.Errorf("decode(%q) succeeded, want error", payload)
+		}
+	}
+}
+
+func TestDecodeStoredPayload(t *testing.T) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testDSNEnv)
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Failed to connect database: %v", err)
+	}
+	b := &PostgresBroker{db: conn}
+
+	// 超过 NOTIFY 上限的投递保存在 broker_payloads 中，通知只携带记录ID
+	want := Delivery{Target: TargetCustomer, ID: 1, Origin: "node-a",
+		Frame: json.RawMessage(`{"content":"` + strings.Repeat("a", maxNotifyPayload) + `"}`)}
+	stored, err := json.Marshal(notification{Delivery: want})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var ref int64
+	if err := conn.Raw("INSERT INTO broker_payloads (payload) VALUES (?) RETURNING id", string(stored)).
+		Scan(&ref).Error; err != nil {
+		t.Fatalf("Failed to store payload: %v", err)
+	}
+	t.Cleanup(func() { conn.Exec("DELETE FROM broker_payloads WHERE id = ?", ref) })
+
+	notified, _ := json.Marshal(notification{Ref: ref})
+	got, err := b.decode(string(notified))
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decode() target %s id %d, want %s id %d", got.Target, got.ID, want.Target, want.ID)
+	}
+
+	// 记录已被清理时返回错误
+	missing, _ := json.Marshal(notification{Ref: ref + 1<<40})
+	if _, err := b.decode(string(missing)); err == nil {
+		t.Error("decode() of a missing payload succeeded, want error")
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	b := NewMemoryBroker()
+	var first, second []uint
+	b.Subscribe(func(d Delivery) { first = append(first, d.ID) })
+	b.Subscribe(func(d Delivery) { second = append(second, d.ID) })
+
+	for _, id := range []uint{1, 2} {
+		if err := b.Publish(Delivery{Target: TargetCustomer, ID: id}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	// 发布者自己的订阅者也会同步收到投递
+	want := []uint{1, 2}
+	if !reflect.DeepEqual(first, want) || !reflect.DeepEqual(second, want) {
+		t.Errorf("received %v and %v, want %v", first, second, want)
+	}
+}
+
+func TestMemoryPresence(t *testing.T) {
+	r := NewMemoryPresence()
+	for _, p := range []Presence{
+		{ConnectionID: "phone", UserID: 1, SessionID: 100},
+		{ConnectionID: "laptop", UserID: 1},
+		{ConnectionID: "agent-a", UserID: 1, IsAgent: true},
+		{ConnectionID: "agent-a2", UserID: 1, IsAgent: true},
+		{ConnectionID: "agent-b", UserID: 2, IsAgent: true},
+	} {
+		if err := r.Add(p); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	connectionIDs := func(userID uint, isAgent bool) []string {
+		connections, err := r.Connections(userID, isAgent)
+		if err != nil {
+			t.Fatalf("Connections failed: %v", err)
+		}
+		var ids []string
+		for _, p := range connections {
+			ids = append(ids, p.ConnectionID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	inSession := func(sessionID uint) bool {
+		ok, err := r.InSession(sessionID)
+		if err != nil {
+			t.Fatalf("InSession failed: %v", err)
+		}
+		return ok
+	}
+	onlineAgents := func() []uint {
+		ids, err := r.OnlineAgents()
+		if err != nil {
+			t.Fatalf("OnlineAgents failed: %v", err)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+
+	// 客户ID和客服ID相同时互不影响
+	if got, want := connectionIDs(1, false), []string{"laptop", "phone"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("customer connections = %v, want %v", got, want)
+	}
+	if got, want := connectionIDs(1, true), []string{"agent-a", "agent-a2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("agent connections = %v, want %v", got, want)
+	}
+	if !inSession(100) || inSession(101) {
+		t.Errorf("InSession(100), InSession(101) = %v, %v, want true, false", inSession(100), inSession(101))
+	}
+	// 客服有多个连接时只返回一次
+	if got, want := onlineAgents(), []uint{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OnlineAgents() = %v, want %v", got, want)
+	}
+
+	if err := r.Remove("phone"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if inSession(100) {
+		t.Error("InSession(100) = true after the connection was removed")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := onlineAgents(); len(got) != 0 {
+		t.Errorf("OnlineAgents() = %v after Close, want none", got)
+	}
+}
@@ -0,0 +1,38 @@
+package broker
+
+import "sync"
+
+// MemoryBroker 进程内的 Broker 实现，用于单节点部署
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+// NewMemoryBroker 创建进程内 Broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+// Publish 同步调用所有处理函数
+func (b *MemoryBroker) Publish(d Delivery) error {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(d)
+	}
+	return nil
+}
+
+// Subscribe 注册投递处理函数
+func (b *MemoryBroker) Subscribe(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+// Close 进程内实现无需释放资源
+func (b *MemoryBroker) Close() error {
+	return nil
+}
@@ -0,0 +1,225 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxNotifyPayload NOTIFY 载荷上限为 8000 字节，超出的投递先写入 broker_payloads 表，通知中只携带记录ID
+	maxNotifyPayload = 7900
+	// payloadRetention broker_payloads 中记录的保留时间
+	payloadRetention = 5 * time.Minute
+
+	minListenRetry = time.Second
+	maxListenRetry = 30 * time.Second
+)
+
+// notification NOTIFY 载荷，Ref 不为 0 时投递保存在 broker_payloads 表中
+type notification struct {
+	Delivery
+	Ref int64 `json:"ref,omitempty"`
+}
+
+// PostgresBroker 基于 PostgreSQL LISTEN/NOTIFY 的 Broker 实现。
+// 监听连接断开后会自动重连，重连期间的投递会丢失，客户端通过增量同步补齐
+type PostgresBroker struct {
+	db      *gorm.DB
+	dsn     string
+	channel string
+
+	mu       sync.RWMutex
+	handlers []Handler
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPostgresBroker 创建 PostgreSQL Broker 并开始监听 channel
+func NewPostgresBroker(db *gorm.DB, dsn string, channel string) (*PostgresBroker, error) {
+	if channel == "" {
+		return nil, errors.New("broker channel is required")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &PostgresBroker{
+		db:      db,
+		dsn:     dsn,
+		channel: channel,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	// 首次连接失败时直接返回错误，避免节点在收不到投递的情况下启动
+	conn, err := b.listen(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go b.run(ctx, conn)
+	return b, nil
+}
+
+// Publish 通过 pg_notify 发布投递
+func (b *PostgresBroker) Publish(d Delivery) error {
+	payload, err := json.Marshal(notification{Delivery: d})
+	if err != nil {
+		return fmt.Errorf("marshal delivery failed: %w", err)
+	}
+
+	if len(payload) > maxNotifyPayload {
+		var ref int64
+		if err := b.db.Raw("INSERT INTO broker_payloads (payload) VALUES (?) RETURNING id", string(payload)).
+			Scan(&ref).Error; err != nil {
+			return fmt.Errorf("store delivery payload failed: %w", err)
+		}
+		if payload, err = json.Marshal(notification{Ref: ref}); err != nil {
+			return err
+		}
+	}
+
+	return b.db.Exec("SELECT pg_notify(?, ?)", b.channel, string(payload)).Error
+}
+
+// Subscribe 注册投递处理函数
+func (b *PostgresBroker) Subscribe(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, h)
+}
+
+// Close 停止监听
+func (b *PostgresBroker) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+// listen 建立监听连接
+func (b *PostgresBroker) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connect listener failed: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("listen on %s failed: %w", b.channel, err)
+	}
+	return conn, nil
+}
+
+// run 接收通知，连接断开后按指数退避重连
+func (b *PostgresBroker) run(ctx context.Context, conn *pgx.Conn) {
+	defer close(b.done)
+
+	cleanup := time.NewTicker(payloadRetention)
+	defer cleanup.Stop()
+	go func() {
+		for {
+			select {
+			case <-cleanup.C:
+				b.deleteExpiredPayloads()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	retry := minListenRetry
+	for {
+		if conn != nil {
+			retry = minListenRetry
+			b.receive(ctx, conn)
+			conn.Close(context.Background())
+			conn = nil
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		if retry *= 2; retry > maxListenRetry {
+			retry = maxListenRetry
+		}
+
+		var err error
+		if conn, err = b.listen(ctx); err != nil {
+			log.Printf("Broker listener reconnect failed: %v", err)
+			continue
+		}
+		log.Printf("Broker listener reconnected to channel %s", b.channel)
+	}
+}
+
+// receive 持续接收通知直到连接出错
+func (b *PostgresBroker) receive(ctx context.Context, conn *pgx.Conn) {
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Broker listener error: %v", err)
+			}
+			return
+		}
+
+		d, err := b.decode(n.Payload)
+		if err != nil {
+			log.Printf("Failed to decode delivery: %v", err)
+			continue
+		}
+		b.dispatch(d)
+	}
+}
+
+// decode 解析通知载荷，必要时从 broker_payloads 表读取投递
+func (b *PostgresBroker) decode(payload string) (Delivery, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return Delivery{}, err
+	}
+	if n.Ref == 0 {
+		return n.Delivery, nil
+	}
+
+	var stored string
+	if err := b.db.Raw("SELECT payload FROM broker_payloads WHERE id = ?", n.Ref).Scan(&stored).Error; err != nil {
+		return Delivery{}, err
+	}
+	if stored == "" {
+		return Delivery{}, fmt.Errorf("delivery payload %d not found", n.Ref)
+	}
+	if err := json.Unmarshal([]byte(stored), &n); err != nil {
+		return Delivery{}, err
+	}
+	return n.Delivery, nil
+}
+
+// dispatch 调用所有处理函数
+func (b *PostgresBroker) dispatch(d Delivery) {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(d)
+	}
+}
+
+// deleteExpiredPayloads 删除已过保留时间的大载荷记录
+func (b *PostgresBroker) deleteExpiredPayloads() {
+	if err := b.db.Exec("DELETE FROM broker_payloads WHERE created_at < ?", time.Now().Add(-payloadRetention)).Error; err != nil {
+		log.Printf("Failed to delete expired delivery payloads: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package broker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Presence 集群中一个在线连接
+type Presence struct {
+	ConnectionID string    `gorm:"primaryKey;size:64" json:"connection_id"`
+	NodeID       string    `gorm:"size:64;not null;index" json:"node_id"`
+	UserID       uint      `gorm:"not null;index:idx_presence_user" json:"user_id"` // 客户ID或客服ID
+	IsAgent      bool      `gorm:"not null;index:idx_presence_user" json:"is_agent"`
+	SessionID    uint      `gorm:"index" json:"session_id,omitempty"` // 客户连接关联的会话，客服连接为 0
+	ConnectedAt  time.Time `gorm:"not null" json:"connected_at"`
+	HeartbeatAt  time.Time `gorm:"not null;index" json:"heartbeat_at"` // 所在节点最后一次续期的时间
+}
+
+// TableName 指定表名
+func (Presence) TableName() string {
+	return "presence"
+}
+
+// PresenceRegistry 集群范围的在线连接登记
+type PresenceRegistry interface {
+	// Add 登记连接
+	Add(p Presence) error
+	// Remove 注销连接
+	Remove(connectionID string) error
+	// Connections 返回用户在所有节点上的连接
+	Connections(userID uint, isAgent bool) ([]Presence, error)
+	// InSession 是否有连接关联该会话
+	InSession(sessionID uint) (bool, error)
+	// OnlineAgents 返回在任一节点在线的客服ID
+	OnlineAgents() ([]uint, error)
+	// Close 注销本节点的所有连接
+	Close() error
+}
+
+// MemoryPresence 进程内的在线登记，用于单节点部署
+type MemoryPresence struct {
+	mu          sync.RWMutex
+	connections map[string]Presence
+}
+
+// NewMemoryPresence 创建进程内在线登记
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{
+		connections: make(map[string]Presence),
+	}
+}
+
+// Add 登记连接
+func (r *MemoryPresence) Add(p Presence) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connections[p.ConnectionID] = p
+	return nil
+}
+
+// Remove 注销连接
+func (r *MemoryPresence) Remove(connectionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.connections, connectionID)
+	return nil
+}
+
+// Connections 返回用户的连接
+func (r *MemoryPresence) Connections(userID uint, isAgent bool) ([]Presence, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var result []Presence
+	for _, p := range r.connections {
+		if p.UserID == userID && p.IsAgent == isAgent {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// InSession 是否有连接关联该会话
+func (r *MemoryPresence) InSession(sessionID uint) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.connections {
+		if p.SessionID == sessionID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// OnlineAgents 返回在线客服ID
+func (r *MemoryPresence) OnlineAgents() ([]uint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, p := range r.connections {
+		if p.IsAgent && !seen[p.UserID] {
+			seen[p.UserID] = true
+			ids = append(ids, p.UserID)
+		}
+	}
+	return ids, nil
+}
+
+// Close 清空登记
+func (r *MemoryPresence) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connections = make(map[string]Presence)
+	return nil
+}
+
+// PostgresPresence 基于 presence 表的在线登记。每个节点定期续期自己登记的连接，
+// 超过 ttl 未续期的记录（如节点崩溃后遗留的）视为离线并被清理
+type PostgresPresence struct {
+	db     *gorm.DB
+	nodeID string
+	ttl    time.Duration
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewPostgresPresence 创建 PostgreSQL 在线登记，并开始续期本节点的记录
+func NewPostgresPresence(db *gorm.DB, nodeID string, ttl time.Duration) *PostgresPresence {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+
+	r := &PostgresPresence{
+		db:     db,
+		nodeID: nodeID,
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.heartbeatLoop()
+	return r
+}
+
+// Add 登记连接
+func (r *PostgresPresence) Add(p Presence) error {
+	p.NodeID = r.nodeID
+	p.HeartbeatAt = time.Now()
+	return r.db.Save(&p).Error
+}
+
+// Remove 注销连接
+func (r *PostgresPresence) Remove(connectionID string) error {
+	return r.db.Delete(&Presence{}, "connection_id = ?", connectionID).Error
+}
+
+// Connections 返回用户在所有节点上的连接
+func (r *PostgresPresence) Connections(userID uint, isAgent bool) ([]Presence, error) {
+	var result []Presence
+	err := r.db.Where("user_id = ? AND is_agent = ? AND heartbeat_at > ?", userID, isAgent, r.cutoff()).
+		Find(&result).Error
+	return result, err
+}
+
+// InSession 是否有连接关联该会话
+func (r *PostgresPresence) InSession(sessionID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&Presence{}).
+		Where("session_id = ? AND heartbeat_at > ?", sessionID, r.cutoff()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// OnlineAgents 返回在任一节点在线的客服ID
+func (r *PostgresPresence) OnlineAgents() ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&Presence{}).
+		Where("is_agent = ? AND heartbeat_at > ?", true, r.cutoff()).
+		Distinct().Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+// Close 停止续期并注销本节点的所有连接
+func (r *PostgresPresence) Close() error {
+	close(r.stop)
+	<-r.done
+	return r.db.Delete(&Presence{}, "node_id = ?", r.nodeID).Error
+}
+
+// cutoff 早于该时间续期的记录视为离线
+func (r *PostgresPresence) cutoff() time.Time {
+	return time.Now().Add(-r.ttl)
+}
+
+// heartbeatLoop 定期续期本节点的记录并清理过期记录
+func (r *PostgresPresence) heartbeatLoop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.db.Model(&Presence{}).Where("node_id = ?", r.nodeID).
+				Update("heartbeat_at", time.Now()).Error; err != nil {
+				log.Printf("Failed to renew presence of node %s: %v", r.nodeID, err)
+			}
+			if err := r.db.Delete(&Presence{}, "heartbeat_at < ?", r.cutoff()).Error; err != nil {
+				log.Printf("Failed to delete expired presence: %v", err)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
@@ -2,13 +2,28 @@ package config
 
 import (
 	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Database DatabaseConfig `mapstructure:"database"`
-	App      AppConfig      `mapstructure:"app"`
+	Database   DatabaseConfig   `mapstructure:"database"`
+	App        AppConfig        `mapstructure:"app"`
+	Reply      ReplyConfig      `mapstructure:"reply"`
+	Intent     IntentConfig     `mapstructure:"intent"`
+	Knowledge  KnowledgeConfig  `mapstructure:"knowledge"`
+	Flow       FlowConfig       `mapstructure:"flow"`
+	Handoff    HandoffConfig    `mapstructure:"handoff"`
+	Routing    RoutingConfig    `mapstructure:"routing"`
+	Attachment AttachmentConfig `mapstructure:"attachment"`
+	WebSocket  WebSocketConfig  `mapstructure:"websocket"`
+	Cluster    ClusterConfig    `mapstructure:"cluster"`
+	Sequence   SequenceConfig   `mapstructure:"sequence"`
+	Message    MessageConfig    `mapstructure:"message"`
+	Moderation ModerationConfig `mapstructure:"moderation"`
+	Privacy    PrivacyConfig    `mapstructure:"privacy"`
 }
 
 type AppConfig struct {
@@ -26,6 +41,133 @@ type DatabaseConfig struct {
 	SSLMode  string `mapstructure:"sslmode"`
 }
 
+// ReplyConfig 回复引擎配置
+type ReplyConfig struct {
+	Engine string    `mapstructure:"engine"` // 回复引擎：echo 或 llm
+	LLM    LLMConfig `mapstructure:"llm"`
+}
+
+// LLMConfig OpenAI兼容的大模型接口配置
+type LLMConfig struct {
+	Endpoint     string        `mapstructure:"endpoint"`      // 完整的 /v1/chat/completions 地址
+	Model        string        `mapstructure:"model"`         // 模型名称
+	APIKey       string        `mapstructure:"api_key"`       // API密钥，可为空
+	Timeout      time.Duration `mapstructure:"timeout"`       // 请求超时时间
+	SystemPrompt string        `mapstructure:"system_prompt"` // 系统提示词
+	HistoryLimit int           `mapstructure:"history_limit"` // 携带的历史消息条数
+}
+
+// IntentConfig 意图识别配置
+type IntentConfig struct {
+	File  string `mapstructure:"file"`  // 意图定义文件，相对路径以配置文件所在目录为准；为空时使用内置定义
+	Watch bool   `mapstructure:"watch"` // 是否监听文件变化并热加载
+}
+
+// KnowledgeConfig 知识库检索配置
+type KnowledgeConfig struct {
+	Threshold      float64 `mapstructure:"threshold"`       // 置信度阈值（0~1），低于阈值时不使用知识库回答
+	SuggestRatio   float64 `mapstructure:"suggest_ratio"`   // 得分不低于最佳结果该比例的文章会列入"您是不是想问"
+	MaxSuggestions int     `mapstructure:"max_suggestions"` // "您是不是想问"最多列出的文章数
+}
+
+// FlowConfig 多轮对话流程配置
+type FlowConfig struct {
+	Dir string `mapstructure:"dir"` // 流程定义目录，相对路径以配置文件所在目录为准；为空时只使用内置流程
+}
+
+// HandoffConfig 转人工配置
+type HandoffConfig struct {
+	FallbackThreshold int  `mapstructure:"fallback_threshold"` // 机器人连续无法回答多少次后转人工，0 表示不启用
+	NegativeSentiment bool `mapstructure:"negative_sentiment"` // 客户情绪明显负面时是否转人工
+}
+
+// RoutingConfig 人工客服排队分配配置
+type RoutingConfig struct {
+	Strategy             string        `mapstructure:"strategy"`               // 分配策略：round_robin 或 least_busy
+	DefaultMaxConcurrent int           `mapstructure:"default_max_concurrent"` // 客服档案未设置时最多同时接待的会话数
+	Interval             time.Duration `mapstructure:"interval"`               // 定期尝试分配的间隔
+}
+
+// AttachmentConfig 附件上传配置
+type AttachmentConfig struct {
+	Dir          string   `mapstructure:"dir"`           // 附件存储目录，相对路径以配置文件所在目录为准
+	MaxSize      int64    `mapstructure:"max_size"`      // 单个附件的最大字节数
+	AllowedTypes []string `mapstructure:"allowed_types"` // 允许的MIME类型，如 image/png；image/* 表示所有图片
+}
+
+// WebSocketConfig 客户WebSocket连接配置
+type WebSocketConfig struct {
+	ResumeGrace    time.Duration `mapstructure:"resume_grace"`                 // 断线后在该时间内携带 session_id 重连可以恢复原会话
+	MaxConnections int           `mapstructure:"max_connections_per_customer"` // 每个客户同时在线的最大连接数，超出时断开最早的连接
+	PingInterval   time.Duration `mapstructure:"ping_interval"`                // 服务器向每个连接发送 ping 的间隔
+	PongTimeout    time.Duration `mapstructure:"pong_timeout"`                 // 超过该时间未收到任何数据（包括 pong）时判定连接已断开，需大于 ping_interval
+	WriteTimeout   time.Duration `mapstructure:"write_timeout"`                // 单次写入的超时时间
+	IdleTimeout    time.Duration `mapstructure:"idle_timeout"`                 // 客户连接超过该时间未发送消息时断开，会话转为不活跃；0 表示不断开
+	ReapInterval   time.Duration `mapstructure:"reap_interval"`                // 批量写入会话最后活动时间并清理空闲连接的间隔
+	DrainTimeout   time.Duration `mapstructure:"drain_timeout"`                // 关闭时等待处理中的消息完成的最长时间
+	ReconnectHint  time.Duration `mapstructure:"reconnect_hint"`               // 关闭时建议客户端在该时间内随机等待后重连
+
+	SendQueueSize     int           `mapstructure:"send_queue_size"`     // 每个连接发送队列的容量
+	SendTimeout       time.Duration `mapstructure:"send_timeout"`        // 队列满时聊天消息等待空位的最长时间，超时断开连接
+	SlowConsumerDrops int           `mapstructure:"slow_consumer_drops"` // 队列清空前最多丢弃的临时帧（输入状态等）数，超出时断开连接
+
+	Workers         int           `mapstructure:"workers"`           // 处理消息的协程数，同一会话的消息按顺序处理，不同会话并行处理
+	WorkerQueueSize int           `mapstructure:"worker_queue_size"` // 等待处理的消息上限，超出时回复 server_busy
+	HandleTimeout   time.Duration `mapstructure:"handle_timeout"`    // 单条消息的处理超时时间
+}
+
+// ClusterConfig 多节点部署配置
+type ClusterConfig struct {
+	Broker      string        `mapstructure:"broker"`       // 节点间投递方式：memory（单节点，默认）或 postgres（LISTEN/NOTIFY）
+	Channel     string        `mapstructure:"channel"`      // postgres 通知频道
+	NodeID      string        `mapstructure:"node_id"`      // 节点ID，为空时启动时随机生成
+	PresenceTTL time.Duration `mapstructure:"presence_ttl"` // 节点超过该时间未续期，其在线连接视为离线
+}
+
+// SequenceConfig 消息序号检查配置
+type SequenceConfig struct {
+	CheckInterval time.Duration `mapstructure:"check_interval"` // 检查新消息所在会话序号连续性的间隔，0 表示不检查
+	AutoRepair    bool          `mapstructure:"auto_repair"`    // 发现不连续时自动重新编号
+	CheckLookback time.Duration `mapstructure:"check_lookback"` // 每次检查都重新检查该时间内写入过消息的会话，覆盖提交较晚的事务，默认 5m
+}
+
+// MessageConfig 消息处理配置
+type MessageConfig struct {
+	DedupeWindow   time.Duration `mapstructure:"dedupe_window"`   // 在该时间内以相同 client_msg_id 重发的消息只确认不处理
+	MaxSize        int           `mapstructure:"max_size"`        // 消息内容的最大字节数，超出时拒绝
+	ProfanityWords []string      `mapstructure:"profanity_words"` // 客户文本消息中以 * 屏蔽的词，英文按整词匹配
+	Audit          bool          `mapstructure:"audit"`           // 是否在日志中记录每条聊天消息的类型、大小和处理结果（不含内容）
+}
+
+// ModerationConfig 内容审核配置
+type ModerationConfig struct {
+	Enabled  bool                `mapstructure:"enabled"`  // 是否审核客户消息
+	Outbound bool                `mapstructure:"outbound"` // 是否同时审核机器人回复
+	Actions  map[string]string   `mapstructure:"actions"`  // 分类 -> 命中后的动作：allow、mask、flag、escalate、block，未配置的分类为 flag
+	Words    map[string][]string `mapstructure:"words"`    // 分类 -> 词表，英文等按整词匹配（不区分大小写），中文按子串匹配
+	Patterns []ModerationPattern `mapstructure:"patterns"` // 正则规则
+	Spam     SpamConfig          `mapstructure:"spam"`     // 刷屏检测，命中的分类为 spam
+}
+
+// ModerationPattern 内容审核的正则规则
+type ModerationPattern struct {
+	Category string `mapstructure:"category"`
+	Pattern  string `mapstructure:"pattern"` // Go 正则表达式，需要不区分大小写时以 (?i) 开头
+}
+
+// SpamConfig 刷屏检测配置
+type SpamConfig struct {
+	MaxCharRepeat int `mapstructure:"max_char_repeat"` // 同一字符（空白除外）连续出现超过该次数视为刷屏，0 表示使用默认值 10
+	MaxWordRepeat int `mapstructure:"max_word_repeat"` // 同一个词连续出现超过该次数视为刷屏，0 表示使用默认值 5
+}
+
+// PrivacyConfig 个人信息保护配置
+type PrivacyConfig struct {
+	Redact   bool   `mapstructure:"redact"`    // 是否在保存消息前以占位符替换银行卡号、手机号、邮箱、身份证号等个人信息
+	Vault    bool   `mapstructure:"vault"`     // 是否加密保存脱敏前的原文，仅管理员可查看
+	VaultKey string `mapstructure:"vault_key"` // 加密原文的 AES-256 密钥，base64 编码的 32 字节，开启 vault 时必填
+}
+
 // DSN 返回PostgreSQL连接字符串
 func (c *DatabaseConfig) DSN() string {
 	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
@@ -40,8 +182,12 @@ func (c *DatabaseConfig) DSN() string {
 
 var GlobalConfig Config
 
+// configDir 配置文件所在目录
+var configDir string
+
 // LoadConfig 加载配置
 func LoadConfig(configPath string) error {
+	configDir = filepath.Dir(configPath)
 	viper.SetConfigFile(configPath)
 	viper.AutomaticEnv()
 
@@ -55,3 +201,11 @@ func LoadConfig(configPath string) error {
 
 	return nil
 }
+
+// ResolvePath 将配置中的相对路径解析为相对于配置文件所在目录的路径
+func ResolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(configDir, path)
+}
@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -26,44 +27,37 @@ func NewMessageDAO(db *gorm.DB) *MessageDAO {
 	return &MessageDAO{db: db}
 }
 
-// GenerateNextSeq 生成下一个消息序号
-func (dao *MessageDAO) GenerateNextSeq(message *model.Message) error {
-	if message.SessionID == 0 {
-		return errors.New("session id is required")
-	}
-
-	var maxSeq struct {
-		MaxSeq uint
-	}
-
-	// 查询当前会话中最大的序号
-	result := dao.db.Model(&model.Message{}).
-		Select("COALESCE(MAX(seq), 0) as max_seq").
-		Where("session_id = ?", message.SessionID).
-		Scan(&maxSeq)
+// WithContext 返回使用 ctx 执行查询的 dao，ctx 结束后查询取消、事务回滚
+func (dao *MessageDAO) WithContext(ctx context.Context) *MessageDAO {
+	return &MessageDAO{db: dao.db.WithContext(ctx)}
+}
 
-	if result.Error != nil {
-		return result.Error
+// NextSeq 原子地分配会话的下一个消息序号，必须在插入消息的事务中调用（dao 由事务创建）。
+// 计数器行在事务提交前保持锁定，同一会话的并发写入依次分配序号；事务回滚时计数器一并回滚，不会产生空洞。
+// 会话还没有计数器时以已有消息的最大序号初始化
+func (dao *MessageDAO) NextSeq(sessionID uint) (uint, error) {
+	if sessionID == 0 {
+		return 0, errors.New("session id is required")
 	}
 
-	// 设置新消息的序号为最大序号+1
-	message.Seq = maxSeq.MaxSeq + 1
-	return nil
+	var seq uint
+	result := dao.db.Raw(`INSERT INTO message_seqs (session_id, last_seq, updated_at)
+		VALUES (?, (SELECT COALESCE(MAX(seq), 0) + 1 FROM messages WHERE session_id = ?), NOW())
+		ON CONFLICT (session_id) DO UPDATE
+		SET last_seq = message_seqs.last_seq + 1, updated_at = NOW()
+		RETURNING last_seq`, sessionID, sessionID).
+		Scan(&seq)
+	return seq, result.Error
 }
 
 // ValidateSeq 验证消息序号的连续性
 func (dao *MessageDAO) ValidateSeq(sessionID uint) error {
-	var count int64
-	result := dao.db.Model(&model.Message{}).
-		Where("session_id = ?", sessionID).
-		Where("seq != (SELECT COUNT(*) FROM (SELECT DISTINCT seq FROM messages m2 WHERE m2.session_id = ? AND m2.seq <= messages.seq) subq)", sessionID).
-		Count(&count)
-
-	if result.Error != nil {
-		return result.Error
+	gaps, err := dao.FindSeqGaps(sessionID, 1)
+	if err != nil {
+		return err
 	}
 
-	if count > 0 {
+	if len(gaps) > 0 {
 		return errors.New("message sequence is not continuous")
 	}
 
@@ -135,21 +129,18 @@ func (dao *MessageDAO) GetLastMessageSeq(sessionID uint) (uint, error) {
 	return message.Seq, result.Error
 }
 
-// CreateMessage 创建新消息
+// CreateMessage 创建新消息，序号在同一事务中分配。在外层事务中调用时使用事务创建的 dao
 func (dao *MessageDAO) CreateMessage(message *model.Message) error {
 	return dao.db.Transaction(func(tx *gorm.DB) error {
 		// 生成消息序号
-		if err := dao.GenerateNextSeq(message); err != nil {
+		seq, err := NewMessageDAO(tx).NextSeq(message.SessionID)
+		if err != nil {
 			return err
 		}
+		message.Seq = seq
 
 		// 保存消息
-		if err := tx.Create(message).Error; err != nil {
-			return err
-		}
-
-		// 验证序号连续性
-		return dao.ValidateSeq(message.SessionID)
+		return tx.Create(message).Error
 	})
 }
 
@@ -0,0 +1,91 @@
+package dao
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// seqRenumberOffset 重新编号时先将序号整体平移到该偏移之上，避免与唯一约束 (session_id, seq) 冲突
+const seqRenumberOffset = 1000000000
+
+// SeqGap 会话中不连续的序号
+type SeqGap struct {
+	SessionID uint `json:"session_id"`
+	After     uint `json:"after"` // 缺口前的序号，0 表示会话开头
+	Next      uint `json:"next"`  // 缺口后的序号，与 After 相等表示序号重复
+}
+
+// FindSeqGaps 查找会话中序号不连续的位置，sessionID 为 0 时检查所有会话
+func (dao *MessageDAO) FindSeqGaps(sessionID uint, limit int) ([]SeqGap, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	filter := ""
+	args := []interface{}{}
+	if sessionID > 0 {
+		filter = "WHERE session_id = ?"
+		args = append(args, sessionID)
+	}
+	args = append(args, limit)
+
+	var gaps []SeqGap
+	result := dao.db.Raw(`SELECT session_id, prev_seq AS after, seq AS next FROM (
+			SELECT session_id, seq,
+				COALESCE(LAG(seq) OVER (PARTITION BY session_id ORDER BY seq, id), 0) AS prev_seq
+			FROM messages `+filter+`
+		) t
+		WHERE seq <> prev_seq + 1
+		ORDER BY session_id, seq
+		LIMIT ?`, args...).
+		Scan(&gaps)
+	return gaps, result.Error
+}
+
+// SessionsWithMessagesAfter 返回 ID 大于 afterID 的消息所在的会话，以及下次查询的起点。
+// 消息ID在提交前分配，提交较晚的事务可能写入比已看到的消息更小的ID，因此起点只推进到
+// 创建时间早于 settledBefore 的消息中的最大ID，之后的消息下次查询时重新扫描
+func (dao *MessageDAO) SessionsWithMessagesAfter(afterID uint, settledBefore time.Time) ([]uint, uint, error) {
+	// 先确定起点再查询会话，查询会话时起点之前的消息都已提交
+	var nextID uint
+	if err := dao.db.Raw("SELECT COALESCE(MAX(id), ?) FROM messages WHERE id > ? AND created_at < ?",
+		afterID, afterID, settledBefore).Scan(&nextID).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var sessionIDs []uint
+	result := dao.db.Raw("SELECT DISTINCT session_id FROM messages WHERE id > ?", afterID).
+		Scan(&sessionIDs)
+	return sessionIDs, nextID, result.Error
+}
+
+// RepairSessionSeq 按现有顺序（序号、ID）将会话的消息重新编号为 1..n，并将计数器重置为 n。
+// 重新编号会改变已推送给客户端的序号，客户端下次同步时可能收到重复的消息
+func (dao *MessageDAO) RepairSessionSeq(sessionID uint) (int64, error) {
+	var renumbered int64
+	err := dao.db.Transaction(func(tx *gorm.DB) error {
+		// 锁定计数器，期间该会话的新消息等待修复完成
+		if err := tx.Exec(`INSERT INTO message_seqs (session_id, last_seq, updated_at) VALUES (?, 0, NOW())
+			ON CONFLICT (session_id) DO UPDATE SET updated_at = NOW()`, sessionID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("UPDATE messages SET seq = seq + ? WHERE session_id = ?",
+			seqRenumberOffset, sessionID).Error; err != nil {
+			return err
+		}
+		result := tx.Exec(`UPDATE messages m SET seq = r.rn FROM (
+				SELECT id, ROW_NUMBER() OVER (ORDER BY seq, id) AS rn FROM messages WHERE session_id = ?
+			) r
+			WHERE m.id = r.id`, sessionID)
+		if result.Error != nil {
+			return result.Error
+		}
+		renumbered = result.RowsAffected
+
+		return tx.Exec("UPDATE message_seqs SET last_seq = ?, updated_at = NOW() WHERE session_id = ?",
+			renumbered, sessionID).Error
+	})
+	return renumbered, err
+}
@@ -0,0 +1,169 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// benchDSNEnv 基准使用的 PostgreSQL 连接串，未设置时跳过
+const benchDSNEnv = "CHATBOT_TEST_DSN"
+
+// BenchmarkCreateMessage 对比两种序号分配方式在并发写入下的吞吐量和冲突数：
+//   - legacy：事务外 SELECT MAX(seq)+1，插入后做全量连续性校验（旧实现）
+//   - counter：在插入事务中原子递增会话计数器（当前实现）
+//
+// 并发数由 -cpu 控制，数据写入新建的测试客户和会话，结束后删除：
+//
+//	CHATBOT_TEST_DSN="host=localhost user=postgres dbname=chatbot sslmode=disable" \
+//		go test ./internal/dao -run=^$ -bench=CreateMessage -cpu=16
+func BenchmarkCreateMessage(b *testing.B) {
+	dsn := os.Getenv(benchDSNEnv)
+	if dsn == "" {
+		b.Skipf("%s not set", benchDSNEnv)
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		b.Fatalf("Failed to connect database: %v", err)
+	}
+
+	customer := &model.Customer{
+		Email: fmt.Sprintf("seqbench-%s@example.invalid", uuid.New().String()),
+		Name:  "seqbench",
+		Salt:  "seqbench",
+	}
+	if err := customer.SetPassword(uuid.New().String()); err != nil {
+		b.Fatalf("Failed to hash password: %v", err)
+	}
+	if err := conn.Create(customer).Error; err != nil {
+		b.Fatalf("Failed to create benchmark customer: %v", err)
+	}
+	b.Cleanup(func() { cleanupBench(b, conn, customer.ID) })
+
+	modes := []struct {
+		name     string
+		allocate func(db *gorm.DB, message *model.Message) error
+	}{
+		{"legacy", legacyCreate},
+		{"counter", func(db *gorm.DB, message *model.Message) error {
+			return NewMessageDAO(db).CreateMessage(message)
+		}},
+	}
+	for _, mode := range modes {
+		for _, sessions := range []int{1, 8} {
+			b.Run(fmt.Sprintf("%s/sessions=%d", mode.name, sessions), func(b *testing.B) {
+				sessionIDs := createBenchSessions(b, conn, customer.ID, sessions)
+				var next, conflicts int64
+
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						n := atomic.AddInt64(&next, 1)
+						message := &model.Message{
+							CustomerID: customer.ID,
+							SessionID:  sessionIDs[int(n)%len(sessionIDs)],
+							Type:       "text",
+							Content:    `{"text":"seqbench"}`,
+							Sender:     model.SenderCustomer,
+						}
+						if err := mode.allocate(conn, message); err != nil {
+							if !errors.Is(err, gorm.ErrDuplicatedKey) && !isUniqueViolation(err) {
+								b.Errorf("insert failed: %v", err)
+							}
+							atomic.AddInt64(&conflicts, 1)
+						}
+					}
+				})
+				b.StopTimer()
+
+				gaps := 0
+				for _, id := range sessionIDs {
+					found, err := NewMessageDAO(conn).FindSeqGaps(id, b.N)
+					if err != nil {
+						b.Fatalf("Failed to check sequence gaps: %v", err)
+					}
+					gaps += len(found)
+				}
+				b.ReportMetric(float64(conflicts)/float64(b.N), "conflicts/op")
+				b.ReportMetric(float64(gaps), "gaps")
+			})
+		}
+	}
+}
+
+// legacyCreate 旧的序号分配方式：事务外读取最大序号，插入后校验整个会话的连续性
+func legacyCreate(conn *gorm.DB, message *model.Message) error {
+	var maxSeq struct {
+		MaxSeq uint
+	}
+	if err := conn.Model(&model.Message{}).
+		Select("COALESCE(MAX(seq), 0) as max_seq").
+		Where("session_id = ?", message.SessionID).
+		Scan(&maxSeq).Error; err != nil {
+		return err
+	}
+	message.Seq = maxSeq.MaxSeq + 1
+
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		var count int64
+		return tx.Model(&model.Message{}).
+			Where("session_id = ?", message.SessionID).
+			Where("seq != (SELECT COUNT(*) FROM (SELECT DISTINCT seq FROM messages m2 WHERE m2.session_id = ? AND m2.seq <= messages.seq) subq)", message.SessionID).
+			Count(&count).Error
+	})
+}
+
+// isUniqueViolation 是否为违反唯一约束的错误（SQLSTATE 23505）
+func isUniqueViolation(err error) bool {
+	var coded interface{ SQLState() string }
+	return errors.As(err, &coded) && coded.SQLState() == "23505"
+}
+
+// createBenchSessions 为客户创建 n 个会话
+func createBenchSessions(b *testing.B, conn *gorm.DB, customerID uint, n int) []uint {
+	ids := make([]uint, 0, n)
+	for i := 0; i < n; i++ {
+		session := &model.Session{
+			CustomerID:   customerID,
+			Status:       string(model.SessionStatusActive),
+			LastActiveAt: time.Now(),
+		}
+		if err := conn.Create(session).Error; err != nil {
+			b.Fatalf("Failed to create benchmark session: %v", err)
+		}
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+// cleanupBench 删除基准写入的数据
+func cleanupBench(b *testing.B, conn *gorm.DB, customerID uint) {
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		sessions := tx.Model(&model.Session{}).Select("id").Where("customer_id = ?", customerID)
+		if err := tx.Unscoped().Where("customer_id = ?", customerID).Delete(&model.Message{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("session_id IN (?)", sessions).Delete(&model.MessageSeq{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("customer_id = ?", customerID).Delete(&model.Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.Customer{}, customerID).Error
+	})
+	if err != nil {
+		b.Errorf("Failed to clean up benchmark data: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StepEnd 表示流程结束的特殊步骤ID
+const StepEnd = "end"
+
+// SlotType 槽位类型
+type SlotType string
+
+const (
+	SlotText    SlotType = "text"    // 任意非空文本
+	SlotNumber  SlotType = "number"  // 数字
+	SlotInteger SlotType = "integer" // 整数
+	SlotChoice  SlotType = "choice"  // 从 choices 中选择，可输入选项或序号
+	SlotYesNo   SlotType = "yes_no"  // 是/否，归一化为 yes 或 no
+	SlotEmail   SlotType = "email"   // 邮箱地址
+)
+
+var (
+	// ErrFlowNotFound 流程不存在
+	ErrFlowNotFound = errors.New("flow not found")
+)
+
+// Condition 分支条件，对已填充的槽位求值
+type Condition struct {
+	Slot   string   `yaml:"slot" json:"slot"`
+	Op     string   `yaml:"op" json:"op"` // eq, ne, lt, lte, gt, gte, in, matches
+	Value  string   `yaml:"value" json:"value"`
+	Values []string `yaml:"values" json:"values"` // op 为 in 时使用
+}
+
+// Branch 条件分支，条件满足时跳转到 Next
+type Branch struct {
+	When Condition `yaml:"when" json:"when"`
+	Next string    `yaml:"next" json:"next"`
+}
+
+// Step 流程中的一个步骤
+type Step struct {
+	ID          string   `yaml:"id" json:"id"`
+	Prompt      string   `yaml:"prompt" json:"prompt"`             // 进入步骤时发送的提示
+	Slot        string   `yaml:"slot" json:"slot"`                 // 用户输入写入的槽位，为空时使用步骤ID
+	Type        SlotType `yaml:"type" json:"type"`                 // 槽位类型，默认 text
+	Choices     []string `yaml:"choices" json:"choices"`           // choice 类型的可选项
+	Min         *float64 `yaml:"min" json:"min"`                   // 数字类型的最小值
+	Max         *float64 `yaml:"max" json:"max"`                   // 数字类型的最大值
+	Pattern     string   `yaml:"pattern" json:"pattern"`           // 额外的正则校验
+	ErrorPrompt string   `yaml:"error_prompt" json:"error_prompt"` // 校验失败时的提示
+	Branches    []Branch `yaml:"branches" json:"branches"`         // 按顺序求值的分支
+	Next        string   `yaml:"next" json:"next"`                 // 没有分支命中时的下一步，为空或 end 时结束
+
+	pattern *regexp.Regexp
+}
+
+// Flow 多轮对话流程定义
+type Flow struct {
+	Name              string `yaml:"name" json:"name"`
+	Start             string `yaml:"start" json:"start"`                           // 起始步骤，默认为第一个步骤
+	OnComplete        string `yaml:"on_complete" json:"on_complete"`               // 完成后执行的动作
+	CompletionMessage string `yaml:"completion_message" json:"completion_message"` // 完成后的回复，动作返回回复时以动作为准
+	CancelMessage     string `yaml:"cancel_message" json:"cancel_message"`         // 取消后的回复
+	Steps             []Step `yaml:"steps" json:"steps"`
+
+	steps map[string]*Step
+}
+
+// Step 根据ID获取步骤
+func (f *Flow) Step(id string) (*Step, bool) {
+	step, ok := f.steps[id]
+	return step, ok
+}
+
+// SlotName 返回步骤写入的槽位名称
+func (s *Step) SlotName() string {
+	if s.Slot != "" {
+		return s.Slot
+	}
+	return s.ID
+}
+
+// LoadFile 从YAML或JSON文件加载流程定义
+func LoadFile(path string) (*Flow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flow file: %w", err)
+	}
+	return Parse(data, filepath.Ext(path))
+}
+
+// Parse 解析流程定义，ext 为 .json 时按JSON解析，否则按YAML解析
+func Parse(data []byte, ext string) (*Flow, error) {
+	var f Flow
+	var err error
+	if strings.EqualFold(ext, ".json") {
+		err = json.Unmarshal(data, &f)
+	} else {
+		err = yaml.Unmarshal(data, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse flow: %w", err)
+	}
+
+	if err := f.compile(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// compile 校验流程图并预编译正则
+func (f *Flow) compile() error {
+	if f.Name == "" {
+		return errors.New("flow has no name")
+	}
+	if len(f.Steps) == 0 {
+		return fmt.Errorf("flow %s has no steps", f.Name)
+	}
+
+	f.steps = make(map[string]*Step, len(f.Steps))
+	for i := range f.Steps {
+		step := &f.Steps[i]
+		if step.ID == "" || step.ID == StepEnd {
+			return fmt.Errorf("flow %s: step #%d has an invalid id", f.Name, i+1)
+		}
+		if _, exists := f.steps[step.ID]; exists {
+			return fmt.Errorf("flow %s: duplicate step %s", f.Name, step.ID)
+		}
+		if step.Type == "" {
+			step.Type = SlotText
+		}
+		switch step.Type {
+		case SlotText, SlotNumber, SlotInteger, SlotYesNo, SlotEmail:
+		case SlotChoice:
+			if len(step.Choices) == 0 {
+				return fmt.Errorf("flow %s: choice step %s has no choices", f.Name, step.ID)
+			}
+		default:
+			return fmt.Errorf("flow %s: step %s has unknown slot type %s", f.Name, step.ID, step.Type)
+		}
+		if step.Pattern != "" {
+			re, err := regexp.Compile(step.Pattern)
+			if err != nil {
+				return fmt.Errorf("flow %s: step %s has invalid pattern: %w", f.Name, step.ID, err)
+			}
+			step.pattern = re
+		}
+		f.steps[step.ID] = step
+	}
+
+	if f.Start == "" {
+		f.Start = f.Steps[0].ID
+	}
+	if _, ok := f.steps[f.Start]; !ok {
+		return fmt.Errorf("flow %s: start step %s not found", f.Name, f.Start)
+	}
+
+	// 校验所有跳转目标都存在
+	for _, step := range f.steps {
+		targets := []string{step.Next}
+		for _, branch := range step.Branches {
+			targets = append(targets, branch.Next)
+			switch branch.When.Op {
+			case "eq", "ne", "lt", "lte", "gt", "gte", "in":
+			case "matches":
+				if _, err := regexp.Compile(branch.When.Value); err != nil {
+					return fmt.Errorf("flow %s: step %s has invalid branch pattern: %w", f.Name, step.ID, err)
+				}
+			default:
+				return fmt.Errorf("flow %s: step %s has unknown branch operator %s", f.Name, step.ID, branch.When.Op)
+			}
+		}
+		for _, target := range targets {
+			if target == "" || target == StepEnd {
+				continue
+			}
+			if _, ok := f.steps[target]; !ok {
+				return fmt.Errorf("flow %s: step %s jumps to unknown step %s", f.Name, step.ID, target)
+			}
+		}
+	}
+
+	return nil
+}
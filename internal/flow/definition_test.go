@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"no name", "steps: [{id: a}]", "has no name"},
+		{"no steps", "name: f", "has no steps"},
+		{"reserved step id", "name: f\nsteps: [{id: end}]", "invalid id"},
+		{"duplicate step", "name: f\nsteps: [{id: a}, {id: a}]", "duplicate step"},
+		{"unknown slot type", "name: f\nsteps: [{id: a, type: date}]", "unknown slot type"},
+		{"choice without choices", "name: f\nsteps: [{id: a, type: choice}]", "has no choices"},
+		{"invalid pattern", "name: f\nsteps: [{id: a, pattern: '('}]", "invalid pattern"},
+		{"unknown start", "name: f\nstart: b\nsteps: [{id: a}]", "start step b not found"},
+		{"unknown next", "name: f\nsteps: [{id: a, next: b}]", "unknown step b"},
+		{"unknown branch target", "name: f\nsteps: [{id: a, branches: [{when: {slot: a, op: eq, value: x}, next: b}]}]", "unknown step b"},
+		{"unknown operator", "name: f\nsteps: [{id: a, branches: [{when: {slot: a, op: like}, next: end}]}]", "unknown branch operator"},
+		{"invalid branch pattern", "name: f\nsteps: [{id: a, branches: [{when: {slot: a, op: matches, value: '('}, next: end}]}]", "invalid branch pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.yaml), ".yaml")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	f, err := Parse([]byte(`{"name":"f","steps":[{"id":"a","slot":"name"},{"id":"b"}]}`), ".json")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if f.Start != "a" {
+		t.Errorf("start = %s, want a", f.Start)
+	}
+	a, _ := f.Step("a")
+	b, _ := f.Step("b")
+	if a.Type != SlotText {
+		t.Errorf("default type = %s, want %s", a.Type, SlotText)
+	}
+	if a.SlotName() != "name" || b.SlotName() != "b" {
+		t.Errorf("slot names = %s, %s, want name, b", a.SlotName(), b.SlotName())
+	}
+}
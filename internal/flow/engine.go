@@ -0,0 +1,348 @@
+package flow
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+//go:embed builtin/*.yaml
+var builtinFlows embed.FS
+
+// cancelWords 取消当前流程的输入
+var cancelWords = map[string]bool{
+	"cancel": true,
+	"取消":     true,
+}
+
+// State 会话中流程的执行状态
+type State struct {
+	Flow  string            `json:"flow"`
+	Step  string            `json:"step"`
+	Slots map[string]string `json:"slots"`
+}
+
+// Outcome 处理一次用户输入的结果
+type Outcome struct {
+	Reply     string // 回复内容：下一步的提示、校验失败提示或完成语
+	Completed bool   // 流程是否已完成
+	Invalid   bool   // 输入是否未通过校验
+}
+
+// Engine 流程引擎，持有所有已注册的流程定义
+type Engine struct {
+	mu    sync.RWMutex
+	flows map[string]*Flow
+}
+
+// NewEngine 创建流程引擎并注册内置流程
+func NewEngine() (*Engine, error) {
+	e := &Engine{flows: make(map[string]*Flow)}
+
+	entries, err := builtinFlows.ReadDir("builtin")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		data, err := builtinFlows.ReadFile("builtin/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		f, err := Parse(data, filepath.Ext(entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("builtin flow %s: %w", entry.Name(), err)
+		}
+		e.Register(f)
+	}
+
+	return e, nil
+}
+
+// LoadDir 加载目录下所有 .yaml/.yml/.json 流程定义，同名流程会覆盖内置流程
+func (e *Engine) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read flow directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		f, err := LoadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		e.Register(f)
+	}
+	return nil
+}
+
+// Register 注册流程定义
+func (e *Engine) Register(f *Flow) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.flows[f.Name] = f
+}
+
+// Get 获取流程定义
+func (e *Engine) Get(name string) (*Flow, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	f, ok := e.flows[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFlowNotFound, name)
+	}
+	return f, nil
+}
+
+// Start 开始一个流程，返回初始状态和第一步的提示
+func (e *Engine) Start(name string) (*State, string, error) {
+	f, err := e.Get(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	state := &State{
+		Flow:  f.Name,
+		Step:  f.Start,
+		Slots: make(map[string]string),
+	}
+	step, _ := f.Step(f.Start)
+	return state, renderPrompt(step, state.Slots), nil
+}
+
+// Prompt 返回当前步骤的提示，用于重连后恢复流程
+func (e *Engine) Prompt(state *State) (string, error) {
+	f, err := e.Get(state.Flow)
+	if err != nil {
+		return "", err
+	}
+	step, ok := f.Step(state.Step)
+	if !ok {
+		return "", fmt.Errorf("flow %s: step %s not found", f.Name, state.Step)
+	}
+	return renderPrompt(step, state.Slots), nil
+}
+
+// Advance 使用用户输入填充当前步骤的槽位并跳转到下一步
+func (e *Engine) Advance(state *State, input string) (*Outcome, error) {
+	f, err := e.Get(state.Flow)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := f.Step(state.Step)
+	if !ok {
+		return nil, fmt.Errorf("flow %s: step %s not found", f.Name, state.Step)
+	}
+
+	value, err := step.parse(input)
+	if err != nil {
+		reply := step.ErrorPrompt
+		if reply == "" {
+			reply = err.Error() + "\n" + renderPrompt(step, state.Slots)
+		}
+		return &Outcome{Reply: reply, Invalid: true}, nil
+	}
+
+	if state.Slots == nil {
+		state.Slots = make(map[string]string)
+	}
+	state.Slots[step.SlotName()] = value
+
+	next := step.next(state.Slots)
+	if next == "" || next == StepEnd {
+		state.Step = StepEnd
+		return &Outcome{Reply: renderText(f.CompletionMessage, state.Slots), Completed: true}, nil
+	}
+
+	state.Step = next
+	nextStep, _ := f.Step(next)
+	return &Outcome{Reply: renderPrompt(nextStep, state.Slots)}, nil
+}
+
+// Choices 返回当前步骤的可选项，供客户端以快捷回复展示；不需要选择的步骤返回 nil
+func (e *Engine) Choices(state *State) []string {
+	f, err := e.Get(state.Flow)
+	if err != nil {
+		return nil
+	}
+	step, ok := f.Step(state.Step)
+	if !ok {
+		return nil
+	}
+
+	switch step.Type {
+	case SlotChoice:
+		return step.Choices
+	case SlotYesNo:
+		return []string{"yes", "no"}
+	}
+	return nil
+}
+
+// CancelMessage 返回流程的取消提示
+func (e *Engine) CancelMessage(name string) string {
+	if f, err := e.Get(name); err == nil && f.CancelMessage != "" {
+		return f.CancelMessage
+	}
+	return "The current process has been cancelled."
+}
+
+// IsCancel 判断输入是否为取消指令
+func IsCancel(input string) bool {
+	return cancelWords[strings.ToLower(strings.TrimSpace(input))]
+}
+
+// parse 按槽位类型校验并归一化用户输入
+func (s *Step) parse(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", fmt.Errorf("input is required")
+	}
+
+	var value string
+	switch s.Type {
+	case SlotNumber, SlotInteger:
+		n, err := strconv.ParseFloat(input, 64)
+		if err != nil || (s.Type == SlotInteger && n != float64(int64(n))) {
+			return "", fmt.Errorf("please enter a valid %s", s.Type)
+		}
+		if (s.Min != nil && n < *s.Min) || (s.Max != nil && n > *s.Max) {
+			return "", fmt.Errorf("the value is out of range")
+		}
+		value = strconv.FormatFloat(n, 'f', -1, 64)
+	case SlotChoice:
+		choice, ok := s.matchChoice(input)
+		if !ok {
+			return "", fmt.Errorf("please choose one of the options")
+		}
+		value = choice
+	case SlotYesNo:
+		switch strings.ToLower(input) {
+		case "y", "yes", "是", "好", "好的", "对":
+			value = "yes"
+		case "n", "no", "否", "不", "不是", "不要":
+			value = "no"
+		default:
+			return "", fmt.Errorf("please answer yes or no")
+		}
+	case SlotEmail:
+		addr, err := mail.ParseAddress(input)
+		if err != nil {
+			return "", fmt.Errorf("please enter a valid email address")
+		}
+		value = addr.Address
+	default:
+		value = input
+	}
+
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		return "", fmt.Errorf("the input format is invalid")
+	}
+	return value, nil
+}
+
+// matchChoice 按选项文本（忽略大小写）或从1开始的序号匹配选项
+func (s *Step) matchChoice(input string) (string, bool) {
+	if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(s.Choices) {
+		return s.Choices[n-1], true
+	}
+	for _, choice := range s.Choices {
+		if strings.EqualFold(choice, input) {
+			return choice, true
+		}
+	}
+	return "", false
+}
+
+// next 依次求值分支条件，返回下一步的ID
+func (s *Step) next(slots map[string]string) string {
+	for _, branch := range s.Branches {
+		if branch.When.eval(slots) {
+			return branch.Next
+		}
+	}
+	return s.Next
+}
+
+// eval 对槽位求值条件，两边都能解析为数字时按数字比较
+func (c Condition) eval(slots map[string]string) bool {
+	value, ok := slots[c.Slot]
+	if !ok {
+		return false
+	}
+
+	switch c.Op {
+	case "eq":
+		return compare(value, c.Value) == 0
+	case "ne":
+		return compare(value, c.Value) != 0
+	case "lt":
+		return compare(value, c.Value) < 0
+	case "lte":
+		return compare(value, c.Value) <= 0
+	case "gt":
+		return compare(value, c.Value) > 0
+	case "gte":
+		return compare(value, c.Value) >= 0
+	case "in":
+		for _, v := range c.Values {
+			if compare(value, v) == 0 {
+				return true
+			}
+		}
+		return false
+	case "matches":
+		matched, _ := regexp.MatchString(c.Value, value)
+		return matched
+	}
+	return false
+}
+
+// compare 比较两个槽位值
+func compare(a, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// renderPrompt 渲染步骤提示，选项由 Choices 单独提供
+func renderPrompt(step *Step, slots map[string]string) string {
+	return renderText(step.Prompt, slots)
+}
+
+// renderText 渲染文本模板，模板中可通过 {{.slot_name}} 引用已填充的槽位
+func renderText(text string, slots map[string]string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+
+	tmpl, err := template.New("prompt").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return text
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, slots); err != nil {
+		return text
+	}
+	return buf.String()
+}
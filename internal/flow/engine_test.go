@@ -0,0 +1,221 @@
+package flow
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStepParse(t *testing.T) {
+	min, max := 1.0, 5.0
+	tests := []struct {
+		name    string
+		step    Step
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"text is trimmed", Step{Type: SlotText}, "  hello ", "hello", false},
+		{"empty input", Step{Type: SlotText}, "   ", "", true},
+		{"number", Step{Type: SlotNumber}, "3.50", "3.5", false},
+		{"not a number", Step{Type: SlotNumber}, "three", "", true},
+		{"integer", Step{Type: SlotInteger}, "4", "4", false},
+		{"fraction is not an integer", Step{Type: SlotInteger}, "4.5", "", true},
+		{"below min", Step{Type: SlotInteger, Min: &min, Max: &max}, "0", "", true},
+		{"above max", Step{Type: SlotInteger, Min: &min, Max: &max}, "6", "", true},
+		{"within range", Step{Type: SlotInteger, Min: &min, Max: &max}, "5", "5", false},
+		{"choice by text", Step{Type: SlotChoice, Choices: []string{"Phone", "Email"}}, "email", "Email", false},
+		{"choice by number", Step{Type: SlotChoice, Choices: []string{"Phone", "Email"}}, "1", "Phone", false},
+		{"choice number out of range", Step{Type: SlotChoice, Choices: []string{"Phone", "Email"}}, "3", "", true},
+		{"unknown choice", Step{Type: SlotChoice, Choices: []string{"Phone", "Email"}}, "fax", "", true},
+		{"yes", Step{Type: SlotYesNo}, "Y", "yes", false},
+		{"chinese no", Step{Type: SlotYesNo}, "不要", "no", false},
+		{"not yes or no", Step{Type: SlotYesNo}, "maybe", "", true},
+		{"email", Step{Type: SlotEmail}, "Bob <bob@example.com>", "bob@example.com", false},
+		{"invalid email", Step{Type: SlotEmail}, "bob@", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.step.parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parse(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStepParsePattern(t *testing.T) {
+	f, err := Parse([]byte("name: f\nsteps: [{id: phone, pattern: '^1\\d{10}$'}]"), ".yaml")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	step, _ := f.Step("phone")
+	if _, err := step.parse("13812345678"); err != nil {
+		t.Errorf("parse(valid) failed: %v", err)
+	}
+	if _, err := step.parse("12345"); err == nil {
+		t.Error("parse(invalid) succeeded, want error")
+	}
+}
+
+func TestConditionEval(t *testing.T) {
+	slots := map[string]string{"rating": "2", "channel": "Email"}
+	tests := []struct {
+		cond Condition
+		want bool
+	}{
+		{Condition{Slot: "rating", Op: "eq", Value: "2.0"}, true}, // 数字按数值比较
+		{Condition{Slot: "rating", Op: "lt", Value: "10"}, true},  // 不按字符串比较
+		{Condition{Slot: "rating", Op: "gte", Value: "3"}, false},
+		{Condition{Slot: "channel", Op: "eq", Value: "email"}, true},
+		{Condition{Slot: "channel", Op: "ne", Value: "phone"}, true},
+		{Condition{Slot: "channel", Op: "in", Values: []string{"phone", "EMAIL"}}, true},
+		{Condition{Slot: "channel", Op: "matches", Value: "^E"}, true},
+		{Condition{Slot: "missing", Op: "ne", Value: "x"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.eval(slots); got != tt.want {
+			t.Errorf("eval(%+v) = %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
+
+// supportFlow 根据评分分支的测试流程
+const supportFlow = `
+name: support
+completion_message: "Thanks {{.name}}"
+steps:
+  - id: rating
+    type: integer
+    prompt: Rate us
+    branches:
+      - when: {slot: rating, op: lte, value: "2"}
+        next: reason
+    next: name
+  - id: reason
+    type: choice
+    choices: [Price, Quality]
+    prompt: Why?
+    next: name
+  - id: name
+    prompt: "Your name? (rating {{.rating}})"
+    error_prompt: Please tell us your name.
+`
+
+func TestAdvance(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputs    []string
+		wantSteps []string // 每次输入后的步骤
+		wantReply string   // 最后一次输入的回复
+		wantSlots map[string]string
+	}{
+		{
+			name:      "high rating skips reason",
+			inputs:    []string{"5", "Ann"},
+			wantSteps: []string{"name", StepEnd},
+			wantReply: "Thanks Ann",
+			wantSlots: map[string]string{"rating": "5", "name": "Ann"},
+		},
+		{
+			name:      "low rating asks for reason",
+			inputs:    []string{"1", "2", "Bob"},
+			wantSteps: []string{"reason", "name", StepEnd},
+			wantReply: "Thanks Bob",
+			wantSlots: map[string]string{"rating": "1", "reason": "Quality", "name": "Bob"},
+		},
+		{
+			name:      "invalid input stays on the step",
+			inputs:    []string{"great"},
+			wantSteps: []string{"rating"},
+			wantReply: "please enter a valid integer\nRate us",
+			wantSlots: map[string]string{},
+		},
+		{
+			name:      "error prompt replaces the default",
+			inputs:    []string{"4", " "},
+			wantSteps: []string{"name", "name"},
+			wantReply: "Please tell us your name.",
+			wantSlots: map[string]string{"rating": "4"},
+		},
+		{
+			name:      "next prompt is rendered with slots",
+			inputs:    []string{"4"},
+			wantSteps: []string{"name"},
+			wantReply: "Your name? (rating 4)",
+			wantSlots: map[string]string{"rating": "4"},
+		},
+	}
+	f, err := Parse([]byte(supportFlow), ".yaml")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	e := &Engine{flows: map[string]*Flow{f.Name: f}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, prompt, err := e.Start("support")
+			if err != nil {
+				t.Fatalf("Start failed: %v", err)
+			}
+			if prompt != "Rate us" {
+				t.Errorf("start prompt = %q, want %q", prompt, "Rate us")
+			}
+
+			var outcome *Outcome
+			for i, input := range tt.inputs {
+				if outcome, err = e.Advance(state, input); err != nil {
+					t.Fatalf("Advance(%q) failed: %v", input, err)
+				}
+				if state.Step != tt.wantSteps[i] {
+					t.Errorf("after %q step = %s, want %s", input, state.Step, tt.wantSteps[i])
+				}
+			}
+			if outcome.Reply != tt.wantReply {
+				t.Errorf("reply = %q, want %q", outcome.Reply, tt.wantReply)
+			}
+			if outcome.Completed != (state.Step == StepEnd) {
+				t.Errorf("completed = %v at step %s", outcome.Completed, state.Step)
+			}
+			if !reflect.DeepEqual(state.Slots, tt.wantSlots) {
+				t.Errorf("slots = %v, want %v", state.Slots, tt.wantSlots)
+			}
+		})
+	}
+}
+
+func TestBuiltinFlows(t *testing.T) {
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	state, _, err := e.Start("feedback")
+	if err != nil {
+		t.Fatalf("Start(feedback) failed: %v", err)
+	}
+	if choices := e.Choices(state); choices != nil {
+		t.Errorf("choices of rating step = %v, want none", choices)
+	}
+	if _, _, err := e.Start("missing"); !errors.Is(err, ErrFlowNotFound) {
+		t.Errorf("Start(missing) = %v, want %v", err, ErrFlowNotFound)
+	}
+}
+
+func TestIsCancel(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"cancel", true},
+		{" CANCEL ", true},
+		{"取消", true},
+		{"cancel my order", false},
+	}
+	for _, tt := range tests {
+		if got := IsCancel(tt.input); got != tt.want {
+			t.Errorf("IsCancel(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/JennerWork/chatbot/internal/service"
+)
+
+// AgentHandler agent console WebSocket message handler
+type AgentHandler struct {
+	handoffService service.HandoffService
+	eventService   service.EventService
+}
+
+// NewAgentHandler create agent console message handler
+func NewAgentHandler(handoffService service.HandoffService, eventService service.EventService) *AgentHandler {
+	return &AgentHandler{
+		handoffService: handoffService,
+		eventService:   eventService,
+	}
+}
+
+// HandleMessage handle agent console message, agentID is the user ID of the agent.
+// Agent connections are not bound to a session, sessionID is always 0
+func (h *AgentHandler) HandleMessage(ctx context.Context, agentID uint, sessionID uint, message []byte, w service.FrameWriter) ([]byte, error) {
+	if len(message) == 0 {
+		return nil, ErrInvalidMessage
+	}
+
+	// 输入状态和已读事件转发给客户，不经过人工客服服务
+	var envelope struct {
+		Type    string          `json:"type"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(message, &envelope); err == nil && service.IsEventType(envelope.Type) {
+		return h.eventService.HandleAgentEvent(agentID, envelope.Type, envelope.Content)
+	}
+
+	return h.handoffService.HandleAgentMessage(ctx, agentID, message, w)
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// AgentProfileRequest agent profile request parameters
+type AgentProfileRequest struct {
+	Skills        []string `json:"skills"`
+	Languages     []string `json:"languages"`
+	MaxConcurrent int      `json:"max_concurrent" binding:"min=0,max=100"`
+}
+
+// AgentProfileHandler agent profile admin handler
+type AgentProfileHandler struct {
+	agentProfileService service.AgentProfileService
+}
+
+// NewAgentProfileHandler create an agent profile handler
+func NewAgentProfileHandler(agentProfileService service.AgentProfileService) *AgentProfileHandler {
+	return &AgentProfileHandler{
+		agentProfileService: agentProfileService,
+	}
+}
+
+// ListProfiles list agent profiles
+// @Summary List Agent Profiles
+// @Description List the routing profiles (skills, languages, capacity) of all agents
+// @Tags agents
+// @Produce json
+// @Success 200 {array} model.AgentProfile
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/agents [get]
+func (h *AgentProfileHandler) ListProfiles(c *gin.Context) {
+	profiles, err := h.agentProfileService.ListProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    500,
+			Message: "Failed to list agent profiles",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if profiles == nil {
+		profiles = []model.AgentProfile{}
+	}
+	c.JSON(http.StatusOK, profiles)
+}
+
+// GetProfile get an agent profile
+// @Summary Get Agent Profile
+// @Description Get the routing profile of an agent
+// @Tags agents
+// @Produce json
+// @Param id path int true "Agent ID"
+// @Success 200 {object} model.AgentProfile
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/agents/{id} [get]
+func (h *AgentProfileHandler) GetProfile(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	profile, err := h.agentProfileService.GetProfile(id)
+	if err != nil {
+		writeAgentProfileError(c, err, "Failed to get agent profile")
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateProfile update an agent profile
+// @Summary Update Agent Profile
+// @Description Set the skills, languages and maximum concurrent chats of an agent
+// @Tags agents
+// @Accept json
+// @Produce json
+// @Param id path int true "Agent ID"
+// @Param request body AgentProfileRequest true "Agent Profile"
+// @Success 200 {object} model.AgentProfile
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/agents/{id} [put]
+func (h *AgentProfileHandler) UpdateProfile(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req AgentProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Invalid request parameters",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	profile, err := h.agentProfileService.UpdateProfile(id, service.AgentProfileInput{
+		Skills:        req.Skills,
+		Languages:     req.Languages,
+		MaxConcurrent: req.MaxConcurrent,
+	})
+	if err != nil {
+		writeAgentProfileError(c, err, "Failed to update agent profile")
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// writeAgentProfileError write an agent profile error response
+func writeAgentProfileError(c *gin.Context, err error, message string) {
+	status := http.StatusInternalServerError
+	if err == service.ErrAgentNotFound {
+		status = http.StatusNotFound
+		message = "Agent not found"
+	}
+
+	c.JSON(status, ErrorResponse{
+		Code:    status,
+		Message: message,
+		Error:   err.Error(),
+	})
+}
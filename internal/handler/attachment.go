@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/JennerWork/chatbot/internal/middleware"
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead extra bytes allowed for multipart headers and boundaries
+const multipartOverhead = 1 << 20
+
+// AttachmentHandler attachment upload and download handler
+type AttachmentHandler struct {
+	attachmentService service.AttachmentService
+}
+
+// NewAttachmentHandler create an attachment handler
+func NewAttachmentHandler(attachmentService service.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+	}
+}
+
+// Upload upload an attachment
+// @Summary Upload Attachment
+// @Description Upload a file or image; reference the returned ID in an image/file WebSocket message to send it
+// @Tags attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "File"
+// @Success 201 {object} model.Attachment
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/attachments [post]
+func (h *AttachmentHandler) Upload(c *gin.Context) {
+	customerID := middleware.GetCustomerID(c)
+	if customerID == 0 {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Code:    401,
+			Message: "Unauthorized user",
+		})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.attachmentService.MaxSize()+multipartOverhead)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAttachmentError(c, service.ErrAttachmentTooLarge, "Failed to upload attachment")
+			return
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Missing file",
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := h.attachmentService.Upload(customerID, header.Filename, file)
+	if err != nil {
+		writeAttachmentError(c, err, "Failed to upload attachment")
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// Download download an attachment
+// @Summary Download Attachment
+// @Description Download an attachment; only the uploader and the agent handling the session may download it
+// @Tags attachments
+// @Produce octet-stream
+// @Param id path int true "Attachment ID"
+// @Success 200 {file} file
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/attachments/{id} [get]
+func (h *AttachmentHandler) Download(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	attachment, rc, err := h.attachmentService.Open(middleware.GetCustomerID(c), id)
+	if err != nil {
+		writeAttachmentError(c, err, "Failed to download attachment")
+		return
+	}
+	defer rc.Close()
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, rc, map[string]string{
+		"Content-Disposition":    contentDisposition(attachment),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// contentDisposition images are shown inline, other files are downloaded
+func contentDisposition(attachment *model.Attachment) string {
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName})
+}
+
+// writeAttachmentError write an attachment error response
+func writeAttachmentError(c *gin.Context, err error, message string) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrAttachmentNotFound):
+		status = http.StatusNotFound
+		message = "Attachment not found"
+	case errors.Is(err, service.ErrAttachmentForbidden):
+		status = http.StatusForbidden
+		message = "Access to the attachment is denied"
+	case errors.Is(err, service.ErrAttachmentTooLarge):
+		status = http.StatusRequestEntityTooLarge
+		message = "Attachment is too large"
+	case errors.Is(err, service.ErrAttachmentTypeNotAllowed):
+		status = http.StatusUnsupportedMediaType
+		message = "Attachment type is not allowed"
+	case errors.Is(err, service.ErrAttachmentEmpty):
+		status = http.StatusBadRequest
+		message = "Attachment is empty"
+	case errors.Is(err, service.ErrAttachmentAlreadySent):
+		status = http.StatusConflict
+		message = "Attachment has already been sent"
+	}
+
+	c.JSON(status, ErrorResponse{
+		Code:    status,
+		Message: message,
+		Error:   err.Error(),
+	})
+}
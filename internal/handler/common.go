@@ -1,8 +1,28 @@
 package handler
 
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
 // ErrorResponse error response
 type ErrorResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Error   string `json:"error,omitempty"`
 }
+
+// parseIDParam parse the ":id" path parameter, writing a 400 response when it is invalid
+func parseIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Invalid ID",
+		})
+		return 0, false
+	}
+	return uint(id), true
+}
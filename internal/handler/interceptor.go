@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/moderation"
+	"github.com/JennerWork/chatbot/internal/service"
+)
+
+var (
+	// ErrMessageTooLarge message content exceeds the size limit, matches service.ErrInvalidMessage
+	ErrMessageTooLarge = fmt.Errorf("消息过长: %w", service.ErrInvalidMessage)
+)
+
+// MessageInvoker processes a chat message and returns the final response, nil when there is nothing to reply
+type MessageInvoker func(ctx context.Context, customerID uint, req *service.MessageRequest) (*service.MessageResponse, error)
+
+// MessageInterceptor wraps the processing of chat messages. An interceptor may inspect or rewrite req
+// before calling next, reject the message by returning an error, short-circuit it by returning a
+// response without calling next, and inspect or rewrite the response returned by next.
+// Sync commands and typing/read events do not pass through interceptors.
+type MessageInterceptor interface {
+	Intercept(ctx context.Context, customerID uint, req *service.MessageRequest, next MessageInvoker) (*service.MessageResponse, error)
+}
+
+// MessageInterceptorFunc adapts a function to MessageInterceptor
+type MessageInterceptorFunc func(ctx context.Context, customerID uint, req *service.MessageRequest, next MessageInvoker) (*service.MessageResponse, error)
+
+// Intercept calls f
+func (f MessageInterceptorFunc) Intercept(ctx context.Context, customerID uint, req *service.MessageRequest, next MessageInvoker) (*service.MessageResponse, error) {
+	return f(ctx, customerID, req, next)
+}
+
+// chain wraps invoker with interceptors, the first interceptor is the outermost
+func chain(interceptors []MessageInterceptor, invoker MessageInvoker) MessageInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, customerID uint, req *service.MessageRequest) (*service.MessageResponse, error) {
+			return interceptor.Intercept(ctx, customerID, req, next)
+		}
+	}
+	return invoker
+}
+
+// sizeLimitInterceptor rejects messages whose content is larger than maxBytes
+type sizeLimitInterceptor struct {
+	maxBytes int
+}
+
+// defaultMaxMessageSize default content size limit of NewSizeLimitInterceptor
+const defaultMaxMessageSize = 16 * 1024
+
+// NewSizeLimitInterceptor create an interceptor rejecting messages whose content exceeds maxBytes bytes,
+// 16 KiB when maxBytes is not positive
+func NewSizeLimitInterceptor(maxBytes int) MessageInterceptor {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxMessageSize
+	}
+	return &sizeLimitInterceptor{maxBytes: maxBytes}
+}
+
+// Intercept reject oversized messages before they are stored
+func (i *sizeLimitInterceptor) Intercept(ctx context.Context, customerID uint, req *service.MessageRequest, next MessageInvoker) (*service.MessageResponse, error) {
+	if size := len(req.Content) + len(req.Extra); size > i.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrMessageTooLarge, size, i.maxBytes)
+	}
+	return next(ctx, customerID, req)
+}
+
+// profanityInterceptor masks profane words in customer text messages
+type profanityInterceptor struct {
+	pattern *regexp.Regexp
+}
+
+// NewProfanityInterceptor create an interceptor masking the given words in text messages with '*',
+// words are matched the same way as moderation word lists (see moderation.WordPattern)
+func NewProfanityInterceptor(words []string) MessageInterceptor {
+	return &profanityInterceptor{pattern: moderation.WordPattern(words)}
+}
+
+// Intercept rewrite text messages with profane words masked
+func (i *profanityInterceptor) Intercept(ctx context.Context, customerID uint, req *service.MessageRequest, next MessageInvoker) (*service.MessageResponse, error) {
+	if i.pattern == nil || req.Type != service.MessageTypeText {
+		return next(ctx, customerID, req)
+	}
+
+	var msg service.TextMessage
+	if err := json.Unmarshal(req.Content, &msg); err != nil {
+		// 内容无效的消息交给消息服务返回错误
+		return next(ctx, customerID, req)
+	}
+	masked := i.pattern.ReplaceAllStringFunc(msg.Text, func(word string) string {
+		return strings.Repeat("*", len([]rune(word)))
+	})
+	if masked != msg.Text {
+		msg.Text = masked
+		content, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		req.Content = content
+	}
+	return next(ctx, customerID, req)
+}
+
+// auditInterceptor logs every chat message with its outcome
+type auditInterceptor struct{}
+
+// NewAuditInterceptor create an interceptor logging the type, size, result and duration of every chat message,
+// message content is not logged
+func NewAuditInterceptor() MessageInterceptor {
+	return &auditInterceptor{}
+}
+
+// Intercept log the message after it has been processed
+func (i *auditInterceptor) Intercept(ctx context.Context, customerID uint, req *service.MessageRequest, next MessageInvoker) (*service.MessageResponse, error) {
+	start := time.Now()
+	resp, err := next(ctx, customerID, req)
+
+	result := "ok"
+	switch {
+	case err != nil:
+		result = "error: " + err.Error()
+	case resp != nil:
+		result = "reply " + resp.Type
+	}
+	log.Printf("[audit] customer=%d type=%s client_msg_id=%q size=%d result=%s duration=%v",
+		customerID, req.Type, req.ClientMsgID, len(req.Content), result, time.Since(start))
+	return resp, err
+}
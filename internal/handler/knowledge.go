@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ArticleRequest knowledge base article request parameters
+type ArticleRequest struct {
+	Title    string   `json:"title" binding:"required,max=255"`
+	Body     string   `json:"body" binding:"required"`
+	Tags     []string `json:"tags"`
+	Language string   `json:"language" binding:"omitempty,max=10"`
+}
+
+// ArticleListResponse knowledge base article list response
+type ArticleListResponse struct {
+	Total    int64             `json:"total"`
+	Articles []model.KBArticle `json:"articles"`
+}
+
+// KnowledgeHandler knowledge base admin handler
+type KnowledgeHandler struct {
+	knowledgeService service.KnowledgeService
+}
+
+// NewKnowledgeHandler create a knowledge base handler
+func NewKnowledgeHandler(knowledgeService service.KnowledgeService) *KnowledgeHandler {
+	return &KnowledgeHandler{
+		knowledgeService: knowledgeService,
+	}
+}
+
+// ListArticles list knowledge base articles
+// @Summary List Articles
+// @Description List knowledge base articles with pagination
+// @Tags knowledge
+// @Produce json
+// @Param page query int false "Page Number (default: 1)"
+// @Param page_size query int false "Page Size (default: 20)"
+// @Success 200 {object} ArticleListResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/kb [get]
+func (h *KnowledgeHandler) ListArticles(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	articles, total, err := h.knowledgeService.ListArticles(page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    500,
+			Message: "Failed to list articles",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ArticleListResponse{
+		Total:    total,
+		Articles: articles,
+	})
+}
+
+// GetArticle get a knowledge base article
+// @Summary Get Article
+// @Description Get a knowledge base article by ID
+// @Tags knowledge
+// @Produce json
+// @Param id path int true "Article ID"
+// @Success 200 {object} model.KBArticle
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/kb/{id} [get]
+func (h *KnowledgeHandler) GetArticle(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	article, err := h.knowledgeService.GetArticle(id)
+	if err != nil {
+		writeArticleError(c, err, "Failed to get article")
+		return
+	}
+
+	c.JSON(http.StatusOK, article)
+}
+
+// CreateArticle create a knowledge base article
+// @Summary Create Article
+// @Description Create a knowledge base article and rebuild the search index
+// @Tags knowledge
+// @Accept json
+// @Produce json
+// @Param request body ArticleRequest true "Article"
+// @Success 200 {object} model.KBArticle
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/kb [post]
+func (h *KnowledgeHandler) CreateArticle(c *gin.Context) {
+	var req ArticleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Invalid request parameters",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	article, err := h.knowledgeService.CreateArticle(req.toInput())
+	if err != nil {
+		writeArticleError(c, err, "Failed to create article")
+		return
+	}
+
+	c.JSON(http.StatusOK, article)
+}
+
+// UpdateArticle update a knowledge base article
+// @Summary Update Article
+// @Description Update a knowledge base article and rebuild the search index
+// @Tags knowledge
+// @Accept json
+// @Produce json
+// @Param id path int true "Article ID"
+// @Param request body ArticleRequest true "Article"
+// @Success 200 {object} model.KBArticle
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/kb/{id} [put]
+func (h *KnowledgeHandler) UpdateArticle(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req ArticleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Invalid request parameters",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	article, err := h.knowledgeService.UpdateArticle(id, req.toInput())
+	if err != nil {
+		writeArticleError(c, err, "Failed to update article")
+		return
+	}
+
+	c.JSON(http.StatusOK, article)
+}
+
+// DeleteArticle delete a knowledge base article
+// @Summary Delete Article
+// @Description Delete a knowledge base article and rebuild the search index
+// @Tags knowledge
+// @Produce json
+// @Param id path int true "Article ID"
+// @Success 200 {object} gin.H
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/kb/{id} [delete]
+func (h *KnowledgeHandler) DeleteArticle(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.knowledgeService.DeleteArticle(id); err != nil {
+		writeArticleError(c, err, "Failed to delete article")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Article deleted successfully",
+	})
+}
+
+// Reindex rebuild the knowledge base search index
+// @Summary Reindex
+// @Description Rebuild the knowledge base search index from the database
+// @Tags knowledge
+// @Produce json
+// @Success 200 {object} gin.H
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/kb/reindex [post]
+func (h *KnowledgeHandler) Reindex(c *gin.Context) {
+	if err := h.knowledgeService.Reindex(); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    500,
+			Message: "Failed to rebuild index",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Index rebuilt successfully",
+	})
+}
+
+// toInput convert the request to service input
+func (r ArticleRequest) toInput() service.ArticleInput {
+	return service.ArticleInput{
+		Title:    r.Title,
+		Body:     r.Body,
+		Tags:     r.Tags,
+		Language: r.Language,
+	}
+}
+
+// writeArticleError write an article error response
+func writeArticleError(c *gin.Context, err error, message string) {
+	status := http.StatusInternalServerError
+	if err == service.ErrArticleNotFound {
+		status = http.StatusNotFound
+		message = "Article not found"
+	}
+
+	c.JSON(status, ErrorResponse{
+		Code:    status,
+		Message: message,
+		Error:   err.Error(),
+	})
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/JennerWork/chatbot/internal/middleware"
@@ -10,19 +11,179 @@ import (
 
 // MessageHandler message-related handler
 type MessageHandler struct {
-	queryService service.MessageQueryService
+	queryService   service.MessageQueryService
+	messageService service.MessageService
+	syncService    service.SyncService
+	dispatcher     service.Dispatcher
 }
 
-// NewMessageHandler create message handler
-func NewMessageHandler(queryService service.MessageQueryService) *MessageHandler {
+// NewMessageHandler create message handler. Messages sent over HTTP go through messageService,
+// the same pipeline as WebSocket messages, and replies are also pushed through dispatcher
+func NewMessageHandler(queryService service.MessageQueryService, messageService service.MessageService, syncService service.SyncService, dispatcher service.Dispatcher) *MessageHandler {
 	return &MessageHandler{
-		queryService: queryService,
+		queryService:   queryService,
+		messageService: messageService,
+		syncService:    syncService,
+		dispatcher:     dispatcher,
+	}
+}
+
+// SendMessage send a message over HTTP
+// @Summary Send Message
+// @Description Send a message without holding a WebSocket connection. The body has the same format as a WebSocket message; the reply is returned synchronously and also pushed to the customer's open WebSocket connections. Streaming is not supported. Returns 202 with an empty body when the message was relayed to a human agent, when a message with the same client_msg_id was already processed within the dedupe window, or for typing events. A read event returns the read_receipt frame.
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Param request body service.MessageRequest true "Message"
+// @Success 200 {object} service.MessageResponse
+// @Success 202 "Relayed to the agent handling the session, a duplicate client_msg_id, or a typing event"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/message/send [post]
+func (h *MessageHandler) SendMessage(c *gin.Context) {
+	customerID := middleware.GetCustomerID(c)
+	if customerID == 0 {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Code:    401,
+			Message: "Unauthorized user",
+		})
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil || len(body) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Invalid request parameters",
+		})
+		return
+	}
+
+	response, err := h.messageService.HandleMessage(c.Request.Context(), customerID, 0, body, nil)
+	if errors.Is(err, service.ErrDuplicateMessage) {
+		// 相同 client_msg_id 的消息已经处理过
+		c.Status(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		writeSendError(c, err)
+		return
+	}
+
+	// 客户已打开的WebSocket连接也显示这条消息
+	if frame, ok, err := service.NewEchoFrame(body); err == nil && ok {
+		h.dispatcher.SendToCustomer(customerID, frame)
+	}
+
+	// 人工客服接待中的会话，消息已转发给客服，没有机器人回复；输入状态事件也没有回复
+	if response == nil {
+		c.Status(http.StatusAccepted)
+		return
+	}
+
+	// 同时推送给客户已打开的WebSocket连接，保持各端消息一致
+	h.dispatcher.SendToCustomer(customerID, response)
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", response)
+}
+
+// SyncMessages incremental sync by message sequence number
+// @Summary Sync Messages
+// @Description Return the messages after the last seen seq of each session, in order, followed by the sync_complete marker. When has_more is set, call again with the returned cursors.
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Param request body service.SyncRequest true "Last seen seq per session"
+// @Success 200 {object} service.SyncResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/message/sync [post]
+func (h *MessageHandler) SyncMessages(c *gin.Context) {
+	customerID := middleware.GetCustomerID(c)
+	if customerID == 0 {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Code:    401,
+			Message: "Unauthorized user",
+		})
+		return
+	}
+
+	var req service.SyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Invalid request parameters",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	result, err := h.syncService.Sync(customerID, req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to sync messages"
+		switch {
+		case errors.Is(err, service.ErrSyncSessionNotFound):
+			status = http.StatusNotFound
+			message = "Session not found"
+		case errors.Is(err, service.ErrInvalidMessage):
+			status = http.StatusBadRequest
+			message = "Invalid request parameters"
+		}
+		c.JSON(status, ErrorResponse{
+			Code:    status,
+			Message: message,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// writeSendError write a send message error response
+func writeSendError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidMessage), errors.Is(err, service.ErrUnsupportedMessageType):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Invalid message",
+			Error:   err.Error(),
+		})
+	case errors.Is(err, service.ErrAttachmentNotFound),
+		errors.Is(err, service.ErrAttachmentForbidden),
+		errors.Is(err, service.ErrAttachmentTypeNotAllowed),
+		errors.Is(err, service.ErrAttachmentAlreadySent):
+		writeAttachmentError(c, err, "Invalid attachment")
+	case errors.Is(err, service.ErrMessageBlocked):
+		c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+			Code:    422,
+			Message: "Message blocked by content moderation",
+			Error:   err.Error(),
+		})
+	case errors.Is(err, service.ErrReadSessionNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    404,
+			Message: "Session not found",
+			Error:   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    500,
+			Message: "Failed to send message",
+			Error:   err.Error(),
+		})
 	}
 }
 
 // GetMessageHistory get message history
 // @Summary Get Chat History
-// @Description Get chat history records of the currently authenticated user
+// @Description Get chat history records of the currently authenticated user. unread_count is the number of bot and agent messages after the customer's last read receipt, filtered by session_id only.
 // @Tags messages
 // @Accept json
 // @Produce json
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/JennerWork/chatbot/internal/middleware"
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ReviewFlagRequest moderation review request parameters
+type ReviewFlagRequest struct {
+	Status string `json:"status" binding:"required,oneof=dismissed confirmed"`
+	Note   string `json:"note"`
+}
+
+// FlagListResponse moderation flag list response
+type FlagListResponse struct {
+	Total int64                  `json:"total"`
+	Flags []model.ModerationFlag `json:"flags"`
+}
+
+// ModerationHandler content moderation admin handler
+type ModerationHandler struct {
+	moderationService service.ModerationService
+}
+
+// NewModerationHandler create a content moderation handler
+func NewModerationHandler(moderationService service.ModerationService) *ModerationHandler {
+	return &ModerationHandler{
+		moderationService: moderationService,
+	}
+}
+
+// ListFlags list moderation flags
+// @Summary List Moderation Flags
+// @Description List messages flagged, escalated or blocked by content moderation, newest first. Each flag carries the original text and the moderation verdict as JSON.
+// @Tags moderation
+// @Produce json
+// @Param status query string false "Review status: pending, dismissed or confirmed (default: all)"
+// @Param page query int false "Page Number (default: 1)"
+// @Param page_size query int false "Page Size (default: 20)"
+// @Success 200 {object} FlagListResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/moderation/flags [get]
+func (h *ModerationHandler) ListFlags(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	flags, total, err := h.moderationService.ListFlags(c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    500,
+			Message: "Failed to list moderation flags",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, FlagListResponse{
+		Total: total,
+		Flags: flags,
+	})
+}
+
+// ReviewFlag review a moderation flag
+// @Summary Review Moderation Flag
+// @Description Mark a pending moderation flag as dismissed (false positive) or confirmed
+// @Tags moderation
+// @Accept json
+// @Produce json
+// @Param id path int true "Flag ID"
+// @Param request body ReviewFlagRequest true "Review"
+// @Success 200 {object} model.ModerationFlag
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/admin/moderation/flags/{id}/review [post]
+func (h *ModerationHandler) ReviewFlag(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req ReviewFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    400,
+			Message: "Invalid request parameters",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	flag, err := h.moderationService.ReviewFlag(id, middleware.GetCustomerID(c), req.Status, req.Note)
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to review moderation flag"
+		switch {
+		case errors.Is(err, service.ErrInvalidReviewStatus):
+			status, message = http.StatusBadRequest, "Invalid review status"
+		case errors.Is(err, service.ErrFlagNotFound):
+			status, message = http.StatusNotFound, "Moderation flag not found"
+		case errors.Is(err, service.ErrFlagAlreadyReviewed):
+			status, message = http.StatusConflict, "Moderation flag already reviewed"
+		}
+		c.JSON(status, ErrorResponse{
+			Code:    status,
+			Message: message,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, flag)
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/JennerWork/chatbot/internal/middleware"
+	"github.com/JennerWork/chatbot/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// PrivacyHandler PII vault admin handler
+type PrivacyHandler struct {
+	privacyService service.PrivacyService
+}
+
+// NewPrivacyHandler create a PII vault handler
+func NewPrivacyHandler(privacyService service.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{
+		privacyService: privacyService,
+	}
+}
+
+// RevealMessage read the original content of a redacted message
+// @Summary Reveal Redacted Message
+// @Description Decrypt the original content of a message whose personal information (card numbers, phone numbers, emails, ID card numbers) was replaced with placeholders before it was stored. Every access is logged.
+// @Tags privacy
+// @Produce json
+// @Param id path int true "Message ID"
+// @Success 200 {object} service.VaultEntry
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /api/admin/vault/messages/{id} [get]
+func (h *PrivacyHandler) RevealMessage(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	entry, err := h.privacyService.Reveal(id, middleware.GetCustomerID(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "Failed to read vault entry"
+		switch {
+		case errors.Is(err, service.ErrVaultEntryNotFound):
+			status, message = http.StatusNotFound, "Vault entry not found"
+		case errors.Is(err, service.ErrVaultDisabled):
+			status, message = http.StatusServiceUnavailable, "PII vault is not enabled"
+		}
+		c.JSON(status, ErrorResponse{
+			Code:    status,
+			Message: message,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
@@ -1,44 +1,122 @@
 package handler
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
+	"fmt"
 
 	"github.com/JennerWork/chatbot/internal/service"
 )
 
 var (
-	// ErrInvalidMessage invalid message
-	ErrInvalidMessage = errors.New("无效的消息")
+	// ErrInvalidMessage invalid message, matches service.ErrInvalidMessage
+	ErrInvalidMessage = fmt.Errorf("无效的消息: %w", service.ErrInvalidMessage)
 )
 
 // WebSocketHandler WebSocket message handler
 type WebSocketHandler struct {
 	messageService service.MessageService
+	syncService    service.SyncService
+	eventService   service.EventService
+	interceptors   []MessageInterceptor
 }
 
 // NewWebSocketHandler create WebSocket message handler
-func NewWebSocketHandler(messageService service.MessageService) *WebSocketHandler {
+func NewWebSocketHandler(messageService service.MessageService, syncService service.SyncService, eventService service.EventService) *WebSocketHandler {
 	return &WebSocketHandler{
 		messageService: messageService,
+		syncService:    syncService,
+		eventService:   eventService,
 	}
 }
 
-// HandleMessage handle WebSocket message
-func (h *WebSocketHandler) HandleMessage(customerID uint, message []byte) ([]byte, error) {
+// Use append interceptors to the chat message pipeline, interceptors run in the order they are added.
+// Must be called before the handler starts serving
+func (h *WebSocketHandler) Use(interceptors ...MessageInterceptor) {
+	h.interceptors = append(h.interceptors, interceptors...)
+}
+
+// HandleMessage handle WebSocket message, sessionID is the session bound to the connection (0 for HTTP requests)
+func (h *WebSocketHandler) HandleMessage(ctx context.Context, customerID uint, sessionID uint, message []byte, w service.FrameWriter) ([]byte, error) {
 	// TODO: Add message validation
 	if len(message) == 0 {
 		return nil, ErrInvalidMessage
 	}
 
-	// TODO: Add pre-message processing hooks
+	// sync 命令和输入状态、已读事件不是聊天消息，不经过消息服务
+	var envelope struct {
+		Type    string          `json:"type"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(message, &envelope); err == nil {
+		switch {
+		case envelope.Type == service.MessageTypeSync:
+			return h.handleSync(customerID, envelope.Content, w)
+		case service.IsEventType(envelope.Type):
+			return h.eventService.HandleCustomerEvent(customerID, envelope.Type, envelope.Content)
+		}
+	}
+
+	if len(h.interceptors) == 0 {
+		return h.messageService.HandleMessage(ctx, customerID, sessionID, message, w)
+	}
+
+	// 聊天消息经过拦截器链处理
+	var request service.MessageRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return nil, fmt.Errorf("%w: %w", service.ErrInvalidMessage, err)
+	}
+	invoke := chain(h.interceptors, func(ctx context.Context, customerID uint, req *service.MessageRequest) (*service.MessageResponse, error) {
+		return h.processMessage(ctx, customerID, sessionID, req, w)
+	})
+	response, err := invoke(ctx, customerID, &request)
+	if err != nil || response == nil {
+		return nil, err
+	}
+	return json.Marshal(response)
+}
 
-	// 处理消息
-	response, err := h.messageService.HandleMessage(customerID, message)
+// processMessage pass the (possibly rewritten) request to the message service and decode its response
+func (h *WebSocketHandler) processMessage(ctx context.Context, customerID uint, sessionID uint, req *service.MessageRequest, w service.FrameWriter) (*service.MessageResponse, error) {
+	message, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
+	frame, err := h.messageService.HandleMessage(ctx, customerID, sessionID, message, w)
+	if err != nil || frame == nil {
+		return nil, err
+	}
+
+	var response service.MessageResponse
+	if err := json.Unmarshal(frame, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// handleSync push the missing messages one frame each, then return the sync_complete frame
+func (h *WebSocketHandler) handleSync(customerID uint, content json.RawMessage, w service.FrameWriter) ([]byte, error) {
+	if w == nil {
+		return nil, fmt.Errorf("%w: sync needs a WebSocket connection, use /api/message/sync instead", service.ErrInvalidMessage)
+	}
 
-	// TODO: Add post-message processing hooks
+	var req service.SyncRequest
+	if err := json.Unmarshal(content, &req); err != nil {
+		return nil, fmt.Errorf("%w: %w", service.ErrInvalidMessage, err)
+	}
 
-	return response, nil
+	result, err := h.syncService.Sync(customerID, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range result.Messages {
+		frame, err := service.NewFrame(service.MessageTypeSyncMessage, msg)
+		if err != nil {
+			return nil, err
+		}
+		if err := w(frame); err != nil {
+			return nil, err
+		}
+	}
+	return service.NewFrame(service.MessageTypeSyncComplete, result.Complete)
 }
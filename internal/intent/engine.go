@@ -0,0 +1,278 @@
+package intent
+
+import (
+	"bytes"
+	"log"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/JennerWork/chatbot/pkg/utils"
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce 文件变更后等待的时间，避免编辑器多次写入触发重复加载
+const reloadDebounce = 200 * time.Millisecond
+
+// Match 意图识别结果
+type Match struct {
+	Intent   *Intent           // 命中的意图
+	Score    float64           // 置信度，0~1
+	Fallback bool              // 是否为兜底意图
+	Captures map[string]string // 正则命名分组的捕获结果
+	Text     string            // 原始输入
+
+	templates []*template.Template
+}
+
+// HasResponse 是否有可用的回复模板
+func (m *Match) HasResponse() bool {
+	return len(m.templates) > 0
+}
+
+// Reply 随机选择一条回复模板并渲染，模板中可使用 .Text、.Intent 和 .Captures
+func (m *Match) Reply() (string, error) {
+	if len(m.templates) == 0 {
+		return "", nil
+	}
+
+	tmpl := m.templates[rand.Intn(len(m.templates))]
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]interface{}{
+		"Text":     m.Text,
+		"Intent":   m.Intent.Name,
+		"Captures": m.Captures,
+	}); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Engine 基于规则的意图识别引擎，支持从文件热加载
+type Engine struct {
+	mu      sync.RWMutex
+	set     *compiledSet
+	path    string
+	watcher *fsnotify.Watcher
+}
+
+// NewEngine 使用给定的意图定义创建引擎
+func NewEngine(def *Definition) (*Engine, error) {
+	set, err := compile(def)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{set: set}, nil
+}
+
+// NewEngineFromFile 从YAML文件创建引擎
+func NewEngineFromFile(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload 重新加载意图文件，加载失败时保留原有定义
+func (e *Engine) Reload() error {
+	def, err := LoadFile(e.path)
+	if err != nil {
+		return err
+	}
+	set, err := compile(def)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.set = set
+	e.mu.Unlock()
+	return nil
+}
+
+// Recognize 对输入文本打分并返回得分最高的意图，低于阈值时返回兜底意图
+func (e *Engine) Recognize(text string) *Match {
+	e.mu.RLock()
+	set := e.set
+	e.mu.RUnlock()
+
+	tokens := utils.Tokenize(text)
+
+	var best *compiledIntent
+	var bestScore float64
+	var bestCaptures map[string]string
+	for _, ci := range set.intents {
+		score, captures := ci.score(text, tokens)
+		if score > bestScore {
+			best, bestScore, bestCaptures = ci, score, captures
+		}
+	}
+
+	if best == nil || bestScore < set.threshold {
+		return &Match{
+			Intent:    set.fallback.Intent,
+			Score:     bestScore,
+			Fallback:  true,
+			Text:      text,
+			templates: set.fallback.templates,
+		}
+	}
+
+	return &Match{
+		Intent:    best.Intent,
+		Score:     bestScore,
+		Captures:  bestCaptures,
+		Text:      text,
+		templates: best.templates,
+	}
+}
+
+// score 计算意图得分：正则命中得 1 分；关键词命中 1 个得 0.6 分，每多 1 个加 0.2 分；
+// 示例语句按词元 Jaccard 相似度计分。最终取三者最大值
+func (ci *compiledIntent) score(text string, tokens []string) (float64, map[string]string) {
+	for _, re := range ci.patterns {
+		m := re.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		captures := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if name != "" && i < len(m) {
+				captures[name] = m[i]
+			}
+		}
+		return 1, captures
+	}
+
+	var score float64
+	hits := 0
+	for _, kw := range ci.keywords {
+		if containsTokens(tokens, kw) {
+			hits++
+		}
+	}
+	if hits > 0 {
+		score = 0.6 + 0.2*float64(hits-1)
+		if score > 1 {
+			score = 1
+		}
+	}
+
+	for _, example := range ci.examples {
+		if sim := jaccard(tokens, example); sim > score {
+			score = sim
+		}
+	}
+
+	return score, nil
+}
+
+// containsTokens 判断 tokens 中是否包含连续的 sub 序列，
+// 按词元而非子串匹配，避免 "hi" 命中 "this" 这类情况
+func containsTokens(tokens, sub []string) bool {
+	for i := 0; i+len(sub) <= len(tokens); i++ {
+		matched := true
+		for j := range sub {
+			if tokens[i+j] != sub[j] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// jaccard 计算两组词元的 Jaccard 相似度
+func jaccard(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	setA := make(map[string]bool, len(a))
+	for _, t := range a {
+		setA[t] = true
+	}
+	setB := make(map[string]bool, len(b))
+	for _, t := range b {
+		setB[t] = true
+	}
+
+	intersection := 0
+	for t := range setA {
+		if setB[t] {
+			intersection++
+		}
+	}
+	union := len(setA) + len(setB) - intersection
+	return float64(intersection) / float64(union)
+}
+
+// Watch 监听意图文件变化并自动重新加载
+func (e *Engine) Watch() error {
+	if e.path == "" {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// 监听所在目录而不是文件本身，编辑器保存时常会替换文件
+	if err := watcher.Add(filepath.Dir(e.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	e.watcher = watcher
+
+	go e.watchLoop(watcher)
+	return nil
+}
+
+// watchLoop 处理文件变更事件
+func (e *Engine) watchLoop(watcher *fsnotify.Watcher) {
+	target := filepath.Clean(e.path)
+	var timer *time.Timer
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != target || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(reloadDebounce, func() {
+				if err := e.Reload(); err != nil {
+					log.Printf("Failed to reload intents from %s: %v", e.path, err)
+					return
+				}
+				log.Printf("Intents reloaded from %s", e.path)
+			})
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Intent file watcher error: %v", err)
+		}
+	}
+}
+
+// Close 停止监听文件变化
+func (e *Engine) Close() error {
+	if e.watcher == nil {
+		return nil
+	}
+	return e.watcher.Close()
+}
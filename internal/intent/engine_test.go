@@ -0,0 +1,172 @@
+package intent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testDefinition 测试用的意图定义
+func testDefinition() *Definition {
+	return &Definition{
+		Threshold: 0.5,
+		Intents: []Intent{
+			{Name: "greeting", Keywords: []string{"hi", "hello", "你好"}},
+			{Name: "order", Patterns: []string{`order #(?P<id>\d+)`}},
+			{Name: "refund", Keywords: []string{"refund", "money back"}, Examples: []string{"i want my money returned"}},
+			{Name: "hours", Examples: []string{"what are your opening hours"}},
+		},
+	}
+}
+
+func TestRecognize(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		wantIntent   string
+		wantFallback bool
+		wantScore    float64
+		wantCaptures map[string]string
+	}{
+		{"keyword", "hi there", "greeting", false, 0.6, nil},
+		{"keyword is case insensitive", "HELLO", "greeting", false, 0.6, nil},
+		{"keyword matches whole tokens", "this is it", DefaultFallback, true, 0, nil},
+		{"chinese keyword", "你好呀", "greeting", false, 0.6, nil},
+		{"each extra keyword adds to the score", "refund, money back please", "refund", false, 0.8, nil},
+		{"pattern with captures", "where is order #42", "order", false, 1, map[string]string{"id": "42"}},
+		{"example similarity", "what are your hours", "hours", false, 0.8, nil},
+		{"below threshold", "are you open", DefaultFallback, true, 1.0 / 7, nil},
+		{"nothing matched", "", DefaultFallback, true, 0, nil},
+	}
+	e, err := NewEngine(testDefinition())
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := e.Recognize(tt.text)
+			if match.Intent.Name != tt.wantIntent || match.Fallback != tt.wantFallback {
+				t.Errorf("Recognize(%q) = %s (fallback %v), want %s (fallback %v)",
+					tt.text, match.Intent.Name, match.Fallback, tt.wantIntent, tt.wantFallback)
+			}
+			if diff := match.Score - tt.wantScore; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("score = %v, want %v", match.Score, tt.wantScore)
+			}
+			if !reflect.DeepEqual(match.Captures, tt.wantCaptures) {
+				t.Errorf("captures = %v, want %v", match.Captures, tt.wantCaptures)
+			}
+		})
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want float64
+	}{
+		{[]string{"a", "b"}, []string{"a", "b"}, 1},
+		{[]string{"a", "b"}, []string{"b", "c"}, 1.0 / 3},
+		{[]string{"a", "a", "b"}, []string{"a"}, 0.5}, // 重复的词元只计一次
+		{[]string{"a"}, []string{"b"}, 0},
+		{nil, []string{"a"}, 0},
+	}
+	for _, tt := range tests {
+		if got := jaccard(tt.a, tt.b); got != tt.want {
+			t.Errorf("jaccard(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestContainsTokens(t *testing.T) {
+	tests := []struct {
+		tokens, sub []string
+		want        bool
+	}{
+		{[]string{"i", "want", "a", "real", "person"}, []string{"real", "person"}, true},
+		{[]string{"a", "real", "nice", "person"}, []string{"real", "person"}, false},
+		{[]string{"real"}, []string{"real", "person"}, false},
+		{[]string{"this"}, []string{"hi"}, false},
+	}
+	for _, tt := range tests {
+		if got := containsTokens(tt.tokens, tt.sub); got != tt.want {
+			t.Errorf("containsTokens(%v, %v) = %v, want %v", tt.tokens, tt.sub, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		intents []Intent
+	}{
+		{"missing name", []Intent{{Keywords: []string{"hi"}}}},
+		{"duplicate name", []Intent{{Name: "a"}, {Name: "a"}}},
+		{"invalid pattern", []Intent{{Name: "a", Patterns: []string{"("}}}},
+		{"invalid template", []Intent{{Name: "a", Responses: []string{"{{.Text"}}}},
+		{"buttons without text", []Intent{{Name: "a", Buttons: []Button{{Title: "b", Payload: "p"}}}}},
+		{"button with payload and url", []Intent{{Name: "a", Responses: []string{"r"},
+			Buttons: []Button{{Title: "b", Payload: "p", URL: "https://example.com"}}}}},
+		{"card without title", []Intent{{Name: "a", Cards: []Card{{Subtitle: "s"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewEngine(&Definition{Intents: tt.intents}); err == nil {
+				t.Error("NewEngine() succeeded, want error")
+			}
+		})
+	}
+}
+
+// writeIntents 将意图文件写入 path
+func writeIntents(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write intent file: %v", err)
+	}
+}
+
+// waitForIntent 等待引擎识别出 want，超时后失败
+func waitForIntent(t *testing.T, e *Engine, text, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if e.Recognize(text).Intent.Name == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Recognize(%q) did not become %s", text, want)
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "intents.yaml")
+	writeIntents(t, path, "intents:\n  - name: greeting\n    keywords: [hi]\n")
+	e, err := NewEngineFromFile(path)
+	if err != nil {
+		t.Fatalf("NewEngineFromFile failed: %v", err)
+	}
+
+	// 加载失败时保留原有定义
+	writeIntents(t, path, "intents:\n  - name: greeting\n    patterns: ['(']\n")
+	if err := e.Reload(); err == nil {
+		t.Fatal("Reload() of an invalid file succeeded")
+	}
+	if got := e.Recognize("hi").Intent.Name; got != "greeting" {
+		t.Errorf("after failed reload Recognize(hi) = %s, want greeting", got)
+	}
+
+	if err := e.Watch(); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer e.Close()
+
+	// 连续写入在最后一次写入后才加载，中间的无效内容不影响结果
+	writeIntents(t, path, "intents:\n  - name: [")
+	writeIntents(t, path, "intents:\n  - name: farewell\n    keywords: [bye]\n")
+	waitForIntent(t, e, "bye", "farewell")
+	if got := e.Recognize("hi").Intent.Name; got != DefaultFallback {
+		t.Errorf("after reload Recognize(hi) = %s, want %s", got, DefaultFallback)
+	}
+}
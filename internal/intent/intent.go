@@ -0,0 +1,227 @@
+package intent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"text/template"
+
+	"github.com/JennerWork/chatbot/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultThreshold 默认置信度阈值
+	DefaultThreshold = 0.5
+	// DefaultFallback 默认兜底意图名称
+	DefaultFallback = "fallback"
+)
+
+var (
+	// ErrDuplicateIntent 意图名称重复
+	ErrDuplicateIntent = errors.New("duplicate intent name")
+)
+
+// Intent 意图定义
+type Intent struct {
+	Name      string   `yaml:"name" json:"name"`
+	Keywords  []string `yaml:"keywords" json:"keywords"`   // 关键词，命中任意一个即可得分
+	Patterns  []string `yaml:"patterns" json:"patterns"`   // 正则表达式，命名分组可在回复模板中引用
+	Examples  []string `yaml:"examples" json:"examples"`   // 示例语句，按词元相似度打分
+	Responses []string `yaml:"responses" json:"responses"` // 回复模板（text/template），随机选择一条
+	Action    string   `yaml:"action" json:"action"`       // 由聊天服务处理的动作，如 flow:feedback 表示启动评价流程
+
+	// 富消息回复，优先级：cards > buttons > quick_replies，回复模板渲染出的文本作为消息正文
+	QuickReplies []string `yaml:"quick_replies" json:"quick_replies"` // 快捷回复，点击后回传选项文本
+	Buttons      []Button `yaml:"buttons" json:"buttons"`             // 按钮
+	Cards        []Card   `yaml:"cards" json:"cards"`                 // 一张时回复卡片，多张时回复轮播
+}
+
+// Button 意图回复中的按钮，设置 payload 时回传事件，设置 url 时打开链接
+type Button struct {
+	Title   string `yaml:"title" json:"title"`
+	Payload string `yaml:"payload" json:"payload"`
+	URL     string `yaml:"url" json:"url"`
+}
+
+// Card 意图回复中的卡片
+type Card struct {
+	Title    string   `yaml:"title" json:"title"`
+	Subtitle string   `yaml:"subtitle" json:"subtitle"`
+	ImageURL string   `yaml:"image_url" json:"image_url"`
+	Buttons  []Button `yaml:"buttons" json:"buttons"`
+}
+
+// HasRichContent 是否配置了富消息回复
+func (i *Intent) HasRichContent() bool {
+	return len(i.QuickReplies) > 0 || len(i.Buttons) > 0 || len(i.Cards) > 0
+}
+
+// Definition 意图配置文件内容
+type Definition struct {
+	Threshold float64  `yaml:"threshold" json:"threshold"` // 置信度阈值，低于阈值时使用兜底意图
+	Fallback  string   `yaml:"fallback" json:"fallback"`   // 兜底意图名称
+	Intents   []Intent `yaml:"intents" json:"intents"`
+}
+
+// compiledIntent 预编译后的意图
+type compiledIntent struct {
+	*Intent
+	keywords  [][]string
+	patterns  []*regexp.Regexp
+	examples  [][]string
+	templates []*template.Template
+}
+
+// compiledSet 预编译后的意图集合
+type compiledSet struct {
+	threshold float64
+	fallback  *compiledIntent
+	intents   []*compiledIntent
+}
+
+// DefaultDefinition 未配置意图文件时使用的内置定义
+func DefaultDefinition() *Definition {
+	return &Definition{
+		Threshold: DefaultThreshold,
+		Fallback:  DefaultFallback,
+		Intents: []Intent{
+			{
+				Name:     "feedback",
+				Keywords: []string{"feedback", "review", "评价", "反馈", "评论"},
+				Action:   "flow:feedback",
+			},
+			{
+				Name:     "handoff",
+				Keywords: []string{"human", "real person", "人工", "转人工"},
+				Action:   "handoff",
+			},
+			{
+				Name: DefaultFallback,
+			},
+		},
+	}
+}
+
+// LoadFile 从YAML文件加载意图定义
+func LoadFile(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read intent file: %w", err)
+	}
+
+	var def Definition
+	if err := yaml.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("failed to parse intent file: %w", err)
+	}
+
+	return &def, nil
+}
+
+// checkRichContent 校验富消息回复的必填项，完整校验在生成回复时进行
+func checkRichContent(in *Intent) error {
+	if len(in.Buttons) > 0 && len(in.Responses) == 0 {
+		return fmt.Errorf("intent %s: buttons need a response text", in.Name)
+	}
+	if len(in.QuickReplies) > 0 && len(in.Responses) == 0 {
+		return fmt.Errorf("intent %s: quick replies need a response text", in.Name)
+	}
+
+	buttons := append([]Button{}, in.Buttons...)
+	for i, card := range in.Cards {
+		if card.Title == "" {
+			return fmt.Errorf("intent %s: card #%d has no title", in.Name, i+1)
+		}
+		buttons = append(buttons, card.Buttons...)
+	}
+	for _, b := range buttons {
+		if b.Title == "" || (b.Payload == "") == (b.URL == "") {
+			return fmt.Errorf("intent %s: button %q needs a title and either a payload or a url", in.Name, b.Title)
+		}
+	}
+	return nil
+}
+
+// compile 校验并预编译意图定义
+func compile(def *Definition) (*compiledSet, error) {
+	set := &compiledSet{
+		threshold: def.Threshold,
+	}
+	if set.threshold <= 0 {
+		set.threshold = DefaultThreshold
+	}
+
+	fallbackName := def.Fallback
+	if fallbackName == "" {
+		fallbackName = DefaultFallback
+	}
+
+	seen := make(map[string]bool)
+	for i := range def.Intents {
+		in := &def.Intents[i]
+		if in.Name == "" {
+			return nil, fmt.Errorf("intent #%d has no name", i+1)
+		}
+		if seen[in.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateIntent, in.Name)
+		}
+		seen[in.Name] = true
+
+		ci := &compiledIntent{Intent: in}
+		templates, err := parseTemplates(in)
+		if err != nil {
+			return nil, err
+		}
+		ci.templates = templates
+		if err := checkRichContent(in); err != nil {
+			return nil, err
+		}
+
+		// 兜底意图不参与打分
+		if in.Name == fallbackName {
+			set.fallback = ci
+			continue
+		}
+
+		for _, kw := range in.Keywords {
+			if tokens := utils.Tokenize(kw); len(tokens) > 0 {
+				ci.keywords = append(ci.keywords, tokens)
+			}
+		}
+		for _, p := range in.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("intent %s: invalid pattern %q: %w", in.Name, p, err)
+			}
+			ci.patterns = append(ci.patterns, re)
+		}
+		for _, ex := range in.Examples {
+			if tokens := utils.Tokenize(ex); len(tokens) > 0 {
+				ci.examples = append(ci.examples, tokens)
+			}
+		}
+
+		set.intents = append(set.intents, ci)
+	}
+
+	// 配置文件中没有定义兜底意图时自动补一个空意图
+	if set.fallback == nil {
+		set.fallback = &compiledIntent{Intent: &Intent{Name: fallbackName}}
+	}
+
+	return set, nil
+}
+
+// parseTemplates 解析意图的回复模板
+func parseTemplates(in *Intent) ([]*template.Template, error) {
+	templates := make([]*template.Template, 0, len(in.Responses))
+	for i, resp := range in.Responses {
+		tmpl, err := template.New(fmt.Sprintf("%s#%d", in.Name, i)).Option("missingkey=zero").Parse(resp)
+		if err != nil {
+			return nil, fmt.Errorf("intent %s: invalid response template: %w", in.Name, err)
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
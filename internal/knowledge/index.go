@@ -0,0 +1,178 @@
+package knowledge
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// BM25 参数
+const (
+	defaultK1 = 1.2
+	defaultB  = 0.75
+	// titleBoost 标题中的词元额外计入的次数
+	titleBoost = 2
+)
+
+// Document 待索引的文档
+type Document struct {
+	ID       uint
+	Title    string
+	Body     string
+	Tags     []string
+	Language string
+}
+
+// Result 检索结果
+type Result struct {
+	ID         uint
+	Score      float64 // BM25 原始得分
+	Confidence float64 // 得分相对于查询理论最高得分的比例，0~1
+}
+
+// indexedDoc 已索引的文档
+type indexedDoc struct {
+	id       uint
+	language string
+	tf       map[string]int
+	length   int
+}
+
+// Index 内存中的BM25倒排索引，可并发读取
+type Index struct {
+	mu     sync.RWMutex
+	docs   []indexedDoc
+	df     map[string]int
+	avgLen float64
+	k1     float64
+	b      float64
+}
+
+// NewIndex 创建空索引
+func NewIndex() *Index {
+	return &Index{
+		df: make(map[string]int),
+		k1: defaultK1,
+		b:  defaultB,
+	}
+}
+
+// Build 使用给定文档重建整个索引
+func (idx *Index) Build(docs []Document) {
+	indexed := make([]indexedDoc, 0, len(docs))
+	df := make(map[string]int)
+	totalLen := 0
+
+	for _, doc := range docs {
+		tf := make(map[string]int)
+		length := 0
+		add := func(tokens []string, weight int) {
+			for _, t := range tokens {
+				tf[t] += weight
+				length += weight
+			}
+		}
+		add(Tokenize(doc.Title), titleBoost)
+		for _, tag := range doc.Tags {
+			add(Tokenize(tag), 1)
+		}
+		add(Tokenize(doc.Body), 1)
+
+		for t := range tf {
+			df[t]++
+		}
+		totalLen += length
+		indexed = append(indexed, indexedDoc{
+			id:       doc.ID,
+			language: doc.Language,
+			tf:       tf,
+			length:   length,
+		})
+	}
+
+	avgLen := 0.0
+	if len(indexed) > 0 {
+		avgLen = float64(totalLen) / float64(len(indexed))
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.docs = indexed
+	idx.df = df
+	idx.avgLen = avgLen
+}
+
+// Size 返回已索引的文档数
+func (idx *Index) Size() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Search 检索与查询最相关的文档，按得分降序返回至多 limit 条。
+// language 不为空时只返回该语言或未设置语言的文档
+func (idx *Index) Search(query, language string, limit int) []Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	terms := uniqueTokens(Tokenize(query))
+	if len(terms) == 0 || len(idx.docs) == 0 {
+		return nil
+	}
+
+	// 查询的理论最高得分，用于把得分归一化为置信度
+	idf := make(map[string]float64, len(terms))
+	maxScore := 0.0
+	for _, t := range terms {
+		n := idx.df[t]
+		if n == 0 {
+			continue
+		}
+		idf[t] = math.Log(1 + (float64(len(idx.docs))-float64(n)+0.5)/(float64(n)+0.5))
+		maxScore += idf[t] * (idx.k1 + 1)
+	}
+	if maxScore == 0 {
+		return nil
+	}
+
+	var results []Result
+	for _, doc := range idx.docs {
+		if language != "" && doc.language != "" && doc.language != language {
+			continue
+		}
+
+		score := 0.0
+		norm := idx.k1 * (1 - idx.b + idx.b*float64(doc.length)/idx.avgLen)
+		for t, w := range idf {
+			f := float64(doc.tf[t])
+			if f == 0 {
+				continue
+			}
+			score += w * f * (idx.k1 + 1) / (f + norm)
+		}
+		if score > 0 {
+			results = append(results, Result{ID: doc.id, Score: score, Confidence: score / maxScore})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// uniqueTokens 去除重复的词元
+func uniqueTokens(tokens []string) []string {
+	seen := make(map[string]bool, len(tokens))
+	unique := tokens[:0]
+	for _, t := range tokens {
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
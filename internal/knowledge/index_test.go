@@ -0,0 +1,88 @@
+package knowledge
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// testDocuments 测试用的文档
+func testDocuments() []Document {
+	return []Document{
+		{ID: 1, Title: "Refund policy", Body: "Refunds are issued within 7 days.", Language: "en"},
+		{ID: 2, Title: "Shipping times", Body: "Orders ship in 2 days. Refund of shipping fees is not possible.", Language: "en"},
+		{ID: 3, Title: "退款政策", Body: "退款在七天内处理。", Language: "zh"},
+		{ID: 4, Title: "Contact", Body: "Email us about refunds or anything else."},
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		language string
+		limit    int
+		wantIDs  []uint
+	}{
+		{"title match ranks first", "refund", "", 0, []uint{1, 4, 2}},
+		{"limit", "refund", "", 1, []uint{1}},
+		{"language filter keeps documents without language", "refund", "zh", 0, []uint{4}},
+		{"chinese query", "怎么退款", "zh", 0, []uint{3}},
+		{"several terms", "shipping days", "", 0, []uint{2, 1}},
+		{"unknown terms", "warranty", "", 0, nil},
+		{"stop words only", "how do I", "", 0, nil},
+	}
+	idx := NewIndex()
+	idx.Build(testDocuments())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []uint
+			for _, result := range idx.Search(tt.query, tt.language, tt.limit) {
+				if result.Confidence <= 0 || result.Confidence > 1 {
+					t.Errorf("document %d confidence = %v, want (0, 1]", result.ID, result.Confidence)
+				}
+				ids = append(ids, result.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestSearchScore(t *testing.T) {
+	idx := NewIndex()
+	idx.Build([]Document{
+		{ID: 1, Body: "refund policy"},
+		{ID: 2, Body: "shipping times"},
+	})
+
+	// 两篇文档长度相同，文档长度归一化为 k1；词频为 1 时得分等于 idf
+	results := idx.Search("refund", "", 0)
+	if len(results) != 1 || results[0].ID != 1 {
+		t.Fatalf("Search(refund) = %+v, want document 1", results)
+	}
+	idf := math.Log(1 + (2-1+0.5)/(1+0.5))
+	if got := results[0].Score; math.Abs(got-idf) > 1e-9 {
+		t.Errorf("score = %v, want %v", got, idf)
+	}
+	if got, want := results[0].Confidence, 1/(defaultK1+1); math.Abs(got-want) > 1e-9 {
+		t.Errorf("confidence = %v, want %v", got, want)
+	}
+}
+
+func TestBuildReplacesIndex(t *testing.T) {
+	idx := NewIndex()
+	idx.Build(testDocuments())
+	idx.Build([]Document{{ID: 9, Title: "Warranty"}})
+
+	if got := idx.Size(); got != 1 {
+		t.Errorf("Size() = %d, want 1", got)
+	}
+	if results := idx.Search("refund", "", 0); len(results) != 0 {
+		t.Errorf("Search(refund) after rebuild = %+v, want none", results)
+	}
+	if results := idx.Search("warranty", "", 0); len(results) != 1 || results[0].ID != 9 {
+		t.Errorf("Search(warranty) after rebuild = %+v, want document 9", results)
+	}
+}
@@ -0,0 +1,86 @@
+package knowledge
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/JennerWork/chatbot/pkg/utils"
+)
+
+// stopWords 常见英文停用词，不参与索引
+var stopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true, "of": true,
+	"to": true, "in": true, "on": true, "for": true, "is": true, "are": true,
+	"was": true, "be": true, "it": true, "i": true, "my": true, "me": true,
+	"you": true, "your": true, "we": true, "do": true, "does": true, "can": true,
+	"how": true, "what": true, "with": true, "at": true, "by": true, "this": true,
+}
+
+// Tokenize 切分文本用于BM25索引和检索：
+// 英文按单词切分、转小写并去除停用词；中日韩文字连续片段切分为二元组，单字片段保留单字
+func Tokenize(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	var cjk []rune
+
+	flushWord := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := word.String()
+		word.Reset()
+		if !stopWords[w] {
+			tokens = append(tokens, stem(w))
+		}
+	}
+	flushCJK := func() {
+		switch {
+		case len(cjk) == 1:
+			tokens = append(tokens, string(cjk))
+		case len(cjk) > 1:
+			for i := 0; i+1 < len(cjk); i++ {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case utils.IsCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word.WriteRune(r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+
+	return tokens
+}
+
+// stem 极简的英文词干处理，只去掉常见的复数后缀
+func stem(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// DetectLanguage 粗略判断文本语言：包含中日韩文字时返回 zh，否则返回 en
+func DetectLanguage(text string) string {
+	for _, r := range text {
+		if utils.IsCJK(r) {
+			return "zh"
+		}
+	}
+	return "en"
+}
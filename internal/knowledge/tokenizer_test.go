@@ -0,0 +1,49 @@
+package knowledge
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"stop words removed", "How do I reset my password?", []string{"reset", "password"}},
+		{"lowercased", "REFUND Policy", []string{"refund", "policy"}},
+		{"plural stemmed", "orders deliveries glass", []string{"order", "delivery", "glass"}},
+		{"short words not stemmed", "gas bus", []string{"gas", "bus"}},
+		{"chinese bigrams", "退款政策", []string{"退款", "款政", "政策"}},
+		{"single chinese char", "退", []string{"退"}},
+		{"mixed", "iPhone退货", []string{"iphone", "退货"}},
+		{"chinese runs split by punctuation", "退款，发货", []string{"退款", "发货"}},
+		{"digits", "order 123", []string{"order", "123"}},
+		{"empty", "?!", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"where is my order", "en"},
+		{"我的订单在哪里", "zh"},
+		{"order 在哪里", "zh"},
+		{"", "en"},
+	}
+	for _, tt := range tests {
+		if got := DetectLanguage(tt.text); got != tt.want {
+			t.Errorf("DetectLanguage(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
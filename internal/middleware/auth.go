@@ -66,10 +66,31 @@ func AuthMiddleware(authService service.AuthService) gin.HandlerFunc {
 		context.Set(c.Request, "customer_id", claims.CustomerID)
 		c.Set("email", claims.Email)
 		context.Set(c.Request, "email", claims.Email)
+		c.Set("role", claims.Role)
+		context.Set(c.Request, "role", claims.Role)
 		c.Next()
 	}
 }
 
+// RequireRole 创建角色校验中间件，需放在认证中间件之后
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := GetRole(c)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "权限不足",
+		})
+		c.Abort()
+	}
+}
+
 // GetCustomerID 从上下文中获取客户ID
 func GetCustomerID(c *gin.Context) uint {
 	if id, exists := c.Get("customer_id"); exists {
@@ -89,3 +110,13 @@ func GetCustomerEmail(c *gin.Context) string {
 	}
 	return ""
 }
+
+// GetRole 从上下文中获取用户角色
+func GetRole(c *gin.Context) string {
+	if role, exists := c.Get("role"); exists {
+		if r, ok := role.(string); ok {
+			return r
+		}
+	}
+	return ""
+}
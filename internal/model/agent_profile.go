@@ -0,0 +1,80 @@
+package model
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AgentProfile 人工客服的接待档案，决定客服可以被分配哪些会话
+type AgentProfile struct {
+	gorm.Model
+	AgentID       uint     `gorm:"uniqueIndex;not null" json:"agent_id"`
+	Agent         Customer `gorm:"foreignKey:AgentID" json:"-"`
+	Skills        string   `gorm:"size:500" json:"skills"`    // 逗号分隔的技能，如 billing,refund
+	Languages     string   `gorm:"size:100" json:"languages"` // 逗号分隔的语言代码，为空时接待所有语言
+	MaxConcurrent int      `json:"max_concurrent"`            // 最多同时接待的会话数，0 表示使用默认值
+}
+
+// SkillList 返回技能列表
+func (p *AgentProfile) SkillList() []string {
+	return splitList(p.Skills)
+}
+
+// SetSkills 设置技能列表
+func (p *AgentProfile) SetSkills(skills []string) {
+	p.Skills = joinList(skills)
+}
+
+// LanguageList 返回语言列表
+func (p *AgentProfile) LanguageList() []string {
+	return splitList(p.Languages)
+}
+
+// SetLanguages 设置语言列表
+func (p *AgentProfile) SetLanguages(languages []string) {
+	p.Languages = joinList(languages)
+}
+
+// HasSkill 判断客服是否具备技能，skill 为空时总是返回 true
+func (p *AgentProfile) HasSkill(skill string) bool {
+	return skill == "" || containsFold(p.SkillList(), skill)
+}
+
+// SpeaksLanguage 判断客服是否接待该语言，未配置语言或 language 为空时总是返回 true
+func (p *AgentProfile) SpeaksLanguage(language string) bool {
+	languages := p.LanguageList()
+	return language == "" || len(languages) == 0 || containsFold(languages, language)
+}
+
+// splitList 拆分逗号分隔的列表
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// joinList 合并为逗号分隔的列表，忽略空项，统一为小写
+func joinList(items []string) string {
+	cleaned := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			cleaned = append(cleaned, item)
+		}
+	}
+	return strings.Join(cleaned, ",")
+}
+
+// containsFold 忽略大小写判断列表是否包含某项
+func containsFold(items []string, target string) bool {
+	for _, item := range items {
+		if strings.EqualFold(item, target) {
+			return true
+		}
+	}
+	return false
+}
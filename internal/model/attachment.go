@@ -0,0 +1,25 @@
+package model
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Attachment 客户上传的附件，发送消息后关联到该消息和会话
+type Attachment struct {
+	gorm.Model
+	CustomerID  uint     `gorm:"not null;index" json:"customer_id"` // 上传者
+	SessionID   *uint    `gorm:"index" json:"session_id,omitempty"`
+	MessageID   *uint    `gorm:"index" json:"message_id,omitempty"` // 未随消息发送前为空
+	FileName    string   `gorm:"size:255;not null" json:"file_name"`
+	ContentType string   `gorm:"size:100;not null" json:"content_type"` // 根据文件内容识别的MIME类型
+	Size        int64    `gorm:"not null" json:"size"`
+	StorageKey  string   `gorm:"size:255;not null" json:"-"` // 在对象存储中的键
+	Customer    Customer `gorm:"foreignKey:CustomerID" json:"-"`
+}
+
+// IsImage 是否为图片
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
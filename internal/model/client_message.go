@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// ClientMessage 客户端消息ID与服务端消息的对应关系，用于在去重窗口内识别重发的消息
+type ClientMessage struct {
+	CustomerID  uint      `gorm:"primaryKey" json:"customer_id"`
+	ClientMsgID string    `gorm:"primaryKey;size:64" json:"client_msg_id"`
+	MessageID   uint      `gorm:"not null" json:"message_id"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+	// RepliedAt 回复已保存或消息已转发给客服的时间，为空时重发的消息会重新生成回复
+	RepliedAt *time.Time `json:"replied_at"`
+}
@@ -7,6 +7,13 @@ import (
 	"gorm.io/gorm"
 )
 
+// 用户角色
+const (
+	RoleCustomer = "customer" // 普通客户
+	RoleAgent    = "agent"    // 人工客服
+	RoleAdmin    = "admin"    // 管理员，可维护知识库等后台数据
+)
+
 // Customer 客户信息
 type Customer struct {
 	ID        uint           `gorm:"primarykey" json:"id"`
@@ -15,6 +22,7 @@ type Customer struct {
 	Salt      string         `gorm:"size:32;not null" json:"-"`  // 密码盐值
 	Name      string         `gorm:"size:100" json:"name"`
 	Status    string         `gorm:"size:20;default:active" json:"status"`
+	Role      string         `gorm:"size:20;default:customer" json:"role"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
@@ -43,5 +51,8 @@ func (c *Customer) BeforeCreate(tx *gorm.DB) error {
 	if c.Status == "" {
 		c.Status = "active"
 	}
+	if c.Role == "" {
+		c.Role = RoleCustomer
+	}
 	return nil
 }
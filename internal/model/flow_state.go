@@ -0,0 +1,23 @@
+package model
+
+import "gorm.io/gorm"
+
+// FlowState 会话中多轮对话流程的执行状态
+type FlowState struct {
+	gorm.Model
+	CustomerID  uint       `json:"customer_id" gorm:"index"`
+	SessionID   uint       `json:"session_id" gorm:"index"`
+	FlowName    string     `json:"flow_name" gorm:"size:100;not null"`
+	CurrentStep string     `json:"current_step" gorm:"size:100;not null"`
+	Slots       string     `json:"slots" gorm:"type:text"` // 已填充槽位的JSON
+	Status      FlowStatus `json:"status" gorm:"size:20;index"`
+}
+
+// FlowStatus 流程状态
+type FlowStatus string
+
+const (
+	FlowStatusActive    FlowStatus = "active"
+	FlowStatusCompleted FlowStatus = "completed"
+	FlowStatusCancelled FlowStatus = "cancelled"
+)
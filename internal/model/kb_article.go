@@ -0,0 +1,38 @@
+package model
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// KBArticle 知识库文章
+type KBArticle struct {
+	gorm.Model
+	Title    string `gorm:"size:255;not null" json:"title"`
+	Body     string `gorm:"type:text;not null" json:"body"`
+	Tags     string `gorm:"size:500" json:"tags"`          // 逗号分隔的标签
+	Language string `gorm:"size:10;index" json:"language"` // 语言代码，如 en、zh
+}
+
+// TagList 返回标签列表
+func (a *KBArticle) TagList() []string {
+	var tags []string
+	for _, tag := range strings.Split(a.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// SetTags 设置标签列表
+func (a *KBArticle) SetTags(tags []string) {
+	cleaned := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			cleaned = append(cleaned, tag)
+		}
+	}
+	a.Tags = strings.Join(cleaned, ",")
+}
@@ -1,16 +1,31 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Message struct {
 	gorm.Model
-	Content    string   `json:"content"`
+	Type       string   `json:"type" gorm:"size:30;not null;default:text"` // 消息类型，决定 Content 的结构
+	Content    string   `json:"content"`                                   // 按类型序列化的JSON
 	CustomerID uint     `json:"customer_id"`
 	Customer   Customer `json:"customer" gorm:"foreignKey:CustomerID"`
 	Sender     Sender   `json:"sender"`
-	SessionID  uint     `json:"session_id"`
+	SessionID  uint     `json:"session_id" gorm:"uniqueIndex:uq_messages_session_seq,priority:1"`
 	Session    Session  `json:"session" gorm:"foreignKey:SessionID"`
-	Seq        uint     `json:"seq" gorm:"index;not null"`
+	Seq        uint     `json:"seq" gorm:"not null;uniqueIndex:uq_messages_session_seq,priority:2"` // 会话内从 1 开始连续递增
+	AgentID    *uint    `json:"agent_id,omitempty"`                                                 // 人工客服发送的消息记录客服ID
+
+	Attachments []Attachment `json:"attachments,omitempty" gorm:"foreignKey:MessageID"` // image/file 消息引用的附件
+}
+
+// MessageSeq 会话的消息序号计数器，在插入消息的事务中原子递增
+type MessageSeq struct {
+	SessionID uint `gorm:"primaryKey"`
+	LastSeq   uint `gorm:"not null;default:0"` // 已分配的最大序号
+	UpdatedAt time.Time
 }
 
 type Sender string
@@ -18,4 +33,5 @@ type Sender string
 const (
 	SenderCustomer Sender = "customer"
 	SenderBot      Sender = "bot"
+	SenderAgent    Sender = "agent"
 )
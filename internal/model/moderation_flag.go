@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 审核记录的复核状态
+const (
+	ModerationStatusPending   = "pending"   // 等待复核
+	ModerationStatusDismissed = "dismissed" // 复核后认为内容没有问题
+	ModerationStatusConfirmed = "confirmed" // 复核后确认违规
+)
+
+// ModerationFlag 内容审核命中、需要人工复核的消息
+type ModerationFlag struct {
+	gorm.Model
+	CustomerID uint       `gorm:"index;not null" json:"customer_id"`
+	SessionID  uint       `gorm:"index;not null" json:"session_id"`
+	MessageID  *uint      `gorm:"uniqueIndex:uq_moderation_flags_message,priority:1" json:"message_id,omitempty"`       // 被拦截的消息没有保存，为空
+	Direction  string     `gorm:"size:20;not null;uniqueIndex:uq_moderation_flags_message,priority:2" json:"direction"` // inbound 客户消息，outbound 机器人回复
+	Content    string     `gorm:"type:text;not null" json:"content"`                                                    // 审核前的原始内容
+	Action     string     `gorm:"size:20;not null" json:"action"`                                                       // 审核时执行的动作
+	Categories string     `gorm:"size:255" json:"categories"`                                                           // 逗号分隔的命中分类
+	Verdict    string     `gorm:"type:text" json:"verdict"`                                                             // 审核结果的JSON，包括每处命中
+	Status     string     `gorm:"size:20;index;not null" json:"status"`                                                 // 复核状态
+	ReviewerID *uint      `json:"reviewer_id,omitempty"`
+	ReviewNote string     `gorm:"type:text" json:"review_note,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
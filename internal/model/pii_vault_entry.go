@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// PIIVaultEntry 脱敏消息的加密原文，仅管理员可查看
+type PIIVaultEntry struct {
+	MessageID  uint      `gorm:"primaryKey" json:"message_id"`
+	CustomerID uint      `gorm:"index;not null" json:"customer_id"`
+	SessionID  uint      `gorm:"not null" json:"session_id"`
+	Kinds      string    `gorm:"size:255;not null" json:"kinds"` // 逗号分隔的个人信息类型
+	Ciphertext string    `gorm:"type:text;not null" json:"-"`    // base64 编码的 nonce 与 AES-GCM 密文，以消息ID为附加数据
+	CreatedAt  time.Time `json:"created_at"`
+}
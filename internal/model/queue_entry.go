@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// QueueEntry 等待人工客服的排队记录，所有节点共享。会话分配或放弃后删除
+type QueueEntry struct {
+	gorm.Model
+	SessionID  uint      `gorm:"uniqueIndex;not null" json:"session_id"`
+	CustomerID uint      `gorm:"index;not null" json:"customer_id"`
+	Skill      string    `gorm:"size:50" json:"skill"`    // 需要的客服技能，为空时任何客服都可以接待
+	Language   string    `gorm:"size:10" json:"language"` // 客户使用的语言
+	Priority   int       `json:"priority"`                // 优先级，数值越大越先分配
+	EnqueuedAt time.Time `json:"enqueued_at"`
+
+	NotifiedPosition int `gorm:"not null;default:0" json:"-"` // 最近一次推送给客户的排队位置
+}
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// ReadReceipt 会话参与者（客户或客服）已读到的最后一条消息序号
+type ReadReceipt struct {
+	SessionID   uint      `gorm:"primaryKey" json:"session_id"`
+	UserID      uint      `gorm:"primaryKey" json:"user_id"`
+	LastReadSeq uint      `gorm:"not null;default:0" json:"last_read_seq"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
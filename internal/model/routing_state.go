@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// RoutingState 人工客服排队分配的状态，表中只有一行。各节点分配时锁定该行，同一时刻只有一个节点在分配
+type RoutingState struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	LastAgentID uint      `gorm:"not null;default:0" json:"last_agent_id"` // 轮流分配时上一次分配到的客服
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName routing_state 只有一行，不使用复数表名
+func (RoutingState) TableName() string {
+	return "routing_state"
+}
@@ -8,11 +8,14 @@ import (
 
 type Session struct {
 	gorm.Model
-	CustomerID   uint      `json:"customer_id"`
-	Customer     Customer  `json:"customer"`
-	Status       string    `json:"status"`
-	LastActiveAt time.Time `json:"last_active_at"`
-	Messages     []Message `json:"messages"`
+	CustomerID    uint      `json:"customer_id"`
+	Customer      Customer  `json:"customer"`
+	Status        string    `json:"status"`
+	LastActiveAt  time.Time `json:"last_active_at"`
+	Messages      []Message `json:"messages"`
+	AgentID       *uint     `json:"agent_id,omitempty"`       // 接手会话的人工客服
+	HandoffReason string    `json:"handoff_reason,omitempty"` // 转人工的原因
+	BotFallbacks  int       `json:"-"`                        // 机器人连续无法回答的次数
 }
 
 // SessionStatus 定义会话状态
@@ -24,12 +27,31 @@ type SessionStatus string
 //    - 当客户端首次连接时
 //
 // 2. active -> inactive：
-//    - 当连接超过30分钟无活动时
-//    - 当连接发生非正常断开时
+//    - 当连接超过 websocket.idle_timeout（默认30分钟）未发送消息时
+//    - 当连接发生非正常断开时，包括 going away 关闭帧和超过 websocket.pong_timeout 未响应 ping
+//    - 当连接因设备数超过上限被断开时
 //
 // 3. active/inactive -> cancelled：
-//    - 当用户主动关闭连接时
-//    - 当同一用户建立新连接，旧连接被关闭时
+//    - 当用户以正常关闭帧（1000）主动关闭连接时
+//
+// 同一客户可以在多个设备上同时连接，未指定 session_id 的新连接加入其他设备正在进行的会话。
+// 规则 2、3 只在会话的最后一个连接断开时生效。
+//
+// 3a. inactive -> active（恢复会话）：
+//    - 当会话所有者携带 session_id 重连，且会话最后活动时间在宽限期内时；
+//      active 会话同样可以被恢复
+//
+// 连接的建立和断开不改变 waiting_agent 和 with_agent 会话的状态：客户断线后会话继续排队或由客服接待，
+// 所有者携带 session_id 重连即可恢复，不受宽限期限制。
+//
+// 4. active -> waiting_agent：
+//    - 当用户要求转人工、情绪明显负面或机器人连续无法回答时
+//
+// 5. waiting_agent -> with_agent：
+//    - 当人工客服认领会话时，此后客户消息不再经过机器人，直接转发给客服
+//
+// 6. with_agent -> active：
+//    - 当人工客服结束接待，会话交还给机器人
 
 const (
 	// SessionStatusInitiated 会话已创建但未开始
@@ -40,4 +62,8 @@ const (
 	SessionStatusInactive SessionStatus = "inactive"
 	// SessionStatusCancelled 会话被用户主动关闭
 	SessionStatusCancelled SessionStatus = "cancelled"
+	// SessionStatusWaitingAgent 会话等待人工客服接入
+	SessionStatusWaitingAgent SessionStatus = "waiting_agent"
+	// SessionStatusWithAgent 会话由人工客服接待中
+	SessionStatusWithAgent SessionStatus = "with_agent"
 )
@@ -0,0 +1,265 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/JennerWork/chatbot/internal/config"
+)
+
+const (
+	// defaultMaxCharRepeat 同一字符连续出现的默认上限
+	defaultMaxCharRepeat = 10
+	// defaultMaxWordRepeat 同一个词连续出现的默认上限
+	defaultMaxWordRepeat = 5
+)
+
+// rule 一条审核规则
+type rule struct {
+	category string
+	name     string
+	pattern  *regexp.Regexp
+}
+
+// localModerator 基于词表、正则和刷屏检测的本地审核器
+type localModerator struct {
+	rules         []rule
+	actions       map[string]Action
+	maxCharRepeat int
+	maxWordRepeat int
+}
+
+// NewLocalModerator 根据配置创建本地审核器
+func NewLocalModerator(cfg config.ModerationConfig) (Moderator, error) {
+	m := &localModerator{
+		actions:       make(map[string]Action, len(cfg.Actions)),
+		maxCharRepeat: cfg.Spam.MaxCharRepeat,
+		maxWordRepeat: cfg.Spam.MaxWordRepeat,
+	}
+	if m.maxCharRepeat <= 0 {
+		m.maxCharRepeat = defaultMaxCharRepeat
+	}
+	if m.maxWordRepeat <= 0 {
+		m.maxWordRepeat = defaultMaxWordRepeat
+	}
+
+	for category, name := range cfg.Actions {
+		action, err := ParseAction(name)
+		if err != nil {
+			return nil, fmt.Errorf("category %s: %w", category, err)
+		}
+		m.actions[category] = action
+	}
+
+	// 按分类名排序，保证命中顺序稳定
+	categories := make([]string, 0, len(cfg.Words))
+	for category := range cfg.Words {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		if pattern := WordPattern(cfg.Words[category]); pattern != nil {
+			m.rules = append(m.rules, rule{category: category, name: "words", pattern: pattern})
+		}
+	}
+
+	for _, p := range cfg.Patterns {
+		if p.Category == "" {
+			return nil, fmt.Errorf("moderation pattern %q has no category", p.Pattern)
+		}
+		pattern, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid moderation pattern %q: %v", p.Pattern, err)
+		}
+		m.rules = append(m.rules, rule{category: p.Category, name: p.Pattern, pattern: pattern})
+	}
+
+	return m, nil
+}
+
+// WordPattern 将词表编译为一个正则，不区分大小写；由字母和数字组成的 ASCII 词按整词匹配，
+// 避免误伤包含该词的正常单词，中文没有词边界，按子串匹配。词表为空时返回 nil
+func WordPattern(words []string) *regexp.Regexp {
+	alternatives := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		alternative := regexp.QuoteMeta(word)
+		if isWordASCII(word) {
+			alternative = `\b` + alternative + `\b`
+		}
+		alternatives = append(alternatives, alternative)
+	}
+	if len(alternatives) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)` + strings.Join(alternatives, "|"))
+}
+
+// isWordASCII 判断词是否只由 ASCII 字母和数字组成
+func isWordASCII(word string) bool {
+	for _, r := range word {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Moderate 依次匹配词表、正则和刷屏检测，取命中分类中最严重的动作
+func (m *localModerator) Moderate(ctx context.Context, text string, direction Direction) (*Verdict, error) {
+	var matches []Match
+	for _, r := range m.rules {
+		for _, loc := range r.pattern.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			matches = append(matches, Match{
+				Category: r.category,
+				Rule:     r.name,
+				Text:     text[loc[0]:loc[1]],
+				start:    loc[0],
+				end:      loc[1],
+			})
+		}
+	}
+	matches = append(matches, m.spamMatches(text)...)
+
+	verdict := &Verdict{Action: ActionAllow, Text: text}
+	seen := make(map[string]bool)
+	var masked []Match
+	for _, match := range matches {
+		action := m.action(match.Category)
+		if action == ActionAllow {
+			continue
+		}
+		verdict.Matches = append(verdict.Matches, match)
+		if !seen[match.Category] {
+			seen[match.Category] = true
+			verdict.Categories = append(verdict.Categories, match.Category)
+		}
+		if action.severity() > verdict.Action.severity() {
+			verdict.Action = action
+		}
+		if action == ActionMask {
+			masked = append(masked, match)
+		}
+	}
+	verdict.Text = mask(text, masked)
+	return verdict, nil
+}
+
+// action 返回分类的动作，未配置的分类需要人工复核
+func (m *localModerator) action(category string) Action {
+	if action, ok := m.actions[category]; ok {
+		return action
+	}
+	return ActionFlag
+}
+
+// spamMatches 检测同一字符或同一个词连续重复过多的刷屏内容
+func (m *localModerator) spamMatches(text string) []Match {
+	var matches []Match
+
+	// 同一字符连续出现，如 "!!!!!!!!!!!!"、"哈哈哈哈哈哈哈哈哈哈哈"
+	runStart, runLen := 0, 0
+	var prev rune
+	flush := func(end int) {
+		if runLen > m.maxCharRepeat {
+			matches = append(matches, Match{
+				Category: CategorySpam,
+				Rule:     "repeated_char",
+				Text:     text[runStart:end],
+				start:    runStart,
+				end:      end,
+			})
+		}
+	}
+	for i, r := range text {
+		if runLen > 0 && r == prev {
+			runLen++
+			continue
+		}
+		flush(i)
+		prev, runStart, runLen = r, i, 1
+		if unicode.IsSpace(r) {
+			runLen = 0
+		}
+	}
+	flush(len(text))
+
+	// 同一个词连续出现，如 "buy now buy now buy now ..." 中的单词按空白分隔
+	words := wordSpans(text)
+	for i := 0; i < len(words); {
+		j := i + 1
+		for j < len(words) && strings.EqualFold(text[words[j][0]:words[j][1]], text[words[i][0]:words[i][1]]) {
+			j++
+		}
+		if j-i > m.maxWordRepeat {
+			start, end := words[i][0], words[j-1][1]
+			matches = append(matches, Match{
+				Category: CategorySpam,
+				Rule:     "repeated_word",
+				Text:     text[start:end],
+				start:    start,
+				end:      end,
+			})
+		}
+		i = j
+	}
+	return matches
+}
+
+// wordSpans 返回以空白分隔的各个词在文本中的字节位置
+func wordSpans(text string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				spans = append(spans, [2]int{start, i})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// mask 将命中的内容按字符数替换为 *，命中位置可以重叠
+func mask(text string, matches []Match) string {
+	if len(matches) == 0 {
+		return text
+	}
+	hidden := make([]bool, len(text))
+	for _, match := range matches {
+		for i := match.start; i < match.end; i++ {
+			hidden[i] = true
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		if hidden[i] {
+			b.WriteByte('*')
+		} else {
+			b.WriteString(text[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package moderation
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/JennerWork/chatbot/internal/config"
+)
+
+// testConfig 测试用的审核配置
+func testConfig() config.ModerationConfig {
+	return config.ModerationConfig{
+		Actions: map[string]string{
+			"profanity": "mask",
+			"abuse":     "escalate",
+			"illegal":   "block",
+			"greeting":  "allow",
+			"spam":      "flag",
+		},
+		Words: map[string][]string{
+			"profanity": {"damn", "ass", "混蛋"},
+			"abuse":     {"idiot"},
+			"illegal":   {"cocaine"},
+			"greeting":  {"hello"},
+			"contact":   {"wechat"}, // 未配置动作，需要复核
+		},
+		Spam: config.SpamConfig{MaxCharRepeat: 5, MaxWordRepeat: 3},
+	}
+}
+
+// moderate 以测试配置审核文本
+func moderate(t *testing.T, cfg config.ModerationConfig, text string) *Verdict {
+	t.Helper()
+	m, err := NewLocalModerator(cfg)
+	if err != nil {
+		t.Fatalf("NewLocalModerator failed: %v", err)
+	}
+	verdict, err := m.Moderate(context.Background(), text, DirectionInbound)
+	if err != nil {
+		t.Fatalf("Moderate failed: %v", err)
+	}
+	return verdict
+}
+
+func TestSpamHeuristics(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		wantRules []string
+	}{
+		{"repeated char over limit", "no!!!!!!", []string{"repeated_char"}},
+		{"repeated char at limit", "no!!!!!", nil},
+		{"repeated chinese char", "哈哈哈哈哈哈", []string{"repeated_char"}},
+		{"whitespace is not spam", "a        b", nil},
+		{"repeated word over limit", "buy now buy buy BUY Buy", []string{"repeated_word"}},
+		{"repeated word at limit", "buy buy buy now", nil},
+		{"both", "go go go go ??????", []string{"repeated_char", "repeated_word"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := moderate(t, testConfig(), tt.text)
+			var rules []string
+			for _, match := range verdict.Matches {
+				if match.Category == CategorySpam {
+					rules = append(rules, match.Rule)
+				}
+			}
+			if !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Errorf("spam rules = %v, want %v", rules, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestMasking(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantText   string
+		wantAction Action
+	}{
+		{"english word", "damn it", "**** it", ActionMask},
+		{"case insensitive", "DAMN it", "**** it", ActionMask},
+		{"whole words only", "first class pass", "first class pass", ActionAllow},
+		{"chinese substring", "你这个混蛋啊", "你这个**啊", ActionMask},
+		{"several matches", "damn, ass", "****, ***", ActionMask},
+		{"flagged content is not masked", "damn wechat", "**** wechat", ActionFlag},
+		{"allowed category", "hello there", "hello there", ActionAllow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := moderate(t, testConfig(), tt.text)
+			if verdict.Text != tt.wantText {
+				t.Errorf("text = %q, want %q", verdict.Text, tt.wantText)
+			}
+			if verdict.Action != tt.wantAction {
+				t.Errorf("action = %s, want %s", verdict.Action, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestMostSevereAction(t *testing.T) {
+	tests := []struct {
+		name           string
+		text           string
+		wantAction     Action
+		wantCategories []string
+	}{
+		{"nothing matched", "how are you", ActionAllow, nil},
+		{"unconfigured category needs review", "add me on wechat", ActionFlag, []string{"contact"}},
+		{"flag over mask", "damn, wechat me", ActionFlag, []string{"contact", "profanity"}},
+		{"escalate over flag", "wechat, idiot", ActionEscalate, []string{"abuse", "contact"}},
+		{"block over everything", "idiot selling cocaine, damn", ActionBlock, []string{"abuse", "illegal", "profanity"}},
+		{"spam uses its configured action", "zzzzzzzz", ActionFlag, []string{CategorySpam}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := moderate(t, testConfig(), tt.text)
+			if verdict.Action != tt.wantAction {
+				t.Errorf("action = %s, want %s", verdict.Action, tt.wantAction)
+			}
+			if !reflect.DeepEqual(verdict.Categories, tt.wantCategories) {
+				t.Errorf("categories = %v, want %v", verdict.Categories, tt.wantCategories)
+			}
+		})
+	}
+}
+
+func TestNewLocalModeratorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *config.ModerationConfig)
+		wantErr string
+	}{
+		{"unknown action", func(cfg *config.ModerationConfig) { cfg.Actions["abuse"] = "ban" }, "unknown moderation action"},
+		{"invalid pattern", func(cfg *config.ModerationConfig) {
+			cfg.Patterns = []config.ModerationPattern{{Category: "contact", Pattern: "("}}
+		}, "invalid moderation pattern"},
+		{"pattern without category", func(cfg *config.ModerationConfig) {
+			cfg.Patterns = []config.ModerationPattern{{Pattern: "qq"}}
+		}, "has no category"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			tt.modify(&cfg)
+			_, err := NewLocalModerator(cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewLocalModerator() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+)
+
+// Action 内容命中某个分类后的处理动作
+type Action string
+
+const (
+	ActionAllow    Action = "allow"    // 放行
+	ActionMask     Action = "mask"     // 命中的内容以 * 屏蔽后放行
+	ActionFlag     Action = "flag"     // 放行并记录，等待人工复核
+	ActionEscalate Action = "escalate" // 记录并转人工客服处理
+	ActionBlock    Action = "block"    // 拦截并记录
+)
+
+// severity 动作的严重程度，命中多个分类时取最严重的动作
+func (a Action) severity() int {
+	switch a {
+	case ActionMask:
+		return 1
+	case ActionFlag:
+		return 2
+	case ActionEscalate:
+		return 3
+	case ActionBlock:
+		return 4
+	}
+	return 0
+}
+
+// ParseAction 解析配置中的动作
+func ParseAction(s string) (Action, error) {
+	switch action := Action(s); action {
+	case ActionAllow, ActionMask, ActionFlag, ActionEscalate, ActionBlock:
+		return action, nil
+	}
+	return "", fmt.Errorf("unknown moderation action: %s", s)
+}
+
+// Direction 被审核内容的方向
+type Direction string
+
+const (
+	DirectionInbound  Direction = "inbound"  // 客户发送的消息
+	DirectionOutbound Direction = "outbound" // 机器人的回复
+)
+
+// CategorySpam 刷屏检测命中的分类
+const CategorySpam = "spam"
+
+// Match 一处命中
+type Match struct {
+	Category string `json:"category"`
+	Rule     string `json:"rule"` // 命中的规则：词表为 "words"，正则为表达式本身，刷屏为 "repeated_char" 或 "repeated_word"
+	Text     string `json:"text"` // 命中的原文
+	start    int
+	end      int
+}
+
+// Verdict 审核结果
+type Verdict struct {
+	Action     Action   `json:"action"`
+	Categories []string `json:"categories,omitempty"`
+	Matches    []Match  `json:"matches,omitempty"`
+	Text       string   `json:"-"` // 处理后的文本，动作为 mask 的分类已被屏蔽；没有需要屏蔽的内容时为原文
+}
+
+// NeedsReview 是否需要保存以便人工复核
+func (v *Verdict) NeedsReview() bool {
+	return v.Action == ActionFlag || v.Action == ActionEscalate || v.Action == ActionBlock
+}
+
+// Moderator 内容审核器
+type Moderator interface {
+	// Moderate 审核一段文本，没有命中任何规则时动作为 allow
+	Moderate(ctx context.Context, text string, direction Direction) (*Verdict, error)
+}
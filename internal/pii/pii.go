@@ -0,0 +1,278 @@
+package pii
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kind 个人信息的类型
+type Kind string
+
+const (
+	KindEmail  Kind = "email"   // 邮箱地址
+	KindIDCard Kind = "id_card" // 中国居民身份证号
+	KindCard   Kind = "card"    // 银行卡号
+	KindPhone  Kind = "phone"   // 手机号（E.164 或中国大陆手机号）
+)
+
+// Placeholder 替换该类型个人信息的占位符，如 [CARD]
+func (k Kind) Placeholder() string {
+	return "[" + strings.ToUpper(string(k)) + "]"
+}
+
+// Finding 文本中的一处个人信息，Start、End 为字节位置
+type Finding struct {
+	Kind  Kind
+	Start int
+	End   int
+}
+
+// Detector 个人信息检测器
+type Detector interface {
+	// Kind 检测的个人信息类型
+	Kind() Kind
+	// Find 返回文本中所有命中的位置
+	Find(text string) [][2]int
+}
+
+// regexDetector 以正则匹配候选内容，再由 valid 校验（如校验位）
+type regexDetector struct {
+	kind    Kind
+	pattern *regexp.Regexp
+	valid   func(match string) bool
+}
+
+// Kind 检测的个人信息类型
+func (d *regexDetector) Kind() Kind {
+	return d.kind
+}
+
+// Find 返回通过校验的命中位置
+func (d *regexDetector) Find(text string) [][2]int {
+	var spans [][2]int
+	for _, loc := range d.pattern.FindAllStringIndex(text, -1) {
+		if d.valid == nil || d.valid(text[loc[0]:loc[1]]) {
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		}
+	}
+	return spans
+}
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	idCardPattern = regexp.MustCompile(`\b\d{17}[\dXx]\b`)
+	// 至少 13 位数字，数字之间可以有空格或连字符；卡号在其中按分组查找
+	cardPattern = regexp.MustCompile(`\b\d{4}(?:[ -]?\d){9,}\b`)
+	// E.164 号码，或可带 +86/86 前缀、以空格或连字符分成 3-4-4 的中国大陆手机号
+	phonePattern = regexp.MustCompile(`\+[1-9]\d{7,14}\b|(?:\+86[ -]?|\b(?:86[ -]?)?)1[3-9]\d[ -]?\d{4}[ -]?\d{4}\b`)
+)
+
+// NewEmailDetector 邮箱地址检测器
+func NewEmailDetector() Detector {
+	return &regexDetector{kind: KindEmail, pattern: emailPattern}
+}
+
+// NewIDCardDetector 中国居民身份证号检测器，校验出生日期格式和校验码
+func NewIDCardDetector() Detector {
+	return &regexDetector{kind: KindIDCard, pattern: idCardPattern, valid: validIDCard}
+}
+
+// NewCardDetector 银行卡号检测器，通过 Luhn 校验
+func NewCardDetector() Detector {
+	return &cardDetector{}
+}
+
+// cardDetector 银行卡号检测器。卡号后面常跟着以空格分开的有效期、CVV 等数字，
+// 整段数字通不过校验时，在分组边界上查找 13~19 位、通过 Luhn 校验的最长一段
+type cardDetector struct{}
+
+// Kind 检测的个人信息类型
+func (d *cardDetector) Kind() Kind {
+	return KindCard
+}
+
+// Find 返回通过校验的卡号位置
+func (d *cardDetector) Find(text string) [][2]int {
+	var spans [][2]int
+	for _, loc := range cardPattern.FindAllStringIndex(text, -1) {
+		spans = append(spans, cardSpans(text, loc[0], loc[1])...)
+	}
+	return spans
+}
+
+// cardSpans 在 text[start:end] 这段以空格或连字符分组的数字中查找卡号。卡号只能从分组开头开始、
+// 在分组结尾结束，不会从连续的长数字（如订单号）中截取一段
+func cardSpans(text string, start, end int) [][2]int {
+	// positions 为各个数字的字节位置，cuts 为各分组第一个数字的序号，最后是数字总数
+	var positions, cuts []int
+	for i := start; i < end; i++ {
+		if text[i] < '0' || text[i] > '9' {
+			continue
+		}
+		if len(positions) == 0 || i > positions[len(positions)-1]+1 {
+			cuts = append(cuts, len(positions))
+		}
+		positions = append(positions, i)
+	}
+	cuts = append(cuts, len(positions))
+
+	number := digits(text[start:end])
+	var spans [][2]int
+	for i := 0; i < len(cuts)-1; {
+		found := false
+		for j := len(cuts) - 1; j > i; j-- {
+			if luhnValid(number[cuts[i]:cuts[j]]) {
+				spans = append(spans, [2]int{positions[cuts[i]], positions[cuts[j]-1] + 1})
+				i, found = j, true
+				break
+			}
+		}
+		if !found {
+			i++
+		}
+	}
+	return spans
+}
+
+// NewPhoneDetector 手机号检测器
+func NewPhoneDetector() Detector {
+	return &regexDetector{kind: KindPhone, pattern: phonePattern}
+}
+
+// DefaultDetectors 默认的检测器，按优先级排列：同一段内容先被前面的检测器命中时，后面的检测器不再处理
+func DefaultDetectors() []Detector {
+	return []Detector{
+		NewEmailDetector(),
+		NewIDCardDetector(),
+		NewCardDetector(),
+		NewPhoneDetector(),
+	}
+}
+
+// Redactor 以占位符替换文本中的个人信息
+type Redactor struct {
+	detectors []Detector
+}
+
+// NewRedactor 创建脱敏器，未指定检测器时使用 DefaultDetectors
+func NewRedactor(detectors ...Detector) *Redactor {
+	if len(detectors) == 0 {
+		detectors = DefaultDetectors()
+	}
+	return &Redactor{detectors: detectors}
+}
+
+// Find 返回文本中的个人信息，按位置排列且互不重叠
+func (r *Redactor) Find(text string) []Finding {
+	var findings []Finding
+	for _, detector := range r.detectors {
+		for _, span := range detector.Find(text) {
+			if overlaps(findings, span) {
+				continue
+			}
+			findings = append(findings, Finding{Kind: detector.Kind(), Start: span[0], End: span[1]})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Start < findings[j].Start
+	})
+	return findings
+}
+
+// Redact 以占位符替换文本中的个人信息，返回替换后的文本和命中的内容
+func (r *Redactor) Redact(text string) (string, []Finding) {
+	findings := r.Find(text)
+	if len(findings) == 0 {
+		return text, nil
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, finding := range findings {
+		b.WriteString(text[last:finding.Start])
+		b.WriteString(finding.Kind.Placeholder())
+		last = finding.End
+	}
+	b.WriteString(text[last:])
+	return b.String(), findings
+}
+
+// Kinds 返回命中的个人信息类型，去重后按出现顺序排列
+func Kinds(findings []Finding) []Kind {
+	var kinds []Kind
+	seen := make(map[Kind]bool)
+	for _, finding := range findings {
+		if !seen[finding.Kind] {
+			seen[finding.Kind] = true
+			kinds = append(kinds, finding.Kind)
+		}
+	}
+	return kinds
+}
+
+// overlaps 判断位置是否与已命中的内容重叠
+func overlaps(findings []Finding, span [2]int) bool {
+	for _, finding := range findings {
+		if span[0] < finding.End && finding.Start < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// digits 去掉分隔符，只保留数字
+func digits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// luhnValid Luhn 校验，长度为 13~19 位
+func luhnValid(number string) bool {
+	if len(number) < 13 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// idCardWeights 身份证号前 17 位的加权系数
+var idCardWeights = [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+// idCardCheckCodes 加权和除以 11 的余数对应的校验码
+const idCardCheckCodes = "10X98765432"
+
+// validIDCard 校验 18 位身份证号的出生月日和校验码（GB 11643）
+func validIDCard(id string) bool {
+	if len(id) != 18 {
+		return false
+	}
+	month := (id[10]-'0')*10 + (id[11] - '0')
+	day := (id[12]-'0')*10 + (id[13] - '0')
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += int(id[i]-'0') * idCardWeights[i]
+	}
+	return strings.ToUpper(id[17:]) == string(idCardCheckCodes[sum%11])
+}
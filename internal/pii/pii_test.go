@@ -0,0 +1,115 @@
+package pii
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"4111111111111111", true},
+		{"5500000000000004", true},
+		{"378282246310005", true}, // 15 位
+		{"4111111111111112", false},
+		{"411111111111", false},         // 少于 13 位
+		{"41111111111111111111", false}, // 多于 19 位
+		{"4111111111111111123", false},
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.number); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestValidIDCard(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"11010519491231002X", true},
+		{"11010519491231002x", true},
+		{"440304199001011233", true},
+		{"440304199001011234", false}, // 校验码错误
+		{"110105194913310021", false}, // 月份为 13
+		{"110105194912320029", false}, // 日期为 32
+		{"4403041990010112", false},   // 长度不足
+	}
+	for _, tt := range tests {
+		if got := validIDCard(tt.id); got != tt.want {
+			t.Errorf("validIDCard(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"card", "my card is 4111 1111 1111 1111.", "my card is [CARD]."},
+		{"card with hyphens", "4111-1111-1111-1111", "[CARD]"},
+		{"card failing luhn", "order 4111 1111 1111 1112", "order 4111 1111 1111 1112"},
+		{"card followed by cvv", "4111 1111 1111 1111 123", "[CARD] 123"},
+		{"card followed by expiry digits", "4111111111111111 99", "[CARD] 99"},
+		{"card inside a longer number", "411111111111111199", "411111111111111199"},
+		{"two cards", "4111111111111111 5500000000000004", "[CARD] [CARD]"},
+		{"id card", "ID 11010519491231002X", "ID [ID_CARD]"},
+		{"id card with bad checksum", "ID 440304199001011234", "ID 440304199001011234"},
+		{"chinese mobile", "call 138-1234-5678", "call [PHONE]"},
+		{"mobile with prefix", "+86 13812345678", "[PHONE]"},
+		{"e164", "call +447911123456 now", "call [PHONE] now"},
+		{"email", "mail a.b+c@example.co.uk", "mail [EMAIL]"},
+		{"no pii", "hello 12345", "hello 12345"},
+	}
+	r := NewRedactor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := r.Redact(tt.text); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindOverlapping(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Finding
+	}{
+		{
+			// 邮箱中的手机号不再单独命中
+			name: "phone inside email",
+			text: "13812345678@163.com",
+			want: []Finding{{Kind: KindEmail, Start: 0, End: 19}},
+		},
+		{
+			// 同时通过 Luhn 校验的身份证号按身份证号处理
+			name: "id card passing luhn",
+			text: "440304199001010249",
+			want: []Finding{{Kind: KindIDCard, Start: 0, End: 18}},
+		},
+		{
+			name: "sorted by position",
+			text: "a@b.io 4111111111111111 13812345678",
+			want: []Finding{
+				{Kind: KindEmail, Start: 0, End: 6},
+				{Kind: KindCard, Start: 7, End: 23},
+				{Kind: KindPhone, Start: 24, End: 35},
+			},
+		},
+	}
+	r := NewRedactor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Find(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
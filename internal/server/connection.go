@@ -1,96 +1,673 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/JennerWork/chatbot/internal/broker"
+	"github.com/JennerWork/chatbot/internal/config"
 	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/service"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
 
+// errSessionNotOwned 客户尝试恢复不属于自己的会话
+var errSessionNotOwned = errors.New("session belongs to another customer")
+
+// sessionLockStripes 会话锁的分段数
+const sessionLockStripes = 64
+
 // ConnectionManager 管理所有的WebSocket连接和会话
 type ConnectionManager struct {
-	connections map[string]*Client // 连接ID -> 客户端连接
-	sessions    map[uint]*Client   // 用户ID -> 客户端连接
+	connections map[string]*Client          // 连接ID -> 客户端连接
+	sessions    map[uint]map[string]*Client // 客户ID -> 连接ID -> 客户端连接，同一客户可在多个设备上同时在线
+	agents      map[uint]*Client            // 客服ID -> 客服连接
 	mu          sync.RWMutex
-	db          *gorm.DB // 数据库连接
+	db          *gorm.DB               // 数据库连接
+	config      config.WebSocketConfig // 连接配置
+	routing     *RoutingQueue          // 人工客服排队队列，由 NewRoutingQueue 设置
+
+	nodeID   string                  // 本节点ID
+	broker   broker.Broker           // 跨节点投递
+	presence broker.PresenceRegistry // 集群范围的在线连接登记
+
+	drainMu  sync.Mutex
+	draining bool // 正在排空连接，见 Drain
+	inflight int  // 正在处理的消息数
+
+	workers *workerPool // 处理客户和客服消息的协程池
+
+	sessionLocks [sessionLockStripes]sync.Mutex // 串行化同一会话的在线检查和状态变更，见 lockSession
+
+	stop     chan struct{} // 关闭时通知清理协程退出
+	reaped   chan struct{} // 清理协程退出后关闭
+	stopOnce sync.Once
+}
+
+// Cluster 多节点部署时连接管理器使用的投递方式和在线登记，零值表示单节点部署
+type Cluster struct {
+	NodeID   string
+	Broker   broker.Broker
+	Presence broker.PresenceRegistry
 }
 
 // NewConnectionManager 创建新的连接管理器
-func NewConnectionManager(db *gorm.DB) *ConnectionManager {
+func NewConnectionManager(db *gorm.DB, cfg config.WebSocketConfig, cluster Cluster) *ConnectionManager {
+	if cfg.ResumeGrace <= 0 {
+		cfg.ResumeGrace = 10 * time.Minute
+	}
+	if cfg.MaxConnections <= 0 {
+		cfg.MaxConnections = 5
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = 30 * time.Second
+	}
+	if cfg.PongTimeout <= cfg.PingInterval {
+		cfg.PongTimeout = 2 * cfg.PingInterval
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	if cfg.IdleTimeout < 0 {
+		cfg.IdleTimeout = 0
+	}
+	if cfg.ReapInterval <= 0 {
+		cfg.ReapInterval = 30 * time.Second
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = 15 * time.Second
+	}
+	if cfg.SendQueueSize <= 0 {
+		cfg.SendQueueSize = 256
+	}
+	if cfg.SendTimeout <= 0 {
+		cfg.SendTimeout = 5 * time.Second
+	}
+	if cfg.SlowConsumerDrops <= 0 {
+		cfg.SlowConsumerDrops = 64
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 32
+	}
+	if cfg.WorkerQueueSize <= 0 {
+		cfg.WorkerQueueSize = 1024
+	}
+	if cfg.HandleTimeout <= 0 {
+		cfg.HandleTimeout = 60 * time.Second
+	}
+	if cluster.NodeID == "" {
+		cluster.NodeID = uuid.New().String()
+	}
+	if cluster.Broker == nil {
+		cluster.Broker = broker.NewMemoryBroker()
+	}
+	if cluster.Presence == nil {
+		cluster.Presence = broker.NewMemoryPresence()
+	}
+
 	cm := &ConnectionManager{
 		connections: make(map[string]*Client),
-		sessions:    make(map[uint]*Client),
+		sessions:    make(map[uint]map[string]*Client),
+		agents:      make(map[uint]*Client),
 		db:          db,
+		config:      cfg,
+		nodeID:      cluster.NodeID,
+		broker:      cluster.Broker,
+		presence:    cluster.Presence,
+		stop:        make(chan struct{}),
+		reaped:      make(chan struct{}),
+		workers:     newWorkerPool(cfg.Workers, cfg.WorkerQueueSize),
 	}
 
+	// 其他节点发布的投递推送给本节点上的连接
+	cm.broker.Subscribe(cm.deliver)
+
 	// 启动定期清理协程
-	go cm.startCleanupLoop()
+	go cm.reapLoop()
 	return cm
 }
 
-// startCleanupLoop 启动定期清理循环
-func (cm *ConnectionManager) startCleanupLoop() {
-	ticker := time.NewTicker(5 * time.Minute)
+// reapLoop 定期批量写入会话最后活动时间并断开空闲的客户连接。
+// 断线检测由各连接的 ping/pong 负责，这里是连接管理器唯一的清理协程
+func (cm *ConnectionManager) reapLoop() {
+	defer close(cm.reaped)
+	ticker := time.NewTicker(cm.config.ReapInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		cm.CleanInactiveConnections(30 * time.Minute)
+	for {
+		select {
+		case <-ticker.C:
+			cm.FlushActivity()
+			if cm.config.IdleTimeout > 0 {
+				cm.CleanInactiveConnections(cm.config.IdleTimeout)
+			}
+		case <-cm.stop:
+			cm.FlushActivity()
+			return
+		}
 	}
 }
 
-// Register 注册新的客户端连接
-func (cm *ConnectionManager) Register(client *Client) {
+// Close 停止清理协程和消息处理协程池，返回前处理完已排队的消息并写入尚未保存的最后活动时间
+func (cm *ConnectionManager) Close() {
+	cm.stopOnce.Do(func() {
+		close(cm.stop)
+	})
+	cm.workers.close()
+	<-cm.reaped
+}
+
+// Register 注册新的客户端连接，正在排空连接时不再注册并返回 false。
+// 连接映射在 cm.mu 内更新，在线登记和会话状态的读写在锁外进行，不阻塞其他连接的投递
+func (cm *ConnectionManager) Register(client *Client) bool {
 	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	if cm.Draining() {
+		cm.mu.Unlock()
+		return false
+	}
 
 	// 生成连接ID
 	connectionID := uuid.New().String()
 	client.id = connectionID
 	cm.connections[connectionID] = client
 
+	// 客服连接不关联会话
+	if client.isAgent {
+		oldClient, replaced := cm.agents[client.customerID]
+		if replaced {
+			oldClient.queue.close()
+			delete(cm.connections, oldClient.id)
+		}
+		cm.agents[client.customerID] = client
+		cm.mu.Unlock()
+
+		if replaced {
+			cm.removePresence(oldClient)
+		}
+		cm.addPresence(client)
+		log.Printf("Agent %d connected", client.customerID)
+		cm.notifyRouting()
+		return true
+	}
+
 	// 如果有客户ID，建立客户会话映射
+	var evicted []*Client
+	pending := make(map[uint]time.Time)
 	if client.customerID > 0 {
-		// 如果客户已有连接，关闭旧连接
-		if oldClient, exists := cm.sessions[client.customerID]; exists {
-			// 更新旧会话状态为已关闭
-			if oldClient.session != nil {
-				oldClient.session.Status = string(model.SessionStatusCancelled)
-				cm.db.Save(oldClient.session)
-				log.Printf("Session cancelled for customer %d (replaced by new connection)", oldClient.customerID)
-			}
-			close(oldClient.send)
-			delete(cm.connections, oldClient.id)
+		clients := cm.sessions[client.customerID]
+		// 超过设备数上限时断开最早的连接，断开该客户的所有连接时 detach 会删除客户的映射
+		for len(clients) >= cm.config.MaxConnections {
+			oldest := oldestClient(clients)
+			cm.detach(oldest, pending)
+			evicted = append(evicted, oldest)
+		}
+		if len(clients) == 0 {
+			clients = make(map[string]*Client)
+			cm.sessions[client.customerID] = clients
 		}
-		cm.sessions[client.customerID] = client
+		clients[connectionID] = client
 	}
+	cm.mu.Unlock()
 
-	// 更新新会话状态为活跃
+	cm.writeActivity(pending)
+	for _, oldClient := range evicted {
+		cm.evict(oldClient)
+	}
+	if client.customerID > 0 {
+		cm.addPresence(client)
+	}
+
+	// 未开始或不活跃的会话转为活跃，等待人工和人工接待中的会话保持不变
 	if client.session != nil {
-		client.session.Status = string(model.SessionStatusActive)
-		cm.db.Save(client.session)
-		log.Printf("Session activated for customer %d", client.customerID)
+		cm.activateSession(client.session)
 	}
+	return true
 }
 
-// Unregister 注销客户端连接
-func (cm *ConnectionManager) Unregister(client *Client) {
+// Unregister 注销客户端连接，会话不再被任何连接使用时以 status 结束。
+// 已被新连接替换或已被清理的连接不再处理，因此读写协程都可以调用
+func (cm *ConnectionManager) Unregister(client *Client, status model.SessionStatus) {
 	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	if cm.connections[client.id] != client {
+		cm.mu.Unlock()
+		return
+	}
 
-	// 更新会话状态
-	if client.session != nil {
-		client.session.Status = string(model.SessionStatusCancelled)
-		cm.db.Save(client.session)
-		log.Printf("Session cancelled for customer %d (unregistered)", client.customerID)
+	if client.isAgent {
+		current := cm.agents[client.customerID] == client
+		if current {
+			delete(cm.agents, client.customerID)
+		}
+		delete(cm.connections, client.id)
+		cm.mu.Unlock()
+
+		cm.removePresence(client)
+		if current {
+			log.Printf("Agent %d disconnected", client.customerID)
+			cm.notifyRouting()
+		}
+		return
 	}
 
-	if client.customerID > 0 {
-		delete(cm.sessions, client.customerID)
+	pending := make(map[uint]time.Time)
+	cm.detach(client, pending)
+	cm.mu.Unlock()
+
+	cm.writeActivity(pending)
+	// 其他设备（包括其他节点上的连接）仍在使用该会话时保留会话
+	if cm.release(client, status) {
+		log.Printf("Session %s for customer %d (unregistered)", status, client.customerID)
+	}
+}
+
+// detach 从连接映射中移除客户连接，并将该连接尚未写入的最后活动时间记入 pending。调用方需持有 cm.mu
+func (cm *ConnectionManager) detach(client *Client, pending map[uint]time.Time) {
+	if client.session != nil {
+		if at, ok := client.pendingActivity(); ok && at.After(pending[client.session.ID]) {
+			pending[client.session.ID] = at
+		}
 	}
 	delete(cm.connections, client.id)
+	if clients, exists := cm.sessions[client.customerID]; exists {
+		delete(clients, client.id)
+		if len(clients) == 0 {
+			delete(cm.sessions, client.customerID)
+		}
+	}
+}
+
+// release 在 cm.mu 外完成已移除客户连接的清理：注销在线登记，会话不再被任何连接使用时以 status 结束。
+// 返回是否结束了会话
+func (cm *ConnectionManager) release(client *Client, status model.SessionStatus) bool {
+	cm.removePresence(client)
+	if client.session == nil {
+		return false
+	}
+
+	unlock := cm.lockSession(client.session.ID)
+	defer unlock()
+	if cm.sessionInUse(client.session.ID) {
+		return false
+	}
+	cm.closeSession(client.session, status)
+	return true
+}
+
+// evict 断开超出设备数上限的连接，调用方已在 cm.mu 内将其移除。发送关闭帧告知客户端不要重连，
+// 连接关闭后读协程退出，由于已从映射中移除，注销时不会再处理
+func (cm *ConnectionManager) evict(client *Client) {
+	cm.release(client, model.SessionStatusInactive)
+
+	client.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many connections"),
+		time.Now().Add(time.Second))
+	client.conn.Close()
+	log.Printf("Connection %s of customer %d evicted (limit %d)", client.id, client.customerID, cm.config.MaxConnections)
+}
+
+// oldestClient 返回最早建立的连接
+func oldestClient(clients map[string]*Client) *Client {
+	var oldest *Client
+	for _, client := range clients {
+		if oldest == nil || client.connectedAt.Before(oldest.connectedAt) {
+			oldest = client
+		}
+	}
+	return oldest
+}
+
+// lockSession 锁定会话，同一会话的在线检查和状态变更串行执行，返回解锁函数。
+// 按会话ID分段加锁，不占用 cm.mu
+func (cm *ConnectionManager) lockSession(sessionID uint) func() {
+	mu := &cm.sessionLocks[sessionID%sessionLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// sessionInUse 集群中是否还有客户连接关联该会话。先检查本节点（新连接在登记在线前已加入映射），
+// 再查询在线登记，查询失败时只以本节点为准
+func (cm *ConnectionManager) sessionInUse(sessionID uint) bool {
+	cm.mu.RLock()
+	for _, client := range cm.connections {
+		if !client.isAgent && client.session != nil && client.session.ID == sessionID {
+			cm.mu.RUnlock()
+			return true
+		}
+	}
+	cm.mu.RUnlock()
+
+	inUse, err := cm.presence.InSession(sessionID)
+	if err != nil {
+		log.Printf("Failed to query presence of session %d: %v", sessionID, err)
+		return false
+	}
+	return inUse
+}
+
+// liveSession 返回客户在集群中最近建立的连接所在的会话，新设备加入该会话继续对话。客户没有在线连接时返回 nil
+func (cm *ConnectionManager) liveSession(customerID uint) *model.Session {
+	presences, err := cm.presence.Connections(customerID, false)
+	if err != nil {
+		log.Printf("Failed to query presence of customer %d: %v", customerID, err)
+		return nil
+	}
+
+	var latest *broker.Presence
+	for i := range presences {
+		if presences[i].SessionID > 0 && (latest == nil || presences[i].ConnectedAt.After(latest.ConnectedAt)) {
+			latest = &presences[i]
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+
+	var session model.Session
+	if err := cm.db.First(&session, latest.SessionID).Error; err != nil {
+		log.Printf("Failed to load live session %d: %v", latest.SessionID, err)
+		return nil
+	}
+	return &session
+}
+
+// addPresence 在集群在线登记中登记连接
+func (cm *ConnectionManager) addPresence(client *Client) {
+	p := broker.Presence{
+		ConnectionID: client.id,
+		NodeID:       cm.nodeID,
+		UserID:       client.customerID,
+		IsAgent:      client.isAgent,
+		ConnectedAt:  client.connectedAt,
+	}
+	if client.session != nil {
+		p.SessionID = client.session.ID
+	}
+	if err := cm.presence.Add(p); err != nil {
+		log.Printf("Failed to register presence of connection %s: %v", client.id, err)
+	}
+}
+
+// removePresence 从集群在线登记中注销连接
+func (cm *ConnectionManager) removePresence(client *Client) {
+	if err := cm.presence.Remove(client.id); err != nil {
+		log.Printf("Failed to remove presence of connection %s: %v", client.id, err)
+	}
+}
+
+// SendToCustomer 向客户在集群中的所有连接推送消息，客户在任一节点在线即返回 true，实现 service.Dispatcher
+func (cm *ConnectionManager) SendToCustomer(customerID uint, frame []byte) bool {
+	return cm.sendToCustomerExcept(customerID, "", frame)
+}
+
+// sendToCustomerExcept 向客户除 exceptID 外的所有连接推送消息
+func (cm *ConnectionManager) sendToCustomerExcept(customerID uint, exceptID string, frame []byte) bool {
+	delivered := cm.publish(broker.Delivery{
+		Target: broker.TargetCustomer,
+		ID:     customerID,
+		Frame:  frame,
+		Except: exceptID,
+	})
+	return delivered > 0 || cm.onlineElsewhere(customerID, false)
+}
+
+// SendToSession 向关联该会话的客户连接推送消息，实现 service.Dispatcher
+func (cm *ConnectionManager) SendToSession(sessionID uint, frame []byte) bool {
+	delivered := cm.publish(broker.Delivery{
+		Target: broker.TargetSession,
+		ID:     sessionID,
+		Frame:  frame,
+	})
+	if delivered > 0 {
+		return true
+	}
+	inUse, err := cm.presence.InSession(sessionID)
+	if err != nil {
+		log.Printf("Failed to query presence of session %d: %v", sessionID, err)
+	}
+	return inUse
+}
+
+// SendToAgent 向客服的连接推送消息，实现 service.Dispatcher
+func (cm *ConnectionManager) SendToAgent(agentID uint, frame []byte) bool {
+	delivered := cm.publish(broker.Delivery{
+		Target: broker.TargetAgent,
+		ID:     agentID,
+		Frame:  frame,
+	})
+	return delivered > 0 || cm.onlineElsewhere(agentID, true)
+}
+
+// BroadcastToAgents 向集群中所有在线客服推送消息，返回本节点送达数与其他节点在线客服数之和，实现 service.Dispatcher
+func (cm *ConnectionManager) BroadcastToAgents(frame []byte) int {
+	delivered := cm.publish(broker.Delivery{
+		Target: broker.TargetAgents,
+		Frame:  frame,
+	})
+
+	agentIDs, err := cm.presence.OnlineAgents()
+	if err != nil {
+		log.Printf("Failed to query online agents: %v", err)
+		return delivered
+	}
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	for _, id := range agentIDs {
+		if _, local := cm.agents[id]; !local {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// publish 先推送给本节点上的连接，再通过 Broker 发布给其他节点，返回本节点送达的连接数
+func (cm *ConnectionManager) publish(d broker.Delivery) int {
+	delivered := cm.deliverLocal(d)
+	cm.publishRemote(d)
+	return delivered
+}
+
+// publishRemote 通过 Broker 发布给其他节点，本节点收到自己发布的投递时会忽略
+func (cm *ConnectionManager) publishRemote(d broker.Delivery) {
+	d.Origin = cm.nodeID
+	if err := cm.broker.Publish(d); err != nil {
+		log.Printf("Failed to publish %s delivery to %d: %v", d.Target, d.ID, err)
+	}
+}
+
+// deliver 处理 Broker 收到的投递，本节点发布的投递已在发布时送达
+func (cm *ConnectionManager) deliver(d broker.Delivery) {
+	if d.Origin == cm.nodeID {
+		return
+	}
+	cm.deliverLocal(d)
+}
+
+// deliverLocal 将投递推送给本节点上的匹配连接，返回送达的连接数。
+// 先在锁内找出目标连接，再在锁外放入发送队列，等待慢连接时不阻塞其他协程
+func (cm *ConnectionManager) deliverLocal(d broker.Delivery) int {
+	targets := cm.localTargets(d)
+
+	delivered := 0
+	for _, client := range targets {
+		if client.enqueue(d.Frame) == nil {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// localTargets 返回投递在本节点上的目标连接
+func (cm *ConnectionManager) localTargets(d broker.Delivery) []*Client {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	var targets []*Client
+	add := func(client *Client) {
+		if client.id != d.Except {
+			targets = append(targets, client)
+		}
+	}
+
+	switch d.Target {
+	case broker.TargetCustomer:
+		for _, client := range cm.sessions[d.ID] {
+			add(client)
+		}
+	case broker.TargetSession:
+		for _, client := range cm.connections {
+			if !client.isAgent && client.session != nil && client.session.ID == d.ID {
+				add(client)
+			}
+		}
+	case broker.TargetAgent:
+		if client, exists := cm.agents[d.ID]; exists {
+			add(client)
+		}
+	case broker.TargetAgents:
+		for _, client := range cm.agents {
+			add(client)
+		}
+	}
+	return targets
+}
+
+// onlineElsewhere 用户是否在其他节点上有连接
+func (cm *ConnectionManager) onlineElsewhere(userID uint, isAgent bool) bool {
+	presences, err := cm.presence.Connections(userID, isAgent)
+	if err != nil {
+		log.Printf("Failed to query presence of user %d: %v", userID, err)
+		return false
+	}
+	for _, p := range presences {
+		if p.NodeID != cm.nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// botSessionStatuses 未开始或由机器人接待的会话状态，连接的建立和断开只在这些状态之间转换；
+// 等待人工和人工接待中的会话不受连接影响，客户短暂断线不会离开排队或与客服断开
+var botSessionStatuses = []string{
+	string(model.SessionStatusInitiated),
+	string(model.SessionStatusActive),
+	string(model.SessionStatusInactive),
+}
+
+// activateSession 将未开始或不活跃的会话转为活跃，只更新会话状态列，避免用内存中过期的会话数据覆盖其他字段
+func (cm *ConnectionManager) activateSession(session *model.Session) {
+	unlock := cm.lockSession(session.ID)
+	defer unlock()
+
+	result := cm.db.Model(&model.Session{}).
+		Where("id = ? AND status IN ?", session.ID, []string{
+			string(model.SessionStatusInitiated),
+			string(model.SessionStatusInactive),
+		}).
+		Update("status", string(model.SessionStatusActive))
+	if result.Error != nil {
+		log.Printf("Failed to activate session %d: %v", session.ID, result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Session %d activated for customer %d", session.ID, session.CustomerID)
+	}
+}
+
+// resumableSession 查找客户重连时要恢复的会话。会话必须属于该客户；状态为 active 或 inactive 时最后活动时间
+// 需在宽限期内，waiting_agent 和 with_agent 的会话仍在排队或由客服接待，总是可以恢复。
+// 不满足条件（包括会话不存在）时返回 nil，由调用方创建新会话
+func (cm *ConnectionManager) resumableSession(customerID uint, sessionID uint) (*model.Session, error) {
+	var session model.Session
+	if err := cm.db.First(&session, sessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if session.CustomerID != customerID {
+		return nil, errSessionNotOwned
+	}
+
+	switch model.SessionStatus(session.Status) {
+	case model.SessionStatusWaitingAgent, model.SessionStatusWithAgent:
+		return &session, nil
+	case model.SessionStatusActive, model.SessionStatusInactive:
+	default:
+		log.Printf("Session %d cannot be resumed (status: %s)", session.ID, session.Status)
+		return nil, nil
+	}
+	if time.Since(session.LastActiveAt) > cm.config.ResumeGrace {
+		log.Printf("Session %d cannot be resumed (inactive since %v)", session.ID, session.LastActiveAt)
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// closeSession 以 status 结束由机器人接待的会话，调用方需持有 lockSession。等待人工和人工接待中的会话保持不变，
+// 客户重连后继续排队或由客服接待；人工接待中的会话通知客服客户已离线，排空连接时客户会很快重连，不通知
+func (cm *ConnectionManager) closeSession(session *model.Session, status model.SessionStatus) {
+	var current model.Session
+	if err := cm.db.First(&current, session.ID).Error; err != nil {
+		log.Printf("Failed to load session %d: %v", session.ID, err)
+		return
+	}
+
+	switch model.SessionStatus(current.Status) {
+	case model.SessionStatusWaitingAgent:
+		return
+	case model.SessionStatusWithAgent:
+		if current.AgentID == nil || cm.Draining() {
+			return
+		}
+		frame, err := service.NewFrame(service.MessageTypeCustomerLeft, service.HandoffNotice{
+			SessionID:  current.ID,
+			CustomerID: current.CustomerID,
+			Since:      time.Now(),
+		})
+		if err == nil {
+			cm.SendToAgent(*current.AgentID, frame)
+		}
+		return
+	}
+
+	// 读取后会话可能已转人工，只在仍由机器人接待时更新
+	if err := cm.db.Model(&model.Session{}).
+		Where("id = ? AND status IN ?", session.ID, botSessionStatuses).
+		Update("status", string(status)).Error; err != nil {
+		log.Printf("Failed to update status of session %d: %v", session.ID, err)
+	}
+}
+
+// notifyRouting 触发人工客服队列重新分配
+func (cm *ConnectionManager) notifyRouting() {
+	if cm.routing != nil {
+		cm.routing.Notify()
+	}
+}
+
+// OnlineAgents 返回在集群中任一节点在线的客服ID，查询在线登记失败时只返回本节点的客服
+func (cm *ConnectionManager) OnlineAgents() []uint {
+	ids, err := cm.presence.OnlineAgents()
+	if err == nil {
+		return ids
+	}
+	log.Printf("Failed to query online agents: %v", err)
+
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	ids = make([]uint, 0, len(cm.agents))
+	for id := range cm.agents {
+		ids = append(ids, id)
+	}
+	return ids
 }
 
 // GetClient 根据连接ID获取客户端
@@ -101,12 +678,60 @@ func (cm *ConnectionManager) GetClient(connectionID string) (*Client, bool) {
 	return client, exists
 }
 
-// GetClientByCustomerID 根据客户ID获取客户端
-func (cm *ConnectionManager) GetClientByCustomerID(customerID uint) (*Client, bool) {
+// GetClientsByCustomerID 根据客户ID获取该客户在本节点上的所有连接
+func (cm *ConnectionManager) GetClientsByCustomerID(customerID uint) []*Client {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
-	client, exists := cm.sessions[customerID]
-	return client, exists
+	clients := make([]*Client, 0, len(cm.sessions[customerID]))
+	for _, client := range cm.sessions[customerID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// ConnectionStats 本节点上一个连接的发送队列统计
+type ConnectionStats struct {
+	ConnectionID string     `json:"connection_id"`
+	UserID       uint       `json:"user_id"`
+	IsAgent      bool       `json:"is_agent"`
+	SessionID    uint       `json:"session_id,omitempty"`
+	ConnectedAt  time.Time  `json:"connected_at"`
+	Queue        QueueStats `json:"queue"`
+}
+
+// ConnectionStats 返回本节点上所有连接的发送队列统计，按排队帧数从多到少排列
+func (cm *ConnectionManager) ConnectionStats() []ConnectionStats {
+	cm.mu.RLock()
+	stats := make([]ConnectionStats, 0, len(cm.connections))
+	for _, client := range cm.connections {
+		s := ConnectionStats{
+			ConnectionID: client.id,
+			UserID:       client.customerID,
+			IsAgent:      client.isAgent,
+			ConnectedAt:  client.connectedAt,
+			Queue:        client.QueueStats(),
+		}
+		if client.session != nil {
+			s.SessionID = client.session.ID
+		}
+		stats = append(stats, s)
+	}
+	cm.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Queue.Depth > stats[j].Queue.Depth
+	})
+	return stats
+}
+
+// newQueue 按配置创建连接的发送队列
+func (cm *ConnectionManager) newQueue() *outboundQueue {
+	return newOutboundQueue(cm.config.SendQueueSize, cm.config.SlowConsumerDrops)
+}
+
+// PendingMessages 返回本节点等待处理的消息数
+func (cm *ConnectionManager) PendingMessages() int {
+	return cm.workers.depth()
 }
 
 // GetActiveConnections 获取活跃连接数
@@ -116,31 +741,84 @@ func (cm *ConnectionManager) GetActiveConnections() int {
 	return len(cm.connections)
 }
 
-// CleanInactiveConnections 清理不活跃的连接
+// CleanInactiveConnections 断开超过 inactiveTimeout 未发送消息的客户连接，由机器人接待的会话转为不活跃。
+// 客服连接等待接待时可能长时间不发消息，只由 ping/pong 判断是否断开
 func (cm *ConnectionManager) CleanInactiveConnections(inactiveTimeout time.Duration) {
+	now := time.Now()
+	var idle []*Client
+	pending := make(map[uint]time.Time)
+
 	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	for _, client := range cm.connections {
+		if client.isAgent || now.Sub(client.activity()) <= inactiveTimeout {
+			continue
+		}
+		cm.detach(client, pending)
+		idle = append(idle, client)
+	}
+	cm.mu.Unlock()
 
-	now := time.Now()
+	cm.writeActivity(pending)
+	for _, client := range idle {
+		log.Printf("Closing idle connection for customer %d, last activity: %v",
+			client.customerID, client.activity())
+		client.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle timeout"),
+			time.Now().Add(time.Second))
+		client.conn.Close()
+
+		// 其他设备不再使用该会话时，更新会话状态为不活跃
+		if cm.release(client, model.SessionStatusInactive) {
+			log.Printf("Session marked as inactive for customer %d (idle for %v)",
+				client.customerID, inactiveTimeout)
+		}
+	}
+}
+
+// FlushActivity 将各连接的最后活动时间批量写入会话表，同一会话取最近的时间
+func (cm *ConnectionManager) FlushActivity() {
+	cm.mu.Lock()
+	pending := make(map[uint]time.Time)
 	for _, client := range cm.connections {
-		if now.Sub(client.lastActivity) > inactiveTimeout {
-			log.Printf("Closing inactive connection for customer %d, last activity: %v",
-				client.customerID, client.lastActivity)
-
-			// 更新会话状态为不活跃
-			if client.session != nil {
-				client.session.Status = string(model.SessionStatusInactive)
-				cm.db.Save(client.session)
-				log.Printf("Session marked as inactive for customer %d (timeout after %v)",
-					client.customerID, inactiveTimeout)
-			}
+		if client.session == nil {
+			continue
+		}
+		if at, ok := client.pendingActivity(); ok && at.After(pending[client.session.ID]) {
+			pending[client.session.ID] = at
+		}
+	}
+	cm.mu.Unlock()
 
-			// 关闭连接
-			client.conn.Close()
-			delete(cm.connections, client.id)
-			if client.customerID > 0 {
-				delete(cm.sessions, client.customerID)
-			}
+	cm.writeActivity(pending)
+}
+
+// activityBatchSize 每条语句更新的最大会话数
+const activityBatchSize = 500
+
+// writeActivity 更新会话的最后活动时间，只前进不后退
+func (cm *ConnectionManager) writeActivity(pending map[uint]time.Time) {
+	values := make([]string, 0, activityBatchSize)
+	args := make([]interface{}, 0, 2*activityBatchSize)
+	flush := func() {
+		if len(values) == 0 {
+			return
+		}
+		query := fmt.Sprintf(`UPDATE sessions SET last_active_at = v.at
+			FROM (VALUES %s) AS v(id, at)
+			WHERE sessions.id = v.id AND sessions.last_active_at < v.at`, strings.Join(values, ", "))
+		if err := cm.db.Exec(query, args...).Error; err != nil {
+			log.Printf("Failed to update last activity of %d session(s): %v", len(values), err)
+		}
+		values = values[:0]
+		args = args[:0]
+	}
+
+	for sessionID, at := range pending {
+		values = append(values, "(?::integer, ?::timestamptz)")
+		args = append(args, sessionID, at)
+		if len(values) == activityBatchSize {
+			flush()
 		}
 	}
+	flush()
 }
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/broker"
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+// newTestManager 创建不连接数据库的连接管理器，测试中的连接都不关联会话
+func newTestManager(t *testing.T, maxConnections int) *ConnectionManager {
+	t.Helper()
+	cm := NewConnectionManager(nil, config.WebSocketConfig{MaxConnections: maxConnections}, Cluster{})
+	t.Cleanup(cm.Close)
+	return cm
+}
+
+// register 注册一个连接，connectedAt 决定超过设备数上限时断开的顺序
+func register(t *testing.T, cm *ConnectionManager, customerID uint, isAgent bool, connectedAt time.Time, conn *websocket.Conn) *Client {
+	t.Helper()
+	client := &Client{
+		customerID:  customerID,
+		conn:        conn,
+		queue:       cm.newQueue(),
+		connectedAt: connectedAt,
+		manager:     cm,
+		isAgent:     isAgent,
+	}
+	if !cm.Register(client) {
+		t.Fatalf("Register(%d) failed", customerID)
+	}
+	return client
+}
+
+// received 返回连接发送队列中的帧
+func received(c *Client) []string {
+	var frames []string
+	for frame, ok := c.queue.pop(); ok; frame, ok = c.queue.pop() {
+		frames = append(frames, string(frame))
+	}
+	return frames
+}
+
+func TestDeliverLocal(t *testing.T) {
+	cm := newTestManager(t, 5)
+	now := time.Now()
+	phone := register(t, cm, 1, false, now, nil)
+	laptop := register(t, cm, 1, false, now, nil)
+	other := register(t, cm, 2, false, now, nil)
+	agent := register(t, cm, 10, true, now, nil)
+	otherAgent := register(t, cm, 11, true, now, nil)
+
+	// 会话在注册后关联，避免注册时更新数据库中的会话状态
+	cm.mu.Lock()
+	phone.session = &model.Session{Model: gorm.Model{ID: 100}}
+	cm.mu.Unlock()
+
+	clients := map[string]*Client{"phone": phone, "laptop": laptop, "other": other, "agent": agent, "otherAgent": otherAgent}
+	tests := []struct {
+		name          string
+		delivery      broker.Delivery
+		wantDelivered int
+		wantClients   []string
+	}{
+		{"all devices of a customer", broker.Delivery{Target: broker.TargetCustomer, ID: 1}, 2, []string{"laptop", "phone"}},
+		{"except the sending device", broker.Delivery{Target: broker.TargetCustomer, ID: 1, Except: phone.id}, 1, []string{"laptop"}},
+		{"offline customer", broker.Delivery{Target: broker.TargetCustomer, ID: 3}, 0, nil},
+		{"session", broker.Delivery{Target: broker.TargetSession, ID: 100}, 1, []string{"phone"}},
+		{"agent", broker.Delivery{Target: broker.TargetAgent, ID: 10}, 1, []string{"agent"}},
+		{"customer ID is not an agent ID", broker.Delivery{Target: broker.TargetAgent, ID: 1}, 0, nil},
+		{"all agents", broker.Delivery{Target: broker.TargetAgents}, 2, []string{"agent", "otherAgent"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.delivery.Frame = []byte(`{"type":"text"}`)
+			if got := cm.deliverLocal(tt.delivery); got != tt.wantDelivered {
+				t.Errorf("deliverLocal() = %d, want %d", got, tt.wantDelivered)
+			}
+			var got []string
+			for name, client := range clients {
+				if len(received(client)) > 0 {
+					got = append(got, name)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.wantClients) {
+				t.Errorf("delivered to %v, want %v", got, tt.wantClients)
+			}
+		})
+	}
+}
+
+// dialPair 建立一对 WebSocket 连接，返回服务端和客户端的连接
+func dialPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	serverConns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	clientConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { clientConn.Close() })
+	return <-serverConns, clientConn
+}
+
+func TestRegisterEvictsOldest(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxConnections int
+		connections    int
+		wantEvicted    int
+	}{
+		{"within limit", 3, 3, 0},
+		{"one over limit", 3, 4, 1},
+		{"single device", 1, 3, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := newTestManager(t, tt.maxConnections)
+			start := time.Now()
+			var clients []*Client
+			var remotes []*websocket.Conn
+			for i := 0; i < tt.connections; i++ {
+				conn, remote := dialPair(t)
+				clients = append(clients, register(t, cm, 1, false, start.Add(time.Duration(i)*time.Second), conn))
+				remotes = append(remotes, remote)
+			}
+
+			if got, want := len(cm.GetClientsByCustomerID(1)), tt.connections-tt.wantEvicted; got != want {
+				t.Errorf("connections = %d, want %d", got, want)
+			}
+			for i, client := range clients {
+				_, registered := cm.GetClient(client.id)
+				if evicted := i < tt.wantEvicted; registered == evicted {
+					t.Errorf("connection #%d registered = %v, want %v", i, registered, !evicted)
+				}
+			}
+
+			// 被断开的连接收到 policy violation 关闭帧，客户端据此不再重连
+			for i := 0; i < tt.wantEvicted; i++ {
+				remotes[i].SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, _, err := remotes[i].ReadMessage()
+				var closeErr *websocket.CloseError
+				if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+					t.Errorf("connection #%d read = %v, want close %d", i, err, websocket.ClosePolicyViolation)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/service"
+	"github.com/gorilla/websocket"
+)
+
+// drainPollInterval 排空时检查处理中的消息和发送队列的间隔
+const drainPollInterval = 50 * time.Millisecond
+
+// Draining 是否正在排空连接，排空开始后不再接受新连接和新消息
+func (cm *ConnectionManager) Draining() bool {
+	cm.drainMu.Lock()
+	defer cm.drainMu.Unlock()
+	return cm.draining
+}
+
+// beginWork 开始处理一条消息，排空开始后返回 false，调用方不应再处理该消息
+func (cm *ConnectionManager) beginWork() bool {
+	cm.drainMu.Lock()
+	defer cm.drainMu.Unlock()
+	if cm.draining {
+		return false
+	}
+	cm.inflight++
+	return true
+}
+
+// endWork 一条消息处理完成
+func (cm *ConnectionManager) endWork() {
+	cm.drainMu.Lock()
+	defer cm.drainMu.Unlock()
+	cm.inflight--
+}
+
+// Drain 在关闭服务器前排空本节点的连接：
+//  1. 不再接受新的连接和消息
+//  2. 向所有连接推送 server_restarting 帧，附带随机的重连等待时间
+//  3. 等待处理中的消息完成、发送队列清空，最多等待 drain_timeout 或 ctx 结束
+//  4. 发送 going away 关闭帧断开连接，不再被其他连接使用、由机器人接待的会话转为不活跃，客户端重连后可以恢复；
+//     等待人工和人工接待中的会话保持不变，重启后排队记录和客服的接待关系仍然有效
+func (cm *ConnectionManager) Drain(ctx context.Context) {
+	cm.drainMu.Lock()
+	if cm.draining {
+		cm.drainMu.Unlock()
+		return
+	}
+	cm.draining = true
+	cm.drainMu.Unlock()
+
+	deadline := time.Now().Add(cm.config.DrainTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	cm.mu.RLock()
+	clients := make([]*Client, 0, len(cm.connections))
+	for _, client := range cm.connections {
+		clients = append(clients, client)
+	}
+	cm.mu.RUnlock()
+	log.Printf("Draining %d WebSocket connection(s)...", len(clients))
+
+	for _, client := range clients {
+		frame, err := service.NewFrame(service.MessageTypeServerRestarting, service.ServerRestarting{
+			ReconnectAfterMs: cm.reconnectHint().Milliseconds(),
+		})
+		if err != nil {
+			log.Printf("Failed to build server_restarting frame: %v", err)
+			break
+		}
+		client.trySend(frame)
+	}
+
+	if !cm.waitDrained(ctx, deadline, clients) {
+		cm.drainMu.Lock()
+		inflight := cm.inflight
+		cm.drainMu.Unlock()
+		log.Printf("Drain deadline reached with %d message(s) still in flight", inflight)
+	}
+
+	// 在锁内移除所有连接，在线登记和会话状态在锁外更新
+	cm.mu.Lock()
+	remaining := make([]*Client, 0, len(cm.connections))
+	pending := make(map[uint]time.Time)
+	agentLeft := false
+	for _, client := range cm.connections {
+		if client.isAgent {
+			delete(cm.connections, client.id)
+			if cm.agents[client.customerID] == client {
+				delete(cm.agents, client.customerID)
+				agentLeft = true
+			}
+		} else {
+			cm.detach(client, pending)
+		}
+		remaining = append(remaining, client)
+	}
+	cm.mu.Unlock()
+
+	cm.writeActivity(pending)
+	closed := 0
+	for _, client := range remaining {
+		if client.isAgent {
+			cm.removePresence(client)
+		} else {
+			cm.release(client, model.SessionStatusInactive)
+		}
+
+		client.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server restarting"),
+			time.Now().Add(time.Second))
+		client.conn.Close()
+		closed++
+	}
+	if agentLeft {
+		cm.notifyRouting()
+	}
+	log.Printf("Drained %d WebSocket connection(s)", closed)
+}
+
+// waitDrained 等待处理中的消息完成且各连接的发送队列清空，超时返回 false
+func (cm *ConnectionManager) waitDrained(ctx context.Context, deadline time.Time, clients []*Client) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if cm.drained(clients) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// drained 是否没有处理中的消息，且各连接排队的帧都已写出
+func (cm *ConnectionManager) drained(clients []*Client) bool {
+	cm.drainMu.Lock()
+	inflight := cm.inflight
+	cm.drainMu.Unlock()
+	if inflight > 0 {
+		return false
+	}
+	for _, client := range clients {
+		if client.queue.depth() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// reconnectHint 返回建议客户端等待的重连时间，在 reconnect_hint 内随机取值
+func (cm *ConnectionManager) reconnectHint() time.Duration {
+	if cm.config.ReconnectHint <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(cm.config.ReconnectHint)))
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/service"
+)
+
+var (
+	// errQueueClosed 连接已关闭，帧不再发送
+	errQueueClosed = errors.New("outbound queue closed")
+	// errSlowConsumer 队列已满且在等待时间内没有空位，连接的读取速度跟不上
+	errSlowConsumer = errors.New("slow consumer")
+)
+
+// frameClass 发送队列满时对帧的处理方式
+type frameClass int
+
+const (
+	// classMessage 聊天消息等必须送达的帧，队列满时等待空位，超时后断开连接
+	classMessage frameClass = iota
+	// classEphemeral 输入状态、已读回执、排队位置等只有最新值有意义的帧，队列满时丢弃最早的同类帧
+	classEphemeral
+)
+
+// classifyFrame 根据帧的类型决定队列满时的处理方式
+func classifyFrame(frame []byte) frameClass {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(frame, &envelope); err != nil {
+		return classMessage
+	}
+	switch envelope.Type {
+	case service.MessageTypeTypingStart, service.MessageTypeTypingStop,
+		service.MessageTypeReadReceipt, service.MessageTypeQueuePosition:
+		return classEphemeral
+	}
+	return classMessage
+}
+
+// QueueStats 连接发送队列的统计
+type QueueStats struct {
+	Depth     int    `json:"depth"`      // 当前排队的帧数
+	Capacity  int    `json:"capacity"`   // 队列容量
+	HighWater int    `json:"high_water"` // 排队帧数的最大值
+	Enqueued  uint64 `json:"enqueued"`   // 放入队列的帧数
+	Sent      uint64 `json:"sent"`       // 已取出发送的帧数
+	Dropped   uint64 `json:"dropped"`    // 队列满时丢弃的临时帧数
+	TimedOut  uint64 `json:"timed_out"`  // 等待空位超时的消息帧数
+	Strikes   int    `json:"strikes"`    // 队列清空前连续丢弃的帧数，超过上限时断开连接
+}
+
+// queuedFrame 队列中的帧
+type queuedFrame struct {
+	data  []byte
+	class frameClass
+}
+
+// outboundQueue 每个连接的发送队列。由写协程取出发送，多个协程可以同时放入；
+// 关闭可以重复调用，关闭后放入的帧返回 errQueueClosed
+type outboundQueue struct {
+	mu       sync.Mutex
+	frames   []queuedFrame
+	capacity int
+	maxDrops int // 队列清空前最多允许丢弃的帧数
+	closed   bool
+
+	ready chan struct{} // 有新帧时通知写协程，容量为 1
+	space chan struct{} // 取出帧后关闭并替换，唤醒所有等待空位的协程
+	done  chan struct{} // 队列关闭时关闭
+
+	stats QueueStats
+}
+
+// newOutboundQueue 创建发送队列
+func newOutboundQueue(capacity int, maxDrops int) *outboundQueue {
+	return &outboundQueue{
+		frames:   make([]queuedFrame, 0, capacity),
+		capacity: capacity,
+		maxDrops: maxDrops,
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// push 放入一帧。队列满时临时帧丢弃最早的临时帧（没有时丢弃该帧），消息帧最多等待 wait；
+// 返回 errSlowConsumer 时调用方应断开连接
+func (q *outboundQueue) push(data []byte, class frameClass, wait time.Duration) error {
+	var deadline <-chan time.Time
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return errQueueClosed
+		}
+		if len(q.frames) < q.capacity {
+			q.appendLocked(queuedFrame{data: data, class: class})
+			q.mu.Unlock()
+			return nil
+		}
+
+		if class == classEphemeral {
+			err := q.dropLocked(data)
+			q.mu.Unlock()
+			return err
+		}
+
+		if wait <= 0 {
+			q.stats.TimedOut++
+			q.mu.Unlock()
+			return errSlowConsumer
+		}
+		space := q.space
+		q.mu.Unlock()
+
+		if deadline == nil {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case <-space:
+		case <-q.done:
+			return errQueueClosed
+		case <-deadline:
+			q.mu.Lock()
+			q.stats.TimedOut++
+			q.mu.Unlock()
+			return errSlowConsumer
+		}
+	}
+}
+
+// appendLocked 追加一帧并通知写协程，调用方需持有 q.mu
+func (q *outboundQueue) appendLocked(frame queuedFrame) {
+	q.frames = append(q.frames, frame)
+	q.stats.Enqueued++
+	if len(q.frames) > q.stats.HighWater {
+		q.stats.HighWater = len(q.frames)
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// dropLocked 队列满时为临时帧腾出位置，连续丢弃过多时返回 errSlowConsumer，调用方需持有 q.mu
+func (q *outboundQueue) dropLocked(data []byte) error {
+	q.stats.Dropped++
+	q.stats.Strikes++
+	for i, frame := range q.frames {
+		if frame.class == classEphemeral {
+			q.frames = append(q.frames[:i], q.frames[i+1:]...)
+			q.appendLocked(queuedFrame{data: data, class: classEphemeral})
+			break
+		}
+	}
+	if q.maxDrops > 0 && q.stats.Strikes > q.maxDrops {
+		return errSlowConsumer
+	}
+	return nil
+}
+
+// pop 取出最早的一帧，队列为空时返回 false
+func (q *outboundQueue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.frames) == 0 {
+		return nil, false
+	}
+	frame := q.frames[0]
+	q.frames[0] = queuedFrame{}
+	q.frames = q.frames[1:]
+	q.stats.Sent++
+	if len(q.frames) == 0 {
+		q.stats.Strikes = 0
+	}
+
+	close(q.space)
+	q.space = make(chan struct{})
+	return frame.data, true
+}
+
+// close 关闭队列，已排队的帧仍可取出
+func (q *outboundQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+}
+
+// depth 返回当前排队的帧数
+func (q *outboundQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames)
+}
+
+// snapshot 返回队列统计
+func (q *outboundQueue) snapshot() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Depth = len(q.frames)
+	stats.Capacity = q.capacity
+	return stats
+}
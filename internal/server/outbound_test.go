@@ -0,0 +1,191 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/service"
+)
+
+// queued 返回队列中各帧的内容
+func queued(q *outboundQueue) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	data := make([]string, len(q.frames))
+	for i, frame := range q.frames {
+		data[i] = string(frame.data)
+	}
+	return data
+}
+
+// fill 依次放入帧，m 开头的为消息帧，其余为临时帧
+func fill(t *testing.T, q *outboundQueue, frames ...string) {
+	t.Helper()
+	for _, frame := range frames {
+		class := classEphemeral
+		if frame[0] == 'm' {
+			class = classMessage
+		}
+		if err := q.push([]byte(frame), class, 0); err != nil {
+			t.Fatalf("push(%q) failed: %v", frame, err)
+		}
+	}
+}
+
+func TestClassifyFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  frameClass
+	}{
+		{"typing", `{"type":"` + service.MessageTypeTypingStart + `"}`, classEphemeral},
+		{"read receipt", `{"type":"` + service.MessageTypeReadReceipt + `"}`, classEphemeral},
+		{"queue position", `{"type":"` + service.MessageTypeQueuePosition + `"}`, classEphemeral},
+		{"text", `{"type":"text"}`, classMessage},
+		{"invalid json", `not json`, classMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFrame([]byte(tt.frame)); got != tt.want {
+				t.Errorf("classifyFrame(%s) = %v, want %v", tt.frame, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutboundQueueDropOldest(t *testing.T) {
+	tests := []struct {
+		name        string
+		queued      []string
+		push        string
+		wantFrames  []string
+		wantDropped uint64
+	}{
+		{
+			name:        "replaces oldest ephemeral frame",
+			queued:      []string{"m1", "e1", "e2"},
+			push:        "e3",
+			wantFrames:  []string{"m1", "e2", "e3"},
+			wantDropped: 1,
+		},
+		{
+			name:        "drops new frame when only messages are queued",
+			queued:      []string{"m1", "m2", "m3"},
+			push:        "e1",
+			wantFrames:  []string{"m1", "m2", "m3"},
+			wantDropped: 1,
+		},
+		{
+			name:       "appends when there is room",
+			queued:     []string{"m1", "e1"},
+			push:       "e2",
+			wantFrames: []string{"m1", "e1", "e2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newOutboundQueue(3, 0)
+			fill(t, q, tt.queued...)
+			if err := q.push([]byte(tt.push), classEphemeral, 0); err != nil {
+				t.Fatalf("push failed: %v", err)
+			}
+			if got := queued(q); !reflect.DeepEqual(got, tt.wantFrames) {
+				t.Errorf("frames = %v, want %v", got, tt.wantFrames)
+			}
+			if got := q.snapshot().Dropped; got != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestOutboundQueueBlockingTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		wait         time.Duration
+		consume      bool // 等待期间取出一帧
+		close        bool // 等待期间关闭队列
+		wantErr      error
+		wantTimedOut uint64
+	}{
+		{name: "no wait", wait: 0, wantErr: errSlowConsumer, wantTimedOut: 1},
+		{name: "times out", wait: 20 * time.Millisecond, wantErr: errSlowConsumer, wantTimedOut: 1},
+		{name: "space freed while waiting", wait: time.Second, consume: true},
+		{name: "closed while waiting", wait: time.Second, close: true, wantErr: errQueueClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newOutboundQueue(2, 0)
+			fill(t, q, "m1", "m2")
+
+			consume, closing := tt.consume, tt.close
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				time.Sleep(10 * time.Millisecond)
+				switch {
+				case consume:
+					q.pop()
+				case closing:
+					q.close()
+				}
+			}()
+
+			err := q.push([]byte("m3"), classMessage, tt.wait)
+			<-done
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("push() = %v, want %v", err, tt.wantErr)
+			}
+			if got := q.snapshot().TimedOut; got != tt.wantTimedOut {
+				t.Errorf("timed out = %d, want %d", got, tt.wantTimedOut)
+			}
+			if tt.consume {
+				if got, want := queued(q), []string{"m2", "m3"}; !reflect.DeepEqual(got, want) {
+					t.Errorf("frames = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboundQueueSlowConsumer(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDrops int
+		drops    int  // 队列满时放入的临时帧数
+		drain    bool // 放入前是否清空过队列
+		wantErr  error
+	}{
+		{name: "within limit", maxDrops: 3, drops: 3},
+		{name: "exceeds limit", maxDrops: 3, drops: 4, wantErr: errSlowConsumer},
+		{name: "unlimited", maxDrops: 0, drops: 10},
+		{name: "strikes reset after drain", maxDrops: 3, drops: 3, drain: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newOutboundQueue(2, tt.maxDrops)
+			fill(t, q, "e1", "e2")
+			if tt.drain {
+				// 先丢弃到上限，清空队列后重新计数
+				for i := 0; i < tt.maxDrops; i++ {
+					if err := q.push([]byte("e"), classEphemeral, 0); err != nil {
+						t.Fatalf("push failed before drain: %v", err)
+					}
+				}
+				for _, ok := q.pop(); ok; _, ok = q.pop() {
+				}
+				fill(t, q, "e1", "e2")
+			}
+
+			var err error
+			for i := 0; i < tt.drops && err == nil; i++ {
+				err = q.push([]byte("e"), classEphemeral, 0)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("push() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
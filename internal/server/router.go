@@ -6,18 +6,24 @@ import (
 
 	"github.com/JennerWork/chatbot/internal/handler"
 	"github.com/JennerWork/chatbot/internal/middleware"
+	"github.com/JennerWork/chatbot/internal/model"
 	"github.com/JennerWork/chatbot/internal/service"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 // SetupRoutes 配置所有路由
-func (s *Server) SetupRoutes(db *gorm.DB, handlers MessageHandlers, cm *ConnectionManager) {
+func (s *Server) SetupRoutes(db *gorm.DB, handlers MessageHandlers, agentHandlers MessageHandlers, cm *ConnectionManager, knowledgeService service.KnowledgeService, attachmentService service.AttachmentService, moderationService service.ModerationService, privacyService service.PrivacyService) {
 	// 创建服务实例
 	messageQueryService := service.NewMessageQueryService(db)
-	messageHandler := handler.NewMessageHandler(messageQueryService)
+	messageHandler := handler.NewMessageHandler(messageQueryService, handlers, service.NewSyncService(db), cm)
 	customerService := service.NewCustomerService(db)
 	customerHandler := handler.NewCustomerHandler(customerService)
+	knowledgeHandler := handler.NewKnowledgeHandler(knowledgeService)
+	agentProfileHandler := handler.NewAgentProfileHandler(service.NewAgentProfileService(db))
+	attachmentHandler := handler.NewAttachmentHandler(attachmentService)
+	moderationHandler := handler.NewModerationHandler(moderationService)
+	privacyHandler := handler.NewPrivacyHandler(privacyService)
 
 	// 创建认证服务
 	jwtConfig := service.JWTConfig{
@@ -35,11 +41,17 @@ func (s *Server) SetupRoutes(db *gorm.DB, handlers MessageHandlers, cm *Connecti
 		cm.HandleWebSocket(c.Writer, c.Request, handlers)
 	})
 
+	// 人工客服WebSocket路由（需要客服或管理员角色）
+	s.router.GET("/ws/agent", authMiddleware, middleware.RequireRole(model.RoleAgent, model.RoleAdmin), func(c *gin.Context) {
+		cm.HandleAgentWebSocket(c.Writer, c.Request, agentHandlers)
+	})
+
 	// 健康检查（无需认证）
 	s.router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
-			"status":      "ok",
-			"connections": cm.GetActiveConnections(),
+			"status":           "ok",
+			"connections":      cm.GetActiveConnections(),
+			"pending_messages": cm.PendingMessages(),
 		})
 	})
 
@@ -75,6 +87,54 @@ func (s *Server) SetupRoutes(db *gorm.DB, handlers MessageHandlers, cm *Connecti
 			messages := authenticated.Group("/message")
 			{
 				messages.GET("/list", messageHandler.GetMessageHistory)
+				messages.POST("/send", messageHandler.SendMessage)
+				messages.POST("/sync", messageHandler.SyncMessages)
+			}
+
+			// 附件上传和下载，下载仅限上传者和接待该会话的客服
+			attachments := authenticated.Group("/attachments")
+			{
+				attachments.POST("", attachmentHandler.Upload)
+				attachments.GET("/:id", attachmentHandler.Download)
+			}
+
+			// 管理后台路由（需要管理员角色）
+			admin := authenticated.Group("/admin")
+			admin.Use(middleware.RequireRole(model.RoleAdmin))
+			{
+				// 知识库管理，变更后自动重建索引
+				kb := admin.Group("/kb")
+				{
+					kb.GET("", knowledgeHandler.ListArticles)
+					kb.POST("", knowledgeHandler.CreateArticle)
+					kb.POST("/reindex", knowledgeHandler.Reindex)
+					kb.GET("/:id", knowledgeHandler.GetArticle)
+					kb.PUT("/:id", knowledgeHandler.UpdateArticle)
+					kb.DELETE("/:id", knowledgeHandler.DeleteArticle)
+				}
+
+				// 客服档案管理，用于人工客服排队分配
+				agents := admin.Group("/agents")
+				{
+					agents.GET("", agentProfileHandler.ListProfiles)
+					agents.GET("/:id", agentProfileHandler.GetProfile)
+					agents.PUT("/:id", agentProfileHandler.UpdateProfile)
+				}
+
+				// 内容审核记录的查询和复核
+				moderationFlags := admin.Group("/moderation/flags")
+				{
+					moderationFlags.GET("", moderationHandler.ListFlags)
+					moderationFlags.POST("/:id/review", moderationHandler.ReviewFlag)
+				}
+
+				// 查看脱敏消息的原文，每次查看都记录日志
+				admin.GET("/vault/messages/:id", privacyHandler.RevealMessage)
+
+				// 本节点连接的发送队列深度，用于定位慢连接
+				admin.GET("/connections", func(c *gin.Context) {
+					c.JSON(http.StatusOK, cm.ConnectionStats())
+				})
 			}
 		}
 	}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/service"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 分配策略
+const (
+	RoutingRoundRobin = "round_robin" // 在可接待的客服中轮流分配
+	RoutingLeastBusy  = "least_busy"  // 分配给当前接待会话最少的客服
+)
+
+// agentSlot 一次分配过程中客服的接待情况
+type agentSlot struct {
+	profile model.AgentProfile
+	load    int // 正在接待的会话数
+}
+
+// routingStateID routing_state 表中唯一一行的ID
+const routingStateID = 1
+
+// RoutingQueue 人工客服排队队列，按优先级和等待时间为会话分配在线客服。
+// 排队记录保存在 queue_entries 表中，所有节点共享同一个队列。每轮分配在事务中锁定 routing_state 的唯一一行，
+// 集群中同一时刻只有一个节点在分配；客服的接待数每轮从会话表统计，轮流分配的位置也保存在该行中
+type RoutingQueue struct {
+	db       *gorm.DB
+	cm       *ConnectionManager
+	config   config.RoutingConfig
+	assigner service.SessionAssigner
+
+	trigger chan struct{}
+}
+
+// NewRoutingQueue 创建人工客服排队队列
+func NewRoutingQueue(db *gorm.DB, cm *ConnectionManager, cfg config.RoutingConfig) *RoutingQueue {
+	if cfg.Strategy != RoutingLeastBusy {
+		cfg.Strategy = RoutingRoundRobin
+	}
+	if cfg.DefaultMaxConcurrent <= 0 {
+		cfg.DefaultMaxConcurrent = 3
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+
+	q := &RoutingQueue{
+		db:      db,
+		cm:      cm,
+		config:  cfg,
+		trigger: make(chan struct{}, 1),
+	}
+	// 客户断开或客服上下线时由连接管理器触发重新分配
+	cm.routing = q
+	return q
+}
+
+// Start 开始分配排队中的会话，包括服务重启前已排队的会话
+func (q *RoutingQueue) Start(assigner service.SessionAssigner) error {
+	// 分配状态行由 init.sql 创建，缺少时补上
+	if err := q.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.RoutingState{ID: routingStateID}).Error; err != nil {
+		return err
+	}
+	var waiting int64
+	if err := q.db.Model(&model.QueueEntry{}).Count(&waiting).Error; err != nil {
+		return err
+	}
+
+	q.assigner = assigner
+	log.Printf("Routing queue started with %d waiting sessions (strategy: %s)", waiting, q.config.Strategy)
+
+	go q.run()
+	q.Notify()
+	return nil
+}
+
+// Enqueue 会话加入队列，已在队列中的会话会更新排队条件
+func (q *RoutingQueue) Enqueue(req service.QueueRequest) (int, error) {
+	entry := model.QueueEntry{
+		SessionID:  req.SessionID,
+		CustomerID: req.CustomerID,
+		Skill:      req.Skill,
+		Language:   req.Language,
+		Priority:   req.Priority,
+		EnqueuedAt: time.Now(),
+	}
+	if err := q.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "session_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"skill", "language", "priority", "updated_at"}),
+	}).Create(&entry).Error; err != nil {
+		return 0, err
+	}
+
+	entries, err := q.load(q.db)
+	if err != nil {
+		return 0, err
+	}
+	position := positionOf(entries, req.SessionID)
+	// 客户在转人工的回复中已得知位置，不再重复推送
+	if err := q.db.Model(&model.QueueEntry{}).
+		Where("session_id = ?", req.SessionID).
+		Update("notified_position", position).Error; err != nil {
+		return 0, err
+	}
+
+	q.Notify()
+	return position, nil
+}
+
+// Remove 将会话移出队列，后面客户的新位置在下一轮分配时推送
+func (q *RoutingQueue) Remove(sessionID uint) {
+	if err := q.db.Unscoped().Where("session_id = ?", sessionID).Delete(&model.QueueEntry{}).Error; err != nil {
+		log.Printf("Failed to delete queue entry of session %d: %v", sessionID, err)
+	}
+	q.Notify()
+}
+
+// Notify 触发一次分配，不会阻塞调用方
+func (q *RoutingQueue) Notify() {
+	select {
+	case q.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Len 返回排队中的会话数
+func (q *RoutingQueue) Len() int {
+	var waiting int64
+	if err := q.db.Model(&model.QueueEntry{}).Count(&waiting).Error; err != nil {
+		log.Printf("Failed to count queue entries: %v", err)
+	}
+	return int(waiting)
+}
+
+// run 收到触发或定时进行分配
+func (q *RoutingQueue) run() {
+	ticker := time.NewTicker(q.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.trigger:
+		case <-ticker.C:
+		}
+		if err := q.dispatch(context.Background()); err != nil {
+			log.Printf("Failed to dispatch routing queue: %v", err)
+		}
+	}
+}
+
+// dispatch 按队列顺序为会话分配客服，没有合适客服的会话继续等待。
+// 其他节点正在分配时等待其完成，再按最新的接待数分配，同一客服不会被分配超过接待上限的会话
+func (q *RoutingQueue) dispatch(ctx context.Context) error {
+	return q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var state model.RoutingState
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&state, routingStateID).Error; err != nil {
+			return err
+		}
+
+		entries, err := q.load(tx)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		if entries, err = q.prune(tx, entries); err != nil {
+			return err
+		}
+
+		slots, err := q.agentSlots(tx)
+		if err != nil {
+			return fmt.Errorf("failed to load agent capacity: %v", err)
+		}
+
+		lastAgent := state.LastAgentID
+		remaining := entries[:0]
+		for _, entry := range entries {
+			agentID, ok := q.pick(entry, slots, lastAgent)
+			if !ok {
+				remaining = append(remaining, entry)
+				continue
+			}
+
+			err := q.assigner.Assign(ctx, agentID, entry.SessionID)
+			switch {
+			case err == nil:
+				slots[agentID].load++
+				lastAgent = agentID
+				q.delete(tx, entry)
+			case errors.Is(err, service.ErrSessionNotWaiting):
+				// 会话已被手动认领或客户已离开
+				q.delete(tx, entry)
+			default:
+				log.Printf("Failed to assign session %d to agent %d: %v", entry.SessionID, agentID, err)
+				remaining = append(remaining, entry)
+			}
+		}
+		if lastAgent != state.LastAgentID {
+			if err := tx.Model(&state).Update("last_agent_id", lastAgent).Error; err != nil {
+				return err
+			}
+		}
+
+		q.notifyPositions(tx, remaining)
+		return nil
+	})
+}
+
+// load 按优先级从高到低、入队时间从早到晚返回排队记录
+func (q *RoutingQueue) load(db *gorm.DB) ([]model.QueueEntry, error) {
+	var entries []model.QueueEntry
+	err := db.Order("priority desc, enqueued_at asc, id asc").Find(&entries).Error
+	return entries, err
+}
+
+// prune 移除已不在等待人工状态的会话，例如会话已结束或已被客服认领
+func (q *RoutingQueue) prune(tx *gorm.DB, entries []model.QueueEntry) ([]model.QueueEntry, error) {
+	ids := make([]uint, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.SessionID
+	}
+
+	var waiting []uint
+	if err := tx.Model(&model.Session{}).
+		Where("id IN ? AND status = ?", ids, model.SessionStatusWaitingAgent).
+		Pluck("id", &waiting).Error; err != nil {
+		return nil, fmt.Errorf("failed to check queued sessions: %v", err)
+	}
+	stillWaiting := make(map[uint]bool, len(waiting))
+	for _, id := range waiting {
+		stillWaiting[id] = true
+	}
+
+	remaining := entries[:0]
+	for _, entry := range entries {
+		if stillWaiting[entry.SessionID] {
+			remaining = append(remaining, entry)
+		} else {
+			q.delete(tx, entry)
+		}
+	}
+	return remaining, nil
+}
+
+// agentSlots 加载在线客服的档案和当前接待数
+func (q *RoutingQueue) agentSlots(tx *gorm.DB) (map[uint]*agentSlot, error) {
+	agentIDs := q.cm.OnlineAgents()
+	slots := make(map[uint]*agentSlot, len(agentIDs))
+	if len(agentIDs) == 0 {
+		return slots, nil
+	}
+
+	for _, id := range agentIDs {
+		slots[id] = &agentSlot{profile: model.AgentProfile{AgentID: id}}
+	}
+
+	var profiles []model.AgentProfile
+	if err := tx.Where("agent_id IN ?", agentIDs).Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	for _, profile := range profiles {
+		slots[profile.AgentID].profile = profile
+	}
+
+	var loads []struct {
+		AgentID uint
+		Count   int
+	}
+	if err := tx.Model(&model.Session{}).
+		Select("agent_id, COUNT(*) AS count").
+		Where("status = ? AND agent_id IN ?", model.SessionStatusWithAgent, agentIDs).
+		Group("agent_id").
+		Scan(&loads).Error; err != nil {
+		return nil, err
+	}
+	for _, l := range loads {
+		slots[l.AgentID].load = l.Count
+	}
+
+	return slots, nil
+}
+
+// pick 按分配策略从可接待的客服中选出一个，lastAgent 为上一次分配到的客服
+func (q *RoutingQueue) pick(entry model.QueueEntry, slots map[uint]*agentSlot, lastAgent uint) (uint, bool) {
+	var eligible []uint
+	for id, slot := range slots {
+		if slot.load < q.capacity(slot.profile) &&
+			slot.profile.HasSkill(entry.Skill) &&
+			slot.profile.SpeaksLanguage(entry.Language) {
+			eligible = append(eligible, id)
+		}
+	}
+	if len(eligible) == 0 {
+		return 0, false
+	}
+	sort.Slice(eligible, func(i, j int) bool { return eligible[i] < eligible[j] })
+
+	if q.config.Strategy == RoutingLeastBusy {
+		best := eligible[0]
+		for _, id := range eligible[1:] {
+			if slots[id].load < slots[best].load {
+				best = id
+			}
+		}
+		return best, true
+	}
+
+	// 轮流分配：选择上一次分配的客服之后的第一个客服
+	for _, id := range eligible {
+		if id > lastAgent {
+			return id, true
+		}
+	}
+	return eligible[0], true
+}
+
+// capacity 客服最多同时接待的会话数
+func (q *RoutingQueue) capacity(profile model.AgentProfile) int {
+	if profile.MaxConcurrent > 0 {
+		return profile.MaxConcurrent
+	}
+	return q.config.DefaultMaxConcurrent
+}
+
+// positionOf 返回会话的排队位置，不在队列中时返回 0
+func positionOf(entries []model.QueueEntry, sessionID uint) int {
+	for i, entry := range entries {
+		if entry.SessionID == sessionID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// notifyPositions 向排队位置发生变化的客户推送新位置，已推送的位置记录在排队记录中，各节点不会重复推送
+func (q *RoutingQueue) notifyPositions(tx *gorm.DB, entries []model.QueueEntry) {
+	for i, entry := range entries {
+		position := i + 1
+		if entry.NotifiedPosition == position {
+			continue
+		}
+		frame, err := service.NewFrame(service.MessageTypeQueuePosition, service.QueuePosition{
+			SessionID: entry.SessionID,
+			Position:  position,
+		})
+		if err != nil {
+			continue
+		}
+		if !q.cm.SendToSession(entry.SessionID, frame) {
+			continue
+		}
+		if err := tx.Model(&model.QueueEntry{}).
+			Where("id = ?", entry.ID).
+			Update("notified_position", position).Error; err != nil {
+			log.Printf("Failed to record queue position of session %d: %v", entry.SessionID, err)
+		}
+	}
+}
+
+// delete 删除持久化的排队记录
+func (q *RoutingQueue) delete(tx *gorm.DB, entry model.QueueEntry) {
+	if err := tx.Unscoped().Delete(&model.QueueEntry{}, entry.ID).Error; err != nil {
+		log.Printf("Failed to delete queue entry of session %d: %v", entry.SessionID, err)
+	}
+}
@@ -1,14 +1,23 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/JennerWork/chatbot/internal/model"
-	"github.com/gorilla/context"
+	"github.com/JennerWork/chatbot/internal/service"
+	gcontext "github.com/gorilla/context"
 	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 )
@@ -44,25 +53,51 @@ type Client struct {
 	id           string             // 连接ID
 	customerID   uint               // 客户ID
 	conn         *websocket.Conn    // WebSocket连接
-	send         chan []byte        // 发送消息的通道
+	queue        *outboundQueue     // 发送队列，由写协程取出发送
 	handlers     MessageHandlers    // 消息处理器
-	lastActivity time.Time          // 最后活动时间
+	lastActivity atomic.Int64       // 最后一次收到消息的时间（UnixNano），读协程写入，清理协程读取
+	flushed      int64              // 已写入会话表的最后活动时间（UnixNano），由 manager.mu 保护
+	connectedAt  time.Time          // 连接建立时间，超过设备数上限时先断开最早的连接
 	manager      *ConnectionManager // 连接管理器
 	session      *model.Session     // 关联的会话
 	db           *gorm.DB           // 数据库连接
+	isAgent      bool               // 是否为人工客服连接，客服连接的 customerID 为客服的用户ID
+	slowOnce     sync.Once          // 慢连接只断开一次
 }
 
 // MessageHandlers 定义消息处理器
 type MessageHandlers interface {
-	HandleMessage(customerID uint, message []byte) ([]byte, error)
+	// HandleMessage 处理消息并返回最终回复，处理过程中的中间帧（如流式片段）通过 w 推送；
+	// sessionID 为客户连接关联的会话，客服连接为 0；ctx 在处理超时后结束
+	HandleMessage(ctx context.Context, customerID uint, sessionID uint, message []byte, w service.FrameWriter) ([]byte, error)
 }
 
-// updateActivity 更新客户端活动时间
+// sessionID 返回连接关联的会话ID，客服连接为 0
+func (c *Client) sessionID() uint {
+	if c.session == nil {
+		return 0
+	}
+	return c.session.ID
+}
+
+// updateActivity 记录收到消息的时间，会话的最后活动时间由连接管理器批量写入
 func (c *Client) updateActivity() {
-	c.lastActivity = time.Now()
-	// 更新会话最后活动时间
-	c.session.LastActiveAt = c.lastActivity
-	c.db.Save(c.session)
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// activity 返回最后一次收到消息的时间
+func (c *Client) activity() time.Time {
+	return time.Unix(0, c.lastActivity.Load())
+}
+
+// pendingActivity 返回尚未写入会话表的最后活动时间，并标记为已写入。调用方需持有 manager.mu
+func (c *Client) pendingActivity() (time.Time, bool) {
+	at := c.lastActivity.Load()
+	if at <= c.flushed {
+		return time.Time{}, false
+	}
+	c.flushed = at
+	return time.Unix(0, at), true
 }
 
 // HandleWebSocket 处理WebSocket连接请求
@@ -74,6 +109,35 @@ func (cm *ConnectionManager) HandleWebSocket(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if cm.rejectDraining(w) {
+		return
+	}
+
+	// 携带 session_id 时尝试恢复之前的会话
+	var session *model.Session
+	if param := r.URL.Query().Get("session_id"); param != "" {
+		sessionID, err := strconv.ParseUint(param, 10, 64)
+		if err != nil || sessionID == 0 {
+			http.Error(w, "Invalid session_id", http.StatusBadRequest)
+			return
+		}
+		session, err = cm.resumableSession(customerID, uint(sessionID))
+		if errors.Is(err, errSessionNotOwned) {
+			log.Printf("Customer %d tried to resume session %d of another customer", customerID, sessionID)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load session %d: %v", sessionID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	// 未恢复指定会话时加入该客户其他设备正在进行的会话
+	if session == nil {
+		session = cm.liveSession(customerID)
+	}
+	resumed := session != nil
 
 	// 升级连接
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -82,109 +146,363 @@ func (cm *ConnectionManager) HandleWebSocket(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// 创建新会话，初始状态为initiated
-	session := &model.Session{
-		CustomerID:   customerID,
-		Status:       string(model.SessionStatusInitiated),
-		LastActiveAt: time.Now(),
+	if resumed {
+		log.Printf("Session %d resumed for customer %d", session.ID, customerID)
+	} else {
+		// 创建新会话，初始状态为initiated
+		session = &model.Session{
+			CustomerID:   customerID,
+			Status:       string(model.SessionStatusInitiated),
+			LastActiveAt: time.Now(),
+		}
+
+		if err := cm.db.Create(session).Error; err != nil {
+			log.Printf("Failed to create session: %v", err)
+			conn.Close()
+			return
+		}
+
+		log.Printf("New session initiated for customer %d", customerID)
+	}
+
+	client := &Client{
+		conn:        conn,
+		queue:       cm.newQueue(),
+		handlers:    handlers,
+		customerID:  customerID,
+		connectedAt: time.Now(),
+		manager:     cm,
+		session:     session,
+		db:          cm.db,
+	}
+
+	client.updateActivity()
+	client.flushed = client.lastActivity.Load()
+
+	// 注册客户端（这里会将状态更新为active）
+	if !cm.Register(client) {
+		client.closeRestarting()
+		return
+	}
+
+	// 告知客户端当前会话，断线重连时携带该ID恢复会话
+	if frame, err := service.NewFrame(service.MessageTypeSession, service.SessionInfo{
+		SessionID: session.ID,
+		Resumed:   resumed,
+	}); err == nil {
+		client.trySend(frame)
 	}
 
-	if err := cm.db.Create(session).Error; err != nil {
-		log.Printf("Failed to create session: %v", err)
-		conn.Close()
+	// 启动读写goroutine
+	go client.writePump()
+	go client.readPump()
+}
+
+// HandleAgentWebSocket 处理人工客服的WebSocket连接请求，调用方需确保已校验客服角色
+func (cm *ConnectionManager) HandleAgentWebSocket(w http.ResponseWriter, r *http.Request, handlers MessageHandlers) {
+	agentID := getCustomerIDFromRequest(r)
+	if agentID == 0 {
+		log.Printf("Unauthorized agent WebSocket connection attempt")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if cm.rejectDraining(w) {
 		return
 	}
 
-	log.Printf("New session initiated for customer %d", customerID)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade agent connection: %v", err)
+		return
+	}
 
 	client := &Client{
-		conn:         conn,
-		send:         make(chan []byte, 256),
-		handlers:     handlers,
-		customerID:   customerID,
-		lastActivity: time.Now(),
-		manager:      cm,
-		session:      session,
-		db:           cm.db,
+		conn:        conn,
+		queue:       cm.newQueue(),
+		handlers:    handlers,
+		customerID:  agentID,
+		connectedAt: time.Now(),
+		manager:     cm,
+		db:          cm.db,
+		isAgent:     true,
 	}
+	client.updateActivity()
 
-	// 注册客户端（这里会将状态更新为active）
-	cm.Register(client)
+	if !cm.Register(client) {
+		client.closeRestarting()
+		return
+	}
 
-	// 启动读写goroutine
 	go client.writePump()
 	go client.readPump()
 }
 
-// writePump 将消息发送到WebSocket连接
+// writePump 将发送队列中的帧写入WebSocket连接，并定期发送 ping 检测连接是否存活。
+// 队列关闭后写出剩余的帧，再发送关闭帧
 func (c *Client) writePump() {
+	cfg := c.manager.config
+	ticker := time.NewTicker(cfg.PingInterval)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
-		c.manager.Unregister(c)
+		c.manager.Unregister(c, model.SessionStatusInactive)
 	}()
 
+	write := func() bool {
+		for {
+			frame, ok := c.queue.pop()
+			if !ok {
+				return true
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				return false
+			}
+		}
+	}
+
 	for {
 		select {
-		case msg, ok := <-c.send:
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, nil)
+		case <-c.queue.ready:
+			if !write() {
 				return
 			}
-			c.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		case <-c.queue.done:
+			if write() {
+				c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+				c.conn.WriteMessage(websocket.CloseMessage, nil)
+			}
+			return
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
-			c.updateActivity()
 		}
 	}
 }
 
 // readPump 从WebSocket连接读取消息
 func (c *Client) readPump() {
+	// 客户端以正常关闭帧关闭时结束会话；其他断开（包括 going away 和 pong 超时）
+	// 会话转为不活跃，可在宽限期内恢复
+	closeStatus := model.SessionStatusInactive
 	defer func() {
-		c.manager.Unregister(c)
-		close(c.send)
+		c.manager.Unregister(c, closeStatus)
+		c.queue.close()
 		c.conn.Close()
 	}()
 
-	// 设置读取超时
-	c.conn.SetReadDeadline(time.Now().Add(time.Second * 60))
+	// 收到任何数据（消息、pong 或客户端的 ping）都说明连接存活，超过 pong_timeout 没有数据时读取失败
+	cfg := c.manager.config
+	extendDeadline := func() {
+		c.conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+	}
+	extendDeadline()
 	c.conn.SetPongHandler(func(string) error {
-		c.updateActivity()
-		c.conn.SetReadDeadline(time.Now().Add(time.Second * 60))
+		extendDeadline()
 		return nil
 	})
+	c.conn.SetPingHandler(func(data string) error {
+		extendDeadline()
+		err := c.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(cfg.WriteTimeout))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		return err
+	})
 
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			switch {
+			case websocket.IsCloseError(err, websocket.CloseNormalClosure):
+				closeStatus = model.SessionStatusCancelled
+			case errors.As(err, &netErr) && netErr.Timeout():
+				log.Printf("WebSocket connection %s of %d timed out (no pong within %v)", c.id, c.customerID, cfg.PongTimeout)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
 				log.Printf("WebSocket read error for customer %d: %v", c.customerID, err)
 			}
 			break
 		}
 
 		c.updateActivity()
-		c.conn.SetReadDeadline(time.Now().Add(time.Second * 60))
+		extendDeadline()
 
-		// 处理接收到的消息并发送回复
-		response, err := c.handlers.HandleMessage(c.customerID, message)
-		if err != nil {
-			log.Printf("Error handling message for customer %d: %v", c.customerID, err)
+		// 排空开始后不再处理新消息，客户端重连后重发
+		if !c.manager.beginWork() {
+			c.sendError(service.ErrServerRestarting, message)
 			continue
 		}
+		// 消息交给协程池处理，读协程继续读取（包括 pong），不被耗时的回复阻塞
+		if err := c.manager.workers.submit(c.orderKey(message), c.task(message)); err != nil {
+			c.manager.endWork()
+			log.Printf("Failed to queue message from %d: %v", c.customerID, err)
+			c.sendError(service.ErrServerBusy, message)
+		}
+	}
+}
 
-		// 如果有回复消息，发送给客户端
-		if response != nil {
-			c.send <- response
+// orderKey 返回消息的排序键，相同键的消息按收到的顺序处理。
+// 客户和客服的消息都按会话排序，同一会话中双方的消息不会被重新排序：客户的消息进入连接关联的会话，
+// 客服同时接待多个会话，按消息中的会话排序
+func (c *Client) orderKey(message []byte) string {
+	if !c.isAgent {
+		return fmt.Sprintf("session:%d", c.sessionID())
+	}
+	var envelope struct {
+		Content struct {
+			SessionID uint `json:"session_id"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(message, &envelope); err == nil && envelope.Content.SessionID > 0 {
+		return fmt.Sprintf("session:%d", envelope.Content.SessionID)
+	}
+	return fmt.Sprintf("agent:%d", c.customerID)
+}
+
+// task 返回在协程池中处理消息的任务。连接断开不取消处理，回复已保存，客户端重连后同步
+func (c *Client) task(message []byte) func() {
+	return func() {
+		defer c.manager.endWork()
+		// 处理过程中 panic 只影响这条消息，以 internal_error 告诉客户端，不影响其他消息
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic handling message for customer %d: %v\n%s", c.customerID, r, debug.Stack())
+				c.sendError(fmt.Errorf("panic: %v", r), message)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), c.manager.config.HandleTimeout)
+		defer cancel()
+		c.process(ctx, message)
+	}
+}
+
+// process 处理接收到的消息并发送回复
+func (c *Client) process(ctx context.Context, message []byte) {
+	response, err := c.handlers.HandleMessage(ctx, c.customerID, c.sessionID(), message, c.writeFrame)
+	if errors.Is(err, service.ErrDuplicateMessage) {
+		// 重发的消息已重新确认，不再回复或回显
+		return
+	}
+	if err != nil {
+		log.Printf("Error handling message for customer %d: %v", c.customerID, err)
+		c.sendError(err, message)
+		return
+	}
+
+	// 客户在一个设备上发送的消息同步显示到其他设备
+	if !c.isAgent {
+		c.echo(message)
+	}
+
+	// 如果有回复消息，发送给客户端，客户的其他设备也会收到
+	if response != nil {
+		if err := c.enqueue(response); err != nil {
+			log.Printf("Failed to queue response for %d: %v", c.customerID, err)
 		}
+		if !c.isAgent {
+			c.manager.sendToCustomerExcept(c.customerID, c.id, response)
+		}
+	}
+}
+
+// sendError 将处理失败的原因以 error 帧告诉客户端，避免客户端一直等待回复
+func (c *Client) sendError(err error, message []byte) {
+	frame, frameErr := service.NewErrorFrame(err, message)
+	if frameErr != nil {
+		log.Printf("Failed to build error frame for %d: %v", c.customerID, frameErr)
+		return
+	}
+	c.trySend(frame)
+}
+
+// echo 将客户发送的聊天消息推送到该客户的其他连接，同步等命令不回显
+func (c *Client) echo(message []byte) {
+	frame, ok, err := service.NewEchoFrame(message)
+	if err != nil {
+		log.Printf("Failed to build echo frame for customer %d: %v", c.customerID, err)
+		return
+	}
+	if ok {
+		c.manager.sendToCustomerExcept(c.customerID, c.id, frame)
+	}
+}
+
+// writeFrame 将处理过程中产生的中间帧放入发送队列，连接过慢或已关闭时返回错误，终止消息处理
+func (c *Client) writeFrame(frame []byte) error {
+	return c.enqueue(frame)
+}
+
+// enqueue 将帧放入发送队列。队列满时临时帧丢弃最早的同类帧，消息帧最多等待 send_timeout，
+// 超时或连续丢弃过多时断开连接
+func (c *Client) enqueue(frame []byte) error {
+	return c.push(frame, c.manager.config.SendTimeout)
+}
+
+// trySend 非阻塞地将帧放入发送队列，调用方持有 cm.mu 时使用。消息帧在队列满时不等待，直接断开连接
+func (c *Client) trySend(frame []byte) bool {
+	return c.push(frame, 0) == nil
+}
+
+// push 按帧的类型放入发送队列，连接过慢时断开
+func (c *Client) push(frame []byte, wait time.Duration) error {
+	err := c.queue.push(frame, classifyFrame(frame), wait)
+	if errors.Is(err, errSlowConsumer) {
+		c.disconnectSlow()
+	}
+	return err
+}
+
+// disconnectSlow 断开读取速度跟不上的连接。以 try again later 关闭帧告知客户端，
+// 客户端重连后通过增量同步补齐消息；读协程随后退出并注销连接
+func (c *Client) disconnectSlow() {
+	c.slowOnce.Do(func() {
+		stats := c.queue.snapshot()
+		log.Printf("Disconnecting slow consumer %s of %d (queued: %d, dropped: %d, timed out: %d)",
+			c.id, c.customerID, stats.Depth, stats.Dropped, stats.TimedOut)
+		// 调用方可能持有 cm.mu，关闭帧在后台发送
+		go func() {
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer"),
+				time.Now().Add(time.Second))
+			c.conn.Close()
+		}()
+	})
+}
+
+// QueueStats 返回连接发送队列的统计
+func (c *Client) QueueStats() QueueStats {
+	return c.queue.snapshot()
+}
+
+// closeRestarting 服务器正在排空连接，以 going away 关闭帧断开刚建立的连接
+func (c *Client) closeRestarting() {
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, "server restarting"),
+		time.Now().Add(time.Second))
+	c.conn.Close()
+}
+
+// rejectDraining 正在排空连接时拒绝升级请求，返回是否已拒绝
+func (cm *ConnectionManager) rejectDraining(w http.ResponseWriter) bool {
+	if !cm.Draining() {
+		return false
 	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(cm.config.ReconnectHint.Seconds())+1))
+	http.Error(w, "Server is restarting", http.StatusServiceUnavailable)
+	return true
 }
 
 // getCustomerIDFromRequest 从请求中获取客户ID
 func getCustomerIDFromRequest(r *http.Request) uint {
 	// 从gin的Context中获取customerID
-	customerID := context.Get(r, "customer_id")
+	customerID := gcontext.Get(r, "customer_id")
 	if customerID != nil {
 		if customerID, ok := customerID.(uint); ok {
 			return customerID
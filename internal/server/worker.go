@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+var (
+	// errPoolFull 等待处理的消息已达上限
+	errPoolFull = errors.New("worker queue full")
+	// errPoolClosed 协程池已关闭
+	errPoolClosed = errors.New("worker pool closed")
+)
+
+// keyQueue 同一个排序键下等待处理的任务
+type keyQueue struct {
+	tasks     []func()
+	scheduled bool // 已在就绪队列中或正在被某个协程处理
+}
+
+// workerPool 固定数量的协程处理消息。相同排序键的任务按提交顺序逐个执行，
+// 不同排序键的任务并行执行；每次只执行一个键的一个任务，之后该键排到就绪队列末尾，
+// 避免一个键的大量任务占满协程
+type workerPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	keys   map[string]*keyQueue // 排序键 -> 等待处理的任务
+	ready  []string             // 有任务待执行且没有协程在处理的键
+	queued int                  // 等待执行的任务数
+	limit  int                  // 等待执行的任务上限
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newWorkerPool 创建并启动协程池
+func newWorkerPool(workers int, limit int) *workerPool {
+	p := &workerPool{
+		keys:  make(map[string]*keyQueue),
+		limit: limit,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+// submit 提交一个任务，等待执行的任务已达上限时返回 errPoolFull
+func (p *workerPool) submit(key string, task func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errPoolClosed
+	}
+	if p.queued >= p.limit {
+		return errPoolFull
+	}
+
+	q, ok := p.keys[key]
+	if !ok {
+		q = &keyQueue{}
+		p.keys[key] = q
+	}
+	q.tasks = append(q.tasks, task)
+	p.queued++
+	if !q.scheduled {
+		q.scheduled = true
+		p.ready = append(p.ready, key)
+		p.cond.Signal()
+	}
+	return nil
+}
+
+// run 协程主循环，关闭后处理完剩余的任务再退出
+func (p *workerPool) run() {
+	defer p.wg.Done()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for len(p.ready) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.ready) == 0 {
+			return
+		}
+
+		key := p.ready[0]
+		p.ready[0] = ""
+		p.ready = p.ready[1:]
+		q := p.keys[key]
+		task := q.tasks[0]
+		q.tasks[0] = nil
+		q.tasks = q.tasks[1:]
+		p.queued--
+
+		p.mu.Unlock()
+		p.execute(key, task)
+		p.mu.Lock()
+
+		if len(q.tasks) > 0 {
+			p.ready = append(p.ready, key)
+			p.cond.Signal()
+		} else {
+			delete(p.keys, key)
+		}
+	}
+}
+
+// execute 执行一个任务。任务 panic 时只记录日志，协程继续运行，同一个键后续的任务照常执行
+func (p *workerPool) execute(key string, task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Task for %s panicked: %v\n%s", key, r, debug.Stack())
+		}
+	}()
+	task()
+}
+
+// close 停止接受新任务，等待已提交的任务执行完成
+func (p *workerPool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// depth 返回等待执行的任务数
+func (p *workerPool) depth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queued
+}
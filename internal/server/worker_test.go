@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWorkerPoolOrdering(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		keys    int
+		tasks   int // 每个键提交的任务数
+	}{
+		{"single worker", 1, 3, 50},
+		{"one key many workers", 8, 1, 200},
+		{"many keys many workers", 4, 16, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newWorkerPool(tt.workers, tt.keys*tt.tasks)
+
+			var mu sync.Mutex
+			order := make(map[string][]int)
+			running := make(map[string]*int32)
+			for k := 0; k < tt.keys; k++ {
+				running[fmt.Sprintf("session:%d", k)] = new(int32)
+			}
+
+			for i := 0; i < tt.tasks; i++ {
+				for k := 0; k < tt.keys; k++ {
+					key, seq := fmt.Sprintf("session:%d", k), i
+					err := p.submit(key, func() {
+						if n := atomic.AddInt32(running[key], 1); n != 1 {
+							t.Errorf("%s: %d tasks running at once", key, n)
+						}
+						mu.Lock()
+						order[key] = append(order[key], seq)
+						mu.Unlock()
+						atomic.AddInt32(running[key], -1)
+					})
+					if err != nil {
+						t.Fatalf("submit failed: %v", err)
+					}
+				}
+			}
+			p.close()
+
+			for key, seqs := range order {
+				if len(seqs) != tt.tasks {
+					t.Errorf("%s: ran %d tasks, want %d", key, len(seqs), tt.tasks)
+				}
+				for i, seq := range seqs {
+					if seq != i {
+						t.Errorf("%s: task %d ran at position %d", key, seq, i)
+						break
+					}
+				}
+			}
+			if got := p.depth(); got != 0 {
+				t.Errorf("depth after close = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolFull(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+	}{
+		{"limit 1", 1},
+		{"limit 5", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newWorkerPool(1, tt.limit)
+
+			// 唯一的协程被阻塞，之后提交的任务都在等待
+			started, release := make(chan struct{}), make(chan struct{})
+			if err := p.submit("session:0", func() {
+				close(started)
+				<-release
+			}); err != nil {
+				t.Fatalf("submit failed: %v", err)
+			}
+			<-started
+
+			for i := 0; i < tt.limit; i++ {
+				if err := p.submit(fmt.Sprintf("session:%d", i), func() {}); err != nil {
+					t.Fatalf("submit %d failed: %v", i, err)
+				}
+			}
+			if err := p.submit("session:new", func() {}); !errors.Is(err, errPoolFull) {
+				t.Errorf("submit to full pool = %v, want %v", err, errPoolFull)
+			}
+			if got := p.depth(); got != tt.limit {
+				t.Errorf("depth = %d, want %d", got, tt.limit)
+			}
+
+			close(release)
+			p.close()
+			if err := p.submit("session:0", func() {}); !errors.Is(err, errPoolClosed) {
+				t.Errorf("submit to closed pool = %v, want %v", err, errPoolClosed)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{"single worker", 1},
+		{"many workers", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newWorkerPool(tt.workers, 10)
+
+			var mu sync.Mutex
+			var ran []string
+			record := func(name string) func() {
+				return func() {
+					mu.Lock()
+					ran = append(ran, name)
+					mu.Unlock()
+				}
+			}
+			tasks := []struct {
+				key  string
+				task func()
+			}{
+				{"session:1", func() { panic("handler failed") }},
+				{"session:1", record("session:1 after panic")},
+				{"session:2", record("session:2")},
+			}
+			for _, task := range tasks {
+				if err := p.submit(task.key, task.task); err != nil {
+					t.Fatalf("submit failed: %v", err)
+				}
+			}
+			p.close()
+
+			// 同一个键在 panic 之后的任务和其他键的任务都照常执行
+			sort.Strings(ran)
+			if want := []string{"session:1 after panic", "session:2"}; !reflect.DeepEqual(ran, want) {
+				t.Errorf("ran = %v, want %v", ran, want)
+			}
+			if got := p.depth(); got != 0 {
+				t.Errorf("depth after close = %d, want 0", got)
+			}
+		})
+	}
+}
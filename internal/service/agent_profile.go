@@ -0,0 +1,116 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/JennerWork/chatbot/internal/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAgentNotFound = errors.New("客服不存在")
+)
+
+// AgentProfileInput 更新客服档案的参数
+type AgentProfileInput struct {
+	Skills        []string
+	Languages     []string
+	MaxConcurrent int
+}
+
+// AgentProfileService 客服档案服务接口
+type AgentProfileService interface {
+	// ListProfiles 获取所有客服的档案，未设置档案的客服返回默认档案
+	ListProfiles() ([]model.AgentProfile, error)
+	// GetProfile 获取客服档案
+	GetProfile(agentID uint) (*model.AgentProfile, error)
+	// UpdateProfile 创建或更新客服档案
+	UpdateProfile(agentID uint, input AgentProfileInput) (*model.AgentProfile, error)
+}
+
+type agentProfileService struct {
+	db *gorm.DB
+}
+
+// NewAgentProfileService 创建客服档案服务实例
+func NewAgentProfileService(db *gorm.DB) AgentProfileService {
+	return &agentProfileService{
+		db: db,
+	}
+}
+
+// ListProfiles 获取所有客服的档案
+func (s *agentProfileService) ListProfiles() ([]model.AgentProfile, error) {
+	var agentIDs []uint
+	if err := s.db.Model(&model.Customer{}).
+		Where("role IN ?", []string{model.RoleAgent, model.RoleAdmin}).
+		Order("id asc").
+		Pluck("id", &agentIDs).Error; err != nil {
+		return nil, err
+	}
+
+	var profiles []model.AgentProfile
+	if err := s.db.Where("agent_id IN ?", agentIDs).Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	byAgent := make(map[uint]model.AgentProfile, len(profiles))
+	for _, profile := range profiles {
+		byAgent[profile.AgentID] = profile
+	}
+
+	result := make([]model.AgentProfile, len(agentIDs))
+	for i, id := range agentIDs {
+		if profile, ok := byAgent[id]; ok {
+			result[i] = profile
+		} else {
+			result[i] = model.AgentProfile{AgentID: id}
+		}
+	}
+	return result, nil
+}
+
+// GetProfile 获取客服档案
+func (s *agentProfileService) GetProfile(agentID uint) (*model.AgentProfile, error) {
+	if err := s.checkAgent(agentID); err != nil {
+		return nil, err
+	}
+
+	var profile model.AgentProfile
+	if err := s.db.Where("agent_id = ?", agentID).First(&profile).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &model.AgentProfile{AgentID: agentID}, nil
+		}
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// UpdateProfile 创建或更新客服档案
+func (s *agentProfileService) UpdateProfile(agentID uint, input AgentProfileInput) (*model.AgentProfile, error) {
+	profile, err := s.GetProfile(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.SetSkills(input.Skills)
+	profile.SetLanguages(input.Languages)
+	profile.MaxConcurrent = input.MaxConcurrent
+	if err := s.db.Save(profile).Error; err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// checkAgent 校验用户存在且具有客服或管理员角色
+func (s *agentProfileService) checkAgent(agentID uint) error {
+	var count int64
+	if err := s.db.Model(&model.Customer{}).
+		Where("id = ? AND role IN ?", agentID, []string{model.RoleAgent, model.RoleAdmin}).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrAgentNotFound
+	}
+	return nil
+}
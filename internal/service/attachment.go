@@ -0,0 +1,292 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/storage"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 附件消息类型
+const (
+	MessageTypeImage = "image" // 图片，引用已上传的附件
+	MessageTypeFile  = "file"  // 文件，引用已上传的附件
+)
+
+// AttachmentURLPrefix 附件下载地址前缀，后接附件ID
+const AttachmentURLPrefix = "/api/attachments/"
+
+// defaultMaxAttachmentSize 未配置时单个附件的最大字节数
+const defaultMaxAttachmentSize = 10 << 20
+
+// maxFileNameLength 保存的文件名最大长度
+const maxFileNameLength = 255
+
+var (
+	ErrAttachmentNotFound       = errors.New("附件不存在")
+	ErrAttachmentForbidden      = errors.New("无权访问该附件")
+	ErrAttachmentTooLarge       = errors.New("附件超过大小限制")
+	ErrAttachmentEmpty          = errors.New("附件为空")
+	ErrAttachmentTypeNotAllowed = errors.New("不支持的附件类型")
+	ErrAttachmentAlreadySent    = errors.New("附件已发送过")
+)
+
+// AttachmentMessage image/file 消息内容。客户端只需提供 AttachmentID 和可选的 Caption，
+// 其余字段由服务端根据附件记录填充
+type AttachmentMessage struct {
+	AttachmentID uint   `json:"attachment_id"`
+	Caption      string `json:"caption,omitempty"`
+	FileName     string `json:"file_name,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+// Validate 校验附件消息
+func (m *AttachmentMessage) Validate() error {
+	if m.AttachmentID == 0 {
+		return errors.New("attachment_id is required")
+	}
+	return checkLength("caption", m.Caption, maxTextLength, false)
+}
+
+// PlainText 文件名和下载地址，附带说明文字
+func (m *AttachmentMessage) PlainText() string {
+	text := fmt.Sprintf("[%s] %s", m.FileName, m.URL)
+	if m.Caption != "" {
+		text += "\n" + m.Caption
+	}
+	return text
+}
+
+// AttachmentService 附件服务接口
+type AttachmentService interface {
+	// Upload 保存客户上传的附件，类型根据文件内容识别
+	Upload(customerID uint, fileName string, r io.Reader) (*model.Attachment, error)
+	// Open 读取附件内容，只有上传者和接待该会话的客服可以读取
+	Open(userID uint, attachmentID uint) (*model.Attachment, io.ReadCloser, error)
+	// Prepare 校验客户消息引用的附件，并用附件信息填充消息内容
+	Prepare(customerID uint, msgType string, msg *AttachmentMessage) (*model.Attachment, error)
+	// MaxSize 单个附件的最大字节数
+	MaxSize() int64
+}
+
+type attachmentService struct {
+	db     *gorm.DB
+	store  storage.BlobStore
+	config config.AttachmentConfig
+}
+
+// NewAttachmentService 创建附件服务实例
+func NewAttachmentService(db *gorm.DB, store storage.BlobStore, cfg config.AttachmentConfig) AttachmentService {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultMaxAttachmentSize
+	}
+
+	return &attachmentService{
+		db:     db,
+		store:  store,
+		config: cfg,
+	}
+}
+
+// Upload 识别类型后写入对象存储并创建附件记录
+func (s *attachmentService) Upload(customerID uint, fileName string, r io.Reader) (*model.Attachment, error) {
+	// 读取文件头识别类型，不信任客户端提供的 Content-Type
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+	if n == 0 {
+		return nil, ErrAttachmentEmpty
+	}
+
+	contentType := detectContentType(head)
+	if !s.allowed(contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrAttachmentTypeNotAllowed, contentType)
+	}
+
+	// 多读一个字节用于判断是否超过大小限制
+	key := fmt.Sprintf("%s/%s", time.Now().Format("2006/01/02"), uuid.New().String())
+	body := io.MultiReader(bytes.NewReader(head), io.LimitReader(r, s.config.MaxSize-int64(n)+1))
+	size, err := s.store.Put(key, body)
+	if err != nil {
+		return nil, err
+	}
+	if size > s.config.MaxSize {
+		s.deleteBlob(key)
+		return nil, ErrAttachmentTooLarge
+	}
+
+	attachment := &model.Attachment{
+		CustomerID:  customerID,
+		FileName:    cleanFileName(fileName),
+		ContentType: contentType,
+		Size:        size,
+		StorageKey:  key,
+	}
+	if err := s.db.Create(attachment).Error; err != nil {
+		s.deleteBlob(key)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Open 校验权限后打开附件
+func (s *attachmentService) Open(userID uint, attachmentID uint) (*model.Attachment, io.ReadCloser, error) {
+	attachment, err := s.find(attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.authorize(userID, attachment); err != nil {
+		return nil, nil, err
+	}
+
+	rc, err := s.store.Open(attachment.StorageKey)
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, rc, nil
+}
+
+// Prepare 附件必须由该客户上传且尚未发送，图片消息只能引用图片
+func (s *attachmentService) Prepare(customerID uint, msgType string, msg *AttachmentMessage) (*model.Attachment, error) {
+	attachment, err := s.find(msg.AttachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.CustomerID != customerID {
+		return nil, ErrAttachmentForbidden
+	}
+	if attachment.MessageID != nil {
+		return nil, ErrAttachmentAlreadySent
+	}
+	if msgType == MessageTypeImage && !attachment.IsImage() {
+		return nil, fmt.Errorf("%w: %s is not an image", ErrAttachmentTypeNotAllowed, attachment.ContentType)
+	}
+
+	msg.FileName = attachment.FileName
+	msg.ContentType = attachment.ContentType
+	msg.Size = attachment.Size
+	msg.URL = fmt.Sprintf("%s%d", AttachmentURLPrefix, attachment.ID)
+	return attachment, nil
+}
+
+// MaxSize 单个附件的最大字节数
+func (s *attachmentService) MaxSize() int64 {
+	return s.config.MaxSize
+}
+
+// find 获取附件记录
+func (s *attachmentService) find(attachmentID uint) (*model.Attachment, error) {
+	var attachment model.Attachment
+	if err := s.db.First(&attachment, attachmentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// authorize 上传者可以读取；附件发送后，接待该会话的客服也可以读取
+func (s *attachmentService) authorize(userID uint, attachment *model.Attachment) error {
+	if attachment.CustomerID == userID {
+		return nil
+	}
+	if attachment.SessionID == nil {
+		return ErrAttachmentForbidden
+	}
+
+	var count int64
+	if err := s.db.Model(&model.Session{}).
+		Where("id = ? AND agent_id = ?", *attachment.SessionID, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrAttachmentForbidden
+	}
+	return nil
+}
+
+// allowed 检查类型是否在允许列表中，未配置时只允许图片
+func (s *attachmentService) allowed(contentType string) bool {
+	allowedTypes := s.config.AllowedTypes
+	if len(allowedTypes) == 0 {
+		allowedTypes = []string{"image/*"}
+	}
+
+	for _, allowed := range allowedTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == contentType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteBlob 删除写入失败的对象
+func (s *attachmentService) deleteBlob(key string) {
+	if err := s.store.Delete(key); err != nil {
+		log.Printf("Failed to delete blob %s: %v", key, err)
+	}
+}
+
+// linkAttachment 将附件关联到消息和会话，附件已被其他消息引用时返回 ErrAttachmentAlreadySent
+func linkAttachment(tx *gorm.DB, attachmentID uint, messageID uint, sessionID uint) error {
+	result := tx.Model(&model.Attachment{}).
+		Where("id = ? AND message_id IS NULL", attachmentID).
+		Updates(map[string]interface{}{
+			"message_id": messageID,
+			"session_id": sessionID,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAttachmentAlreadySent
+	}
+	return nil
+}
+
+// detectContentType 根据文件头识别MIME类型，去掉 charset 等参数
+func detectContentType(head []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// cleanFileName 去掉路径部分并限制长度
+func cleanFileName(name string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	if runes := []rune(name); len(runes) > maxFileNameLength {
+		name = string(runes[:maxFileNameLength])
+	}
+	return name
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/storage"
+)
+
+// pngHeader PNG 文件头
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"png", pngHeader, "image/png"},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "image/jpeg"},
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"text drops charset", []byte("hello world"), "text/plain"},
+		{"html disguised as image", []byte("<html><script>alert(1)</script>"), "text/html"},
+		{"binary", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectContentType(tt.head); got != tt.want {
+				t.Errorf("detectContentType() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachmentAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     []string
+		contentType string
+		want        bool
+	}{
+		{"images by default", nil, "image/png", true},
+		{"only images by default", nil, "application/pdf", false},
+		{"exact type", []string{"application/pdf"}, "application/pdf", true},
+		{"wildcard", []string{"image/*"}, "image/gif", true},
+		{"wildcard does not match other types", []string{"image/*"}, "text/plain", false},
+		{"case and spaces", []string{" Application/PDF "}, "application/pdf", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &attachmentService{config: config.AttachmentConfig{AllowedTypes: tt.allowed}}
+			if got := s.allowed(tt.contentType); got != tt.want {
+				t.Errorf("allowed(%s) = %v, want %v", tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "photo.png", "photo.png"},
+		{"unix path", "../../etc/passwd", "passwd"},
+		{"windows path", `C:\Users\me\photo.png`, "photo.png"},
+		{"empty", "  ", "file"},
+		{"dot", ".", "file"},
+		{"directory", "a/", "a"},
+		{"too long", strings.Repeat("好", maxFileNameLength+10), strings.Repeat("好", maxFileNameLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanFileName(tt.in); got != tt.want {
+				t.Errorf("cleanFileName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrAttachmentEmpty},
+		{"type not allowed", []byte("%PDF-1.7\n"), ErrAttachmentTypeNotAllowed},
+		{"over size limit", append(append([]byte{}, pngHeader...), make([]byte, 1024)...), ErrAttachmentTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			store, err := storage.NewFSBlobStore(root)
+			if err != nil {
+				t.Fatalf("NewFSBlobStore failed: %v", err)
+			}
+			// 被拒绝的附件不写入数据库
+			s := NewAttachmentService(nil, store, config.AttachmentConfig{MaxSize: 1024})
+			if _, err := s.Upload(1, "a.png", bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Upload() = %v, want %v", err, tt.wantErr)
+			}
+
+			// 超过大小限制时已写入的对象被删除
+			var files []string
+			filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if len(files) > 0 {
+				t.Errorf("blobs left after rejected upload: %v", files)
+			}
+		})
+	}
+}
@@ -38,6 +38,7 @@ type Claims struct {
 	jwt.RegisteredClaims
 	CustomerID uint   `json:"customer_id"`
 	Email      string `json:"email"`
+	Role       string `json:"role"`
 }
 
 type authService struct {
@@ -123,6 +124,7 @@ func (s *authService) generateToken(customer model.Customer) (string, error) {
 		},
 		CustomerID: customer.ID,
 		Email:      customer.Email,
+		Role:       customer.Role,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
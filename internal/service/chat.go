@@ -1,9 +1,15 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/flow"
+	"github.com/JennerWork/chatbot/internal/intent"
+	"github.com/JennerWork/chatbot/internal/knowledge"
 	"github.com/JennerWork/chatbot/internal/model"
 	"gorm.io/gorm"
 )
@@ -13,150 +19,407 @@ type TextMessage struct {
 	Text string `json:"text"`
 }
 
+// Reply is the bot's answer to a message. Text is always the plain-text rendering;
+// Rich carries the structured message of Type when the answer offers choices or cards.
+type Reply struct {
+	Text string
+	Type string
+	Rich RichMessage
+}
+
+// textReply creates a plain text reply
+func textReply(text string) *Reply {
+	return &Reply{Text: text, Type: MessageTypeText}
+}
+
+// richReply creates a structured reply
+func richReply(msgType string, msg RichMessage) *Reply {
+	return &Reply{Text: msg.PlainText(), Type: msgType, Rich: msg}
+}
+
 // ChatService handles the business logic related to chat
 type ChatService interface {
-	// ProcessText processes a text message and returns a reply
-	ProcessText(customerID uint, sessionID uint, text string) (string, error)
+	// ProcessText processes a text message and returns a reply; ctx bounds the reply generation
+	ProcessText(ctx context.Context, customerID uint, sessionID uint, text string) (*Reply, error)
+	// ProcessTextStream processes a text message, reporting text replies incrementally through onDelta,
+	// and returns the complete reply. Structured replies are not streamed.
+	ProcessTextStream(ctx context.Context, customerID uint, sessionID uint, text string, onDelta func(delta string) error) (*Reply, error)
+}
+
+// recentMessageLimit is the number of recent messages passed to the reply engine as context
+const recentMessageLimit = 20
+
+// intentActionFlowPrefix prefixes intent actions that start a flow, e.g. "flow:feedback"
+const intentActionFlowPrefix = "flow:"
+
+// intentActionHandoff is the intent action that transfers the session to a human agent;
+// "handoff:<skill>" additionally routes it to agents with that skill
+const intentActionHandoff = "handoff"
+
+// flowActionSaveFeedback is the flow action that stores the collected feedback
+const flowActionSaveFeedback = "save_feedback"
+
+// ChatOptions holds the optional collaborators of the chat service
+type ChatOptions struct {
+	ReplyEngine   ReplyEngine      // defaults to the echo engine
+	Intents       *intent.Engine   // defaults to the built-in intent definition
+	Knowledge     KnowledgeService // FAQ answers are skipped when nil
+	Flows         *flow.Engine     // defaults to the built-in flows
+	Handoff       HandoffService   // human handoff is disabled when nil
+	HandoffConfig config.HandoffConfig
+	Privacy       PrivacyService // flow slots and feedback comments are stored as is when nil
 }
 
 type chatService struct {
-	db *gorm.DB
+	db         *gorm.DB
+	engine     ReplyEngine
+	intents    *intent.Engine
+	knowledge  KnowledgeService
+	flows      *flowRunner
+	handoff    HandoffService
+	handoffCfg config.HandoffConfig
+	privacy    PrivacyService
+	sentiment  *SentimentAnalysisService
 }
 
 // NewChatService creates an instance of the chat service
-func NewChatService(db *gorm.DB) ChatService {
-	return &chatService{
-		db: db,
+func NewChatService(db *gorm.DB, opts ChatOptions) (ChatService, error) {
+	if opts.ReplyEngine == nil {
+		opts.ReplyEngine = NewEchoReplyEngine()
 	}
+	if opts.Intents == nil {
+		intents, err := intent.NewEngine(intent.DefaultDefinition())
+		if err != nil {
+			return nil, err
+		}
+		opts.Intents = intents
+	}
+	if opts.Flows == nil {
+		flows, err := flow.NewEngine()
+		if err != nil {
+			return nil, err
+		}
+		opts.Flows = flows
+	}
+
+	s := &chatService{
+		db:         db,
+		engine:     opts.ReplyEngine,
+		intents:    opts.Intents,
+		knowledge:  opts.Knowledge,
+		flows:      newFlowRunner(db, opts.Flows, opts.Privacy),
+		handoff:    opts.Handoff,
+		handoffCfg: opts.HandoffConfig,
+		privacy:    opts.Privacy,
+		sentiment:  NewSentimentAnalysisService(),
+	}
+	s.flows.registerAction(flowActionSaveFeedback, s.saveFeedback)
+	return s, nil
 }
 
 // ProcessText processes a text message
-func (s *chatService) ProcessText(customerID uint, sessionID uint, text string) (string, error) {
+func (s *chatService) ProcessText(ctx context.Context, customerID uint, sessionID uint, text string) (*Reply, error) {
+	return s.processText(ctx, customerID, sessionID, text, nil)
+}
+
+// ProcessTextStream processes a text message and streams the reply.
+// Text replies that are not produced by a streaming engine are delivered as a single delta.
+func (s *chatService) ProcessTextStream(ctx context.Context, customerID uint, sessionID uint, text string, onDelta func(delta string) error) (*Reply, error) {
+	streamed := false
+	reply, err := s.processText(ctx, customerID, sessionID, text, func(delta string) error {
+		streamed = true
+		return onDelta(delta)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !streamed && reply.Rich == nil && reply.Text != "" {
+		if err := onDelta(reply.Text); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// processText runs the chat pipeline; onDelta is only used when the reply engine supports streaming
+func (s *chatService) processText(ctx context.Context, customerID uint, sessionID uint, text string, onDelta func(delta string) error) (*Reply, error) {
 	// 1. Retrieve the context of the current session
 	var session model.Session
-	if err := s.db.First(&session, sessionID).Error; err != nil {
-		return "", fmt.Errorf("failed to retrieve session: %v", err)
+	if err := s.db.WithContext(ctx).First(&session, sessionID).Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve session: %v", err)
 	}
 
-	// 2. Check for any pending feedback
-	var feedback model.Feedback
-	result := s.db.Where("customer_id = ? AND status != ?",
-		customerID, model.FeedbackStatusCompleted).
-		Last(&feedback)
-	hasPendingFeedback := result.Error == nil
+	// 2. Continue the running flow, if any
+	state, err := s.flows.active(ctx, customerID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		return s.flows.handle(ctx, state, text)
+	}
 
-	if hasPendingFeedback {
-		// Process replies during the feedback process
-		return s.handleFeedbackResponse(text, &feedback)
+	// 3. Recognize the intent of the message
+	match := s.intents.Recognize(text)
+	if reason, skill := s.handoffReason(match, text); reason != "" {
+		if err := s.resetFallbacks(ctx, &session); err != nil {
+			return nil, err
+		}
+		return s.requestHandoff(ctx, HandoffRequest{
+			CustomerID: customerID,
+			SessionID:  sessionID,
+			Reason:     reason,
+			Skill:      skill,
+			Language:   knowledge.DetectLanguage(text),
+		})
+	}
+	if !match.Fallback {
+		if err := s.resetFallbacks(ctx, &session); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case strings.HasPrefix(match.Intent.Action, intentActionFlowPrefix):
+		return s.flows.start(ctx, customerID, sessionID, strings.TrimPrefix(match.Intent.Action, intentActionFlowPrefix))
+	case match.HasResponse() || match.Intent.HasRichContent():
+		return intentReply(match)
 	}
 
-	// 3. Check if the message is a feedback trigger
-	if s.isFeedbackTrigger(text) {
-		return s.initiateFeedback(customerID, sessionID)
+	// 4. Answer from the knowledge base when an article matches well enough
+	if s.knowledge != nil {
+		answer, err := s.knowledge.Answer(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search knowledge base: %v", err)
+		}
+		if answer != nil {
+			if err := s.resetFallbacks(ctx, &session); err != nil {
+				return nil, err
+			}
+			return formatKnowledgeAnswer(answer, knowledge.DetectLanguage(text)), nil
+		}
+	}
+
+	// 5. Hand the session over to a human agent once the bot has failed too many times in a row
+	if s.handoff != nil && s.handoffCfg.FallbackThreshold > 0 {
+		if err := s.recordFallback(ctx, &session); err != nil {
+			return nil, err
+		}
+		if session.BotFallbacks >= s.handoffCfg.FallbackThreshold {
+			if err := s.resetFallbacks(ctx, &session); err != nil {
+				return nil, err
+			}
+			return s.requestHandoff(ctx, HandoffRequest{
+				CustomerID: customerID,
+				SessionID:  sessionID,
+				Reason:     HandoffReasonBotFallback,
+				Language:   knowledge.DetectLanguage(text),
+			})
+		}
 	}
 
-	// 4. Retrieve recent session messages for context understanding
-	var recentMessages []model.Message
-	if err := s.db.Where("session_id = ?", sessionID).
-		Order("created_at desc").
-		Find(&recentMessages).Error; err != nil {
-		return "", fmt.Errorf("failed to retrieve historical messages: %v", err)
+	// 6. Retrieve recent session messages for context understanding
+	recentMessages, err := s.recentMessages(ctx, sessionID)
+	if err != nil {
+		return nil, err
 	}
 
-	// 5. Generate a basic reply
-	return s.generateBasicReply(text, recentMessages), nil
+	// 7. Generate a reply
+	reply, err := s.generateReply(ctx, customerID, sessionID, text, recentMessages, onDelta)
+	if err != nil {
+		return nil, err
+	}
+	return textReply(reply), nil
 }
 
-// isFeedbackTrigger checks if the message is a feedback trigger
-func (s *chatService) isFeedbackTrigger(text string) bool {
-	triggers := []string{"feedback", "review", "评价", "反馈", "评论"}
-	text = strings.ToLower(text)
-	for _, trigger := range triggers {
-		if strings.Contains(text, trigger) {
-			return true
+// requestHandoff hands the session over to a human agent
+func (s *chatService) requestHandoff(ctx context.Context, req HandoffRequest) (*Reply, error) {
+	reply, err := s.handoff.RequestHandoff(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return textReply(reply), nil
+}
+
+// intentReply renders the response of a matched intent, preferring cards, then buttons, then quick replies
+func intentReply(match *intent.Match) (*Reply, error) {
+	text, err := match.Reply()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render reply of intent %s: %v", match.Intent.Name, err)
+	}
+
+	var reply *Reply
+	in := match.Intent
+	switch {
+	case len(in.Cards) == 1:
+		card := toCardMessage(in.Cards[0])
+		reply = richReply(MessageTypeCard, &card)
+	case len(in.Cards) > 1:
+		carousel := &CarouselMessage{}
+		for _, c := range in.Cards {
+			carousel.Cards = append(carousel.Cards, toCardMessage(c))
 		}
+		reply = richReply(MessageTypeCarousel, carousel)
+	case len(in.Buttons) > 0:
+		reply = richReply(MessageTypeButtonPostback, &ButtonPostbackMessage{Text: text, Buttons: toButtons(in.Buttons)})
+	case len(in.QuickReplies) > 0:
+		reply = richReply(MessageTypeQuickReplies, &QuickRepliesMessage{Text: text, Replies: choiceButtons(in.QuickReplies)})
+	default:
+		return textReply(text), nil
+	}
+
+	if err := reply.Rich.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rich reply of intent %s: %v", in.Name, err)
 	}
-	return false
+	return reply, nil
 }
 
-// initiateFeedback initializes the feedback process
-func (s *chatService) initiateFeedback(customerID uint, sessionID uint) (string, error) {
-	// Create a new feedback record
-	feedback := model.Feedback{
-		CustomerID: customerID,
-		SessionID:  sessionID,
-		Status:     model.FeedbackStatusInitiated,
+// toCardMessage converts an intent card to a card message
+func toCardMessage(c intent.Card) CardMessage {
+	return CardMessage{
+		Title:    c.Title,
+		Subtitle: c.Subtitle,
+		ImageURL: c.ImageURL,
+		Buttons:  toButtons(c.Buttons),
 	}
+}
 
-	if err := s.db.Create(&feedback).Error; err != nil {
-		return "", fmt.Errorf("failed to create feedback record: %v", err)
+// toButtons converts intent buttons to message buttons
+func toButtons(buttons []intent.Button) []Button {
+	result := make([]Button, len(buttons))
+	for i, b := range buttons {
+		result[i] = Button{Title: b.Title, Payload: b.Payload, URL: b.URL}
 	}
+	return result
+}
 
-	return "Please rate this service on a scale of 1-5, with 5 being very satisfied and 1 being very dissatisfied.", nil
+// handoffReason returns why the message should be handed over to a human agent, or "" to keep the bot in charge.
+// The skill required from the agent comes from intent actions of the form "handoff:<skill>".
+func (s *chatService) handoffReason(match *intent.Match, text string) (reason string, skill string) {
+	if s.handoff == nil {
+		return "", ""
+	}
+	action := match.Intent.Action
+	if action == intentActionHandoff || strings.HasPrefix(action, intentActionHandoff+":") {
+		return HandoffReasonRequested, strings.TrimPrefix(strings.TrimPrefix(action, intentActionHandoff), ":")
+	}
+	if s.handoffCfg.NegativeSentiment && s.sentiment.AnalyzeText(text) == SentimentNegative {
+		return HandoffReasonNegativeSentiment, ""
+	}
+	return "", ""
 }
 
-// handleFeedbackResponse processes replies during the feedback process
-func (s *chatService) handleFeedbackResponse(text string, feedback *model.Feedback) (string, error) {
-	switch feedback.Status {
-	case model.FeedbackStatusInitiated:
-		// Process rating
-		rating, err := s.parseRating(text)
-		if err != nil {
-			return "Please enter a number between 1 and 5 to rate.", nil
-		}
+// recordFallback increments the consecutive fallback count stored on the session
+func (s *chatService) recordFallback(ctx context.Context, session *model.Session) error {
+	err := s.db.WithContext(ctx).Raw("UPDATE sessions SET bot_fallbacks = bot_fallbacks + 1 WHERE id = ? RETURNING bot_fallbacks", session.ID).
+		Scan(&session.BotFallbacks).Error
+	if err != nil {
+		return fmt.Errorf("failed to record fallback: %v", err)
+	}
+	return nil
+}
 
-		feedback.Rating = rating
-		feedback.Status = model.FeedbackStatusRatingProvided
-		if err := s.db.Save(feedback).Error; err != nil {
-			return "", fmt.Errorf("failed to save rating: %v", err)
-		}
+// resetFallbacks clears the consecutive fallback count stored on the session
+func (s *chatService) resetFallbacks(ctx context.Context, session *model.Session) error {
+	if session.BotFallbacks == 0 {
+		return nil
+	}
+	err := s.db.WithContext(ctx).Model(&model.Session{}).Where("id = ?", session.ID).
+		UpdateColumn("bot_fallbacks", 0).Error
+	if err != nil {
+		return fmt.Errorf("failed to reset fallbacks: %v", err)
+	}
+	session.BotFallbacks = 0
+	return nil
+}
 
-		return "Thank you for your rating! Do you have any suggestions or comments about our service?", nil
-
-	case model.FeedbackStatusRatingProvided:
-		// Process comments
-		sentimentService := NewSentimentAnalysisService()
-		sentiment := sentimentService.AnalyzeSentiment(text, int(feedback.Rating))
-		feedback.Comment = text
-		feedback.Sentiment = sentiment
-		feedback.Status = model.FeedbackStatusCompleted
-		if err := s.db.Save(feedback).Error; err != nil {
-			return "", fmt.Errorf("failed to save comment: %v", err)
-		}
+// formatKnowledgeAnswer renders a knowledge base answer, offering close matches as "did you mean" quick replies
+func formatKnowledgeAnswer(answer *KnowledgeAnswer, language string) *Reply {
+	if len(answer.Suggestions) == 0 {
+		return textReply(answer.Article.Body)
+	}
+
+	heading := "Did you mean:"
+	if language == "zh" {
+		heading = "您是不是想问："
+	}
 
-		return "Thank you very much for your feedback! We will continue to strive to provide better service.", nil
+	titles := make([]string, len(answer.Suggestions))
+	for i, suggestion := range answer.Suggestions {
+		titles[i] = suggestion.Title
+	}
+	return richReply(MessageTypeQuickReplies, &QuickRepliesMessage{
+		Text:    answer.Article.Body + "\n\n" + heading,
+		Replies: choiceButtons(titles),
+	})
+}
 
-	default:
-		return s.generateBasicReply(text, nil), nil
-	}
-}
-
-// parseRating parses the rating
-func (s *chatService) parseRating(text string) (model.FeedbackRating, error) {
-	text = strings.TrimSpace(text)
-	switch text {
-	case "1":
-		return model.FeedbackRating1, nil
-	case "2":
-		return model.FeedbackRating2, nil
-	case "3":
-		return model.FeedbackRating3, nil
-	case "4":
-		return model.FeedbackRating4, nil
-	case "5":
-		return model.FeedbackRating5, nil
-	default:
-		return 0, fmt.Errorf("invalid rating")
+// recentMessages retrieves the recent messages of a session in chronological order,
+// excluding the customer message currently being processed
+func (s *chatService) recentMessages(ctx context.Context, sessionID uint) ([]model.Message, error) {
+	var messages []model.Message
+	if err := s.db.WithContext(ctx).Where("session_id = ?", sessionID).
+		Order("seq desc").
+		Limit(recentMessageLimit + 1).
+		Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to retrieve historical messages: %v", err)
+	}
+
+	// The message being processed has already been stored, so drop it from the context
+	if len(messages) > 0 && messages[0].Sender == model.SenderCustomer {
+		messages = messages[1:]
+	}
+	if len(messages) > recentMessageLimit {
+		messages = messages[:recentMessageLimit]
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
 	}
+
+	return messages, nil
 }
 
-// generateBasicReply generates a basic reply
-func (s *chatService) generateBasicReply(text string, context []model.Message) string {
-	// TODO: Implement more complex reply generation logic
-	// 1. Integrate with AI services like OpenAI
-	// 2. Implement keyword matching
-	// 3. Implement intent recognition
-	// 4. Implement multi-turn conversation management
+// saveFeedback stores the rating and comment collected by the feedback flow
+func (s *chatService) saveFeedback(ctx context.Context, customerID uint, sessionID uint, slots map[string]string) (string, error) {
+	rating, err := strconv.Atoi(slots["rating"])
+	if err != nil || rating < int(model.FeedbackRating1) || rating > int(model.FeedbackRating5) {
+		return "", fmt.Errorf("invalid rating: %q", slots["rating"])
+	}
 
-	return fmt.Sprintf("I have received your message: %s\nIf you are satisfied with the service, you can enter 'feedback' to provide a review.", text)
+	comment := slots["comment"]
+	sentimentService := NewSentimentAnalysisService()
+	sentiment := sentimentService.AnalyzeSentiment(comment, rating)
+	// The comment is free text and may contain personal data
+	if s.privacy != nil {
+		comment, _ = s.privacy.Redact(comment)
+	}
+	feedback := model.Feedback{
+		CustomerID: customerID,
+		SessionID:  sessionID,
+		Rating:     model.FeedbackRating(rating),
+		Comment:    comment,
+		Sentiment:  sentiment,
+		Status:     model.FeedbackStatusCompleted,
+	}
+	if err := s.db.WithContext(ctx).Create(&feedback).Error; err != nil {
+		return "", fmt.Errorf("failed to save feedback: %v", err)
+	}
+
+	return "", nil
+}
+
+// generateReply delegates reply generation to the configured reply engine,
+// streaming the reply when onDelta is set and the engine supports it
+func (s *chatService) generateReply(ctx context.Context, customerID uint, sessionID uint, text string, history []model.Message, onDelta func(delta string) error) (string, error) {
+	var reply string
+	var err error
+	if streamer, ok := s.engine.(StreamingReplyEngine); ok && onDelta != nil {
+		reply, err = streamer.StreamReply(ctx, customerID, sessionID, text, history, onDelta)
+	} else {
+		reply, err = s.engine.GenerateReply(ctx, customerID, sessionID, text, history)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate reply: %w", err)
+	}
+	return reply, nil
 }
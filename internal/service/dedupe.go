@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/model"
+	"gorm.io/gorm"
+)
+
+// MessageTypeAck 推送给客户：消息已保存，携带服务端消息ID和序号
+const MessageTypeAck = "ack"
+
+// maxClientMsgIDLength 客户端消息ID的最大长度
+const maxClientMsgIDLength = 64
+
+// defaultDedupeWindow 未配置时的去重窗口
+const defaultDedupeWindow = 24 * time.Hour
+
+var (
+	ErrDuplicateMessage = errors.New("重复的消息")
+)
+
+// Ack ack 帧的内容
+type Ack struct {
+	ClientMsgID string `json:"client_msg_id"`
+	MessageID   uint   `json:"message_id"`
+	SessionID   uint   `json:"session_id"`
+	Seq         uint   `json:"seq"`
+	Duplicate   bool   `json:"duplicate,omitempty"` // 消息在去重窗口内已保存并回复过，本次未处理
+}
+
+// newAck 根据已保存的消息构造 ack
+func newAck(clientMsgID string, msg *model.Message, duplicate bool) Ack {
+	return Ack{
+		ClientMsgID: clientMsgID,
+		MessageID:   msg.ID,
+		SessionID:   msg.SessionID,
+		Seq:         msg.Seq,
+		Duplicate:   duplicate,
+	}
+}
+
+// validateClientMsgID 校验客户端消息ID
+func validateClientMsgID(id string) error {
+	if len(id) > maxClientMsgIDLength {
+		return fmt.Errorf("%w: client_msg_id exceeds %d characters", ErrInvalidMessage, maxClientMsgIDLength)
+	}
+	return nil
+}
+
+// clientMessageRecord 去重窗口内以同一客户端消息ID保存过的消息
+type clientMessageRecord struct {
+	message *model.Message
+	replied bool // 已回复或已转发给客服
+}
+
+// findClientMessage 查找客户在去重窗口内以该ID发送过的消息，没有时返回 nil
+func findClientMessage(db *gorm.DB, customerID uint, clientMsgID string, window time.Duration) (*clientMessageRecord, error) {
+	var record model.ClientMessage
+	err := db.Where("customer_id = ? AND client_msg_id = ? AND created_at > ?",
+		customerID, clientMsgID, time.Now().Add(-window)).
+		First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg model.Message
+	if err := db.First(&msg, record.MessageID).Error; err != nil {
+		return nil, err
+	}
+	return &clientMessageRecord{message: &msg, replied: record.RepliedAt != nil}, nil
+}
+
+// recordClientMessage 在保存消息的事务中记录客户端消息ID，ID在去重窗口内已被记录（并发重发）时返回 ErrDuplicateMessage。
+// 超出去重窗口但尚未清理的记录改为指向新消息
+func recordClientMessage(tx *gorm.DB, customerID uint, clientMsgID string, messageID uint, window time.Duration) error {
+	result := tx.Exec(`INSERT INTO client_messages (customer_id, client_msg_id, message_id, created_at)
+		VALUES (?, ?, ?, NOW())
+		ON CONFLICT (customer_id, client_msg_id) DO UPDATE
+		SET message_id = EXCLUDED.message_id, created_at = EXCLUDED.created_at, replied_at = NULL
+		WHERE client_messages.created_at <= ?`,
+		customerID, clientMsgID, messageID, time.Now().Add(-window))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDuplicateMessage
+	}
+	return nil
+}
+
+// markClientMessageReplied 记录客户端消息已回复，之后在去重窗口内重发只确认不处理。clientMsgID 为空时跳过
+func markClientMessageReplied(db *gorm.DB, customerID uint, clientMsgID string) error {
+	if clientMsgID == "" {
+		return nil
+	}
+	return db.Model(&model.ClientMessage{}).
+		Where("customer_id = ? AND client_msg_id = ?", customerID, clientMsgID).
+		Update("replied_at", time.Now()).Error
+}
+
+// clientMessagePurgeInterval 删除过期客户端消息ID的间隔
+const clientMessagePurgeInterval = time.Hour
+
+// ClientMessagePurger 后台定期删除超出去重窗口的客户端消息ID记录
+type ClientMessagePurger struct {
+	db       *gorm.DB
+	window   time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewClientMessagePurger 创建客户端消息ID清理器
+func NewClientMessagePurger(db *gorm.DB, window time.Duration) *ClientMessagePurger {
+	if window <= 0 {
+		window = defaultDedupeWindow
+	}
+	return &ClientMessagePurger{
+		db:     db,
+		window: window,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start 启动定期清理
+func (p *ClientMessagePurger) Start() {
+	go func() {
+		ticker := time.NewTicker(clientMessagePurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if purged, err := p.Purge(); err != nil {
+					log.Printf("Failed to purge client message IDs: %v", err)
+				} else if purged > 0 {
+					log.Printf("Purged %d expired client message IDs", purged)
+				}
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止定期清理
+func (p *ClientMessagePurger) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Purge 删除超出去重窗口的客户端消息ID记录，之后同一ID可以再次使用
+func (p *ClientMessagePurger) Purge() (int64, error) {
+	result := p.db.Where("created_at < ?", time.Now().Add(-p.window)).Delete(&model.ClientMessage{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDSNEnv 测试使用的 PostgreSQL 连接串，未设置时跳过需要数据库的测试
+const testDSNEnv = "CHATBOT_TEST_DSN"
+
+// openTestTx 连接测试数据库并开启事务，测试结束后回滚，不留下数据
+func openTestTx(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testDSNEnv)
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Failed to connect database: %v", err)
+	}
+	tx := conn.Begin()
+	if tx.Error != nil {
+		t.Fatalf("Failed to begin transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+// createTestMessages 为新建的测试客户保存 n 条消息
+func createTestMessages(t *testing.T, tx *gorm.DB, n int) (uint, []uint) {
+	t.Helper()
+	customer := &model.Customer{
+		Email: "dedupe-" + uuid.New().String() + "@example.invalid",
+		Name:  "dedupe",
+		Salt:  "dedupe",
+	}
+	if err := customer.SetPassword(uuid.New().String()); err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if err := tx.Create(customer).Error; err != nil {
+		t.Fatalf("Failed to create customer: %v", err)
+	}
+	session := &model.Session{
+		CustomerID:   customer.ID,
+		Status:       string(model.SessionStatusActive),
+		LastActiveAt: time.Now(),
+	}
+	if err := tx.Create(session).Error; err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	ids := make([]uint, 0, n)
+	for i := 1; i <= n; i++ {
+		msg := &model.Message{
+			CustomerID: customer.ID,
+			SessionID:  session.ID,
+			Seq:        uint(i),
+			Type:       "text",
+			Content:    `{"text":"dedupe"}`,
+			Sender:     model.SenderCustomer,
+		}
+		if err := tx.Create(msg).Error; err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	return customer.ID, ids
+}
+
+func TestRecordClientMessage(t *testing.T) {
+	tx := openTestTx(t)
+	customerID, messageIDs := createTestMessages(t, tx, 2)
+	first, second := messageIDs[0], messageIDs[1]
+	const window = time.Hour
+
+	tests := []struct {
+		name          string
+		clientMsgID   string
+		messageID     uint
+		expire        bool // 调用前将已有记录移出去重窗口并标记为已回复
+		wantErr       error
+		wantMessageID uint
+	}{
+		{"first use", "a", first, false, nil, first},
+		{"resent within window", "a", second, false, ErrDuplicateMessage, first},
+		{"another ID", "b", second, false, nil, second},
+		{"reused after window", "a", second, true, nil, second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.expire {
+				if err := tx.Model(&model.ClientMessage{}).
+					Where("customer_id = ? AND client_msg_id = ?", customerID, tt.clientMsgID).
+					Updates(map[string]interface{}{"created_at": time.Now().Add(-2 * window), "replied_at": time.Now()}).
+					Error; err != nil {
+					t.Fatalf("Failed to expire record: %v", err)
+				}
+			}
+
+			err := recordClientMessage(tx, customerID, tt.clientMsgID, tt.messageID, window)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("recordClientMessage() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var record model.ClientMessage
+			if err := tx.Where("customer_id = ? AND client_msg_id = ?", customerID, tt.clientMsgID).
+				First(&record).Error; err != nil {
+				t.Fatalf("Failed to load record: %v", err)
+			}
+			if record.MessageID != tt.wantMessageID {
+				t.Errorf("message_id = %d, want %d", record.MessageID, tt.wantMessageID)
+			}
+			// 指向新消息的记录需要重新回复
+			if tt.expire && record.RepliedAt != nil {
+				t.Errorf("replied_at = %v after reuse, want NULL", record.RepliedAt)
+			}
+		})
+	}
+}
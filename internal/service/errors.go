@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// MessageTypeError 推送给客户端：消息处理失败
+const MessageTypeError = "error"
+
+// ErrorCode 错误帧中的错误码，客户端据此判断如何处理，已发布的错误码不能修改
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidMessage       ErrorCode = "invalid_message"        // 消息格式或内容无效，不应原样重发
+	ErrorCodeUnsupportedType      ErrorCode = "unsupported_type"       // 不支持的消息类型
+	ErrorCodeSessionNotFound      ErrorCode = "session_not_found"      // 引用的会话不存在或不属于该客户
+	ErrorCodeSessionNotActive     ErrorCode = "session_not_active"     // 会话已转人工或已结束，不能再转人工
+	ErrorCodeSessionNotWaiting    ErrorCode = "session_not_waiting"    // 会话不在等待人工状态
+	ErrorCodeSessionNotAssigned   ErrorCode = "session_not_assigned"   // 会话未由该客服接待
+	ErrorCodeAttachmentNotFound   ErrorCode = "attachment_not_found"   // 附件不存在
+	ErrorCodeAttachmentForbidden  ErrorCode = "attachment_forbidden"   // 无权使用该附件
+	ErrorCodeAttachmentNotAllowed ErrorCode = "attachment_not_allowed" // 附件类型不允许
+	ErrorCodeAttachmentSent       ErrorCode = "attachment_sent"        // 附件已被其他消息引用
+	ErrorCodeMessageBlocked       ErrorCode = "message_blocked"        // 消息未通过内容审核，不应原样重发
+	ErrorCodeServerRestarting     ErrorCode = "server_restarting"      // 服务器正在重启，消息未处理，重连后重发
+	ErrorCodeServerBusy           ErrorCode = "server_busy"            // 待处理的消息过多，消息未处理，稍后重发
+	ErrorCodeTimeout              ErrorCode = "timeout"                // 处理超时，客户消息可能已保存，不应原样重发
+	ErrorCodeInternal             ErrorCode = "internal_error"         // 服务端错误，可以稍后重试
+)
+
+var (
+	ErrServerRestarting = errors.New("服务器正在重启")
+	ErrServerBusy       = errors.New("服务器繁忙")
+)
+
+// errorCatalog 错误与错误码的对应关系，按顺序匹配，未匹配的错误视为服务端错误；
+// hideDetail 的错误由服务端原因引起，不返回具体原因
+var errorCatalog = []struct {
+	err        error
+	code       ErrorCode
+	message    string
+	hideDetail bool
+}{
+	{ErrInvalidMessage, ErrorCodeInvalidMessage, "The message is malformed or its content is invalid.", false},
+	{ErrUnsupportedMessageType, ErrorCodeUnsupportedType, "The message type is not supported.", false},
+	{ErrSyncSessionNotFound, ErrorCodeSessionNotFound, "The session does not exist.", false},
+	{ErrReadSessionNotFound, ErrorCodeSessionNotFound, "The session does not exist.", false},
+	{ErrSessionNotActive, ErrorCodeSessionNotActive, "The session has already been handed over to an agent or has ended.", false},
+	{ErrSessionNotWaiting, ErrorCodeSessionNotWaiting, "The session is not waiting for an agent.", false},
+	{ErrSessionNotAssigned, ErrorCodeSessionNotAssigned, "The session is not assigned to you.", false},
+	{ErrAttachmentNotFound, ErrorCodeAttachmentNotFound, "The attachment does not exist.", false},
+	{ErrAttachmentForbidden, ErrorCodeAttachmentForbidden, "You are not allowed to use this attachment.", false},
+	{ErrAttachmentTypeNotAllowed, ErrorCodeAttachmentNotAllowed, "The attachment type is not allowed.", false},
+	{ErrAttachmentAlreadySent, ErrorCodeAttachmentSent, "The attachment has already been sent.", false},
+	{ErrMessageBlocked, ErrorCodeMessageBlocked, "The message was blocked by content moderation.", false},
+	{ErrServerRestarting, ErrorCodeServerRestarting, "The server is restarting, please reconnect and send the message again.", false},
+	{ErrServerBusy, ErrorCodeServerBusy, "The server is busy, please send the message again later.", false},
+	{context.DeadlineExceeded, ErrorCodeTimeout, "The message took too long to process.", true},
+}
+
+// internalErrorMessage 服务端错误的提示，不向客户端暴露内部错误信息
+const internalErrorMessage = "Something went wrong on our side, please try again later."
+
+// ErrorMessage 错误帧的内容。Detail 为具体原因，服务端错误不返回；
+// ClientMsgID 为出错消息的 client_msg_id，消息没有携带时为空
+type ErrorMessage struct {
+	Code        ErrorCode `json:"code"`
+	Message     string    `json:"message"`
+	Detail      string    `json:"detail,omitempty"`
+	ClientMsgID string    `json:"client_msg_id,omitempty"`
+}
+
+// NewErrorMessage 根据处理消息时返回的错误构造错误帧内容，message 为出错的原始消息
+func NewErrorMessage(err error, message []byte) ErrorMessage {
+	var request struct {
+		ClientMsgID string `json:"client_msg_id"`
+	}
+	// 消息不是合法JSON时无法取得 client_msg_id
+	_ = json.Unmarshal(message, &request)
+
+	for _, entry := range errorCatalog {
+		if errors.Is(err, entry.err) {
+			msg := ErrorMessage{
+				Code:        entry.code,
+				Message:     entry.message,
+				ClientMsgID: request.ClientMsgID,
+			}
+			if !entry.hideDetail {
+				msg.Detail = err.Error()
+			}
+			return msg
+		}
+	}
+	return ErrorMessage{
+		Code:        ErrorCodeInternal,
+		Message:     internalErrorMessage,
+		ClientMsgID: request.ClientMsgID,
+	}
+}
+
+// NewErrorFrame 构造推送给客户端的错误帧
+func NewErrorFrame(err error, message []byte) ([]byte, error) {
+	return NewFrame(MessageTypeError, NewErrorMessage(err, message))
+}
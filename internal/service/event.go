@@ -0,0 +1,237 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/model"
+	"gorm.io/gorm"
+)
+
+// 输入状态和已读回执相关的消息类型，这些事件不保存为消息
+const (
+	MessageTypeTypingStart = "typing_start" // 双向：对方开始输入
+	MessageTypeTypingStop  = "typing_stop"  // 双向：对方停止输入
+	MessageTypeRead        = "read"         // 客户端请求：已读到会话中的某条消息
+	MessageTypeReadReceipt = "read_receipt" // 推送给双方：已读位置更新
+)
+
+var (
+	ErrReadSessionNotFound = errors.New("已读的会话不存在")
+)
+
+// TypingEvent 输入状态事件。客户和客服发送时只需填写 SessionID（客户可省略），
+// 服务端转发时补充发送方
+type TypingEvent struct {
+	SessionID uint         `json:"session_id"`
+	Sender    model.Sender `json:"sender,omitempty"`
+}
+
+// ReadRequest 已读请求，Seq 为已读到的最后一条消息序号
+type ReadRequest struct {
+	SessionID uint `json:"session_id"`
+	Seq       uint `json:"seq"`
+}
+
+// ReadReceipt 已读回执，Seq 为保存后的已读位置，不会回退
+type ReadReceipt struct {
+	SessionID uint         `json:"session_id"`
+	Reader    model.Sender `json:"reader"`
+	UserID    uint         `json:"user_id"`
+	Seq       uint         `json:"seq"`
+	ReadAt    time.Time    `json:"read_at"`
+}
+
+// IsEventType 判断消息类型是否为不保存的会话事件
+func IsEventType(msgType string) bool {
+	switch msgType {
+	case MessageTypeTypingStart, MessageTypeTypingStop, MessageTypeRead:
+		return true
+	}
+	return false
+}
+
+// EventService 处理输入状态和已读回执
+type EventService interface {
+	// HandleCustomerEvent 处理客户发送的事件，返回需要回复给客户的帧，没有时返回 nil
+	HandleCustomerEvent(customerID uint, msgType string, content json.RawMessage) ([]byte, error)
+	// HandleAgentEvent 处理客服发送的事件，返回需要回复给客服的帧，没有时返回 nil
+	HandleAgentEvent(agentID uint, msgType string, content json.RawMessage) ([]byte, error)
+}
+
+type eventService struct {
+	db         *gorm.DB
+	dispatcher Dispatcher
+}
+
+// NewEventService 创建输入状态与已读回执服务实例
+func NewEventService(db *gorm.DB, dispatcher Dispatcher) EventService {
+	return &eventService{
+		db:         db,
+		dispatcher: dispatcher,
+	}
+}
+
+// HandleCustomerEvent 客户的输入状态转发给接待的客服，已读位置同时通知客服
+func (s *eventService) HandleCustomerEvent(customerID uint, msgType string, content json.RawMessage) ([]byte, error) {
+	switch msgType {
+	case MessageTypeTypingStart, MessageTypeTypingStop:
+		var event TypingEvent
+		if err := decodeEvent(content, &event); err != nil {
+			return nil, err
+		}
+		// 只有人工接待中的会话才有需要通知的对方，机器人不关心客户的输入状态
+		query := s.db.Where("customer_id = ? AND status = ?", customerID, model.SessionStatusWithAgent)
+		if event.SessionID > 0 {
+			query = query.Where("id = ?", event.SessionID)
+		}
+		var session model.Session
+		if err := query.Order("id desc").First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if session.AgentID == nil {
+			return nil, nil
+		}
+		frame, err := NewFrame(msgType, TypingEvent{SessionID: session.ID, Sender: model.SenderCustomer})
+		if err != nil {
+			return nil, err
+		}
+		s.dispatcher.SendToAgent(*session.AgentID, frame)
+		return nil, nil
+	case MessageTypeRead:
+		var req ReadRequest
+		if err := decodeEvent(content, &req); err != nil {
+			return nil, err
+		}
+		var session model.Session
+		if err := s.db.Where("id = ? AND customer_id = ?", req.SessionID, customerID).
+			First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrReadSessionNotFound
+			}
+			return nil, err
+		}
+		receipt, err := s.markRead(session.ID, customerID, model.SenderCustomer, req.Seq)
+		if err != nil {
+			return nil, err
+		}
+		frame, err := NewFrame(MessageTypeReadReceipt, receipt)
+		if err != nil {
+			return nil, err
+		}
+		if session.AgentID != nil && session.Status == string(model.SessionStatusWithAgent) {
+			s.dispatcher.SendToAgent(*session.AgentID, frame)
+		}
+		return frame, nil
+	default:
+		return nil, fmt.Errorf("%w: event type %s", ErrUnsupportedMessageType, msgType)
+	}
+}
+
+// HandleAgentEvent 客服的输入状态和已读位置推送给会话中的客户，只能用于自己接待的会话
+func (s *eventService) HandleAgentEvent(agentID uint, msgType string, content json.RawMessage) ([]byte, error) {
+	switch msgType {
+	case MessageTypeTypingStart, MessageTypeTypingStop:
+		var event TypingEvent
+		if err := decodeEvent(content, &event); err != nil {
+			return nil, err
+		}
+		session, err := s.agentSession(agentID, event.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		frame, err := NewFrame(msgType, TypingEvent{SessionID: session.ID, Sender: model.SenderAgent})
+		if err != nil {
+			return nil, err
+		}
+		s.dispatcher.SendToSession(session.ID, frame)
+		return nil, nil
+	case MessageTypeRead:
+		var req ReadRequest
+		if err := decodeEvent(content, &req); err != nil {
+			return nil, err
+		}
+		session, err := s.agentSession(agentID, req.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		receipt, err := s.markRead(session.ID, agentID, model.SenderAgent, req.Seq)
+		if err != nil {
+			return nil, err
+		}
+		frame, err := NewFrame(MessageTypeReadReceipt, receipt)
+		if err != nil {
+			return nil, err
+		}
+		s.dispatcher.SendToSession(session.ID, frame)
+		return frame, nil
+	default:
+		return nil, fmt.Errorf("%w: event type %s", ErrUnsupportedMessageType, msgType)
+	}
+}
+
+// agentSession 获取由该客服接待的会话
+func (s *eventService) agentSession(agentID uint, sessionID uint) (*model.Session, error) {
+	var session model.Session
+	if err := s.db.Where("id = ? AND status = ? AND agent_id = ?",
+		sessionID, model.SessionStatusWithAgent, agentID).
+		First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotAssigned
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// markRead 保存已读位置。序号不超过会话当前的最后序号，已读位置只前进不后退
+func (s *eventService) markRead(sessionID uint, userID uint, reader model.Sender, seq uint) (*ReadReceipt, error) {
+	var lastSeq []uint
+	if err := s.db.Model(&model.MessageSeq{}).
+		Where("session_id = ?", sessionID).
+		Pluck("last_seq", &lastSeq).Error; err != nil {
+		return nil, err
+	}
+	if len(lastSeq) == 0 {
+		seq = 0
+	} else if seq > lastSeq[0] {
+		seq = lastSeq[0]
+	}
+
+	now := time.Now()
+	var saved uint
+	if err := s.db.Raw(`
+		INSERT INTO read_receipts (session_id, user_id, last_read_seq, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (session_id, user_id) DO UPDATE
+		SET last_read_seq = GREATEST(read_receipts.last_read_seq, EXCLUDED.last_read_seq),
+			updated_at = EXCLUDED.updated_at
+		RETURNING last_read_seq`,
+		sessionID, userID, seq, now).Scan(&saved).Error; err != nil {
+		return nil, err
+	}
+
+	return &ReadReceipt{
+		SessionID: sessionID,
+		Reader:    reader,
+		UserID:    userID,
+		Seq:       saved,
+		ReadAt:    now,
+	}, nil
+}
+
+// decodeEvent 解析事件内容，输入状态事件的内容可以为空
+func decodeEvent(content json.RawMessage, v interface{}) error {
+	if len(content) == 0 || string(content) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/JennerWork/chatbot/internal/flow"
+	"github.com/JennerWork/chatbot/internal/model"
+	"gorm.io/gorm"
+)
+
+// FlowAction is executed when a flow completes; a non-empty result replaces the flow's completion message
+type FlowAction func(ctx context.Context, customerID uint, sessionID uint, slots map[string]string) (string, error)
+
+// flowRunner drives declarative flows and persists their per-session state
+type flowRunner struct {
+	db      *gorm.DB
+	engine  *flow.Engine
+	privacy PrivacyService // slots are stored as is when nil
+	actions map[string]FlowAction
+}
+
+// newFlowRunner creates a flow runner
+func newFlowRunner(db *gorm.DB, engine *flow.Engine, privacy PrivacyService) *flowRunner {
+	return &flowRunner{
+		db:      db,
+		engine:  engine,
+		privacy: privacy,
+		actions: make(map[string]FlowAction),
+	}
+}
+
+// registerAction registers an action that flows can reference through on_complete
+func (r *flowRunner) registerAction(name string, action FlowAction) {
+	r.actions[name] = action
+}
+
+// active returns the customer's running flow, or nil if there is none.
+// A flow started before a reconnect is moved over to the current session so it can be resumed.
+func (r *flowRunner) active(ctx context.Context, customerID uint, sessionID uint) (*model.FlowState, error) {
+	db := r.db.WithContext(ctx)
+	var state model.FlowState
+	err := db.Where("customer_id = ? AND status = ?", customerID, model.FlowStatusActive).
+		Order("id desc").
+		First(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve flow state: %v", err)
+	}
+
+	if state.SessionID != sessionID {
+		state.SessionID = sessionID
+		if err := db.Save(&state).Error; err != nil {
+			return nil, fmt.Errorf("failed to resume flow: %v", err)
+		}
+	}
+	return &state, nil
+}
+
+// start starts a flow and returns the prompt of its first step
+func (r *flowRunner) start(ctx context.Context, customerID uint, sessionID uint, name string) (*Reply, error) {
+	db := r.db.WithContext(ctx)
+	// Only one flow runs at a time, so a newly started flow replaces the old one
+	if err := db.Model(&model.FlowState{}).
+		Where("customer_id = ? AND status = ?", customerID, model.FlowStatusActive).
+		Update("status", model.FlowStatusCancelled).Error; err != nil {
+		return nil, fmt.Errorf("failed to cancel previous flow: %v", err)
+	}
+
+	state, prompt, err := r.engine.Start(name)
+	if err != nil {
+		return nil, err
+	}
+
+	slots, err := r.encodeSlots(customerID, state.Slots)
+	if err != nil {
+		return nil, err
+	}
+	record := model.FlowState{
+		CustomerID:  customerID,
+		SessionID:   sessionID,
+		FlowName:    state.Flow,
+		CurrentStep: state.Step,
+		Slots:       slots,
+		Status:      model.FlowStatusActive,
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to create flow state: %v", err)
+	}
+
+	return r.reply(state, prompt), nil
+}
+
+// handle feeds the customer's input into the running flow and returns the reply
+func (r *flowRunner) handle(ctx context.Context, record *model.FlowState, text string) (*Reply, error) {
+	db := r.db.WithContext(ctx)
+	if flow.IsCancel(text) {
+		record.Status = model.FlowStatusCancelled
+		if err := db.Save(record).Error; err != nil {
+			return nil, fmt.Errorf("failed to cancel flow: %v", err)
+		}
+		return textReply(r.engine.CancelMessage(record.FlowName)), nil
+	}
+
+	state := &flow.State{
+		Flow: record.FlowName,
+		Step: record.CurrentStep,
+	}
+	slots, err := r.decodeSlots(record.CustomerID, record.Slots)
+	if err != nil {
+		return nil, err
+	}
+	state.Slots = slots
+
+	outcome, err := r.engine.Advance(state, text)
+	if err != nil {
+		return nil, err
+	}
+	if outcome.Invalid {
+		return r.reply(state, outcome.Reply), nil
+	}
+
+	reply := outcome.Reply
+	if outcome.Completed {
+		record.Status = model.FlowStatusCompleted
+		if result, err := r.complete(ctx, record, state.Slots); err != nil {
+			return nil, err
+		} else if result != "" {
+			reply = result
+		}
+	}
+
+	stored, err := r.encodeSlots(record.CustomerID, state.Slots)
+	if err != nil {
+		return nil, err
+	}
+	record.CurrentStep = state.Step
+	record.Slots = stored
+	if err := db.Save(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save flow state: %v", err)
+	}
+
+	return r.reply(state, reply), nil
+}
+
+// encodeSlots serializes the slots for storage. Slot values may hold personal data such as phone numbers,
+// so each value is sealed in the vault or redacted according to the privacy settings
+func (r *flowRunner) encodeSlots(customerID uint, slots map[string]string) (string, error) {
+	stored := slots
+	if r.privacy != nil {
+		stored = make(map[string]string, len(slots))
+		for name, value := range slots {
+			protected, err := r.privacy.ProtectField(flowSlotLabel(customerID, name), value)
+			if err != nil {
+				return "", err
+			}
+			stored[name] = protected
+		}
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeSlots parses the slots stored by encodeSlots
+func (r *flowRunner) decodeSlots(customerID uint, stored string) (map[string]string, error) {
+	if stored == "" {
+		return nil, nil
+	}
+	var slots map[string]string
+	if err := json.Unmarshal([]byte(stored), &slots); err != nil {
+		return nil, fmt.Errorf("failed to parse flow slots: %v", err)
+	}
+	if r.privacy != nil {
+		for name, value := range slots {
+			recovered, err := r.privacy.RecoverField(flowSlotLabel(customerID, name), value)
+			if err != nil {
+				return nil, err
+			}
+			slots[name] = recovered
+		}
+	}
+	return slots, nil
+}
+
+// flowSlotLabel identifies a slot of the customer's flows when sealing its value
+func flowSlotLabel(customerID uint, name string) string {
+	return fmt.Sprintf("flow_slot:%d:%s", customerID, name)
+}
+
+// reply offers the choices of the current step as quick replies; finished flows have no current step
+func (r *flowRunner) reply(state *flow.State, text string) *Reply {
+	choices := r.engine.Choices(state)
+	if len(choices) == 0 {
+		return textReply(text)
+	}
+	return richReply(MessageTypeQuickReplies, &QuickRepliesMessage{
+		Text:    text,
+		Replies: choiceButtons(choices),
+	})
+}
+
+// complete runs the flow's on_complete action
+func (r *flowRunner) complete(ctx context.Context, record *model.FlowState, slots map[string]string) (string, error) {
+	f, err := r.engine.Get(record.FlowName)
+	if err != nil {
+		return "", err
+	}
+	if f.OnComplete == "" {
+		return "", nil
+	}
+
+	action, ok := r.actions[f.OnComplete]
+	if !ok {
+		return "", fmt.Errorf("flow %s: unknown action %s", f.Name, f.OnComplete)
+	}
+	return action(ctx, record.CustomerID, record.SessionID, slots)
+}
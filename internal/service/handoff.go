@@ -0,0 +1,466 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/dao"
+	"github.com/JennerWork/chatbot/internal/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSessionNotActive   = errors.New("会话不在机器人接待状态")
+	ErrSessionNotWaiting  = errors.New("会话不在等待人工状态")
+	ErrSessionNotAssigned = errors.New("会话未由该客服接待")
+)
+
+// 人工客服相关的消息类型
+const (
+	MessageTypeHandoffRequest  = "handoff_request"  // 推送给客服：有会话等待接入
+	MessageTypeListWaiting     = "list_waiting"     // 客服请求：获取等待中的会话
+	MessageTypeWaitingSessions = "waiting_sessions" // 返回给客服：等待中的会话列表
+	MessageTypeClaim           = "claim"            // 客服请求：认领会话
+	MessageTypeClaimed         = "claimed"          // 返回给客服：认领成功及会话历史
+	MessageTypeRelease         = "release"          // 客服请求：结束接待，交还给机器人
+	MessageTypeReleased        = "released"         // 返回给客服：已结束接待
+	MessageTypeCustomerMessage = "customer_message" // 推送给客服：客户发送的消息
+	MessageTypeCustomerLeft    = "customer_left"    // 推送给客服：客户的所有连接已断开，会话保留，客户重连后可以继续
+	MessageTypeAgentJoined     = "agent_joined"     // 推送给客户：客服已接入
+	MessageTypeAgentLeft       = "agent_left"       // 推送给客户：客服已离开
+	MessageTypeQueuePosition   = "queue_position"   // 推送给客户：排队位置变化
+)
+
+// 转人工的原因
+const (
+	HandoffReasonRequested         = "requested"          // 客户主动要求
+	HandoffReasonNegativeSentiment = "negative_sentiment" // 客户情绪负面
+	HandoffReasonBotFallback       = "bot_fallback"       // 机器人连续无法回答
+	HandoffReasonModeration        = "moderation"         // 内容审核要求人工处理
+)
+
+// HandoffRequest 转人工请求
+type HandoffRequest struct {
+	CustomerID uint
+	SessionID  uint
+	Reason     string
+	Skill      string // 需要的客服技能，为空时任何客服都可以接待
+	Language   string // 客户使用的语言
+}
+
+// QueueRequest 会话排队请求
+type QueueRequest struct {
+	SessionID  uint
+	CustomerID uint
+	Skill      string
+	Language   string
+	Priority   int
+}
+
+// QueuePosition 推送给客户的排队位置
+type QueuePosition struct {
+	SessionID uint `json:"session_id"`
+	Position  int  `json:"position"` // 从1开始
+}
+
+// AgentRouter 将等待人工的会话排队并分配给合适的客服
+type AgentRouter interface {
+	// Enqueue 会话加入队列，返回排队位置（从1开始）
+	Enqueue(req QueueRequest) (int, error)
+	// Remove 会话被客服手动认领或不再等待时移出队列
+	Remove(sessionID uint)
+	// Notify 客服的接待能力发生变化时触发一次分配
+	Notify()
+}
+
+// SessionAssigner 将等待人工的会话分配给客服，由路由队列调用
+type SessionAssigner interface {
+	// Assign 将会话分配给客服并通知双方，会话已不在等待状态时返回 ErrSessionNotWaiting
+	Assign(ctx context.Context, agentID uint, sessionID uint) error
+}
+
+// Dispatcher 向在线的客户或客服推送消息，由连接管理器实现
+type Dispatcher interface {
+	// SendToCustomer 推送给客户，客户不在线时返回 false
+	SendToCustomer(customerID uint, frame []byte) bool
+	// SendToSession 推送给关联该会话的客户连接，没有连接时返回 false
+	SendToSession(sessionID uint, frame []byte) bool
+	// SendToAgent 推送给客服，客服不在线时返回 false
+	SendToAgent(agentID uint, frame []byte) bool
+	// BroadcastToAgents 推送给所有在线客服，返回送达的客服数
+	BroadcastToAgents(frame []byte) int
+}
+
+// SessionRef 客服请求中引用的会话
+type SessionRef struct {
+	SessionID uint `json:"session_id"`
+}
+
+// AgentTextMessage 客服发送给客户的文本消息
+type AgentTextMessage struct {
+	SessionID uint   `json:"session_id"`
+	Text      string `json:"text"`
+}
+
+// HandoffNotice 推送给客服的会话通知
+type HandoffNotice struct {
+	SessionID  uint      `json:"session_id"`
+	CustomerID uint      `json:"customer_id"`
+	Reason     string    `json:"reason,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	Since      time.Time `json:"since"`
+}
+
+// ClaimResult 认领成功后返回给客服的会话信息
+type ClaimResult struct {
+	SessionID  uint            `json:"session_id"`
+	CustomerID uint            `json:"customer_id"`
+	History    []MessageDetail `json:"history"`
+}
+
+// AgentInfo 推送给客户的客服信息
+type AgentInfo struct {
+	AgentID uint   `json:"agent_id"`
+	Name    string `json:"name"`
+}
+
+// HandoffService 人工客服转接服务接口
+type HandoffService interface {
+	SessionAssigner
+
+	// RequestHandoff 将会话转入等待人工状态并加入排队，返回给客户的提示；会话不在机器人接待状态时返回 ErrSessionNotActive
+	RequestHandoff(ctx context.Context, req HandoffRequest) (string, error)
+	// RelayToAgent 将客户消息转发给接待该会话的客服
+	RelayToAgent(session *model.Session, text string) error
+	// HandleAgentMessage 处理客服WebSocket发来的消息
+	HandleAgentMessage(ctx context.Context, agentID uint, message []byte, w FrameWriter) ([]byte, error)
+}
+
+type handoffService struct {
+	db         *gorm.DB
+	dispatcher Dispatcher
+	router     AgentRouter
+	privacy    PrivacyService
+}
+
+// NewHandoffService 创建人工客服转接服务实例，客服消息中的个人信息按 privacy 脱敏后保存
+func NewHandoffService(db *gorm.DB, dispatcher Dispatcher, router AgentRouter, privacy PrivacyService) HandoffService {
+	return &handoffService{
+		db:         db,
+		dispatcher: dispatcher,
+		router:     router,
+		privacy:    privacy,
+	}
+}
+
+// RequestHandoff 转人工，会话已在等待人工、人工接待中或已结束时返回 ErrSessionNotActive
+func (s *handoffService) RequestHandoff(ctx context.Context, req HandoffRequest) (string, error) {
+	result := s.db.WithContext(ctx).Model(&model.Session{}).
+		Where("id = ? AND status = ?", req.SessionID, model.SessionStatusActive).
+		Updates(map[string]interface{}{
+			"status":         model.SessionStatusWaitingAgent,
+			"handoff_reason": req.Reason,
+		})
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to request handoff: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return "", fmt.Errorf("%w: session %d", ErrSessionNotActive, req.SessionID)
+	}
+
+	log.Printf("Session %d of customer %d is waiting for an agent (%s)", req.SessionID, req.CustomerID, req.Reason)
+
+	position, err := s.router.Enqueue(QueueRequest{
+		SessionID:  req.SessionID,
+		CustomerID: req.CustomerID,
+		Skill:      req.Skill,
+		Language:   req.Language,
+		Priority:   handoffPriority(req.Reason),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue session: %v", err)
+	}
+
+	frame, err := NewFrame(MessageTypeHandoffRequest, HandoffNotice{
+		SessionID:  req.SessionID,
+		CustomerID: req.CustomerID,
+		Reason:     req.Reason,
+		Since:      time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if s.dispatcher.BroadcastToAgents(frame) == 0 {
+		return "All of our agents are currently offline. Your request has been recorded and an agent will contact you as soon as possible.", nil
+	}
+
+	return fmt.Sprintf("I am transferring you to a human agent. You are number %d in the queue, please wait a moment.", position), nil
+}
+
+// handoffPriority 根据转人工原因决定排队优先级，情绪负面的客户优先接待
+func handoffPriority(reason string) int {
+	if reason == HandoffReasonNegativeSentiment {
+		return 1
+	}
+	return 0
+}
+
+// Assign 将会话分配给客服，并把会话信息推送给客服
+func (s *handoffService) Assign(ctx context.Context, agentID uint, sessionID uint) error {
+	result, err := s.assign(ctx, agentID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	frame, err := NewFrame(MessageTypeClaimed, result)
+	if err != nil {
+		return err
+	}
+	if !s.dispatcher.SendToAgent(agentID, frame) {
+		log.Printf("Agent %d went offline before session %d was delivered", agentID, sessionID)
+	}
+	return nil
+}
+
+// RelayToAgent 转发客户消息给客服
+func (s *handoffService) RelayToAgent(session *model.Session, text string) error {
+	if session.AgentID == nil {
+		return ErrSessionNotAssigned
+	}
+
+	frame, err := NewFrame(MessageTypeCustomerMessage, HandoffNotice{
+		SessionID:  session.ID,
+		CustomerID: session.CustomerID,
+		Text:       text,
+		Since:      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if !s.dispatcher.SendToAgent(*session.AgentID, frame) {
+		log.Printf("Agent %d is offline, message of session %d not relayed", *session.AgentID, session.ID)
+	}
+	return nil
+}
+
+// HandleAgentMessage 处理客服消息
+func (s *handoffService) HandleAgentMessage(ctx context.Context, agentID uint, message []byte, w FrameWriter) ([]byte, error) {
+	var request MessageRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	switch request.Type {
+	case MessageTypeListWaiting:
+		return s.listWaiting(ctx)
+	case MessageTypeClaim:
+		var ref SessionRef
+		if err := json.Unmarshal(request.Content, &ref); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+		}
+		return s.claim(ctx, agentID, ref.SessionID)
+	case MessageTypeRelease:
+		var ref SessionRef
+		if err := json.Unmarshal(request.Content, &ref); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+		}
+		return s.release(ctx, agentID, ref.SessionID)
+	case MessageTypeText:
+		var msg AgentTextMessage
+		if err := json.Unmarshal(request.Content, &msg); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+		}
+		return nil, s.sendToCustomer(ctx, agentID, msg)
+	default:
+		return nil, fmt.Errorf("%w: agent message type %s", ErrUnsupportedMessageType, request.Type)
+	}
+}
+
+// listWaiting 返回等待人工的会话
+func (s *handoffService) listWaiting(ctx context.Context) ([]byte, error) {
+	var sessions []model.Session
+	if err := s.db.WithContext(ctx).Where("status = ?", model.SessionStatusWaitingAgent).
+		Order("updated_at asc").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	notices := make([]HandoffNotice, len(sessions))
+	for i, session := range sessions {
+		notices[i] = HandoffNotice{
+			SessionID:  session.ID,
+			CustomerID: session.CustomerID,
+			Reason:     session.HandoffReason,
+			Since:      session.UpdatedAt,
+		}
+	}
+	return NewFrame(MessageTypeWaitingSessions, notices)
+}
+
+// claim 客服手动认领会话
+func (s *handoffService) claim(ctx context.Context, agentID uint, sessionID uint) ([]byte, error) {
+	result, err := s.assign(ctx, agentID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	s.router.Remove(sessionID)
+
+	return NewFrame(MessageTypeClaimed, result)
+}
+
+// assign 将会话交给客服接待，同一会话只能被一个客服接待
+func (s *handoffService) assign(ctx context.Context, agentID uint, sessionID uint) (*ClaimResult, error) {
+	db := s.db.WithContext(ctx)
+	result := db.Model(&model.Session{}).
+		Where("id = ? AND status = ?", sessionID, model.SessionStatusWaitingAgent).
+		Updates(map[string]interface{}{
+			"status":   model.SessionStatusWithAgent,
+			"agent_id": agentID,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrSessionNotWaiting
+	}
+
+	var session model.Session
+	if err := db.First(&session, sessionID).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("Session %d assigned to agent %d", sessionID, agentID)
+
+	// 通知客户客服已接入
+	var agent model.Customer
+	if err := db.First(&agent, agentID).Error; err != nil {
+		return nil, err
+	}
+	if frame, err := NewFrame(MessageTypeAgentJoined, AgentInfo{AgentID: agentID, Name: agent.Name}); err == nil {
+		s.dispatcher.SendToCustomer(session.CustomerID, frame)
+	}
+
+	// 返回会话历史，方便客服了解上下文
+	var messages []model.Message
+	if err := db.Where("session_id = ?", sessionID).Order("seq asc").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	history := make([]MessageDetail, len(messages))
+	for i, msg := range messages {
+		history[i] = toMessageDetail(msg)
+	}
+
+	return &ClaimResult{
+		SessionID:  session.ID,
+		CustomerID: session.CustomerID,
+		History:    history,
+	}, nil
+}
+
+// release 结束接待，会话交还给机器人
+func (s *handoffService) release(ctx context.Context, agentID uint, sessionID uint) ([]byte, error) {
+	session, err := s.assignedSession(ctx, agentID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Model(session).Updates(map[string]interface{}{
+		"status":   model.SessionStatusActive,
+		"agent_id": nil,
+	}).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("Session %d released by agent %d", sessionID, agentID)
+	s.router.Notify()
+
+	if frame, err := NewFrame(MessageTypeAgentLeft, AgentInfo{AgentID: agentID}); err == nil {
+		s.dispatcher.SendToCustomer(session.CustomerID, frame)
+	}
+
+	return NewFrame(MessageTypeReleased, SessionRef{SessionID: sessionID})
+}
+
+// sendToCustomer 保存客服消息并推送给客户。个人信息脱敏后保存，客户收到的是原文
+func (s *handoffService) sendToCustomer(ctx context.Context, agentID uint, msg AgentTextMessage) error {
+	session, err := s.assignedSession(ctx, agentID, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(TextMessage{Text: msg.Text})
+	if err != nil {
+		return err
+	}
+	redacted, findings := s.privacy.Redact(msg.Text)
+	stored, err := json.Marshal(TextMessage{Text: redacted})
+	if err != nil {
+		return err
+	}
+	dbMessage := &model.Message{
+		CustomerID: session.CustomerID,
+		SessionID:  session.ID,
+		Type:       MessageTypeText,
+		Content:    string(stored),
+		Sender:     model.SenderAgent,
+		AgentID:    &agentID,
+	}
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := dao.NewMessageDAO(tx).CreateMessage(dbMessage); err != nil {
+			return err
+		}
+		if len(findings) > 0 {
+			return s.privacy.Seal(tx, dbMessage, string(content), findings)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	extra, err := messageExtra(dbMessage)
+	if err != nil {
+		return err
+	}
+	frame, err := json.Marshal(MessageResponse{
+		Type:      MessageTypeText,
+		Content:   content,
+		Timestamp: time.Now(),
+		Extra:     extra,
+	})
+	if err != nil {
+		return err
+	}
+	if !s.dispatcher.SendToSession(session.ID, frame) {
+		log.Printf("Customer %d is offline, agent message stored only", session.CustomerID)
+	}
+	return nil
+}
+
+// assignedSession 获取由该客服接待的会话
+func (s *handoffService) assignedSession(ctx context.Context, agentID uint, sessionID uint) (*model.Session, error) {
+	var session model.Session
+	if err := s.db.WithContext(ctx).Where("id = ? AND status = ? AND agent_id = ?",
+		sessionID, model.SessionStatusWithAgent, agentID).
+		First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotAssigned
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// NewFrame 构造推送给客户端的消息帧
+func NewFrame(msgType string, content interface{}) ([]byte, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(MessageResponse{
+		Type:      msgType,
+		Content:   data,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/knowledge"
+	"github.com/JennerWork/chatbot/internal/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrArticleNotFound = errors.New("文章不存在")
+)
+
+// ArticleInput 创建或更新知识库文章的参数
+type ArticleInput struct {
+	Title    string
+	Body     string
+	Tags     []string
+	Language string
+}
+
+// KnowledgeAnswer 知识库回答
+type KnowledgeAnswer struct {
+	Article     *model.KBArticle  // 最佳匹配的文章
+	Confidence  float64           // 最佳匹配的置信度
+	Suggestions []model.KBArticle // 得分接近的其他文章
+}
+
+// KnowledgeService 知识库服务接口
+type KnowledgeService interface {
+	// CreateArticle 创建文章并重建索引
+	CreateArticle(input ArticleInput) (*model.KBArticle, error)
+	// UpdateArticle 更新文章并重建索引
+	UpdateArticle(id uint, input ArticleInput) (*model.KBArticle, error)
+	// DeleteArticle 删除文章并重建索引
+	DeleteArticle(id uint) error
+	// GetArticle 获取文章
+	GetArticle(id uint) (*model.KBArticle, error)
+	// ListArticles 分页获取文章列表
+	ListArticles(page, pageSize int) ([]model.KBArticle, int64, error)
+	// Reindex 从数据库重建索引
+	Reindex() error
+	// Answer 检索问题的答案，没有超过阈值的结果时返回 nil
+	Answer(question string) (*KnowledgeAnswer, error)
+}
+
+type knowledgeService struct {
+	db     *gorm.DB
+	index  *knowledge.Index
+	config config.KnowledgeConfig
+}
+
+// NewKnowledgeService 创建知识库服务实例
+func NewKnowledgeService(db *gorm.DB, cfg config.KnowledgeConfig) KnowledgeService {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 0.3
+	}
+	if cfg.SuggestRatio <= 0 {
+		cfg.SuggestRatio = 0.8
+	}
+	if cfg.MaxSuggestions <= 0 {
+		cfg.MaxSuggestions = 3
+	}
+
+	return &knowledgeService{
+		db:     db,
+		index:  knowledge.NewIndex(),
+		config: cfg,
+	}
+}
+
+// CreateArticle 创建文章
+func (s *knowledgeService) CreateArticle(input ArticleInput) (*model.KBArticle, error) {
+	article := &model.KBArticle{}
+	applyArticleInput(article, input)
+
+	if err := s.db.Create(article).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.Reindex(); err != nil {
+		return nil, err
+	}
+	return article, nil
+}
+
+// UpdateArticle 更新文章
+func (s *knowledgeService) UpdateArticle(id uint, input ArticleInput) (*model.KBArticle, error) {
+	article, err := s.GetArticle(id)
+	if err != nil {
+		return nil, err
+	}
+
+	applyArticleInput(article, input)
+	if err := s.db.Save(article).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.Reindex(); err != nil {
+		return nil, err
+	}
+	return article, nil
+}
+
+// DeleteArticle 删除文章
+func (s *knowledgeService) DeleteArticle(id uint) error {
+	result := s.db.Delete(&model.KBArticle{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrArticleNotFound
+	}
+
+	return s.Reindex()
+}
+
+// GetArticle 获取文章
+func (s *knowledgeService) GetArticle(id uint) (*model.KBArticle, error) {
+	var article model.KBArticle
+	if err := s.db.First(&article, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrArticleNotFound
+		}
+		return nil, err
+	}
+	return &article, nil
+}
+
+// ListArticles 分页获取文章列表
+func (s *knowledgeService) ListArticles(page, pageSize int) ([]model.KBArticle, int64, error) {
+	var total int64
+	if err := s.db.Model(&model.KBArticle{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var articles []model.KBArticle
+	if err := s.db.Order("id asc").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&articles).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return articles, total, nil
+}
+
+// Reindex 从数据库加载全部文章并重建索引
+func (s *knowledgeService) Reindex() error {
+	var articles []model.KBArticle
+	if err := s.db.Find(&articles).Error; err != nil {
+		return fmt.Errorf("failed to load knowledge base articles: %v", err)
+	}
+
+	docs := make([]knowledge.Document, len(articles))
+	for i, article := range articles {
+		docs[i] = knowledge.Document{
+			ID:       article.ID,
+			Title:    article.Title,
+			Body:     article.Body,
+			Tags:     article.TagList(),
+			Language: article.Language,
+		}
+	}
+	s.index.Build(docs)
+
+	log.Printf("Knowledge base reindexed: %d articles", len(docs))
+	return nil
+}
+
+// Answer 检索问题的答案
+func (s *knowledgeService) Answer(question string) (*KnowledgeAnswer, error) {
+	results := s.index.Search(question, knowledge.DetectLanguage(question), s.config.MaxSuggestions+1)
+	if len(results) == 0 || results[0].Confidence < s.config.Threshold {
+		return nil, nil
+	}
+
+	best := results[0]
+	ids := []uint{best.ID}
+	for _, r := range results[1:] {
+		if r.Score >= best.Score*s.config.SuggestRatio {
+			ids = append(ids, r.ID)
+		}
+	}
+
+	var articles []model.KBArticle
+	if err := s.db.Where("id IN ?", ids).Find(&articles).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]model.KBArticle, len(articles))
+	for _, article := range articles {
+		byID[article.ID] = article
+	}
+
+	article, ok := byID[best.ID]
+	if !ok {
+		// 文章已被删除但索引尚未更新
+		return nil, nil
+	}
+
+	answer := &KnowledgeAnswer{
+		Article:    &article,
+		Confidence: best.Confidence,
+	}
+	for _, id := range ids[1:] {
+		if suggestion, ok := byID[id]; ok {
+			answer.Suggestions = append(answer.Suggestions, suggestion)
+		}
+	}
+
+	return answer, nil
+}
+
+// applyArticleInput 将输入参数写入文章
+func applyArticleInput(article *model.KBArticle, input ArticleInput) {
+	article.Title = strings.TrimSpace(input.Title)
+	article.Body = strings.TrimSpace(input.Body)
+	article.Language = strings.ToLower(strings.TrimSpace(input.Language))
+	article.SetTags(input.Tags)
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/model"
+)
+
+var (
+	// ErrEmptyCompletion 大模型未返回任何内容
+	ErrEmptyCompletion = errors.New("empty completion")
+)
+
+// chatCompletionMessage OpenAI 对话消息
+type chatCompletionMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatCompletionRequest /v1/chat/completions 请求体
+type chatCompletionRequest struct {
+	Model    string                  `json:"model"`
+	Messages []chatCompletionMessage `json:"messages"`
+	Stream   bool                    `json:"stream"`
+}
+
+// chatCompletionResponse /v1/chat/completions 响应体
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message chatCompletionMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// chatCompletionChunk 流式响应中的单个片段
+type chatCompletionChunk struct {
+	Choices []struct {
+		Delta chatCompletionMessage `json:"delta"`
+	} `json:"choices"`
+}
+
+// llmReplyEngine 调用OpenAI兼容接口生成回复
+type llmReplyEngine struct {
+	config     config.LLMConfig
+	httpClient *http.Client
+}
+
+// NewLLMReplyEngine 创建大模型回复引擎
+func NewLLMReplyEngine(cfg config.LLMConfig) (ReplyEngine, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("llm endpoint is required")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.HistoryLimit <= 0 {
+		cfg.HistoryLimit = 10
+	}
+
+	return &llmReplyEngine{
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout: cfg.Timeout,
+		},
+	}, nil
+}
+
+// GenerateReply 调用 /v1/chat/completions 生成回复
+func (e *llmReplyEngine) GenerateReply(ctx context.Context, customerID uint, sessionID uint, text string, history []model.Message) (string, error) {
+	resp, err := e.doCompletion(ctx, text, history, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read completion response: %v", err)
+	}
+
+	var completion chatCompletionResponse
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return "", fmt.Errorf("failed to parse completion response: %v", err)
+	}
+
+	if len(completion.Choices) == 0 {
+		return "", ErrEmptyCompletion
+	}
+
+	reply := strings.TrimSpace(completion.Choices[0].Message.Content)
+	if reply == "" {
+		return "", ErrEmptyCompletion
+	}
+
+	return reply, nil
+}
+
+// StreamReply 以流式方式调用 /v1/chat/completions，每收到一个片段就回调 onDelta
+func (e *llmReplyEngine) StreamReply(ctx context.Context, customerID uint, sessionID uint, text string, history []model.Message, onDelta func(delta string) error) (string, error) {
+	resp, err := e.doCompletion(ctx, text, history, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var reply strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// 服务端推送格式为 "data: {...}"，以 "data: [DONE]" 结束
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "[DONE]" {
+			break
+		}
+
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			return "", fmt.Errorf("failed to parse completion chunk: %v", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		reply.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return "", err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read completion stream: %w", err)
+	}
+
+	if strings.TrimSpace(reply.String()) == "" {
+		return "", ErrEmptyCompletion
+	}
+
+	return reply.String(), nil
+}
+
+// doCompletion 发送补全请求，ctx 结束时请求和流式读取都会中断，调用方负责关闭响应体
+func (e *llmReplyEngine) doCompletion(ctx context.Context, text string, history []model.Message, stream bool) (*http.Response, error) {
+	reqBody := chatCompletionRequest{
+		Model:    e.config.Model,
+		Messages: e.buildMessages(text, history),
+		Stream:   stream,
+	}
+
+	data, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal completion request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create completion request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	if e.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.config.APIKey)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("completion request failed: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("completion request returned HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
+
+// buildMessages 根据历史消息构造对话上下文
+func (e *llmReplyEngine) buildMessages(text string, history []model.Message) []chatCompletionMessage {
+	messages := make([]chatCompletionMessage, 0, len(history)+2)
+	if e.config.SystemPrompt != "" {
+		messages = append(messages, chatCompletionMessage{Role: "system", Content: e.config.SystemPrompt})
+	}
+
+	// 只保留最近的若干条历史消息
+	if len(history) > e.config.HistoryLimit {
+		history = history[len(history)-e.config.HistoryLimit:]
+	}
+
+	for _, msg := range history {
+		role := "user"
+		if msg.Sender == model.SenderBot {
+			role = "assistant"
+		}
+		messages = append(messages, chatCompletionMessage{Role: role, Content: messageText(msg)})
+	}
+
+	return append(messages, chatCompletionMessage{Role: "user", Content: text})
+}
@@ -1,18 +1,43 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/dao"
 	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/moderation"
+	"github.com/JennerWork/chatbot/internal/pii"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// 消息类型
+const (
+	MessageTypeText        = "text"         // 文本消息，其他类型见 rich_message.go
+	MessageTypeStreamStart = "stream_start" // 流式回复开始
+	MessageTypeStreamDelta = "stream_delta" // 流式回复片段
+	MessageTypeStreamEnd   = "stream_end"   // 流式回复结束
+	MessageTypeSession     = "session"      // 连接建立后推送给客户：当前会话，重连时携带其ID可恢复会话
+	MessageTypeEcho        = "echo"         // 推送给客户的其他设备：客户在某个设备上发送的消息
+
+	MessageTypeServerRestarting = "server_restarting" // 推送给所有连接：服务器即将重启，连接断开后按提示的时间重连
+)
+
 // MessageRequest 定义客户端发送的消息格式
 type MessageRequest struct {
-	Type    string          `json:"type"`            // 消息类型：text, image, etc.
-	Content json.RawMessage `json:"content"`         // 消息内容，根据type解析
-	Extra   json.RawMessage `json:"extra,omitempty"` // 额外参数
+	Type    string          `json:"type"`             // 消息类型：text, postback, image, file 等
+	Content json.RawMessage `json:"content"`          // 消息内容，根据type解析
+	Extra   json.RawMessage `json:"extra,omitempty"`  // 额外参数
+	Stream  bool            `json:"stream,omitempty"` // 是否以流式片段返回回复
+
+	// ClientMsgID 客户端生成的消息ID，重发时保持不变。服务端在去重窗口内按 (客户, ID) 去重，并以 ack 帧确认
+	ClientMsgID string `json:"client_msg_id,omitempty"`
 }
 
 // MessageResponse 定义返回给客户端的消息格式
@@ -23,145 +48,806 @@ type MessageResponse struct {
 	Extra     json.RawMessage `json:"extra,omitempty"` // 额外参数
 }
 
+// StreamChunk 流式回复帧的内容，同一回复的所有帧共享 ReplyID
+type StreamChunk struct {
+	ReplyID string `json:"reply_id"`
+	Delta   string `json:"delta,omitempty"` // stream_delta 携带的增量文本
+	Text    string `json:"text,omitempty"`  // stream_end 携带的完整文本
+}
+
+// SessionInfo session 帧的内容
+type SessionInfo struct {
+	SessionID uint `json:"session_id"`
+	Resumed   bool `json:"resumed"` // 是否恢复了之前的会话，或加入了其他设备正在进行的会话
+}
+
+// ServerRestarting server_restarting 帧的内容。ReconnectAfterMs 为建议的重连等待时间，
+// 各连接取不同的值，避免所有客户端同时重连
+type ServerRestarting struct {
+	ReconnectAfterMs int64 `json:"reconnect_after_ms"`
+}
+
+// EchoMessage echo 帧的内容，即客户发送的原始消息
+type EchoMessage struct {
+	Type    string          `json:"type"`
+	Content json.RawMessage `json:"content"`
+}
+
+// NewEchoFrame 根据客户发送的消息构造 echo 帧，同步等非聊天命令返回 ok=false
+func NewEchoFrame(message []byte) (frame []byte, ok bool, err error) {
+	var request MessageRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return nil, false, err
+	}
+	if request.Type == "" || request.Type == MessageTypeSync || IsEventType(request.Type) {
+		return nil, false, nil
+	}
+	frame, err = NewFrame(MessageTypeEcho, EchoMessage{Type: request.Type, Content: request.Content})
+	if err != nil {
+		return nil, false, err
+	}
+	return frame, true, nil
+}
+
+// FrameWriter 在消息处理过程中向客户端推送中间帧
+type FrameWriter func(frame []byte) error
+
 // MessageService 定义消息处理服务的接口
 type MessageService interface {
-	// HandleMessage 处理接收到的消息
-	HandleMessage(customerID uint, message []byte) ([]byte, error)
+	// HandleMessage 处理接收到的消息，返回最终回复。sessionID 为连接关联的会话，消息保存到该会话；
+	// 为 0 时（HTTP 请求）使用客户最近的进行中会话。
+	// 请求开启流式模式且 w 不为空时，中间帧通过 w 推送，返回值为 stream_end 帧；
+	// ctx 结束时停止生成回复并返回 ctx 的错误
+	HandleMessage(ctx context.Context, customerID uint, sessionID uint, message []byte, w FrameWriter) ([]byte, error)
 }
 
+// waitingAgentReply 等待人工客服接入期间对客户消息的回复
+const waitingAgentReply = "An agent will be with you shortly, thank you for your patience."
+
 // messageService 实现 MessageService 接口
 type messageService struct {
-	db          *gorm.DB
-	chatService ChatService
+	db                *gorm.DB
+	chatService       ChatService
+	handoffService    HandoffService
+	attachmentService AttachmentService
+	moderationService ModerationService
+	privacyService    PrivacyService
+	config            config.MessageConfig
 }
 
-// NewMessageService 创建新的消息服务实例
-func NewMessageService(db *gorm.DB) MessageService {
+// NewMessageService 创建新的消息服务实例，handoffService 为空时不支持人工客服，
+// attachmentService 为空时不支持图片和文件消息，moderationService 为空时不审核消息内容，
+// privacyService 为空时不对保存的消息脱敏
+func NewMessageService(db *gorm.DB, chatService ChatService, handoffService HandoffService, attachmentService AttachmentService, moderationService ModerationService, privacyService PrivacyService, cfg config.MessageConfig) MessageService {
+	if cfg.DedupeWindow <= 0 {
+		cfg.DedupeWindow = defaultDedupeWindow
+	}
+
 	return &messageService{
-		db:          db,
-		chatService: NewChatService(db),
+		db:                db,
+		chatService:       chatService,
+		handoffService:    handoffService,
+		attachmentService: attachmentService,
+		moderationService: moderationService,
+		privacyService:    privacyService,
+		config:            cfg,
 	}
 }
 
 // HandleMessage 处理消息的具体实现
-func (s *messageService) HandleMessage(customerID uint, message []byte) ([]byte, error) {
-	// 1. 解析接收到的消息
+func (s *messageService) HandleMessage(ctx context.Context, customerID uint, sessionID uint, message []byte, w FrameWriter) ([]byte, error) {
+	// 1. 解析并校验接收到的消息
 	var request MessageRequest
 	if err := json.Unmarshal(message, &request); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	if err := validateClientMsgID(request.ClientMsgID); err != nil {
 		return nil, err
 	}
 
-	// 2. 获取或创建当前会话
-	var session model.Session
-	if err := s.db.Where("customer_id = ? AND status = ?",
-		customerID, model.SessionStatusActive).
-		First(&session).Error; err != nil {
-		// 如果没有活跃会话，创建新会话
-		session = model.Session{
-			CustomerID:   customerID,
-			Status:       string(model.SessionStatusActive),
-			LastActiveAt: time.Now(),
-		}
-		if err := s.db.Create(&session).Error; err != nil {
+	// 客户端重发的消息已回复时不再处理，只重新确认；之前的处理在回复前失败或超时时，
+	// 已保存的消息不再保存，重新生成回复。同一连接的消息按顺序处理，重发的消息不会与原消息同时处理
+	var existing *model.Message
+	if request.ClientMsgID != "" {
+		record, err := findClientMessage(s.db.WithContext(ctx), customerID, request.ClientMsgID, s.config.DedupeWindow)
+		if err != nil {
 			return nil, err
 		}
+		if record != nil && record.replied {
+			if err := writeAck(w, newAck(request.ClientMsgID, record.message, true)); err != nil {
+				return nil, err
+			}
+			return nil, ErrDuplicateMessage
+		}
+		if record != nil {
+			existing = record.message
+		}
 	}
 
-	// 3. 保存客户发送的消息
-	dbMessage := &model.Message{
-		CustomerID: customerID,
-		SessionID:  session.ID,
-		Content:    string(request.Content),
-		Sender:     model.SenderCustomer,
-		Seq:        s.getNextMessageSeq(session.ID),
+	var (
+		content    string
+		attachment *model.Attachment
+		err        error
+	)
+	if existing != nil && (existing.Type == MessageTypeImage || existing.Type == MessageTypeFile) {
+		// 附件已关联到保存的消息，使用保存的内容
+		request.Type = existing.Type
+		request.Content = json.RawMessage(existing.Content)
+	} else if content, attachment, err = s.inboundContent(customerID, &request); err != nil {
+		return nil, err
 	}
-	if err := s.db.Create(dbMessage).Error; err != nil {
+
+	// 2. 获取或创建当前会话（包括等待人工和人工接待中的会话）
+	session, err := s.currentSession(ctx, customerID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 审核客户消息：拦截的消息不保存，需要屏蔽的内容保存屏蔽后的文本
+	verdict, reviewedText, err := s.moderateInbound(ctx, customerID, session.ID, &request)
+	if err != nil {
 		return nil, err
 	}
+	if verdict != nil {
+		content = string(request.Content)
+	}
+
+	// 3. 保存客户发送的消息，引用的附件关联到该消息。个人信息脱敏后保存，机器人和人工客服仍使用原文处理
+	dbMessage := existing
+	if dbMessage == nil {
+		if dbMessage, err = s.saveInbound(ctx, customerID, session.ID, &request, content, attachment); err != nil {
+			// 并发重发的消息已由另一个请求保存
+			if errors.Is(err, ErrDuplicateMessage) {
+				if _, err := s.ackDuplicate(ctx, customerID, request.ClientMsgID, w); err != nil {
+					return nil, err
+				}
+			}
+			return nil, err
+		}
+	}
+	if request.ClientMsgID != "" {
+		if err := writeAck(w, newAck(request.ClientMsgID, dbMessage, false)); err != nil {
+			return nil, err
+		}
+	}
+	if verdict != nil && verdict.NeedsReview() {
+		if err := s.moderationService.Flag(FlagInput{
+			CustomerID: customerID,
+			SessionID:  session.ID,
+			MessageID:  &dbMessage.ID,
+			Direction:  moderation.DirectionInbound,
+			Content:    reviewedText,
+		}, verdict); err != nil {
+			return nil, err
+		}
+	}
 
-	// 4. 根据消息类型处理消息
-	response, err := s.processMessage(customerID, session.ID, &request)
+	// 4. 根据会话状态和消息类型处理消息
+	var response *MessageResponse
+	switch {
+	case s.handoffService != nil && session.Status == string(model.SessionStatusWithAgent):
+		// 人工客服接待中，消息直接转发给客服，不经过机器人
+		if err := s.handoffService.RelayToAgent(session, requestText(&request)); err != nil {
+			return nil, err
+		}
+		if err := markClientMessageReplied(s.db.WithContext(ctx), customerID, request.ClientMsgID); err != nil {
+			return nil, err
+		}
+		return nil, s.touchSession(ctx, session.ID)
+	case s.handoffService != nil && session.Status == string(model.SessionStatusWaitingAgent):
+		response, err = newTextResponse(waitingAgentReply)
+	case s.handoffService != nil && verdict != nil && verdict.Action == moderation.ActionEscalate:
+		// 内容审核要求人工处理，不再由机器人回复
+		var reply string
+		if reply, err = s.handoffService.RequestHandoff(ctx, HandoffRequest{
+			CustomerID: customerID,
+			SessionID:  session.ID,
+			Reason:     HandoffReasonModeration,
+		}); err == nil {
+			response, err = newTextResponse(reply)
+		}
+	default:
+		// 机器人处理期间向客户显示输入状态
+		if err := writeTyping(w, MessageTypeTypingStart, session.ID); err != nil {
+			return nil, err
+		}
+		response, err = s.processMessage(ctx, customerID, session.ID, &request, w)
+		if typingErr := writeTyping(w, MessageTypeTypingStop, session.ID); err == nil {
+			err = typingErr
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// 5. 保存机器人的回复
+	// 5. 审核并保存机器人的回复
+	replyVerdict, replyText, err := s.moderateReply(ctx, customerID, session.ID, response)
+	if err != nil {
+		return nil, err
+	}
+	replyType, replyContent, err := persistedMessage(response)
+	if err != nil {
+		return nil, err
+	}
+	storedReply, replyFindings, err := s.redactContent(replyType, replyContent)
+	if err != nil {
+		return nil, err
+	}
 	botMessage := &model.Message{
 		CustomerID: customerID,
 		SessionID:  session.ID,
-		Content:    string(response.Content),
+		Type:       replyType,
+		Content:    storedReply,
 		Sender:     model.SenderBot,
-		Seq:        s.getNextMessageSeq(session.ID),
 	}
-	if err := s.db.Create(botMessage).Error; err != nil {
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := dao.NewMessageDAO(tx).CreateMessage(botMessage); err != nil {
+			return err
+		}
+		if len(replyFindings) > 0 {
+			if err := s.privacyService.Seal(tx, botMessage, replyContent, replyFindings); err != nil {
+				return err
+			}
+		}
+		// 回复保存后重发的消息才只确认不处理
+		return markClientMessageReplied(tx, customerID, request.ClientMsgID)
+	}); err != nil {
 		return nil, err
 	}
+	if replyVerdict != nil && replyVerdict.NeedsReview() {
+		if err := s.moderationService.Flag(FlagInput{
+			CustomerID: customerID,
+			SessionID:  session.ID,
+			MessageID:  &botMessage.ID,
+			Direction:  moderation.DirectionOutbound,
+			Content:    replyText,
+		}, replyVerdict); err != nil {
+			return nil, err
+		}
+	}
 
 	// 6. 更新会话最后活动时间
-	session.LastActiveAt = time.Now()
-	if err := s.db.Save(&session).Error; err != nil {
+	if err := s.touchSession(ctx, session.ID); err != nil {
 		return nil, err
 	}
 
-	// 7. 将响应序列化为JSON
+	// 7. 附带消息序号后序列化为JSON，客户端据此增量同步
+	if response.Extra, err = messageExtra(botMessage); err != nil {
+		return nil, err
+	}
 	return json.Marshal(response)
 }
 
-// getNextMessageSeq 获取下一个消息序号
-func (s *messageService) getNextMessageSeq(sessionID uint) uint {
-	var maxSeq struct {
-		MaxSeq uint
+// saveInbound 保存客户发送的消息并记录客户端消息ID，引用的附件关联到该消息。content 为脱敏前的内容
+func (s *messageService) saveInbound(ctx context.Context, customerID uint, sessionID uint, request *MessageRequest, content string, attachment *model.Attachment) (*model.Message, error) {
+	stored, findings, err := s.redactContent(request.Type, content)
+	if err != nil {
+		return nil, err
+	}
+	dbMessage := &model.Message{
+		CustomerID: customerID,
+		SessionID:  sessionID,
+		Type:       request.Type,
+		Content:    stored,
+		Sender:     model.SenderCustomer,
 	}
-	s.db.Model(&model.Message{}).
-		Select("COALESCE(MAX(seq), 0) as max_seq").
-		Where("session_id = ?", sessionID).
-		Scan(&maxSeq)
-	return maxSeq.MaxSeq + 1
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := dao.NewMessageDAO(tx).CreateMessage(dbMessage); err != nil {
+			return err
+		}
+		if len(findings) > 0 {
+			if err := s.privacyService.Seal(tx, dbMessage, content, findings); err != nil {
+				return err
+			}
+		}
+		if request.ClientMsgID != "" {
+			if err := recordClientMessage(tx, customerID, request.ClientMsgID, dbMessage.ID, s.config.DedupeWindow); err != nil {
+				return err
+			}
+		}
+		if attachment != nil {
+			return linkAttachment(tx, attachment.ID, dbMessage.ID, sessionID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dbMessage, nil
 }
 
-// processMessage 根据消息类型处理消息
-func (s *messageService) processMessage(customerID uint, sessionID uint, request *MessageRequest) (*MessageResponse, error) {
+// openSessionStatuses 进行中的会话状态，包括等待人工和人工接待中
+var openSessionStatuses = []string{
+	string(model.SessionStatusActive),
+	string(model.SessionStatusWaitingAgent),
+	string(model.SessionStatusWithAgent),
+}
+
+// currentSession 返回消息所属的会话。sessionID 不为 0 时为连接关联的会话，连接仍在使用但已被结束的会话
+// （例如其他设备断开时在线登记查询失败）重新激活；为 0 时使用客户最近的进行中会话，没有时创建新会话
+func (s *messageService) currentSession(ctx context.Context, customerID uint, sessionID uint) (*model.Session, error) {
+	db := s.db.WithContext(ctx)
+	var session model.Session
+	if sessionID > 0 {
+		if err := db.Where("id = ? AND customer_id = ?", sessionID, customerID).First(&session).Error; err != nil {
+			return nil, fmt.Errorf("failed to load session %d: %w", sessionID, err)
+		}
+		for _, status := range openSessionStatuses {
+			if session.Status == status {
+				return &session, nil
+			}
+		}
+		if err := db.Model(&session).
+			Where("status = ?", session.Status).
+			Update("status", string(model.SessionStatusActive)).Error; err != nil {
+			return nil, err
+		}
+		return &session, nil
+	}
+
+	if err := db.Where("customer_id = ? AND status IN ?", customerID, openSessionStatuses).
+		Order("id desc").
+		First(&session).Error; err == nil {
+		return &session, nil
+	}
+	// 如果没有活跃会话，创建新会话
+	session = model.Session{
+		CustomerID:   customerID,
+		Status:       string(model.SessionStatusActive),
+		LastActiveAt: time.Now(),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ackDuplicate 客户在去重窗口内以该ID发送过消息时重新确认，返回是否为重发的消息
+func (s *messageService) ackDuplicate(ctx context.Context, customerID uint, clientMsgID string, w FrameWriter) (bool, error) {
+	record, err := findClientMessage(s.db.WithContext(ctx), customerID, clientMsgID, s.config.DedupeWindow)
+	if err != nil || record == nil {
+		return false, err
+	}
+	return true, writeAck(w, newAck(clientMsgID, record.message, true))
+}
+
+// writeAck 通过 w 推送 ack，HTTP 请求没有 w 时跳过
+func writeAck(w FrameWriter, ack Ack) error {
+	if w == nil {
+		return nil
+	}
+	frame, err := NewFrame(MessageTypeAck, ack)
+	if err != nil {
+		return err
+	}
+	return w(frame)
+}
+
+// writeTyping 通过 w 推送机器人的输入状态，HTTP 请求没有 w 时跳过
+func writeTyping(w FrameWriter, msgType string, sessionID uint) error {
+	if w == nil {
+		return nil
+	}
+	frame, err := NewFrame(msgType, TypingEvent{SessionID: sessionID, Sender: model.SenderBot})
+	if err != nil {
+		return err
+	}
+	return w(frame)
+}
+
+// touchSession 更新会话最后活动时间。只更新该列，避免覆盖处理过程中变更的会话状态
+func (s *messageService) touchSession(ctx context.Context, sessionID uint) error {
+	return s.db.WithContext(ctx).Model(&model.Session{}).
+		Where("id = ?", sessionID).
+		Update("last_active_at", time.Now()).Error
+}
+
+// inboundContent 校验客户发送的消息并返回需要持久化的内容，以及消息引用的附件。
+// 支持的类型按结构重新序列化，去掉多余字段；不支持的类型原样保存，由 processMessage 回复提示
+func (s *messageService) inboundContent(customerID uint, request *MessageRequest) (string, *model.Attachment, error) {
 	switch request.Type {
-	case "text":
-		return s.handleTextMessage(customerID, sessionID, request)
+	case MessageTypeText, MessageTypePostback:
+	case MessageTypeImage, MessageTypeFile:
+		if s.attachmentService == nil {
+			return string(request.Content), nil, nil
+		}
 	default:
-		return s.handleUnknownMessage(customerID, request)
+		return string(request.Content), nil, nil
+	}
+
+	msg, err := DecodeMessage(request.Type, request.Content)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 附件消息补充文件名、类型和下载地址
+	var attachment *model.Attachment
+	if attachmentMsg, ok := msg.(*AttachmentMessage); ok {
+		if attachment, err = s.attachmentService.Prepare(customerID, request.Type, attachmentMsg); err != nil {
+			return "", nil, err
+		}
+	}
+
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return "", nil, err
 	}
+	request.Content = content
+	return string(content), attachment, nil
+}
+
+// moderateInbound 审核客户消息中的文本（文本消息的内容、附件的说明文字）。拦截时保存审核记录并返回
+// ErrMessageBlocked，需要屏蔽时改写 request.Content。返回审核结果和审核前的文本，未审核时审核结果为 nil
+func (s *messageService) moderateInbound(ctx context.Context, customerID uint, sessionID uint, request *MessageRequest) (*moderation.Verdict, string, error) {
+	if s.moderationService == nil {
+		return nil, "", nil
+	}
+	text, ok := requestBodyText(request)
+	if !ok {
+		return nil, "", nil
+	}
+	verdict, err := s.moderationService.Moderate(ctx, moderation.DirectionInbound, text)
+	if err != nil || verdict == nil {
+		return nil, "", err
+	}
+
+	if verdict.Action == moderation.ActionBlock {
+		if err := s.moderationService.Flag(FlagInput{
+			CustomerID: customerID,
+			SessionID:  sessionID,
+			Direction:  moderation.DirectionInbound,
+			Content:    text,
+		}, verdict); err != nil {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("%w: %s", ErrMessageBlocked, strings.Join(verdict.Categories, ", "))
+	}
+	if verdict.Text != text {
+		if err := setRequestBodyText(request, verdict.Text); err != nil {
+			return nil, "", err
+		}
+	}
+	return verdict, text, nil
+}
+
+// moderateReply 审核机器人的文本回复：拦截时改为固定回复，需要转人工时改为转人工的提示，需要屏蔽时改写回复。
+// 流式回复已推送的片段无法撤回，只改写 stream_end 中的完整文本和保存的内容。返回审核结果和审核前的文本
+func (s *messageService) moderateReply(ctx context.Context, customerID uint, sessionID uint, response *MessageResponse) (*moderation.Verdict, string, error) {
+	if s.moderationService == nil {
+		return nil, "", nil
+	}
+	text, ok := responseBodyText(response)
+	if !ok {
+		return nil, "", nil
+	}
+	verdict, err := s.moderationService.Moderate(ctx, moderation.DirectionOutbound, text)
+	if err != nil || verdict == nil {
+		return nil, "", err
+	}
+
+	replacement := verdict.Text
+	switch verdict.Action {
+	case moderation.ActionBlock:
+		replacement = blockedReply
+	case moderation.ActionEscalate:
+		replacement = blockedReply
+		if s.handoffService != nil {
+			reply, err := s.handoffService.RequestHandoff(ctx, HandoffRequest{
+				CustomerID: customerID,
+				SessionID:  sessionID,
+				Reason:     HandoffReasonModeration,
+			})
+			// 回复本身已触发转人工时会话不再处于机器人接待状态，仍以固定回复替换
+			switch {
+			case err == nil:
+				replacement = reply
+			case !errors.Is(err, ErrSessionNotActive):
+				return nil, "", err
+			}
+		}
+	}
+	if replacement != text {
+		if err := setResponseBodyText(response, replacement); err != nil {
+			return nil, "", err
+		}
+	}
+	return verdict, text, nil
+}
+
+// requestBodyText 返回客户消息中的文本（文本消息的内容、附件的说明文字），其他类型的消息没有需要审核和脱敏的文本
+func requestBodyText(request *MessageRequest) (string, bool) {
+	switch request.Type {
+	case MessageTypeText:
+		var msg TextMessage
+		if err := json.Unmarshal(request.Content, &msg); err == nil {
+			return msg.Text, true
+		}
+	case MessageTypeImage, MessageTypeFile:
+		var msg AttachmentMessage
+		if err := json.Unmarshal(request.Content, &msg); err == nil {
+			return msg.Caption, true
+		}
+	}
+	return "", false
+}
+
+// setRequestBodyText 以审核或脱敏后的文本替换客户消息中的文本
+func setRequestBodyText(request *MessageRequest, text string) error {
+	var msg interface{}
+	switch request.Type {
+	case MessageTypeText:
+		msg = TextMessage{Text: text}
+	case MessageTypeImage, MessageTypeFile:
+		var attachmentMsg AttachmentMessage
+		if err := json.Unmarshal(request.Content, &attachmentMsg); err != nil {
+			return err
+		}
+		attachmentMsg.Caption = text
+		msg = attachmentMsg
+	default:
+		return nil
+	}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	request.Content = content
+	return nil
+}
+
+// redactContent 对需要保存的消息内容中的文本脱敏，返回脱敏后的内容和命中的个人信息。
+// 只处理文本消息的内容和附件的说明文字，文件名、下载地址等字段保持不变
+func (s *messageService) redactContent(msgType string, content string) (string, []pii.Finding, error) {
+	if s.privacyService == nil {
+		return content, nil, nil
+	}
+	msg := MessageRequest{Type: msgType, Content: json.RawMessage(content)}
+	text, ok := requestBodyText(&msg)
+	if !ok {
+		return content, nil, nil
+	}
+	redacted, findings := s.privacyService.Redact(text)
+	if len(findings) == 0 {
+		return content, nil, nil
+	}
+	if err := setRequestBodyText(&msg, redacted); err != nil {
+		return "", nil, err
+	}
+	return string(msg.Content), findings, nil
+}
+
+// responseBodyText 返回机器人回复中需要审核的文本，富消息的内容来自意图配置，不审核
+func responseBodyText(response *MessageResponse) (string, bool) {
+	switch response.Type {
+	case MessageTypeText:
+		var msg TextMessage
+		if err := json.Unmarshal(response.Content, &msg); err == nil {
+			return msg.Text, true
+		}
+	case MessageTypeStreamEnd:
+		var chunk StreamChunk
+		if err := json.Unmarshal(response.Content, &chunk); err == nil {
+			return chunk.Text, true
+		}
+	}
+	return "", false
+}
+
+// setResponseBodyText 以审核后的文本替换机器人回复中的文本
+func setResponseBodyText(response *MessageResponse, text string) error {
+	var msg interface{}
+	switch response.Type {
+	case MessageTypeText:
+		msg = TextMessage{Text: text}
+	case MessageTypeStreamEnd:
+		var chunk StreamChunk
+		if err := json.Unmarshal(response.Content, &chunk); err != nil {
+			return err
+		}
+		chunk.Text = text
+		msg = chunk
+	default:
+		return nil
+	}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	response.Content = content
+	return nil
+}
+
+// persistedMessage 返回回复需要持久化的类型和内容，流式回复只保存拼接后的完整文本
+func persistedMessage(response *MessageResponse) (string, string, error) {
+	if response.Type != MessageTypeStreamEnd {
+		return response.Type, string(response.Content), nil
+	}
+
+	var chunk StreamChunk
+	if err := json.Unmarshal(response.Content, &chunk); err != nil {
+		return "", "", err
+	}
+	content, err := json.Marshal(TextMessage{Text: chunk.Text})
+	if err != nil {
+		return "", "", err
+	}
+	return MessageTypeText, string(content), nil
+}
+
+// processMessage 根据消息类型处理消息
+func (s *messageService) processMessage(ctx context.Context, customerID uint, sessionID uint, request *MessageRequest, w FrameWriter) (*MessageResponse, error) {
+	switch request.Type {
+	case MessageTypeText:
+		return s.handleTextMessage(ctx, customerID, sessionID, request, w)
+	case MessageTypePostback:
+		return s.handlePostbackMessage(ctx, customerID, sessionID, request, w)
+	case MessageTypeImage, MessageTypeFile:
+		if s.attachmentService != nil {
+			return s.handleAttachmentMessage(ctx, customerID, sessionID, request, w)
+		}
+	}
+	return s.handleUnknownMessage(customerID, request)
 }
 
 // handleTextMessage 处理文本消息
-func (s *messageService) handleTextMessage(customerID uint, sessionID uint, request *MessageRequest) (*MessageResponse, error) {
+func (s *messageService) handleTextMessage(ctx context.Context, customerID uint, sessionID uint, request *MessageRequest, w FrameWriter) (*MessageResponse, error) {
 	// 1. 解析文本消息内容
 	var textMsg TextMessage
 	if err := json.Unmarshal(request.Content, &textMsg); err != nil {
 		return nil, err
 	}
 
-	// 2. 使用聊天服务处理文本消息
-	reply, err := s.chatService.ProcessText(customerID, sessionID, textMsg.Text)
+	// 2. 使用聊天服务处理文本消息并构造响应
+	return s.reply(ctx, customerID, sessionID, textMsg.Text, request.Stream, w)
+}
+
+// handlePostbackMessage 处理按钮回传事件，payload 作为客户输入交给聊天服务
+func (s *messageService) handlePostbackMessage(ctx context.Context, customerID uint, sessionID uint, request *MessageRequest, w FrameWriter) (*MessageResponse, error) {
+	var postback PostbackMessage
+	if err := json.Unmarshal(request.Content, &postback); err != nil {
+		return nil, err
+	}
+
+	return s.reply(ctx, customerID, sessionID, postback.Payload, request.Stream, w)
+}
+
+// handleAttachmentMessage 处理图片和文件消息，附带说明文字时按文本处理说明文字
+func (s *messageService) handleAttachmentMessage(ctx context.Context, customerID uint, sessionID uint, request *MessageRequest, w FrameWriter) (*MessageResponse, error) {
+	var attachmentMsg AttachmentMessage
+	if err := json.Unmarshal(request.Content, &attachmentMsg); err != nil {
+		return nil, err
+	}
+
+	if attachmentMsg.Caption != "" {
+		return s.reply(ctx, customerID, sessionID, attachmentMsg.Caption, request.Stream, w)
+	}
+	return newTextResponse(fmt.Sprintf("已收到您的附件：%s", attachmentMsg.FileName))
+}
+
+// reply 使用聊天服务生成回复，流式模式下逐段推送文本回复
+func (s *messageService) reply(ctx context.Context, customerID uint, sessionID uint, text string, stream bool, w FrameWriter) (*MessageResponse, error) {
+	// 消息在队列中等待期间可能已超时
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if stream && w != nil {
+		return s.streamTextReply(ctx, customerID, sessionID, text, w)
+	}
+
+	reply, err := s.chatService.ProcessText(ctx, customerID, sessionID, text)
+	if err != nil {
+		return nil, err
+	}
+	return newReplyResponse(reply)
+}
+
+// newReplyResponse 根据回复类型构造响应
+func newReplyResponse(reply *Reply) (*MessageResponse, error) {
+	if reply.Rich == nil {
+		return newTextResponse(reply.Text)
+	}
+
+	content, err := json.Marshal(reply.Rich)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageResponse{
+		Type:      reply.Type,
+		Content:   content,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// newTextResponse 构造文本回复
+func newTextResponse(text string) (*MessageResponse, error) {
+	content, err := json.Marshal(TextMessage{Text: text})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MessageResponse{
+		Type:      MessageTypeText,
+		Content:   content,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// requestText 返回消息的纯文本形式，无法解析的消息返回原始内容
+func requestText(request *MessageRequest) string {
+	if msg, err := DecodeMessage(request.Type, request.Content); err == nil {
+		return msg.PlainText()
+	}
+	return string(request.Content)
+}
+
+// streamTextReply 以 stream_start/stream_delta/stream_end 帧推送回复，返回 stream_end 帧。
+// 富消息回复不分段，直接返回完整消息
+func (s *messageService) streamTextReply(ctx context.Context, customerID uint, sessionID uint, text string, w FrameWriter) (*MessageResponse, error) {
+	replyID := uuid.New().String()
+
+	// 收到第一个片段时才发送 stream_start
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		return writeStreamFrame(w, MessageTypeStreamStart, StreamChunk{ReplyID: replyID})
+	}
+
+	reply, err := s.chatService.ProcessTextStream(ctx, customerID, sessionID, text, func(delta string) error {
+		if err := start(); err != nil {
+			return err
+		}
+		return writeStreamFrame(w, MessageTypeStreamDelta, StreamChunk{ReplyID: replyID, Delta: delta})
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// 3. 构造响应
-	content, err := json.Marshal(TextMessage{Text: reply})
+	if reply.Rich != nil && !started {
+		return newReplyResponse(reply)
+	}
+	if err := start(); err != nil {
+		return nil, err
+	}
+
+	content, err := json.Marshal(StreamChunk{ReplyID: replyID, Text: reply.Text})
 	if err != nil {
 		return nil, err
 	}
 
 	return &MessageResponse{
-		Type:      "text",
+		Type:      MessageTypeStreamEnd,
 		Content:   content,
 		Timestamp: time.Now(),
 	}, nil
 }
 
+// writeStreamFrame 序列化并推送一个流式帧
+func writeStreamFrame(w FrameWriter, msgType string, chunk StreamChunk) error {
+	content, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+
+	frame, err := json.Marshal(MessageResponse{
+		Type:      msgType,
+		Content:   content,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return w(frame)
+}
+
 // handleUnknownMessage 处理未知类型的消息
 func (s *messageService) handleUnknownMessage(customerID uint, request *MessageRequest) (*MessageResponse, error) {
 	content, _ := json.Marshal(TextMessage{Text: "抱歉，暂不支持该类型的消息"})
 
 	return &MessageResponse{
-		Type:      "text",
+		Type:      MessageTypeText,
 		Content:   content,
 		Timestamp: time.Now(),
 	}, nil
@@ -19,15 +19,17 @@ type MessageQueryParams struct {
 
 // MessageQueryResult 消息查询结果
 type MessageQueryResult struct {
-	Total    int64           `json:"total"`    // 总记录数
-	Messages []MessageDetail `json:"messages"` // 消息列表
+	Total       int64           `json:"total"`        // 总记录数
+	UnreadCount int64           `json:"unread_count"` // 客户未读的机器人和客服消息数，只按会话过滤
+	Messages    []MessageDetail `json:"messages"`     // 消息列表
 }
 
 // MessageDetail 消息详情
 type MessageDetail struct {
 	ID        uint   `json:"id"`
-	Content   string `json:"content"`
-	Sender    string `json:"sender"`     // customer 或 bot
+	Type      string `json:"type"`       // 消息类型，如 text、quick_replies、postback
+	Content   string `json:"content"`    // 按类型序列化的JSON
+	Sender    string `json:"sender"`     // customer、bot 或 agent
 	Seq       uint   `json:"seq"`        // 消息序号
 	CreatedAt string `json:"created_at"` // 格式化的时间字符串
 	SessionID uint   `json:"session_id"`
@@ -87,18 +89,47 @@ func (s *messageQueryService) GetMessageHistory(params MessageQueryParams) (*Mes
 	// 转换为 MessageDetail
 	details := make([]MessageDetail, len(messages))
 	for i, msg := range messages {
-		details[i] = MessageDetail{
-			ID:        msg.ID,
-			Content:   msg.Content,
-			Sender:    string(msg.Sender),
-			Seq:       msg.Seq,
-			CreatedAt: msg.CreatedAt.Format("2006-01-02 15:04:05"),
-			SessionID: msg.SessionID,
-		}
+		details[i] = toMessageDetail(msg)
+	}
+
+	unread, err := s.unreadCount(params.CustomerID, params.SessionID)
+	if err != nil {
+		return nil, err
 	}
 
 	return &MessageQueryResult{
-		Total:    total,
-		Messages: details,
+		Total:       total,
+		UnreadCount: unread,
+		Messages:    details,
 	}, nil
 }
+
+// unreadCount 统计客户已读位置之后的机器人和客服消息，sessionID 为 0 时统计所有会话
+func (s *messageQueryService) unreadCount(customerID uint, sessionID uint) (int64, error) {
+	query := s.db.Model(&model.Message{}).
+		Joins("LEFT JOIN read_receipts ON read_receipts.session_id = messages.session_id AND read_receipts.user_id = messages.customer_id").
+		Where("messages.customer_id = ? AND messages.sender <> ?", customerID, model.SenderCustomer).
+		Where("messages.seq > COALESCE(read_receipts.last_read_seq, 0)")
+	if sessionID > 0 {
+		query = query.Where("messages.session_id = ?", sessionID)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// toMessageDetail 将消息模型转换为 MessageDetail
+func toMessageDetail(msg model.Message) MessageDetail {
+	return MessageDetail{
+		ID:        msg.ID,
+		Type:      msg.Type,
+		Content:   msg.Content,
+		Sender:    string(msg.Sender),
+		Seq:       msg.Seq,
+		CreatedAt: msg.CreatedAt.Format("2006-01-02 15:04:05"),
+		SessionID: msg.SessionID,
+	}
+}
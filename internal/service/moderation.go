@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/moderation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMessageBlocked      = errors.New("消息未通过内容审核")
+	ErrFlagNotFound        = errors.New("审核记录不存在")
+	ErrInvalidReviewStatus = errors.New("无效的复核结果")
+	ErrFlagAlreadyReviewed = errors.New("审核记录已复核")
+)
+
+// blockedReply 机器人回复被拦截时改为回复的内容
+const blockedReply = "Sorry, I can't help with that. Please rephrase your question or ask for a human agent."
+
+// FlagInput 保存审核记录的参数
+type FlagInput struct {
+	CustomerID uint
+	SessionID  uint
+	MessageID  *uint // 被拦截的消息没有保存，为空
+	Direction  moderation.Direction
+	Content    string // 审核前的原始内容
+}
+
+// ModerationService 审核客户消息和机器人回复，保存需要人工复核的记录
+type ModerationService interface {
+	// Moderate 审核一段文本。未开启该方向的审核时返回 nil
+	Moderate(ctx context.Context, direction moderation.Direction, text string) (*moderation.Verdict, error)
+	// Flag 保存需要人工复核的消息及审核结果。已保存过同一消息同方向的记录时（重新处理重发的消息）不重复保存
+	Flag(input FlagInput, verdict *moderation.Verdict) error
+	// ListFlags 分页获取审核记录，status 为空时返回所有状态，最新的在前
+	ListFlags(status string, page, pageSize int) ([]model.ModerationFlag, int64, error)
+	// ReviewFlag 复核一条待复核的记录，status 为 dismissed 或 confirmed
+	ReviewFlag(id uint, reviewerID uint, status string, note string) (*model.ModerationFlag, error)
+}
+
+type moderationService struct {
+	db        *gorm.DB
+	moderator moderation.Moderator
+	privacy   PrivacyService
+	config    config.ModerationConfig
+}
+
+// NewModerationService 创建内容审核服务实例，moderator 为空时不审核，只提供复核记录的查询和处理。
+// 审核记录中的内容以 privacy 脱敏后保存，privacy 为空时原样保存
+func NewModerationService(db *gorm.DB, moderator moderation.Moderator, privacy PrivacyService, cfg config.ModerationConfig) ModerationService {
+	return &moderationService{
+		db:        db,
+		moderator: moderator,
+		privacy:   privacy,
+		config:    cfg,
+	}
+}
+
+// Moderate 审核一段文本
+func (s *moderationService) Moderate(ctx context.Context, direction moderation.Direction, text string) (*moderation.Verdict, error) {
+	if s.moderator == nil || !s.config.Enabled || text == "" {
+		return nil, nil
+	}
+	if direction == moderation.DirectionOutbound && !s.config.Outbound {
+		return nil, nil
+	}
+	return s.moderator.Moderate(ctx, text, direction)
+}
+
+// Flag 保存审核记录
+func (s *moderationService) Flag(input FlagInput, verdict *moderation.Verdict) error {
+	// 原文和命中的片段都脱敏后保存，复核记录中不留个人信息
+	stored := *verdict
+	stored.Matches = make([]moderation.Match, len(verdict.Matches))
+	for i, match := range verdict.Matches {
+		match.Text = s.redact(match.Text)
+		stored.Matches[i] = match
+	}
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	flag := &model.ModerationFlag{
+		CustomerID: input.CustomerID,
+		SessionID:  input.SessionID,
+		MessageID:  input.MessageID,
+		Direction:  string(input.Direction),
+		Content:    s.redact(input.Content),
+		Action:     string(verdict.Action),
+		Categories: strings.Join(verdict.Categories, ","),
+		Verdict:    string(data),
+		Status:     model.ModerationStatusPending,
+	}
+	// 重新处理重发的消息时同一消息同方向的记录已存在，由唯一索引保证不重复保存
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}, {Name: "direction"}},
+		DoNothing: true,
+	}).Create(flag).Error
+	if err != nil {
+		return fmt.Errorf("failed to save moderation flag: %v", err)
+	}
+	return nil
+}
+
+// redact 替换文本中的个人信息
+func (s *moderationService) redact(text string) string {
+	if s.privacy == nil {
+		return text
+	}
+	redacted, _ := s.privacy.Redact(text)
+	return redacted
+}
+
+// ListFlags 分页获取审核记录
+func (s *moderationService) ListFlags(status string, page, pageSize int) ([]model.ModerationFlag, int64, error) {
+	query := s.db.Model(&model.ModerationFlag{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var flags []model.ModerationFlag
+	if err := query.Order("id desc").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&flags).Error; err != nil {
+		return nil, 0, err
+	}
+	return flags, total, nil
+}
+
+// ReviewFlag 复核审核记录，已复核的记录不能再次复核
+func (s *moderationService) ReviewFlag(id uint, reviewerID uint, status string, note string) (*model.ModerationFlag, error) {
+	if status != model.ModerationStatusDismissed && status != model.ModerationStatusConfirmed {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidReviewStatus, status)
+	}
+
+	now := time.Now()
+	result := s.db.Model(&model.ModerationFlag{}).
+		Where("id = ? AND status = ?", id, model.ModerationStatusPending).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"reviewer_id": reviewerID,
+			"review_note": note,
+			"reviewed_at": now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var flag model.ModerationFlag
+	if err := s.db.First(&flag, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFlagNotFound
+		}
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrFlagAlreadyReviewed
+	}
+	return &flag, nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/model"
+	"github.com/JennerWork/chatbot/internal/pii"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrVaultDisabled      = errors.New("未开启个人信息保险库")
+	ErrVaultEntryNotFound = errors.New("消息没有保存原文")
+)
+
+// sealedFieldPrefix 加密保存的字段值的前缀
+const sealedFieldPrefix = "vault:"
+
+// VaultEntry 脱敏消息的原文
+type VaultEntry struct {
+	MessageID  uint      `json:"message_id"`
+	CustomerID uint      `json:"customer_id"`
+	SessionID  uint      `json:"session_id"`
+	Kinds      []string  `json:"kinds"`   // 原文中的个人信息类型
+	Content    string    `json:"content"` // 脱敏前的消息内容
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PrivacyService 消息中个人信息的脱敏和原文的加密保存
+type PrivacyService interface {
+	// Redact 以占位符替换文本中的个人信息，返回替换后的文本和命中的内容。未开启脱敏时原样返回
+	Redact(text string) (string, []pii.Finding)
+	// Seal 在事务 tx 中加密保存消息脱敏前的内容，未开启保险库时不保存
+	Seal(tx *gorm.DB, message *model.Message, original string, findings []pii.Finding) error
+	// Reveal 解密消息的原文，adminID 为查看的管理员，记录在日志中
+	Reveal(messageID uint, adminID uint) (*VaultEntry, error)
+	// ProtectField 返回字段保存到数据库的值，用于之后还需要原文的字段（如流程槽位）：开启保险库时加密整个值，
+	// 否则替换其中的个人信息。label 标识字段，密文不能挪用到其他字段。未开启脱敏时原样返回
+	ProtectField(label string, value string) (string, error)
+	// RecoverField 还原 ProtectField 保存的值，未加密的值原样返回
+	RecoverField(label string, stored string) (string, error)
+}
+
+type privacyService struct {
+	db       *gorm.DB
+	redactor *pii.Redactor
+	aead     cipher.AEAD // 未开启保险库时为空
+	config   config.PrivacyConfig
+}
+
+// NewPrivacyService 创建个人信息保护服务实例，开启保险库时密钥必须是 base64 编码的 32 字节
+func NewPrivacyService(db *gorm.DB, cfg config.PrivacyConfig) (PrivacyService, error) {
+	s := &privacyService{
+		db:       db,
+		redactor: pii.NewRedactor(),
+		config:   cfg,
+	}
+	if !cfg.Vault {
+		return s, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.VaultKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault key: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid vault key: want 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Redact 以占位符替换文本中的个人信息
+func (s *privacyService) Redact(text string) (string, []pii.Finding) {
+	if !s.config.Redact || text == "" {
+		return text, nil
+	}
+	return s.redactor.Redact(text)
+}
+
+// Seal 加密保存消息的原文，消息ID作为附加数据，密文不能挪用到其他消息
+func (s *privacyService) Seal(tx *gorm.DB, message *model.Message, original string, findings []pii.Finding) error {
+	if s.aead == nil || len(findings) == 0 {
+		return nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(original), vaultAdditionalData(message.ID))
+
+	kinds := pii.Kinds(findings)
+	names := make([]string, len(kinds))
+	for i, kind := range kinds {
+		names[i] = string(kind)
+	}
+	entry := &model.PIIVaultEntry{
+		MessageID:  message.ID,
+		CustomerID: message.CustomerID,
+		SessionID:  message.SessionID,
+		Kinds:      strings.Join(names, ","),
+		Ciphertext: base64.StdEncoding.EncodeToString(sealed),
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to save vault entry: %v", err)
+	}
+	return nil
+}
+
+// Reveal 解密消息的原文
+func (s *privacyService) Reveal(messageID uint, adminID uint) (*VaultEntry, error) {
+	if s.aead == nil {
+		return nil, ErrVaultDisabled
+	}
+
+	var entry model.PIIVaultEntry
+	if err := s.db.Where("message_id = ?", messageID).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrVaultEntryNotFound
+		}
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(entry.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault entry of message %d: %v", messageID, err)
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("invalid vault entry of message %d: ciphertext too short", messageID)
+	}
+	original, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], vaultAdditionalData(messageID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault entry of message %d: %v", messageID, err)
+	}
+	log.Printf("PII vault entry of message %d read by admin %d", messageID, adminID)
+
+	return &VaultEntry{
+		MessageID:  entry.MessageID,
+		CustomerID: entry.CustomerID,
+		SessionID:  entry.SessionID,
+		Kinds:      strings.Split(entry.Kinds, ","),
+		Content:    string(original),
+		CreatedAt:  entry.CreatedAt,
+	}, nil
+}
+
+// ProtectField 加密或脱敏字段值
+func (s *privacyService) ProtectField(label string, value string) (string, error) {
+	if !s.config.Redact || value == "" {
+		return value, nil
+	}
+	if s.aead == nil {
+		redacted, _ := s.redactor.Redact(value)
+		return redacted, nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), []byte(label))
+	return sealedFieldPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// RecoverField 解密加密保存的字段值
+func (s *privacyService) RecoverField(label string, stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedFieldPrefix) {
+		return stored, nil
+	}
+	if s.aead == nil {
+		return "", fmt.Errorf("%w: cannot decrypt %s", ErrVaultDisabled, label)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedFieldPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed %s: %v", label, err)
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("invalid sealed %s: ciphertext too short", label)
+	}
+	value, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(label))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %v", label, err)
+	}
+	return string(value), nil
+}
+
+// vaultAdditionalData 加密原文时的附加数据
+func vaultAdditionalData(messageID uint) []byte {
+	return []byte("message:" + strconv.FormatUint(uint64(messageID), 10))
+}
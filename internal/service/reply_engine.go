@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/model"
+)
+
+const (
+	// ReplyEngineEcho 回显引擎
+	ReplyEngineEcho = "echo"
+	// ReplyEngineLLM OpenAI兼容的大模型引擎
+	ReplyEngineLLM = "llm"
+)
+
+// ReplyEngine 根据用户输入和会话上下文生成机器人回复
+type ReplyEngine interface {
+	// GenerateReply 生成回复，history 为按时间升序排列的历史消息（不含本条输入），ctx 结束时应尽快返回
+	GenerateReply(ctx context.Context, customerID uint, sessionID uint, text string, history []model.Message) (string, error)
+}
+
+// StreamingReplyEngine 支持流式输出的回复引擎
+type StreamingReplyEngine interface {
+	ReplyEngine
+	// StreamReply 生成回复并逐段回调 onDelta，返回拼接后的完整回复
+	StreamReply(ctx context.Context, customerID uint, sessionID uint, text string, history []model.Message, onDelta func(delta string) error) (string, error)
+}
+
+// NewReplyEngine 根据配置创建回复引擎
+func NewReplyEngine(cfg config.ReplyConfig) (ReplyEngine, error) {
+	switch cfg.Engine {
+	case "", ReplyEngineEcho:
+		return NewEchoReplyEngine(), nil
+	case ReplyEngineLLM:
+		return NewLLMReplyEngine(cfg.LLM)
+	default:
+		return nil, fmt.Errorf("unknown reply engine: %s", cfg.Engine)
+	}
+}
+
+// echoReplyEngine 将用户输入原样返回
+type echoReplyEngine struct{}
+
+// NewEchoReplyEngine 创建回显引擎
+func NewEchoReplyEngine() ReplyEngine {
+	return &echoReplyEngine{}
+}
+
+// GenerateReply 回显用户输入
+func (e *echoReplyEngine) GenerateReply(ctx context.Context, customerID uint, sessionID uint, text string, history []model.Message) (string, error) {
+	return fmt.Sprintf("I have received your message: %s\nIf you are satisfied with the service, you can enter 'feedback' to provide a review.", text), nil
+}
+
+// messageText 返回存储的消息的纯文本形式
+func messageText(msg model.Message) string {
+	msgType := msg.Type
+	if msgType == "" {
+		msgType = MessageTypeText
+	}
+	if decoded, err := DecodeMessage(msgType, json.RawMessage(msg.Content)); err == nil {
+		return decoded.PlainText()
+	}
+	return msg.Content
+}
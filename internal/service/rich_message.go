@@ -0,0 +1,299 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// 富消息类型
+const (
+	MessageTypeQuickReplies   = "quick_replies"   // 文本加快捷回复选项
+	MessageTypeCard           = "card"            // 卡片：标题、图片和按钮
+	MessageTypeCarousel       = "carousel"        // 多张卡片横向排列
+	MessageTypeButtonPostback = "button_postback" // 文本加回传按钮
+	MessageTypePostback       = "postback"        // 客户点击按钮或快捷回复后回传的事件
+)
+
+// 富消息的长度和数量限制
+const (
+	maxTitleLength   = 80
+	maxTextLength    = 2000
+	maxPayloadLength = 1000
+	maxQuickReplies  = 13
+	maxButtons       = 3
+	maxCarouselCards = 10
+)
+
+var (
+	ErrUnsupportedMessageType = errors.New("unsupported message type")
+	ErrInvalidMessage         = errors.New("invalid message")
+)
+
+// RichMessage 可校验、可降级为纯文本的消息内容
+type RichMessage interface {
+	// Validate 校验消息内容
+	Validate() error
+	// PlainText 返回纯文本形式，用于不支持富消息的场景（如大模型上下文、转发给客服）
+	PlainText() string
+}
+
+// Button 按钮或快捷回复。设置 Payload 时点击后回传 postback 事件，设置 URL 时打开链接
+type Button struct {
+	Title   string `json:"title"`
+	Payload string `json:"payload,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// QuickRepliesMessage 文本加快捷回复选项，选项只能回传 payload
+type QuickRepliesMessage struct {
+	Text    string   `json:"text"`
+	Replies []Button `json:"replies"`
+}
+
+// CardMessage 卡片消息
+type CardMessage struct {
+	Title    string   `json:"title"`
+	Subtitle string   `json:"subtitle,omitempty"`
+	ImageURL string   `json:"image_url,omitempty"`
+	Buttons  []Button `json:"buttons,omitempty"`
+}
+
+// CarouselMessage 多张卡片组成的轮播消息
+type CarouselMessage struct {
+	Cards []CardMessage `json:"cards"`
+}
+
+// ButtonPostbackMessage 文本加按钮
+type ButtonPostbackMessage struct {
+	Text    string   `json:"text"`
+	Buttons []Button `json:"buttons"`
+}
+
+// PostbackMessage 客户回传的按钮事件，Title 为被点击按钮的标题
+type PostbackMessage struct {
+	Payload string `json:"payload"`
+	Title   string `json:"title,omitempty"`
+}
+
+// DecodeMessage 按消息类型解析并校验消息内容，不允许出现未定义的字段
+func DecodeMessage(msgType string, content json.RawMessage) (RichMessage, error) {
+	var msg RichMessage
+	switch msgType {
+	case MessageTypeText:
+		msg = &TextMessage{}
+	case MessageTypeQuickReplies:
+		msg = &QuickRepliesMessage{}
+	case MessageTypeCard:
+		msg = &CardMessage{}
+	case MessageTypeCarousel:
+		msg = &CarouselMessage{}
+	case MessageTypeButtonPostback:
+		msg = &ButtonPostbackMessage{}
+	case MessageTypePostback:
+		msg = &PostbackMessage{}
+	case MessageTypeImage, MessageTypeFile:
+		msg = &AttachmentMessage{}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMessageType, msgType)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(content)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(msg); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidMessage, msgType, err)
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidMessage, msgType, err)
+	}
+	return msg, nil
+}
+
+// Validate 校验文本消息
+func (m *TextMessage) Validate() error {
+	if strings.TrimSpace(m.Text) == "" {
+		return errors.New("text is required")
+	}
+	return nil
+}
+
+// PlainText 返回文本
+func (m *TextMessage) PlainText() string {
+	return m.Text
+}
+
+// Validate 校验按钮
+func (b Button) Validate() error {
+	if err := checkLength("title", b.Title, maxTitleLength, true); err != nil {
+		return err
+	}
+	if err := checkLength("payload", b.Payload, maxPayloadLength, false); err != nil {
+		return err
+	}
+	switch {
+	case b.Payload == "" && b.URL == "":
+		return errors.New("button needs a payload or a url")
+	case b.Payload != "" && b.URL != "":
+		return errors.New("button cannot have both a payload and a url")
+	case b.URL != "":
+		return checkURL("url", b.URL)
+	}
+	return nil
+}
+
+// Validate 校验快捷回复消息
+func (m *QuickRepliesMessage) Validate() error {
+	if err := checkLength("text", m.Text, maxTextLength, true); err != nil {
+		return err
+	}
+	if len(m.Replies) == 0 || len(m.Replies) > maxQuickReplies {
+		return fmt.Errorf("quick replies must have 1 to %d replies", maxQuickReplies)
+	}
+	for i, reply := range m.Replies {
+		if reply.URL != "" {
+			return fmt.Errorf("reply #%d: quick replies cannot open urls", i+1)
+		}
+		if err := reply.Validate(); err != nil {
+			return fmt.Errorf("reply #%d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// PlainText 文本后附带编号选项
+func (m *QuickRepliesMessage) PlainText() string {
+	return m.Text + formatButtons(m.Replies)
+}
+
+// Validate 校验卡片
+func (m *CardMessage) Validate() error {
+	if err := checkLength("title", m.Title, maxTitleLength, true); err != nil {
+		return err
+	}
+	if err := checkLength("subtitle", m.Subtitle, maxTitleLength, false); err != nil {
+		return err
+	}
+	if m.ImageURL != "" {
+		if err := checkURL("image_url", m.ImageURL); err != nil {
+			return err
+		}
+	}
+	if len(m.Buttons) > maxButtons {
+		return fmt.Errorf("a card can have at most %d buttons", maxButtons)
+	}
+	for i, button := range m.Buttons {
+		if err := button.Validate(); err != nil {
+			return fmt.Errorf("button #%d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// PlainText 标题、副标题后附带编号按钮
+func (m *CardMessage) PlainText() string {
+	text := m.Title
+	if m.Subtitle != "" {
+		text += "\n" + m.Subtitle
+	}
+	return text + formatButtons(m.Buttons)
+}
+
+// Validate 校验轮播消息
+func (m *CarouselMessage) Validate() error {
+	if len(m.Cards) == 0 || len(m.Cards) > maxCarouselCards {
+		return fmt.Errorf("a carousel must have 1 to %d cards", maxCarouselCards)
+	}
+	for i := range m.Cards {
+		if err := m.Cards[i].Validate(); err != nil {
+			return fmt.Errorf("card #%d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// PlainText 依次列出每张卡片
+func (m *CarouselMessage) PlainText() string {
+	cards := make([]string, len(m.Cards))
+	for i := range m.Cards {
+		cards[i] = m.Cards[i].PlainText()
+	}
+	return strings.Join(cards, "\n\n")
+}
+
+// Validate 校验按钮消息
+func (m *ButtonPostbackMessage) Validate() error {
+	if err := checkLength("text", m.Text, maxTextLength, true); err != nil {
+		return err
+	}
+	if len(m.Buttons) == 0 || len(m.Buttons) > maxButtons {
+		return fmt.Errorf("button messages must have 1 to %d buttons", maxButtons)
+	}
+	for i, button := range m.Buttons {
+		if err := button.Validate(); err != nil {
+			return fmt.Errorf("button #%d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// PlainText 文本后附带编号按钮
+func (m *ButtonPostbackMessage) PlainText() string {
+	return m.Text + formatButtons(m.Buttons)
+}
+
+// Validate 校验回传事件
+func (m *PostbackMessage) Validate() error {
+	if err := checkLength("payload", m.Payload, maxPayloadLength, true); err != nil {
+		return err
+	}
+	return checkLength("title", m.Title, maxTitleLength, false)
+}
+
+// PlainText 优先返回按钮标题
+func (m *PostbackMessage) PlainText() string {
+	if m.Title != "" {
+		return m.Title
+	}
+	return m.Payload
+}
+
+// checkLength 校验字段长度（按字符计）
+func checkLength(field, value string, max int, required bool) error {
+	if required && strings.TrimSpace(value) == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if utf8.RuneCountInString(value) > max {
+		return fmt.Errorf("%s exceeds %d characters", field, max)
+	}
+	return nil
+}
+
+// checkURL 校验 http(s) 链接
+func checkURL(field, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an http(s) url", field)
+	}
+	return nil
+}
+
+// formatButtons 将按钮渲染为编号列表
+func formatButtons(buttons []Button) string {
+	var b strings.Builder
+	for i, button := range buttons {
+		fmt.Fprintf(&b, "\n%d. %s", i+1, button.Title)
+	}
+	return b.String()
+}
+
+// choiceButtons 将选项转换为回传选项本身的快捷回复
+func choiceButtons(choices []string) []Button {
+	buttons := make([]Button, len(choices))
+	for i, choice := range choices {
+		buttons[i] = Button{Title: choice, Payload: choice}
+	}
+	return buttons
+}
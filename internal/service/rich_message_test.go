@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		msgType   string
+		content   string
+		wantErr   error
+		wantPlain string
+	}{
+		{"text", MessageTypeText, `{"text":"hi"}`, nil, "hi"},
+		{"blank text", MessageTypeText, `{"text":"  "}`, ErrInvalidMessage, ""},
+		{"unknown field", MessageTypeText, `{"text":"hi","color":"red"}`, ErrInvalidMessage, ""},
+		{"unknown type", "video", `{}`, ErrUnsupportedMessageType, ""},
+		{
+			"quick replies", MessageTypeQuickReplies,
+			`{"text":"Pick one","replies":[{"title":"A","payload":"a"},{"title":"B","payload":"b"}]}`,
+			nil, "Pick one\n1. A\n2. B",
+		},
+		{"quick replies without replies", MessageTypeQuickReplies, `{"text":"Pick one","replies":[]}`, ErrInvalidMessage, ""},
+		{
+			"quick reply with url", MessageTypeQuickReplies,
+			`{"text":"Pick one","replies":[{"title":"A","url":"https://example.com"}]}`,
+			ErrInvalidMessage, "",
+		},
+		{
+			"card", MessageTypeCard,
+			`{"title":"Plan","subtitle":"$10","image_url":"https://example.com/a.png","buttons":[{"title":"Buy","payload":"buy"}]}`,
+			nil, "Plan\n$10\n1. Buy",
+		},
+		{"card without title", MessageTypeCard, `{"subtitle":"$10"}`, ErrInvalidMessage, ""},
+		{"card with invalid image url", MessageTypeCard, `{"title":"Plan","image_url":"javascript:alert(1)"}`, ErrInvalidMessage, ""},
+		{
+			"card with too many buttons", MessageTypeCard,
+			`{"title":"Plan","buttons":[{"title":"1","payload":"1"},{"title":"2","payload":"2"},{"title":"3","payload":"3"},{"title":"4","payload":"4"}]}`,
+			ErrInvalidMessage, "",
+		},
+		{"carousel", MessageTypeCarousel, `{"cards":[{"title":"A"},{"title":"B"}]}`, nil, "A\n\nB"},
+		{"empty carousel", MessageTypeCarousel, `{"cards":[]}`, ErrInvalidMessage, ""},
+		{"carousel with invalid card", MessageTypeCarousel, `{"cards":[{"title":"A"},{}]}`, ErrInvalidMessage, ""},
+		{
+			"button postback", MessageTypeButtonPostback,
+			`{"text":"Open?","buttons":[{"title":"Site","url":"https://example.com"}]}`,
+			nil, "Open?\n1. Site",
+		},
+		{
+			"button with payload and url", MessageTypeButtonPostback,
+			`{"text":"Open?","buttons":[{"title":"Site","payload":"p","url":"https://example.com"}]}`,
+			ErrInvalidMessage, "",
+		},
+		{"button without action", MessageTypeButtonPostback, `{"text":"Open?","buttons":[{"title":"Site"}]}`, ErrInvalidMessage, ""},
+		{"postback", MessageTypePostback, `{"payload":"buy","title":"Buy"}`, nil, "Buy"},
+		{"postback without title", MessageTypePostback, `{"payload":"buy"}`, nil, "buy"},
+		{"postback without payload", MessageTypePostback, `{"title":"Buy"}`, ErrInvalidMessage, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := DecodeMessage(tt.msgType, []byte(tt.content))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecodeMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && msg.PlainText() != tt.wantPlain {
+				t.Errorf("PlainText() = %q, want %q", msg.PlainText(), tt.wantPlain)
+			}
+		})
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		required bool
+		wantErr  bool
+	}{
+		{"at limit", strings.Repeat("a", maxTitleLength), true, false},
+		{"over limit", strings.Repeat("a", maxTitleLength+1), true, true},
+		{"counts characters not bytes", strings.Repeat("好", maxTitleLength), true, false},
+		{"optional empty", "", false, false},
+		{"required blank", " ", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLength("title", tt.value, maxTitleLength, tt.required)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkLength() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
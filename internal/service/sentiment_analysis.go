@@ -3,8 +3,28 @@ package service
 import (
 	"fmt"
 	"log"
+	"strings"
 )
 
+// 情感分析结果
+const (
+	SentimentPositive = "positive"
+	SentimentNegative = "negative"
+	SentimentNeutral  = "neutral"
+)
+
+// negativeLexicon 明显负面情绪的词语
+var negativeLexicon = []string{
+	"angry", "terrible", "awful", "useless", "worst", "ridiculous", "hate", "furious", "stupid", "complain",
+	"生气", "愤怒", "垃圾", "太差", "投诉", "没用", "气死", "恶心", "骗子", "受不了",
+}
+
+// positiveLexicon 明显正面情绪的词语
+var positiveLexicon = []string{
+	"thanks", "thank you", "great", "awesome", "excellent", "helpful", "love",
+	"谢谢", "感谢", "很好", "太棒", "满意", "不错",
+}
+
 // SentimentAnalysisService 提供情感分析服务
 type SentimentAnalysisService struct{}
 
@@ -39,3 +59,29 @@ func (s *SentimentAnalysisService) mockAPICall(prompt string) string {
 	log.Println(prompt)
 	return "neutral" // 示例返回值
 }
+
+// AnalyzeText 基于词典对聊天消息做快速情感判断，用于实时判断是否需要转人工
+func (s *SentimentAnalysisService) AnalyzeText(text string) string {
+	text = strings.ToLower(text)
+
+	score := 0
+	for _, word := range negativeLexicon {
+		if strings.Contains(text, word) {
+			score--
+		}
+	}
+	for _, word := range positiveLexicon {
+		if strings.Contains(text, word) {
+			score++
+		}
+	}
+
+	switch {
+	case score < 0:
+		return SentimentNegative
+	case score > 0:
+		return SentimentPositive
+	default:
+		return SentimentNeutral
+	}
+}
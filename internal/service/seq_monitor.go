@@ -0,0 +1,99 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/JennerWork/chatbot/internal/config"
+	"github.com/JennerWork/chatbot/internal/dao"
+	"gorm.io/gorm"
+)
+
+// maxSeqGapsPerCheck 每次检查最多报告的不连续位置
+const maxSeqGapsPerCheck = 100
+
+// SeqMonitor 后台检查消息序号的连续性。每次检查上次检查之后有新消息的会话，
+// 最近 check_lookback 内写入消息的会话每次都重新检查，覆盖提交较晚的事务写入的消息；
+// 发现不连续时记录日志，开启自动修复时重新编号
+type SeqMonitor struct {
+	messageDAO *dao.MessageDAO
+	config     config.SequenceConfig
+	lastID     uint // 该ID及之前的消息都已提交并检查过
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// NewSeqMonitor 创建消息序号检查器
+func NewSeqMonitor(db *gorm.DB, cfg config.SequenceConfig) *SeqMonitor {
+	if cfg.CheckLookback <= 0 {
+		cfg.CheckLookback = 5 * time.Minute
+	}
+	return &SeqMonitor{
+		messageDAO: dao.NewMessageDAO(db),
+		config:     cfg,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start 启动定期检查，检查间隔为 0 时不启动。首次检查覆盖所有会话
+func (m *SeqMonitor) Start() {
+	if m.config.CheckInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.config.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.Check(); err != nil {
+					log.Printf("Message sequence check failed: %v", err)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止定期检查
+func (m *SeqMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// Check 检查上次检查之后有新消息的会话
+func (m *SeqMonitor) Check() error {
+	sessionIDs, nextID, err := m.messageDAO.SessionsWithMessagesAfter(m.lastID, time.Now().Add(-m.config.CheckLookback))
+	if err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		gaps, err := m.messageDAO.FindSeqGaps(sessionID, maxSeqGapsPerCheck)
+		if err != nil {
+			return err
+		}
+		if len(gaps) == 0 {
+			continue
+		}
+
+		for _, gap := range gaps {
+			log.Printf("Message sequence of session %d is not continuous: %d -> %d", gap.SessionID, gap.After, gap.Next)
+		}
+		if !m.config.AutoRepair {
+			continue
+		}
+		renumbered, err := m.messageDAO.RepairSessionSeq(sessionID)
+		if err != nil {
+			return err
+		}
+		log.Printf("Message sequence of session %d repaired (%d messages renumbered)", sessionID, renumbered)
+	}
+
+	m.lastID = nextID
+	return nil
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/JennerWork/chatbot/internal/dao"
+	"github.com/JennerWork/chatbot/internal/model"
+	"gorm.io/gorm"
+)
+
+// 增量同步相关的消息类型
+const (
+	MessageTypeSync         = "sync"          // 客户端请求：补齐各会话中缺失的消息
+	MessageTypeSyncMessage  = "sync_message"  // 返回给客户端：一条缺失的消息，按序号升序推送
+	MessageTypeSyncComplete = "sync_complete" // 返回给客户端：本次同步结束
+)
+
+// 同步限制
+const (
+	maxSyncSessions = 20  // 一次同步最多包含的会话数
+	maxSyncMessages = 500 // 一次同步最多返回的消息数，超出时 has_more 为 true
+	syncPageSize    = 100 // 每次从数据库读取的消息数
+)
+
+var (
+	ErrSyncSessionNotFound = errors.New("同步的会话不存在")
+)
+
+// SessionCursor 客户端在某个会话中已收到的最后一条消息序号
+type SessionCursor struct {
+	SessionID uint `json:"session_id"`
+	LastSeq   uint `json:"last_seq"`
+}
+
+// SyncRequest 同步请求，WebSocket sync 命令的内容和 REST 接口的请求体
+type SyncRequest struct {
+	Sessions []SessionCursor `json:"sessions"`
+}
+
+// SyncComplete 同步结束标记，Sessions 为同步后各会话的最后序号。
+// HasMore 为 true 时还有消息未返回，客户端应使用新的序号再次同步
+type SyncComplete struct {
+	Sessions []SessionCursor `json:"sessions"`
+	HasMore  bool            `json:"has_more"`
+}
+
+// SyncResult 同步结果
+type SyncResult struct {
+	Messages []MessageDetail `json:"messages"`
+	Complete SyncComplete    `json:"complete"`
+}
+
+// SyncService 基于消息序号的增量同步服务
+type SyncService interface {
+	// Sync 返回客户各会话中序号大于 LastSeq 的消息，按会话和序号升序排列
+	Sync(customerID uint, req SyncRequest) (*SyncResult, error)
+}
+
+type syncService struct {
+	db         *gorm.DB
+	messageDAO *dao.MessageDAO
+}
+
+// NewSyncService 创建增量同步服务实例
+func NewSyncService(db *gorm.DB) SyncService {
+	return &syncService{
+		db:         db,
+		messageDAO: dao.NewMessageDAO(db),
+	}
+}
+
+// Sync 增量同步
+func (s *syncService) Sync(customerID uint, req SyncRequest) (*SyncResult, error) {
+	cursors, err := s.ownedCursors(customerID, req.Sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{Messages: []MessageDetail{}}
+	for _, cursor := range cursors {
+		lastSeq := cursor.LastSeq
+		// 达到上限后不再读取，其余会话原样返回序号，由客户端再次同步
+		for !result.Complete.HasMore {
+			remaining := maxSyncMessages - len(result.Messages)
+			if remaining <= 0 {
+				result.Complete.HasMore = true
+				break
+			}
+			limit := syncPageSize
+			if remaining < limit {
+				limit = remaining
+			}
+
+			// 多取一条用于判断是否还有消息
+			messages, err := s.messageDAO.GetMessages(dao.MessageQuery{
+				SessionID: cursor.SessionID,
+				LastSeq:   &lastSeq,
+				Limit:     limit + 1,
+			})
+			if err != nil {
+				return nil, err
+			}
+			more := len(messages) > limit
+			if more {
+				messages = messages[:limit]
+			}
+
+			for _, msg := range messages {
+				result.Messages = append(result.Messages, toMessageDetail(msg))
+				lastSeq = msg.Seq
+			}
+			if !more {
+				break
+			}
+		}
+		result.Complete.Sessions = append(result.Complete.Sessions, SessionCursor{
+			SessionID: cursor.SessionID,
+			LastSeq:   lastSeq,
+		})
+	}
+	return result, nil
+}
+
+// ownedCursors 去重并校验会话属于该客户
+func (s *syncService) ownedCursors(customerID uint, sessions []SessionCursor) ([]SessionCursor, error) {
+	seen := make(map[uint]bool, len(sessions))
+	cursors := make([]SessionCursor, 0, len(sessions))
+	ids := make([]uint, 0, len(sessions))
+	for _, cursor := range sessions {
+		if cursor.SessionID == 0 || seen[cursor.SessionID] {
+			continue
+		}
+		seen[cursor.SessionID] = true
+		cursors = append(cursors, cursor)
+		ids = append(ids, cursor.SessionID)
+	}
+	if len(cursors) > maxSyncSessions {
+		return nil, fmt.Errorf("%w: at most %d sessions can be synced at once", ErrInvalidMessage, maxSyncSessions)
+	}
+	if len(ids) == 0 {
+		return cursors, nil
+	}
+
+	var count int64
+	if err := s.db.Model(&model.Session{}).
+		Where("id IN ? AND customer_id = ?", ids, customerID).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(ids) {
+		return nil, ErrSyncSessionNotFound
+	}
+	return cursors, nil
+}
+
+// messageExtra 推送已保存消息时附带的信息，客户端据此记录各会话收到的最后序号
+func messageExtra(msg *model.Message) (json.RawMessage, error) {
+	extra := map[string]interface{}{
+		"message_id": msg.ID,
+		"session_id": msg.SessionID,
+		"seq":        msg.Seq,
+		"sender":     msg.Sender,
+	}
+	if msg.AgentID != nil {
+		extra["agent_id"] = *msg.AgentID
+	}
+	return json.Marshal(extra)
+}
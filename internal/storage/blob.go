@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrBlobNotFound 对象不存在
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrInvalidKey 对象键不合法
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore 二进制对象存储，键为以 / 分隔的相对路径
+type BlobStore interface {
+	// Put 写入对象，返回写入的字节数；同名对象会被覆盖
+	Put(key string, r io.Reader) (int64, error)
+	// Open 读取对象，对象不存在时返回 ErrBlobNotFound
+	Open(key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(key string) error
+}
+
+// FSBlobStore 基于本地文件系统的对象存储
+type FSBlobStore struct {
+	root string
+}
+
+// NewFSBlobStore 创建以 root 为根目录的对象存储，目录不存在时自动创建
+func NewFSBlobStore(root string) (*FSBlobStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &FSBlobStore{root: root}, nil
+}
+
+// Put 先写入临时文件再重命名，避免读到写了一半的对象
+func (s *FSBlobStore) Put(key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Open 打开对象文件
+func (s *FSBlobStore) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+// Delete 删除对象文件
+func (s *FSBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path 将键转换为根目录下的文件路径，拒绝绝对路径和跳出根目录的键
+func (s *FSBlobStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	cleaned := filepath.Clean(filepath.FromSlash(key))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.root, cleaned), nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFSBlobStorePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    string // 相对根目录的路径
+		wantErr bool
+	}{
+		{"nested key", "2024/01/02/abc", "2024/01/02/abc", false},
+		{"cleaned", "a/./b//c", "a/b/c", false},
+		{"dot dot inside root", "a/../b", "b", false},
+		{"empty", "", "", true},
+		{"absolute", "/etc/passwd", "", true},
+		{"backslash", `a\..\..\b`, "", true},
+		{"parent", "..", "", true},
+		{"escapes root", "../outside", "", true},
+		{"escapes root after cleaning", "a/../../outside", "", true},
+		{"root itself", "a/..", "", true},
+	}
+	root := t.TempDir()
+	s, err := NewFSBlobStore(root)
+	if err != nil {
+		t.Fatalf("NewFSBlobStore failed: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.path(tt.key)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Errorf("path(%q) = %q, %v, want ErrInvalidKey", tt.key, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("path(%q) failed: %v", tt.key, err)
+			}
+			if want := filepath.Join(s.root, filepath.FromSlash(tt.want)); got != want {
+				t.Errorf("path(%q) = %q, want %q", tt.key, got, want)
+			}
+		})
+	}
+}
+
+func TestFSBlobStore(t *testing.T) {
+	s, err := NewFSBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFSBlobStore failed: %v", err)
+	}
+
+	if n, err := s.Put("a/b", strings.NewReader("first")); err != nil || n != 5 {
+		t.Fatalf("Put() = %d, %v, want 5", n, err)
+	}
+	if _, err := s.Put("a/b", strings.NewReader("second")); err != nil {
+		t.Fatalf("Put() overwrite failed: %v", err)
+	}
+	r, err := s.Open("a/b")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "second" {
+		t.Errorf("content = %q, want %q", data, "second")
+	}
+
+	// 写入完成后不留临时文件
+	entries, _ := os.ReadDir(filepath.Join(s.root, "a"))
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+
+	if err := s.Delete("a/b"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := s.Delete("a/b"); err != nil {
+		t.Errorf("Delete of a missing blob = %v, want nil", err)
+	}
+	if _, err := s.Open("a/b"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Open after delete = %v, want ErrBlobNotFound", err)
+	}
+	if _, err := s.Put("../x", strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put(../x) = %v, want ErrInvalidKey", err)
+	}
+}
@@ -6,6 +6,7 @@ CREATE TABLE customers (
     salt VARCHAR(32) NOT NULL,
     name VARCHAR(100),
     status VARCHAR(20) DEFAULT 'active',
+    role VARCHAR(20) NOT NULL DEFAULT 'customer',
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP WITH TIME ZONE
@@ -20,6 +21,9 @@ CREATE TABLE sessions (
     customer_id INTEGER NOT NULL REFERENCES customers(id),
     status VARCHAR(20) NOT NULL,
     last_active_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    agent_id INTEGER REFERENCES customers(id),
+    handoff_reason VARCHAR(50),
+    bot_fallbacks INTEGER NOT NULL DEFAULT 0,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP WITH TIME ZONE
@@ -28,26 +32,56 @@ CREATE TABLE sessions (
 CREATE INDEX idx_sessions_customer_id ON sessions(customer_id);
 CREATE INDEX idx_sessions_status ON sessions(status);
 CREATE INDEX idx_sessions_last_active_at ON sessions(last_active_at);
+CREATE INDEX idx_sessions_agent_id ON sessions(agent_id);
 CREATE INDEX idx_sessions_deleted_at ON sessions(deleted_at);
 
 -- 创建消息表
 CREATE TABLE messages (
     id SERIAL PRIMARY KEY,
     content TEXT NOT NULL,
+    type VARCHAR(30) NOT NULL DEFAULT 'text',
     customer_id INTEGER NOT NULL REFERENCES customers(id),
     session_id INTEGER NOT NULL REFERENCES sessions(id),
     sender VARCHAR(20) NOT NULL,
     seq INTEGER NOT NULL,
+    agent_id INTEGER REFERENCES customers(id),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    deleted_at TIMESTAMP WITH TIME ZONE
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT uq_messages_session_seq UNIQUE (session_id, seq)
 );
 
 CREATE INDEX idx_messages_customer_id ON messages(customer_id);
-CREATE INDEX idx_messages_session_id ON messages(session_id);
-CREATE INDEX idx_messages_seq ON messages(seq);
 CREATE INDEX idx_messages_deleted_at ON messages(deleted_at);
 
+-- 创建客户端消息ID表，用于在去重窗口内识别重发的消息
+CREATE TABLE client_messages (
+    customer_id INTEGER NOT NULL REFERENCES customers(id),
+    client_msg_id VARCHAR(64) NOT NULL,
+    message_id INTEGER NOT NULL REFERENCES messages(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    replied_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (customer_id, client_msg_id)
+);
+
+CREATE INDEX idx_client_messages_created_at ON client_messages(created_at);
+
+-- 创建已读回执表，记录每个会话参与者已读到的最后一条消息序号
+CREATE TABLE read_receipts (
+    session_id INTEGER NOT NULL REFERENCES sessions(id),
+    user_id INTEGER NOT NULL REFERENCES customers(id),
+    last_read_seq INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id, user_id)
+);
+
+-- 创建消息序号计数器表，每个会话一行，插入消息时在同一事务中原子递增
+CREATE TABLE message_seqs (
+    session_id INTEGER PRIMARY KEY REFERENCES sessions(id),
+    last_seq INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
 -- 创建反馈表
 CREATE TABLE feedbacks (
     id SERIAL PRIMARY KEY,
@@ -68,6 +102,166 @@ CREATE INDEX idx_feedbacks_session_id ON feedbacks(session_id);
 CREATE INDEX idx_feedbacks_status ON feedbacks(status);
 CREATE INDEX idx_feedbacks_deleted_at ON feedbacks(deleted_at);
 
+-- 创建知识库文章表
+CREATE TABLE kb_articles (
+    id SERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    tags VARCHAR(500),
+    language VARCHAR(10),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_kb_articles_language ON kb_articles(language);
+CREATE INDEX idx_kb_articles_deleted_at ON kb_articles(deleted_at);
+
+-- 创建流程状态表
+CREATE TABLE flow_states (
+    id SERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL REFERENCES customers(id),
+    session_id INTEGER NOT NULL REFERENCES sessions(id),
+    flow_name VARCHAR(100) NOT NULL,
+    current_step VARCHAR(100) NOT NULL,
+    slots TEXT,
+    status VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_flow_states_customer_id ON flow_states(customer_id);
+CREATE INDEX idx_flow_states_session_id ON flow_states(session_id);
+CREATE INDEX idx_flow_states_status ON flow_states(status);
+CREATE INDEX idx_flow_states_deleted_at ON flow_states(deleted_at);
+
+-- 创建客服档案表
+CREATE TABLE agent_profiles (
+    id SERIAL PRIMARY KEY,
+    agent_id INTEGER NOT NULL UNIQUE REFERENCES customers(id),
+    skills VARCHAR(500),
+    languages VARCHAR(100),
+    max_concurrent INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_agent_profiles_deleted_at ON agent_profiles(deleted_at);
+
+-- 创建人工客服排队表
+CREATE TABLE queue_entries (
+    id SERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL UNIQUE REFERENCES sessions(id),
+    customer_id INTEGER NOT NULL REFERENCES customers(id),
+    skill VARCHAR(50),
+    language VARCHAR(10),
+    priority INTEGER NOT NULL DEFAULT 0,
+    enqueued_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    notified_position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_queue_entries_customer_id ON queue_entries(customer_id);
+CREATE INDEX idx_queue_entries_deleted_at ON queue_entries(deleted_at);
+CREATE INDEX idx_queue_entries_order ON queue_entries(priority DESC, enqueued_at, id);
+
+-- 创建排队分配状态表，只有一行：各节点分配时锁定该行，同一时刻只有一个节点在分配
+CREATE TABLE routing_state (
+    id INTEGER PRIMARY KEY,
+    last_agent_id INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO routing_state (id) VALUES (1);
+
+-- 创建附件表
+CREATE TABLE attachments (
+    id SERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL REFERENCES customers(id),
+    session_id INTEGER REFERENCES sessions(id),
+    message_id INTEGER REFERENCES messages(id),
+    file_name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_attachments_customer_id ON attachments(customer_id);
+CREATE INDEX idx_attachments_session_id ON attachments(session_id);
+CREATE INDEX idx_attachments_message_id ON attachments(message_id);
+CREATE INDEX idx_attachments_deleted_at ON attachments(deleted_at);
+
+-- 创建内容审核记录表，保存需要人工复核的消息及审核结果
+CREATE TABLE moderation_flags (
+    id SERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL REFERENCES customers(id),
+    session_id INTEGER NOT NULL REFERENCES sessions(id),
+    message_id INTEGER REFERENCES messages(id),
+    direction VARCHAR(20) NOT NULL,
+    content TEXT NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    categories VARCHAR(255),
+    verdict TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    reviewer_id INTEGER REFERENCES customers(id),
+    review_note TEXT,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    -- 同一消息同方向只保存一条记录；被拦截的消息 message_id 为空，不受限制
+    CONSTRAINT uq_moderation_flags_message UNIQUE (message_id, direction)
+);
+
+CREATE INDEX idx_moderation_flags_customer_id ON moderation_flags(customer_id);
+CREATE INDEX idx_moderation_flags_session_id ON moderation_flags(session_id);
+CREATE INDEX idx_moderation_flags_status ON moderation_flags(status, created_at);
+CREATE INDEX idx_moderation_flags_deleted_at ON moderation_flags(deleted_at);
+
+-- 创建个人信息保险库表，加密保存脱敏消息的原文
+CREATE TABLE pii_vault_entries (
+    message_id INTEGER PRIMARY KEY REFERENCES messages(id),
+    customer_id INTEGER NOT NULL REFERENCES customers(id),
+    session_id INTEGER NOT NULL REFERENCES sessions(id),
+    kinds VARCHAR(255) NOT NULL,
+    ciphertext TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_pii_vault_entries_customer_id ON pii_vault_entries(customer_id);
+
+-- 创建在线连接登记表，多节点部署时记录每个连接所在的节点
+CREATE TABLE presence (
+    connection_id VARCHAR(64) PRIMARY KEY,
+    node_id VARCHAR(64) NOT NULL,
+    user_id INTEGER NOT NULL,
+    is_agent BOOLEAN NOT NULL DEFAULT FALSE,
+    session_id INTEGER,
+    connected_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_presence_node_id ON presence(node_id);
+CREATE INDEX idx_presence_user ON presence(user_id, is_agent);
+CREATE INDEX idx_presence_session_id ON presence(session_id);
+CREATE INDEX idx_presence_heartbeat_at ON presence(heartbeat_at);
+
+-- 创建跨节点投递载荷表，超过 NOTIFY 大小限制的投递暂存于此
+CREATE TABLE broker_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_broker_payloads_created_at ON broker_payloads(created_at);
+
 -- 创建触发器函数来自动更新 updated_at 字段
 CREATE OR REPLACE FUNCTION update_updated_at_column()
 RETURNS TRIGGER AS $$
@@ -96,4 +290,34 @@ CREATE TRIGGER update_messages_updated_at
 CREATE TRIGGER update_feedbacks_updated_at
     BEFORE UPDATE ON feedbacks
     FOR EACH ROW
-    EXECUTE FUNCTION update_updated_at_column(); 
\ No newline at end of file
+    EXECUTE FUNCTION update_updated_at_column(); 
+
+CREATE TRIGGER update_kb_articles_updated_at
+    BEFORE UPDATE ON kb_articles
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_flow_states_updated_at
+    BEFORE UPDATE ON flow_states
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_agent_profiles_updated_at
+    BEFORE UPDATE ON agent_profiles
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_queue_entries_updated_at
+    BEFORE UPDATE ON queue_entries
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_moderation_flags_updated_at
+    BEFORE UPDATE ON moderation_flags
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_attachments_updated_at
+    BEFORE UPDATE ON attachments
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
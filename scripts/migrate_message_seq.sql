@@ -0,0 +1,32 @@
+-- 将已有数据库迁移到基于计数器的消息序号分配
+-- 1. 重新编号序号重复的会话（旧实现并发写入时可能产生重复序号）
+-- 2. 添加 (session_id, seq) 唯一约束
+-- 3. 创建并初始化计数器表
+-- 在停止所有服务节点后执行
+
+BEGIN;
+
+UPDATE messages m SET seq = r.rn FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY seq, id) AS rn
+    FROM messages
+    WHERE session_id IN (
+        SELECT session_id FROM messages GROUP BY session_id, seq HAVING COUNT(*) > 1
+    )
+) r
+WHERE m.id = r.id AND m.seq <> r.rn;
+
+DROP INDEX IF EXISTS idx_messages_session_id;
+DROP INDEX IF EXISTS idx_messages_seq;
+ALTER TABLE messages ADD CONSTRAINT uq_messages_session_seq UNIQUE (session_id, seq);
+
+CREATE TABLE IF NOT EXISTS message_seqs (
+    session_id INTEGER PRIMARY KEY REFERENCES sessions(id),
+    last_seq INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO message_seqs (session_id, last_seq)
+SELECT session_id, MAX(seq) FROM messages GROUP BY session_id
+ON CONFLICT (session_id) DO UPDATE SET last_seq = GREATEST(message_seqs.last_seq, EXCLUDED.last_seq);
+
+COMMIT;